@@ -0,0 +1,284 @@
+// Package acuity scores how unwell a patient is from their vital signs: the
+// National Early Warning Score 2 (NEWS2, Royal College of Physicians 2017)
+// and an acuity level in the style of the Emergency Severity Index (ESI
+// version 4), where 1 needs immediate life-saving care and 5 is least
+// urgent.
+//
+// ESI proper also asks how many resources a patient will need, which vital
+// signs cannot tell, so levels 3 to 5 are graded on the vital signs and
+// pain instead. The rules are versioned so that stored scores can be told
+// apart when they change.
+package acuity
+
+import (
+	"fmt"
+
+	"barman/internal/demographics"
+)
+
+// Version identifies the rules in this package. Change it whenever a
+// threshold or level changes.
+const Version = "news2-2017/esi4-vitals-2"
+
+// NEWS2 clinical risk, from the aggregate score and single parameters
+const (
+	RiskLow       = "low"
+	RiskLowMedium = "low-medium" // a single parameter scored 3
+	RiskMedium    = "medium"
+	RiskHigh      = "high"
+)
+
+// news2MinAgeMonths is the age NEWS2 is validated from; it is not used for
+// children
+const news2MinAgeMonths = 16 * 12
+
+// Vitals are the measurements scored. Nil means not measured.
+type Vitals struct {
+	HeartRate        *int
+	RespiratoryRate  *int
+	OxygenSaturation *int
+	Temperature      *float64
+	Systolic         *int
+	PainLevel        *int
+	// GCS is the Glasgow Coma Scale, 3 to 15, which stands for the level
+	// of consciousness
+	GCS *int
+	// Age is the patient's age, nil when unknown; patients of unknown age
+	// are scored as adults
+	Age *demographics.Age
+}
+
+// Component is the NEWS2 points given for one parameter
+type Component struct {
+	Parameter string   `json:"parameter"`
+	Value     *float64 `json:"value"` // nil when not measured
+	Points    int      `json:"points"`
+}
+
+// Result is a scored set of vital signs
+type Result struct {
+	Version string `json:"version"`
+	// NEWS2 is nil for children and when nothing it scores was measured
+	NEWS2      *int        `json:"news2"`
+	NEWS2Risk  string      `json:"news2_risk,omitempty"`
+	Components []Component `json:"components"`
+	// Missing lists the NEWS2 parameters that were not measured; they add
+	// nothing to the score. Supplemental oxygen is not recorded and is
+	// taken as air.
+	Missing []string `json:"missing,omitempty"`
+	// ESILevel is nil when no vital sign was measured
+	ESILevel *int     `json:"esi_level"`
+	Reasons  []string `json:"reasons"`
+}
+
+// band is a range of values and the NEWS2 points they score. Values below
+// the first band's Below are scored by it, and so on; the last band takes
+// everything else.
+type band struct {
+	Below  float64
+	Points int
+}
+
+var (
+	respiratoryRateBands  = []band{{9, 3}, {12, 1}, {21, 0}, {25, 2}, {0, 3}}
+	oxygenSaturationBands = []band{{92, 3}, {94, 2}, {96, 1}, {0, 0}}
+	systolicBands         = []band{{91, 3}, {101, 2}, {111, 1}, {220, 0}, {0, 3}}
+	heartRateBands        = []band{{41, 3}, {51, 1}, {91, 0}, {111, 1}, {131, 2}, {0, 3}}
+	// Temperatures are in tenths of a degree
+	temperatureBands   = []band{{350.1, 3}, {360.1, 1}, {380.1, 0}, {390.1, 1}, {0, 2}}
+	consciousnessBands = []band{{15, 3}, {0, 0}}
+)
+
+func points(value float64, bands []band) int {
+	for _, b := range bands[:len(bands)-1] {
+		if value < b.Below {
+			return b.Points
+		}
+	}
+	return bands[len(bands)-1].Points
+}
+
+// dangerZone is the ESI heart and respiratory rate above which a patient of
+// a given age is high risk
+type dangerZone struct {
+	UnderMonths     int
+	HeartRate       int
+	RespiratoryRate int
+}
+
+var dangerZones = []dangerZone{
+	{3, 180, 50},
+	{36, 160, 40},
+	{96, 140, 30},
+	{0, 100, 20}, // older than 8 years
+}
+
+func zoneFor(age *demographics.Age) dangerZone {
+	if age != nil {
+		for _, zone := range dangerZones[:len(dangerZones)-1] {
+			if age.Months < zone.UnderMonths {
+				return zone
+			}
+		}
+	}
+	return dangerZones[len(dangerZones)-1]
+}
+
+// Score works out NEWS2 and the acuity level of v
+func Score(v Vitals) Result {
+	result := Result{Version: Version, Components: []Component{}, Reasons: []string{}}
+
+	if v.Age == nil || v.Age.Months >= news2MinAgeMonths {
+		scoreNEWS2(v, &result)
+	} else {
+		result.Reasons = append(result.Reasons, "NEWS2 is not used under 16 years")
+	}
+
+	measured := v.HeartRate != nil || v.RespiratoryRate != nil || v.OxygenSaturation != nil ||
+		v.Temperature != nil || v.Systolic != nil || v.PainLevel != nil || v.GCS != nil
+	if measured {
+		level := esiLevel(v, &result)
+		result.ESILevel = &level
+	}
+	return result
+}
+
+func scoreNEWS2(v Vitals, result *Result) {
+	parameters := []struct {
+		Name  string
+		Value *float64
+		Bands []band
+		Scale float64
+	}{
+		{"respiratory_rate", intValue(v.RespiratoryRate), respiratoryRateBands, 1},
+		{"oxygen_saturation", intValue(v.OxygenSaturation), oxygenSaturationBands, 1},
+		{"systolic", intValue(v.Systolic), systolicBands, 1},
+		{"heart_rate", intValue(v.HeartRate), heartRateBands, 1},
+		{"temperature", v.Temperature, temperatureBands, 10},
+		// A GCS below 15 is taken as not alert
+		{"consciousness", intValue(v.GCS), consciousnessBands, 1},
+	}
+
+	total, highest, scored := 0, 0, 0
+	for _, p := range parameters {
+		component := Component{Parameter: p.Name, Value: p.Value}
+		if p.Value == nil {
+			result.Missing = append(result.Missing, p.Name)
+		} else {
+			component.Points = points(*p.Value*p.Scale, p.Bands)
+			total += component.Points
+			scored++
+			if component.Points > highest {
+				highest = component.Points
+			}
+		}
+		result.Components = append(result.Components, component)
+	}
+	if scored == 0 {
+		return
+	}
+
+	result.NEWS2 = &total
+	switch {
+	case total >= 7:
+		result.NEWS2Risk = RiskHigh
+	case total >= 5:
+		result.NEWS2Risk = RiskMedium
+	case highest == 3:
+		result.NEWS2Risk = RiskLowMedium
+	default:
+		result.NEWS2Risk = RiskLow
+	}
+}
+
+// esiLevel grades v from 1 to 5, noting why in result
+func esiLevel(v Vitals, result *Result) int {
+	reason := func(format string, args ...interface{}) {
+		result.Reasons = append(result.Reasons, fmt.Sprintf(format, args...))
+	}
+	infant := v.Age != nil && v.Age.Months < 12
+
+	// Level 1: signs that need immediate life-saving care
+	level1 := len(result.Reasons)
+	if v.OxygenSaturation != nil && *v.OxygenSaturation < 85 {
+		reason("oxygen saturation %d%% is below 85%%", *v.OxygenSaturation)
+	}
+	if v.RespiratoryRate != nil && *v.RespiratoryRate <= 8 {
+		reason("respiratory rate %d is 8 or below", *v.RespiratoryRate)
+	}
+	if v.Systolic != nil && *v.Systolic < 70 {
+		reason("systolic blood pressure %d is below 70", *v.Systolic)
+	}
+	if v.GCS != nil && *v.GCS <= 8 {
+		reason("GCS %d is 8 or below", *v.GCS)
+	}
+	if bradycardia := 40; v.HeartRate != nil {
+		if infant {
+			bradycardia = 60
+		}
+		if *v.HeartRate < bradycardia {
+			reason("heart rate %d is below %d", *v.HeartRate, bradycardia)
+		}
+	}
+	if len(result.Reasons) > level1 {
+		return 1
+	}
+
+	// Level 2: high risk, from the ESI danger zone vitals, reduced
+	// consciousness, severe pain, a medium or high NEWS2 or fever in a young
+	// infant
+	level2 := len(result.Reasons)
+	zone := zoneFor(v.Age)
+	if v.HeartRate != nil && *v.HeartRate > zone.HeartRate {
+		reason("heart rate %d is above %d", *v.HeartRate, zone.HeartRate)
+	}
+	if v.RespiratoryRate != nil && *v.RespiratoryRate > zone.RespiratoryRate {
+		reason("respiratory rate %d is above %d", *v.RespiratoryRate, zone.RespiratoryRate)
+	}
+	if v.OxygenSaturation != nil && *v.OxygenSaturation < 92 {
+		reason("oxygen saturation %d%% is below 92%%", *v.OxygenSaturation)
+	}
+	if v.GCS != nil && *v.GCS < 15 {
+		reason("reduced consciousness (GCS %d)", *v.GCS)
+	}
+	if v.PainLevel != nil && *v.PainLevel >= 7 {
+		reason("severe pain (%d/10)", *v.PainLevel)
+	}
+	if result.NEWS2 != nil && *result.NEWS2 >= 5 {
+		reason("NEWS2 of %d", *result.NEWS2)
+	}
+	if v.Temperature != nil && *v.Temperature >= 38 && v.Age != nil && v.Age.Months < 3 {
+		reason("fever of %g °C under 3 months", *v.Temperature)
+	}
+	if len(result.Reasons) > level2 {
+		return 2
+	}
+
+	// Levels 3 to 5 are graded on the remaining signs
+	if result.NEWS2 != nil && *result.NEWS2 > 0 {
+		reason("NEWS2 of %d", *result.NEWS2)
+		return 3
+	}
+	if v.Temperature != nil && *v.Temperature >= 38 {
+		reason("fever of %g °C", *v.Temperature)
+		return 3
+	}
+	if v.PainLevel != nil && *v.PainLevel >= 4 {
+		reason("moderate pain (%d/10)", *v.PainLevel)
+		return 3
+	}
+	if v.PainLevel != nil && *v.PainLevel > 0 {
+		reason("mild pain (%d/10)", *v.PainLevel)
+		return 4
+	}
+	reason("vital signs within normal limits")
+	return 5
+}
+
+func intValue(v *int) *float64 {
+	if v == nil {
+		return nil
+	}
+	f := float64(*v)
+	return &f
+}
@@ -0,0 +1,210 @@
+package acuity
+
+import (
+	"fmt"
+	"testing"
+
+	"barman/internal/demographics"
+)
+
+func intp(v int) *int { return &v }
+
+func floatp(v float64) *float64 { return &v }
+
+func months(m int) *demographics.Age { return &demographics.Age{Years: m / 12, Months: m} }
+
+// TestNEWS2Bands checks the points either side of every NEWS2 band edge
+func TestNEWS2Bands(t *testing.T) {
+	tests := []struct {
+		parameter string
+		vitals    func(value float64) Vitals
+		value     float64
+		points    int
+	}{
+		{"respiratory_rate", respiratoryRate, 8, 3},
+		{"respiratory_rate", respiratoryRate, 9, 1},
+		{"respiratory_rate", respiratoryRate, 11, 1},
+		{"respiratory_rate", respiratoryRate, 12, 0},
+		{"respiratory_rate", respiratoryRate, 20, 0},
+		{"respiratory_rate", respiratoryRate, 21, 2},
+		{"respiratory_rate", respiratoryRate, 24, 2},
+		{"respiratory_rate", respiratoryRate, 25, 3},
+
+		{"oxygen_saturation", oxygenSaturation, 91, 3},
+		{"oxygen_saturation", oxygenSaturation, 92, 2},
+		{"oxygen_saturation", oxygenSaturation, 93, 2},
+		{"oxygen_saturation", oxygenSaturation, 94, 1},
+		{"oxygen_saturation", oxygenSaturation, 95, 1},
+		{"oxygen_saturation", oxygenSaturation, 96, 0},
+
+		{"systolic", systolic, 90, 3},
+		{"systolic", systolic, 91, 2},
+		{"systolic", systolic, 100, 2},
+		{"systolic", systolic, 101, 1},
+		{"systolic", systolic, 110, 1},
+		{"systolic", systolic, 111, 0},
+		{"systolic", systolic, 219, 0},
+		{"systolic", systolic, 220, 3},
+
+		{"heart_rate", heartRate, 40, 3},
+		{"heart_rate", heartRate, 41, 1},
+		{"heart_rate", heartRate, 50, 1},
+		{"heart_rate", heartRate, 51, 0},
+		{"heart_rate", heartRate, 90, 0},
+		{"heart_rate", heartRate, 91, 1},
+		{"heart_rate", heartRate, 110, 1},
+		{"heart_rate", heartRate, 111, 2},
+		{"heart_rate", heartRate, 130, 2},
+		{"heart_rate", heartRate, 131, 3},
+
+		{"temperature", temperature, 35.0, 3},
+		{"temperature", temperature, 35.1, 1},
+		{"temperature", temperature, 36.0, 1},
+		{"temperature", temperature, 36.1, 0},
+		{"temperature", temperature, 38.0, 0},
+		{"temperature", temperature, 38.1, 1},
+		{"temperature", temperature, 39.0, 1},
+		{"temperature", temperature, 39.1, 2},
+
+		{"consciousness", gcs, 14, 3},
+		{"consciousness", gcs, 15, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %g", tt.parameter, tt.value), func(t *testing.T) {
+			result := Score(tt.vitals(tt.value))
+			component, ok := componentOf(result, tt.parameter)
+			if !ok || component.Value == nil {
+				t.Fatalf("%s was not scored: %+v", tt.parameter, result.Components)
+			}
+			if component.Points != tt.points {
+				t.Errorf("points = %d, want %d", component.Points, tt.points)
+			}
+			if result.NEWS2 == nil || *result.NEWS2 != tt.points {
+				t.Errorf("NEWS2 = %v, want %d", result.NEWS2, tt.points)
+			}
+		})
+	}
+}
+
+func TestNEWS2Risk(t *testing.T) {
+	tests := []struct {
+		name   string
+		vitals Vitals
+		news2  *int
+		risk   string
+	}{
+		{"nothing measured", Vitals{PainLevel: intp(2)}, nil, ""},
+		{"under 16 years", Vitals{HeartRate: intp(135), Age: months(16*12 - 1)}, nil, ""},
+		{"16 years", Vitals{HeartRate: intp(135), Age: months(16 * 12)}, intp(3), RiskLowMedium},
+		{"score 0", Vitals{HeartRate: intp(70)}, intp(0), RiskLow},
+		{"score 4 without a 3", Vitals{RespiratoryRate: intp(22), HeartRate: intp(120)}, intp(4), RiskLow},
+		{"single parameter of 3", Vitals{RespiratoryRate: intp(8)}, intp(3), RiskLowMedium},
+		{"score 5", Vitals{RespiratoryRate: intp(22), HeartRate: intp(135)}, intp(5), RiskMedium},
+		{"score 6", Vitals{RespiratoryRate: intp(25), HeartRate: intp(135)}, intp(6), RiskMedium},
+		{"score 7", Vitals{RespiratoryRate: intp(25), HeartRate: intp(135), OxygenSaturation: intp(95)}, intp(7), RiskHigh},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Score(tt.vitals)
+			switch {
+			case tt.news2 == nil && result.NEWS2 != nil:
+				t.Errorf("NEWS2 = %d, want none", *result.NEWS2)
+			case tt.news2 != nil && (result.NEWS2 == nil || *result.NEWS2 != *tt.news2):
+				t.Errorf("NEWS2 = %v, want %d", result.NEWS2, *tt.news2)
+			}
+			if result.NEWS2Risk != tt.risk {
+				t.Errorf("risk = %q, want %q", result.NEWS2Risk, tt.risk)
+			}
+		})
+	}
+}
+
+// TestESILevel checks the level either side of every threshold
+func TestESILevel(t *testing.T) {
+	tests := []struct {
+		name   string
+		vitals Vitals
+		level  int
+	}{
+		{"oxygen saturation 84", Vitals{OxygenSaturation: intp(84)}, 1},
+		{"oxygen saturation 85", Vitals{OxygenSaturation: intp(85)}, 2},
+		{"respiratory rate 8", Vitals{RespiratoryRate: intp(8)}, 1},
+		{"respiratory rate 9", Vitals{RespiratoryRate: intp(9)}, 3},
+		{"systolic 69", Vitals{Systolic: intp(69)}, 1},
+		{"systolic 70", Vitals{Systolic: intp(70)}, 3},
+		{"GCS 8", Vitals{GCS: intp(8)}, 1},
+		{"GCS 9", Vitals{GCS: intp(9)}, 2},
+		{"heart rate 39", Vitals{HeartRate: intp(39)}, 1},
+		{"heart rate 40", Vitals{HeartRate: intp(40)}, 3},
+		{"infant heart rate 59", Vitals{HeartRate: intp(59), Age: months(6)}, 1},
+		{"infant heart rate 60", Vitals{HeartRate: intp(60), Age: months(6)}, 5},
+
+		{"heart rate 100", Vitals{HeartRate: intp(100)}, 3},
+		{"heart rate 101", Vitals{HeartRate: intp(101)}, 2},
+		{"respiratory rate 20", Vitals{RespiratoryRate: intp(20)}, 5},
+		{"respiratory rate 21", Vitals{RespiratoryRate: intp(21)}, 2},
+		{"under 3 months heart rate 180", Vitals{HeartRate: intp(180), Age: months(2)}, 5},
+		{"under 3 months heart rate 181", Vitals{HeartRate: intp(181), Age: months(2)}, 2},
+		{"under 3 months respiratory rate 50", Vitals{RespiratoryRate: intp(50), Age: months(2)}, 5},
+		{"under 3 months respiratory rate 51", Vitals{RespiratoryRate: intp(51), Age: months(2)}, 2},
+		{"3 months heart rate 161", Vitals{HeartRate: intp(161), Age: months(3)}, 2},
+		{"under 3 years heart rate 160", Vitals{HeartRate: intp(160), Age: months(35)}, 5},
+		{"under 3 years respiratory rate 41", Vitals{RespiratoryRate: intp(41), Age: months(35)}, 2},
+		{"3 years heart rate 141", Vitals{HeartRate: intp(141), Age: months(36)}, 2},
+		{"under 8 years heart rate 140", Vitals{HeartRate: intp(140), Age: months(95)}, 5},
+		{"under 8 years respiratory rate 31", Vitals{RespiratoryRate: intp(31), Age: months(95)}, 2},
+		{"8 years heart rate 101", Vitals{HeartRate: intp(101), Age: months(96)}, 2},
+		{"oxygen saturation 91", Vitals{OxygenSaturation: intp(91)}, 2},
+		{"oxygen saturation 92", Vitals{OxygenSaturation: intp(92)}, 3},
+		{"GCS 14", Vitals{GCS: intp(14)}, 2},
+		{"GCS 15", Vitals{GCS: intp(15)}, 5},
+		{"pain 7", Vitals{PainLevel: intp(7)}, 2},
+		{"pain 6", Vitals{PainLevel: intp(6)}, 3},
+		{"NEWS2 4", Vitals{Temperature: floatp(39.1), Systolic: intp(95)}, 3},
+		{"NEWS2 5", Vitals{Temperature: floatp(39.1), Systolic: intp(95), OxygenSaturation: intp(95)}, 2},
+		{"fever under 3 months", Vitals{Temperature: floatp(38), Age: months(2)}, 2},
+		{"no fever under 3 months", Vitals{Temperature: floatp(37.9), Age: months(2)}, 5},
+		{"fever at 3 months", Vitals{Temperature: floatp(38), Age: months(3)}, 3},
+
+		{"NEWS2 1", Vitals{HeartRate: intp(91)}, 3},
+		{"fever", Vitals{Temperature: floatp(38)}, 3},
+		{"no fever", Vitals{Temperature: floatp(37.9)}, 5},
+		{"pain 4", Vitals{PainLevel: intp(4)}, 3},
+		{"pain 3", Vitals{PainLevel: intp(3)}, 4},
+		{"pain 1", Vitals{PainLevel: intp(1)}, 4},
+		{"pain 0", Vitals{PainLevel: intp(0)}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Score(tt.vitals)
+			if result.ESILevel == nil {
+				t.Fatal("no ESI level")
+			}
+			if *result.ESILevel != tt.level {
+				t.Errorf("ESI level = %d, want %d (%v)", *result.ESILevel, tt.level, result.Reasons)
+			}
+		})
+	}
+}
+
+func TestESILevelNeedsVitals(t *testing.T) {
+	if result := Score(Vitals{Age: months(240)}); result.ESILevel != nil {
+		t.Errorf("ESI level = %d, want none", *result.ESILevel)
+	}
+}
+
+func respiratoryRate(v float64) Vitals  { return Vitals{RespiratoryRate: intp(int(v))} }
+func oxygenSaturation(v float64) Vitals { return Vitals{OxygenSaturation: intp(int(v))} }
+func systolic(v float64) Vitals         { return Vitals{Systolic: intp(int(v))} }
+func heartRate(v float64) Vitals        { return Vitals{HeartRate: intp(int(v))} }
+func temperature(v float64) Vitals      { return Vitals{Temperature: floatp(v)} }
+func gcs(v float64) Vitals              { return Vitals{GCS: intp(int(v))} }
+
+func componentOf(result Result, parameter string) (Component, bool) {
+	for _, component := range result.Components {
+		if component.Parameter == parameter {
+			return component, true
+		}
+	}
+	return Component{}, false
+}
@@ -0,0 +1,398 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"barman/internal/fieldcrypt"
+	"barman/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ErrAppendOnly is returned when something tries to modify an audit entry
+var ErrAppendOnly = errors.New("audit log is append-only")
+
+const snapshotKey = "audit:before"
+
+var (
+	userType      = reflect.TypeOf(models.User{})
+	auditLogType  = reflect.TypeOf(models.AuditLog{})
+	encryptedType = reflect.TypeOf(fieldcrypt.EncryptedString(""))
+)
+
+// change is the before/after value of a single column
+type change struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// Register installs GORM callbacks that write an audit entry for every
+// create, update and delete, and collect patient reads for the middleware
+func Register(db *gorm.DB) error {
+	cb := db.Callback()
+
+	if err := cb.Create().After("gorm:after_create").Before("gorm:commit_or_rollback_transaction").
+		Register("audit:after_create", afterCreate); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:begin_transaction").Before("gorm:before_update").
+		Register("audit:before_update", beforeUpdate); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:after_update").Before("gorm:commit_or_rollback_transaction").
+		Register("audit:after_update", afterUpdate); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:begin_transaction").Before("gorm:before_delete").
+		Register("audit:before_delete", beforeDelete); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:after_delete").Before("gorm:commit_or_rollback_transaction").
+		Register("audit:after_delete", afterDelete); err != nil {
+		return err
+	}
+	return cb.Query().After("gorm:after_query").Register("audit:after_query", afterQuery)
+}
+
+func skip(db *gorm.DB) bool {
+	return db.Error != nil || db.Statement.Schema == nil || db.Statement.Schema.ModelType == auditLogType
+}
+
+func afterCreate(db *gorm.DB) {
+	if skip(db) {
+		return
+	}
+	stmt := db.Statement
+
+	eachRecord(stmt, stmt.ReflectValue, func(rv reflect.Value) {
+		changes := make(map[string]change)
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			value, zero := field.ValueOf(stmt.Context, rv)
+			if zero {
+				continue
+			}
+			changes[field.DBName] = change{New: redact(field, value)}
+		}
+		write(db, models.AuditActionCreate, rv, changes)
+	})
+}
+
+func beforeUpdate(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.Schema != nil && stmt.Schema.ModelType == auditLogType {
+		db.AddError(ErrAppendOnly)
+		return
+	}
+	if skip(db) || stmt.ReflectValue.Kind() != reflect.Struct || stmt.ReflectValue.Type() != stmt.Schema.ModelType {
+		return
+	}
+
+	id, ok := primaryKey(stmt, stmt.ReflectValue)
+	if !ok {
+		return
+	}
+
+	before := reflect.New(stmt.Schema.ModelType)
+	err := db.Session(&gorm.Session{NewDB: true}).Unscoped().Take(before.Interface(), id).Error
+	if err == nil {
+		stmt.Settings.Store(snapshotKey, before.Elem())
+	}
+}
+
+func afterUpdate(db *gorm.DB) {
+	if skip(db) {
+		return
+	}
+	stmt := db.Statement
+
+	if stmt.ReflectValue.Kind() != reflect.Struct || stmt.ReflectValue.Type() != stmt.Schema.ModelType {
+		write(db, models.AuditActionUpdate, reflect.Value{}, nil)
+		return
+	}
+
+	changes := make(map[string]change)
+	if value, ok := stmt.Settings.Load(snapshotKey); ok {
+		before := value.(reflect.Value)
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || field.AutoUpdateTime > 0 {
+				continue
+			}
+			oldValue, _ := field.ValueOf(stmt.Context, before)
+			newValue, _ := field.ValueOf(stmt.Context, stmt.ReflectValue)
+			if equal(oldValue, newValue) {
+				continue
+			}
+			changes[field.DBName] = change{Old: redact(field, oldValue), New: redact(field, newValue)}
+		}
+	} else if updates, ok := stmt.Dest.(map[string]interface{}); ok {
+		for column, value := range updates {
+			if field := stmt.Schema.LookUpField(column); field != nil {
+				value = redact(field, value)
+			}
+			changes[column] = change{New: value}
+		}
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+	write(db, models.AuditActionUpdate, stmt.ReflectValue, changes)
+}
+
+func beforeDelete(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.Schema != nil && stmt.Schema.ModelType == auditLogType {
+		db.AddError(ErrAppendOnly)
+	}
+}
+
+func afterDelete(db *gorm.DB) {
+	if skip(db) {
+		return
+	}
+	stmt := db.Statement
+
+	eachRecord(stmt, stmt.ReflectValue, func(rv reflect.Value) {
+		changes := make(map[string]change)
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			value, zero := field.ValueOf(stmt.Context, rv)
+			if zero {
+				continue
+			}
+			changes[field.DBName] = change{Old: redact(field, value)}
+		}
+		write(db, models.AuditActionDelete, rv, changes)
+	})
+}
+
+func afterQuery(db *gorm.DB) {
+	if skip(db) {
+		return
+	}
+	stmt := db.Statement
+	reads := readsFrom(stmt.Context)
+	if reads == nil {
+		return
+	}
+
+	name := stmt.Schema.Name
+	eachRecord(stmt, stmt.ReflectValue, func(rv reflect.Value) {
+		if patientID, ok := directPatientID(stmt, rv); ok {
+			reads.addPatient(name, patientID)
+		} else if visitID, ok := uintField(stmt, rv, "VisitID"); ok {
+			reads.addVisit(name, visitID)
+		}
+	})
+}
+
+// write stores one audit entry inside the statement's transaction so the
+// change and its audit record are committed or rolled back together
+func write(db *gorm.DB, action string, rv reflect.Value, changes map[string]change) {
+	stmt := db.Statement
+	entry := newEntry(stmt.Context, action, stmt.Schema.Name)
+
+	if rv.IsValid() {
+		if id, ok := primaryKey(stmt, rv); ok {
+			entry.EntityID = &id
+		}
+		entry.PatientID = patientID(db, rv)
+	}
+
+	if len(changes) > 0 {
+		data, err := json.Marshal(changes)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		entry.Changes = data
+	}
+
+	if err := db.Session(&gorm.Session{NewDB: true}).Create(&entry).Error; err != nil {
+		db.AddError(err)
+	}
+}
+
+// Record writes an audit entry for an action on a whole patient, such as
+// erasing them, rather than on a single record. Details are stored as the
+// entry's changes.
+func Record(db *gorm.DB, action string, patientID uint, details interface{}) error {
+	entry := newEntry(db.Statement.Context, action, userType.Name())
+	entry.EntityID, entry.PatientID = &patientID, &patientID
+
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		entry.Changes = data
+	}
+	return db.Session(&gorm.Session{NewDB: true}).Create(&entry).Error
+}
+
+func newEntry(ctx context.Context, action, entityType string) models.AuditLog {
+	entry := models.AuditLog{
+		CreatedAt:  time.Now(),
+		Action:     action,
+		EntityType: entityType,
+	}
+	if actor, ok := ActorFrom(ctx); ok {
+		if actor.StaffID != 0 {
+			staffID := actor.StaffID
+			entry.ActorID = &staffID
+		}
+		entry.ActorRole = actor.Role
+		entry.ClientIP = actor.ClientIP
+		entry.Method = actor.Method
+		entry.Path = actor.Path
+	}
+	return entry
+}
+
+// patientID works out which patient a record belongs to, following the
+// visit for records such as triage that only reference a visit
+func patientID(db *gorm.DB, rv reflect.Value) *uint {
+	stmt := db.Statement
+	if id, ok := directPatientID(stmt, rv); ok {
+		return &id
+	}
+
+	visitID, ok := uintField(stmt, rv, "VisitID")
+	if !ok {
+		return nil
+	}
+	var userIDs []uint
+	db.Session(&gorm.Session{NewDB: true}).Table("visits").Where("id = ?", visitID).Pluck("user_id", &userIDs)
+	if len(userIDs) == 0 || userIDs[0] == 0 {
+		return nil
+	}
+	return &userIDs[0]
+}
+
+func directPatientID(stmt *gorm.Statement, rv reflect.Value) (uint, bool) {
+	if stmt.Schema.ModelType == userType {
+		return primaryKey(stmt, rv)
+	}
+	return uintField(stmt, rv, "UserID")
+}
+
+func primaryKey(stmt *gorm.Statement, rv reflect.Value) (uint, bool) {
+	field := stmt.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return 0, false
+	}
+	value, zero := field.ValueOf(stmt.Context, rv)
+	if zero {
+		return 0, false
+	}
+	return toUint(value)
+}
+
+func uintField(stmt *gorm.Statement, rv reflect.Value, name string) (uint, bool) {
+	field := stmt.Schema.LookUpField(name)
+	if field == nil {
+		return 0, false
+	}
+	value, zero := field.ValueOf(stmt.Context, rv)
+	if zero {
+		return 0, false
+	}
+	return toUint(value)
+}
+
+func toUint(value interface{}) (uint, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uint(rv.Uint()), rv.Uint() != 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint(rv.Int()), rv.Int() > 0
+	}
+	return 0, false
+}
+
+// redact hides values of fields that are never serialised, such as
+// password hashes, of encrypted fields and of fields tagged audit:"redact",
+// such as names, so the audit log does not hold identifiers in plaintext,
+// while still recording that they changed
+func redact(field *schema.Field, value interface{}) interface{} {
+	if redacted(field) {
+		return "[redacted]"
+	}
+	return value
+}
+
+func redacted(field *schema.Field) bool {
+	return field.Tag.Get("json") == "-" || field.Tag.Get("audit") == "redact" || field.FieldType == encryptedType
+}
+
+// Scrub redacts, in the entries already written about a patient, every
+// column of the given models that new entries redact. It is the only
+// change made to existing entries, for anonymising a patient whose details
+// were recorded before they were redacted.
+func Scrub(db *gorm.DB, patientID uint, records ...interface{}) error {
+	db = db.Session(&gorm.Session{NewDB: true})
+	logs := &gorm.Statement{DB: db}
+	if err := logs.Parse(&models.AuditLog{}); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(record); err != nil {
+			return err
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || !redacted(field) {
+				continue
+			}
+			// Replaces each of the old and new values the entry holds
+			err := db.Exec("UPDATE "+logs.Schema.Table+" SET changes = jsonb_set(changes, ARRAY[?::text], "+
+				"(SELECT COALESCE(jsonb_object_agg(part, to_jsonb(?::text)), '{}') FROM jsonb_object_keys(changes -> ?) AS part)) "+
+				"WHERE patient_id = ? AND entity_type = ? AND jsonb_typeof(changes -> ?) = 'object'",
+				field.DBName, "[redacted]", field.DBName, patientID, stmt.Schema.Name, field.DBName).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func equal(a, b interface{}) bool {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Equal(tb)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// eachRecord calls fn for every model value held in rv. Values of other
+// types, such as rows scanned into a projection struct, are skipped because
+// the schema's fields cannot be read from them.
+func eachRecord(stmt *gorm.Statement, rv reflect.Value, fn func(reflect.Value)) {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if item := reflect.Indirect(rv.Index(i)); item.Type() == stmt.Schema.ModelType {
+				fn(item)
+			}
+		}
+	case reflect.Struct:
+		if rv.Type() == stmt.Schema.ModelType {
+			fn(rv)
+		}
+	}
+}
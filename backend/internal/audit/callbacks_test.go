@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"barman/internal/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		model    interface{}
+		field    string
+		redacted bool
+	}{
+		{&models.User{}, "FirstName", true},
+		{&models.User{}, "LastName", true},
+		{&models.User{}, "NationalID", true},
+		{&models.User{}, "NationalIDIndex", true},
+		{&models.User{}, "DateOfBirth", true},
+		{&models.User{}, "MobilePhone", true},
+		{&models.User{}, "Sex", false},
+		{&models.User{}, "BloodType", false},
+		{&models.Staff{}, "PasswordHash", true},
+		{&models.Staff{}, "Username", false},
+		{&models.InsurancePolicy{}, "PolicyNumber", true},
+		{&models.Visit{}, "Type", false},
+	}
+	for _, tt := range tests {
+		s, err := schema.Parse(tt.model, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(s.Name+"."+tt.field, func(t *testing.T) {
+			field := s.LookUpField(tt.field)
+			if field == nil {
+				t.Fatalf("%s has no field %s", s.Name, tt.field)
+			}
+			value := redact(field, "value")
+			if got := value == "[redacted]"; got != tt.redacted {
+				t.Errorf("redact = %v, want redacted %v", value, tt.redacted)
+			}
+		})
+	}
+}
+
+// TestCallbacksAgainstSchema writes a patient with the callbacks registered
+// when TEST_DATABASE_URL names a PostgreSQL database. Nothing is kept, as
+// the schema is created in a transaction that is rolled back.
+func TestCallbacksAgainstSchema(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithActor(context.Background(), Actor{StaffID: 3, Role: models.RoleNurse, Method: "PUT", Path: "/api/users/1"})
+	tx := conn.WithContext(ctx).Begin()
+	defer tx.Rollback()
+	if err := tx.AutoMigrate(&models.User{}, &models.AuditLog{}); err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{FirstName: "Sara", LastName: "Ahmadi", Sex: "female"}
+	if err := tx.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	user.FirstName, user.BloodType = "Zahra", "A+"
+	if err := tx.Save(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []models.AuditLog
+	if err := tx.Where("patient_id = ?", user.ID).Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != models.AuditActionCreate || entries[1].Action != models.AuditActionUpdate {
+		t.Fatalf("entries = %+v, want a create and an update", entries)
+	}
+	for _, entry := range entries {
+		if entry.ActorID == nil || *entry.ActorID != 3 || entry.ActorRole != models.RoleNurse || entry.Path != "/api/users/1" {
+			t.Errorf("%s entry was made by %v %q at %q", entry.Action, entry.ActorID, entry.ActorRole, entry.Path)
+		}
+	}
+
+	var changes map[string]change
+	if err := json.Unmarshal(entries[1].Changes, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if changes["first_name"].Old != "[redacted]" || changes["first_name"].New != "[redacted]" {
+		t.Errorf("first_name change = %+v, want it redacted", changes["first_name"])
+	}
+	if changes["blood_type"].New != "A+" {
+		t.Errorf("blood_type change = %+v, want A+", changes["blood_type"])
+	}
+	if _, ok := changes["last_name"]; ok {
+		t.Error("unchanged last_name was recorded")
+	}
+
+	if err := tx.Model(&entries[0]).Update("action", "changed").Error; !errors.Is(err, ErrAppendOnly) {
+		t.Errorf("updating an entry = %v, want %v", err, ErrAppendOnly)
+	}
+}
@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"context"
+	"sync"
+)
+
+// Actor identifies who is performing a request and from where
+type Actor struct {
+	StaffID  uint
+	Role     string
+	ClientIP string
+	Method   string
+	Path     string
+}
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	readsKey
+)
+
+// WithActor returns a copy of ctx carrying the acting staff member
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFrom returns the actor stored on ctx, if any
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	if ctx == nil {
+		return Actor{}, false
+	}
+	actor, ok := ctx.Value(actorKey).(Actor)
+	return actor, ok
+}
+
+// readSet collects the patients whose records were loaded while serving a
+// single request, so that one read entry can be written per patient
+type readSet struct {
+	mu         sync.Mutex
+	entityType string
+	patients   map[uint]struct{}
+	visits     map[uint]struct{}
+}
+
+func newReadSet() *readSet {
+	return &readSet{
+		patients: make(map[uint]struct{}),
+		visits:   make(map[uint]struct{}),
+	}
+}
+
+func readsFrom(ctx context.Context) *readSet {
+	if ctx == nil {
+		return nil
+	}
+	reads, _ := ctx.Value(readsKey).(*readSet)
+	return reads
+}
+
+func (r *readSet) addPatient(entityType string, id uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entityType == "" {
+		r.entityType = entityType
+	}
+	r.patients[id] = struct{}{}
+}
+
+func (r *readSet) addVisit(entityType string, id uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entityType == "" {
+		r.entityType = entityType
+	}
+	r.visits[id] = struct{}{}
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"barman/internal/auth"
+	"barman/internal/database"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware attaches the signed-in staff member to the request context so
+// GORM callbacks can attribute writes, and records one read entry per
+// patient whose records were returned by a successful GET request.
+// Must run after auth.RequireAuth.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := Actor{
+			ClientIP: c.ClientIP(),
+			Method:   c.Request.Method,
+			Path:     c.Request.URL.Path,
+		}
+		if staff := auth.CurrentStaff(c); staff != nil {
+			actor.StaffID = staff.ID
+			actor.Role = staff.Role
+		}
+
+		ctx := WithActor(c.Request.Context(), actor)
+		var reads *readSet
+		if c.Request.Method == http.MethodGet {
+			reads = newReadSet()
+			ctx = context.WithValue(ctx, readsKey, reads)
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if reads != nil && c.Writer.Status() < http.StatusBadRequest {
+			if err := flushReads(reads, actor, c.Param("id")); err != nil {
+				log.Println("Failed to write audit read entries:", err)
+			}
+		}
+	}
+}
+
+// FlushReads writes the read entries collected so far by a long-running
+// request, such as an event stream, and starts collecting afresh, so that
+// reads are recorded as they happen rather than held until it ends
+func FlushReads(c *gin.Context) {
+	reads := readsFrom(c.Request.Context())
+	actor, ok := ActorFrom(c.Request.Context())
+	if reads == nil || !ok {
+		return
+	}
+	if err := flushReads(reads, actor, c.Param("id")); err != nil {
+		log.Println("Failed to write audit read entries:", err)
+	}
+}
+
+func flushReads(reads *readSet, actor Actor, id string) error {
+	reads.mu.Lock()
+	defer reads.mu.Unlock()
+
+	if len(reads.visits) > 0 {
+		visitIDs := make([]uint, 0, len(reads.visits))
+		for visitID := range reads.visits {
+			visitIDs = append(visitIDs, visitID)
+		}
+		var userIDs []uint
+		if err := database.DB.Table("visits").Where("id IN ?", visitIDs).Pluck("user_id", &userIDs).Error; err != nil {
+			return err
+		}
+		for _, userID := range userIDs {
+			if userID != 0 {
+				reads.patients[userID] = struct{}{}
+			}
+		}
+		reads.visits = make(map[uint]struct{})
+	}
+
+	if len(reads.patients) == 0 {
+		return nil
+	}
+
+	var entityID *uint
+	if parsed, err := strconv.ParseUint(id, 10, 64); err == nil {
+		value := uint(parsed)
+		entityID = &value
+	}
+
+	entries := make([]models.AuditLog, 0, len(reads.patients))
+	for patientID := range reads.patients {
+		entry := newEntry(WithActor(context.Background(), actor), models.AuditActionRead, reads.entityType)
+		entry.EntityID = entityID
+		entry.PatientID = &patientID
+		entries = append(entries, entry)
+	}
+
+	if err := database.DB.CreateInBatches(&entries, 100).Error; err != nil {
+		return err
+	}
+	reads.patients = make(map[uint]struct{})
+	return nil
+}
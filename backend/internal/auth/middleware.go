@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"barman/internal/database"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const staffContextKey = "staff"
+
+// RequireAuth rejects requests without a valid bearer token for an active
+// staff account and stores the authenticated staff member on the context
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		token, found := strings.CutPrefix(header, "Bearer ")
+		scope := ""
+		// Browsers cannot set headers on an EventSource, so event streams
+		// may pass a stream ticket as ?access_token= instead
+		if !found && strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+			token = c.Query("access_token")
+			found = token != ""
+			scope = StreamScope
+		}
+		if !found || token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization token is required"})
+			return
+		}
+
+		claims, err := ParseToken(token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		// Bearer tokens are never accepted in the URL, where they would be
+		// logged, and stream tickets are only accepted there
+		if claims.Scope != scope {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidToken.Error()})
+			return
+		}
+
+		var staff models.Staff
+		if err := database.DB.First(&staff, claims.StaffID).Error; err != nil || !staff.Active {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Staff account is not active"})
+			return
+		}
+
+		c.Set(staffContextKey, &staff)
+		c.Next()
+	}
+}
+
+// RequireRoles only lets through staff holding one of the given roles.
+// Admins are always allowed. Must run after RequireAuth.
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		staff := CurrentStaff(c)
+		if staff == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		if staff.Role == models.RoleAdmin {
+			c.Next()
+			return
+		}
+		for _, role := range roles {
+			if staff.Role == role {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You do not have permission to perform this action"})
+	}
+}
+
+// CurrentStaff returns the authenticated staff member, or nil if the
+// request has not been authenticated
+func CurrentStaff(c *gin.Context) *models.Staff {
+	value, ok := c.Get(staffContextKey)
+	if !ok {
+		return nil
+	}
+	staff, _ := value.(*models.Staff)
+	return staff
+}
+
+// LogFormatter formats request logs like gin's default logger, with the
+// value of any ?access_token= replaced so that stream tickets are not
+// written to the logs
+func LogFormatter(param gin.LogFormatterParams) string {
+	path := param.Path
+	if base, query, found := strings.Cut(path, "?"); found && strings.Contains(query, "access_token") {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			values = url.Values{}
+		}
+		values.Set("access_token", "redacted")
+		path = base + "?" + values.Encode()
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		path,
+		param.ErrorMessage,
+	)
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"log"
+	"os"
+
+	"barman/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// MinPasswordLength is the shortest password accepted for a staff account
+const MinPasswordLength = 8
+
+// HashPassword returns the bcrypt hash of a plaintext password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches the stored hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// SeedAdmin creates the initial admin account from ADMIN_USERNAME and
+// ADMIN_PASSWORD when no staff accounts exist yet
+func SeedAdmin(db *gorm.DB) {
+	var count int64
+	if err := db.Model(&models.Staff{}).Count(&count).Error; err != nil {
+		log.Fatal("Failed to count staff accounts:", err)
+	}
+	if count > 0 {
+		return
+	}
+
+	username := os.Getenv("ADMIN_USERNAME")
+	password := os.Getenv("ADMIN_PASSWORD")
+	if username == "" || len(password) < MinPasswordLength {
+		log.Println("No staff accounts exist; set ADMIN_USERNAME and ADMIN_PASSWORD to create the first admin")
+		return
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		log.Fatal("Failed to hash admin password:", err)
+	}
+
+	admin := models.Staff{
+		Username:     username,
+		PasswordHash: hash,
+		FullName:     "Administrator",
+		Role:         models.RoleAdmin,
+		Active:       true,
+	}
+	if err := db.Create(&admin).Error; err != nil {
+		log.Fatal("Failed to create admin account:", err)
+	}
+	log.Printf("Created initial admin account %q", username)
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"barman/internal/models"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+var (
+	signingKey []byte
+	tokenTTL   = 12 * time.Hour
+	// streamTicketTTL is how long a stream ticket can be used to open an
+	// event stream. The stream stays open once it has started.
+	streamTicketTTL = time.Minute
+)
+
+// StreamScope is the scope of a stream ticket, a short-lived token only
+// accepted when opening an event stream
+const StreamScope = "stream"
+
+// Claims is the payload carried by a signed bearer token
+type Claims struct {
+	StaffID   uint   `json:"sub"`
+	Role      string `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	// Scope limits what the token may be used for. Bearer tokens have none.
+	Scope string `json:"scope,omitempty"`
+}
+
+// Init loads the token signing key and lifetime from the environment
+func Init() {
+	secret := os.Getenv("AUTH_SECRET")
+	if len(secret) < 32 {
+		log.Fatal("AUTH_SECRET must be set to at least 32 characters")
+	}
+	signingKey = []byte(secret)
+
+	if ttl := os.Getenv("AUTH_TOKEN_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatal("Invalid AUTH_TOKEN_TTL:", err)
+		}
+		tokenTTL = d
+	}
+}
+
+// tokenHeader is the fixed JWT header for HMAC-SHA256 signed tokens
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// IssueToken creates a signed bearer token for the given staff member
+func IssueToken(staff *models.Staff) (string, time.Time, error) {
+	return issue(staff, "", tokenTTL)
+}
+
+// IssueStreamTicket creates a stream ticket for the given staff member. It
+// is passed as ?access_token= by browsers, which cannot set headers on an
+// EventSource, and expires within a minute so one that is logged along
+// with the URL is of little use.
+func IssueStreamTicket(staff *models.Staff) (string, time.Time, error) {
+	return issue(staff, StreamScope, streamTicketTTL)
+}
+
+func issue(staff *models.Staff, scope string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	payload, err := json.Marshal(Claims{
+		StaffID:   staff.ID,
+		Role:      staff.Role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+		Scope:     scope,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + sign(unsigned), expiresAt, nil
+}
+
+// ParseToken verifies the signature and expiry of a token and returns its claims
+func ParseToken(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+
+	expected := sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func sign(data string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"barman/internal/database"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var nurse = &models.Staff{Model: gorm.Model{ID: 7}, Role: models.RoleNurse}
+
+func useKey(t *testing.T, key string) {
+	t.Helper()
+	previous := signingKey
+	signingKey = []byte(key)
+	t.Cleanup(func() { signingKey = previous })
+}
+
+func TestParseToken(t *testing.T) {
+	useKey(t, strings.Repeat("k", 32))
+	token, expiresAt, err := IssueToken(nurse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expiresAt); until <= tokenTTL-time.Minute || until > tokenTTL {
+		t.Errorf("token expires in %s, want %s", until, tokenTTL)
+	}
+	ticket, expiresAt, err := IssueStreamTicket(nurse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expiresAt); until > streamTicketTTL {
+		t.Errorf("ticket expires in %s, want at most %s", until, streamTicketTTL)
+	}
+	expired, _, err := issue(nurse, "", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		scope string
+		err   error
+	}{
+		{"bearer token", token, "", nil},
+		{"stream ticket", ticket, StreamScope, nil},
+		{"expired", expired, "", ErrExpiredToken},
+		{"changed payload", parts[0] + "." + encodeClaims(t, Claims{StaffID: 1, Role: models.RoleAdmin, ExpiresAt: time.Now().Add(time.Hour).Unix()}) + "." + parts[2], "", ErrInvalidToken},
+		{"changed signature", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])), "", ErrInvalidToken},
+		{"other header", "eyJhbGciOiJub25lIn0." + parts[1] + "." + parts[2], "", ErrInvalidToken},
+		{"two parts", parts[0] + "." + parts[1], "", ErrInvalidToken},
+		{"empty", "", "", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ParseToken(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if claims.StaffID != nurse.ID || claims.Role != nurse.Role || claims.Scope != tt.scope {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestParseTokenSignedWithAnotherKey(t *testing.T) {
+	useKey(t, strings.Repeat("k", 32))
+	token, _, err := IssueToken(nurse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKey(t, strings.Repeat("j", 32))
+	if _, err := ParseToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("err = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+// TestRequireAuthScope checks where each kind of token is accepted. The
+// staff lookup that follows finds no one, so a token that got past the
+// token checks is refused as an inactive account.
+func TestRequireAuthScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useKey(t, strings.Repeat("k", 32))
+	dryRun, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}),
+		&gorm.Config{NamingStrategy: database.Naming, DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DB
+	database.DB = dryRun
+	defer func() { database.DB = previous }()
+
+	token, _, _ := IssueToken(nurse)
+	ticket, _, _ := IssueStreamTicket(nurse)
+	const accepted = "Staff account is not active"
+
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		stream bool
+		error  string
+	}{
+		{"bearer token in header", "Bearer " + token, "", false, accepted},
+		{"bearer token in header of a stream", "Bearer " + token, "", true, accepted},
+		{"stream ticket in URL of a stream", "", ticket, true, accepted},
+		{"stream ticket in header", "Bearer " + ticket, "", false, ErrInvalidToken.Error()},
+		{"stream ticket in header of a stream", "Bearer " + ticket, "", true, ErrInvalidToken.Error()},
+		{"bearer token in URL of a stream", "", token, true, ErrInvalidToken.Error()},
+		{"stream ticket in URL of another request", "", ticket, false, "Authorization token is required"},
+		{"no token", "", "", false, "Authorization token is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/", RequireAuth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			path := "/"
+			if tt.query != "" {
+				path += "?access_token=" + tt.query
+			}
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			if tt.header != "" {
+				request.Header.Set("Authorization", tt.header)
+			}
+			if tt.stream {
+				request.Header.Set("Accept", "text/event-stream")
+			}
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			var body struct{ Error string }
+			json.Unmarshal(response.Body.Bytes(), &body)
+			if response.Code != http.StatusUnauthorized || body.Error != tt.error {
+				t.Errorf("response = %d %q, want 401 %q", response.Code, body.Error, tt.error)
+			}
+		})
+	}
+}
+
+func TestLogFormatterRedactsTickets(t *testing.T) {
+	line := LogFormatter(gin.LogFormatterParams{Path: "/api/board/stream?department_id=2&access_token=secret", Method: http.MethodGet})
+	if strings.Contains(line, "secret") || !strings.Contains(line, "access_token=redacted") || !strings.Contains(line, "department_id=2") {
+		t.Errorf("log line = %q", line)
+	}
+}
+
+func encodeClaims(t *testing.T, claims Claims) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
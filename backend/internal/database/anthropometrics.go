@@ -0,0 +1,46 @@
+package database
+
+import (
+	"time"
+
+	"barman/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// BackfillAnthropometrics records the height and weight held on patient
+// records created before measurements were kept as a history, so that every
+// trend starts from the value already known. Patients with any measurement
+// are skipped, which makes it safe to run at every startup.
+func BackfillAnthropometrics(db *gorm.DB) (int, error) {
+	var users []struct {
+		ID        uint
+		Height    float64
+		Weight    float64
+		UpdatedAt time.Time
+	}
+	err := db.Model(&models.User{}).
+		Select("id", "height", "weight", "updated_at").
+		Where("height > 0 OR weight > 0").
+		Where("NOT EXISTS (SELECT 1 FROM anthropometrics WHERE anthropometrics.user_id = users.id)").
+		Find(&users).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for _, user := range users {
+		measurement := models.Anthropometric{UserID: user.ID, MeasuredAt: user.UpdatedAt, Notes: "Copied from patient record"}
+		if user.Height > 0 {
+			height := user.Height
+			measurement.HeightCm = &height
+		}
+		if user.Weight > 0 {
+			weight := user.Weight
+			measurement.WeightKg = &weight
+		}
+		if err := db.Create(&measurement).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(users), nil
+}
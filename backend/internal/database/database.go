@@ -6,13 +6,65 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 var DB *gorm.DB
 
+// Naming is how tables and columns are named. SQL that has to name a
+// table takes it from TableName so that it follows.
+var Naming = schema.NamingStrategy{}
+
+var tableNames sync.Map
+
+// TableName returns the table model is stored in
+func TableName(model interface{}) string {
+	modelSchema, err := schema.Parse(model, &tableNames, Naming)
+	if err != nil {
+		panic(err)
+	}
+	return modelSchema.Table
+}
+
+// Models are the models migrated at startup, in one list so that tests can
+// check every table
+var Models = []interface{}{
+	&models.User{},
+	&models.HereditaryDisease{},
+	&models.Disability{},
+	&models.MedicalImage{},
+	&models.Surgery{},
+	&models.Allergy{},
+	&models.ChronicCondition{},
+	&models.Visit{},
+	&triage.Triage{},
+	&triage.Observation{},
+	&models.Diagnosis{},
+	&models.Prescription{},
+	&models.Medication{},
+	&models.MedicationCatalog{},
+	&models.Appointment{},
+	&models.Facility{},
+	&models.Department{},
+	&models.Staff{},
+	&models.AuditLog{},
+	&models.Practitioner{},
+	&models.PatientMerge{},
+	&models.Anthropometric{},
+	&models.FamilyRelation{},
+	&models.EmergencyContact{},
+	&models.Insurer{},
+	&models.CoverageRule{},
+	&models.InsurancePolicy{},
+	&models.PolicyDependant{},
+	&models.EligibilityCheck{},
+	&models.PatientLifecycleEvent{},
+}
+
 func InitDB() {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		os.Getenv("DB_HOST"),
@@ -22,25 +74,13 @@ func InitDB() {
 		os.Getenv("DB_PORT"),
 	)
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{NamingStrategy: Naming})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
 	// Auto Migrate the schema
-	err = db.AutoMigrate(
-		&models.User{},
-		&models.HereditaryDisease{},
-		&models.Disability{},
-		&models.MedicalImage{},
-		&models.Surgery{},
-		&models.Visit{},
-		&triage.Triage{},
-		&models.Diagnosis{},
-		&models.Prescription{},
-		&models.Medication{},
-		&models.Appointment{},
-	)
+	err = db.AutoMigrate(Models...)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
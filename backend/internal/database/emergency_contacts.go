@@ -0,0 +1,93 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+
+	"barman/internal/fieldcrypt"
+	"barman/internal/models"
+	"barman/internal/validation"
+
+	"gorm.io/gorm"
+)
+
+// legacyContactColumns held free-text emergency contacts before they had
+// their own table
+var legacyContactColumns = []string{"emergency_contact1", "emergency_contact2"}
+
+// phonePattern finds a phone number within free text, in Latin, Persian or
+// Arabic digits
+var phonePattern = regexp.MustCompile(`\+?[0-9۰-۹٠-٩][0-9۰-۹٠-٩ \-]{6,}[0-9۰-۹٠-٩]`)
+
+// MigrateEmergencyContacts moves the free-text emergency_contact1 and
+// emergency_contact2 columns into emergency contacts with priority 1 and 2,
+// splitting out a phone number where one can be recognised, and then drops
+// the old columns. It does nothing once the columns are gone.
+func MigrateEmergencyContacts(db *gorm.DB) (int, error) {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&models.User{}, legacyContactColumns[0]) {
+		return 0, nil
+	}
+
+	migrated := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var rows []struct {
+			ID                uint
+			EmergencyContact1 fieldcrypt.EncryptedString
+			EmergencyContact2 fieldcrypt.EncryptedString
+		}
+		err := tx.Table("users").
+			Select("id", "emergency_contact1", "emergency_contact2").
+			Where("emergency_contact1 <> '' OR emergency_contact2 <> ''").
+			Find(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			for i, text := range []string{string(row.EmergencyContact1), string(row.EmergencyContact2)} {
+				if strings.TrimSpace(text) == "" {
+					continue
+				}
+				contact := legacyContact(text)
+				contact.UserID = row.ID
+				contact.Priority = i + 1
+				if err := tx.Create(&contact).Error; err != nil {
+					return err
+				}
+				migrated++
+			}
+		}
+
+		for _, column := range legacyContactColumns {
+			if err := tx.Migrator().DropColumn(&models.User{}, column); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return migrated, err
+}
+
+// legacyContact turns free text such as "Ali Rezaei 0912 123 4567" into a
+// contact. Text without a recognisable phone number is kept as the name.
+func legacyContact(text string) models.EmergencyContact {
+	contact := models.EmergencyContact{
+		Name:  fieldcrypt.EncryptedString(strings.TrimSpace(text)),
+		Notes: fieldcrypt.EncryptedString("Imported from the former emergency contact field: " + strings.TrimSpace(text)),
+	}
+	for _, match := range phonePattern.FindAllString(text, -1) {
+		phone, err := validation.Phone(match, validation.Landline)
+		if err != nil {
+			continue
+		}
+		contact.MobilePhone = fieldcrypt.EncryptedString(phone)
+		if !strings.HasPrefix(phone, "+989") {
+			contact.MobilePhone, contact.AlternatePhone = "", contact.MobilePhone
+		}
+		name := strings.Trim(strings.Replace(text, match, " ", 1), " \t,;:-()")
+		contact.Name = fieldcrypt.EncryptedString(strings.Join(strings.Fields(name), " "))
+		break
+	}
+	return contact
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"barman/internal/fieldcrypt"
+	"barman/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// encryptedUserColumns are the users columns stored as fieldcrypt ciphertext
+var encryptedUserColumns = []string{
+	"national_id",
+	"address",
+	"mobile_phone",
+	"landline_phone",
+}
+
+// ReencryptUsers rewrites every patient row that still holds plaintext or
+// ciphertext from a retired key, and fills in missing blind indexes and
+// search columns. It is run at startup and after adding a new key to
+// ENCRYPTION_KEYS.
+func ReencryptUsers(db *gorm.DB) (int, error) {
+	stale := db.Where("national_id_index IS NULL AND national_id <> ''").
+		Or("mobile_phone_index IS NULL AND mobile_phone <> ''").
+		Or("search_name IS NULL OR (search_name = '' AND (first_name <> '' OR last_name <> ''))")
+	for _, column := range encryptedUserColumns {
+		stale = stale.Or(column+" <> '' AND "+column+" NOT LIKE ?", fieldcrypt.ActivePrefix()+"%")
+	}
+	query := db.Model(&models.User{}).Unscoped().Where(stale)
+
+	updated := 0
+	var users []models.User
+	result := query.FindInBatches(&users, 100, func(tx *gorm.DB, batch int) error {
+		for i := range users {
+			if err := db.Unscoped().Save(&users[i]).Error; err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	return updated, result.Error
+}
+
+// encryptedContactColumns are the emergency_contacts columns stored as
+// fieldcrypt ciphertext
+var encryptedContactColumns = []string{
+	"name",
+	"notes",
+	"mobile_phone",
+	"alternate_phone",
+	"address",
+}
+
+// ReencryptEmergencyContacts does for emergency contacts what ReencryptUsers
+// does for patients
+func ReencryptEmergencyContacts(db *gorm.DB) (int, error) {
+	stale := db.Where("1 = 0")
+	for _, column := range encryptedContactColumns {
+		stale = stale.Or(column+" <> '' AND "+column+" NOT LIKE ?", fieldcrypt.ActivePrefix()+"%")
+	}
+	query := db.Model(&models.EmergencyContact{}).Unscoped().Where(stale)
+
+	updated := 0
+	var contacts []models.EmergencyContact
+	result := query.FindInBatches(&contacts, 100, func(tx *gorm.DB, batch int) error {
+		for i := range contacts {
+			if err := db.Unscoped().Save(&contacts[i]).Error; err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	return updated, result.Error
+}
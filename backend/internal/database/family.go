@@ -0,0 +1,37 @@
+package database
+
+import (
+	"barman/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// UniqueFamilyRelations removes repeated links between the same two
+// patients, keeping the first, and then adds a unique index so that a link
+// can only be made once. Removed links are soft-deleted. Both steps are
+// no-ops once done, which makes it safe to run at every startup.
+func UniqueFamilyRelations(db *gorm.DB) (int, error) {
+	table := TableName(&models.FamilyRelation{})
+
+	var links []models.FamilyRelation
+	err := db.Where("id IN (?)", db.Table("(?) AS numbered",
+		db.Model(&models.FamilyRelation{}).
+			Select("id, row_number() OVER (PARTITION BY user_id, relative_id ORDER BY id) AS n")).
+		Select("id").
+		Where("n > 1")).
+		Find(&links).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(links) > 0 {
+		if err := db.Delete(&links).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	// Soft-deleted links are left out so that a removed link can be made
+	// again
+	err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_family_relations_pair ON " + table +
+		" (user_id, relative_id) WHERE deleted_at IS NULL").Error
+	return len(links), err
+}
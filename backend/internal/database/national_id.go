@@ -0,0 +1,44 @@
+package database
+
+import (
+	"barman/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// legacyNationalIDIndex is the unique index on national_id_index that
+// AutoMigrate used to build, which could not be built, and made
+// re-encryption fail, when two registrations shared a national ID
+const legacyNationalIDIndex = "idx_users_national_id_index"
+
+// DropLegacyNationalIDIndex drops the unique index AutoMigrate used to build
+// on national IDs, before ReencryptUsers fills in the blind indexes of
+// legacy rows. UniqueNationalIDs adds it back once no two patients share a
+// national ID.
+func DropLegacyNationalIDIndex(db *gorm.DB) error {
+	return db.Exec("DROP INDEX IF EXISTS " + legacyNationalIDIndex).Error
+}
+
+// UniqueNationalIDs lists the patients who share a national ID with another
+// current patient, one comma-separated list of IDs per national ID. When
+// there are none it adds a unique index so that a national ID can only be
+// registered once; until then registrations are not refused, so that the
+// duplicates can be merged. It is a no-op once done, which makes it safe to
+// run at every startup.
+func UniqueNationalIDs(db *gorm.DB) ([]string, error) {
+	var duplicates []string
+	err := db.Model(&models.User{}).
+		Where("national_id_index IS NOT NULL").
+		Group("national_id_index").
+		Having("COUNT(*) > 1").
+		Order("MIN(id)").
+		Pluck("string_agg(id::text, ', ' ORDER BY id)", &duplicates).Error
+	if err != nil || len(duplicates) > 0 {
+		return duplicates, err
+	}
+
+	// Merged duplicates are soft-deleted, so they are left out
+	err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_national_id_unique ON " +
+		TableName(&models.User{}) + " (national_id_index) WHERE deleted_at IS NULL").Error
+	return nil, err
+}
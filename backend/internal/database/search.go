@@ -0,0 +1,15 @@
+package database
+
+import (
+	"gorm.io/gorm"
+)
+
+// EnableNameSearch installs the pg_trgm extension and a trigram index on
+// users.search_name, which patient search ranks candidates with before it
+// limits them. Both statements are no-ops once in place.
+func EnableNameSearch(db *gorm.DB) error {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return err
+	}
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_users_search_name_trgm ON users USING gin (search_name gin_trgm_ops)").Error
+}
@@ -0,0 +1,241 @@
+package database
+
+import (
+	"time"
+
+	"barman/internal/demographics"
+	"barman/internal/models"
+	"barman/internal/models/triage"
+
+	"gorm.io/gorm"
+)
+
+// ClearUnmeasuredVitals empties vital signs stored as 0 before triage could
+// leave them out. No living patient has a heart rate, temperature,
+// respiratory rate or oxygen saturation of 0, so a 0 meant the sign was
+// not measured. A pain level of 0 is a real answer and is kept.
+func ClearUnmeasuredVitals(db *gorm.DB) (int, error) {
+	var cleared int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, column := range []string{"heart_rate", "temperature", "respiratory_rate", "oxygen_saturation"} {
+			result := tx.Unscoped().Model(&triage.Triage{}).Where(column+" = 0").UpdateColumn(column, nil)
+			if result.Error != nil {
+				return result.Error
+			}
+			cleared += int(result.RowsAffected)
+		}
+		return nil
+	})
+	return cleared, err
+}
+
+// legacyBloodPressureColumn held blood pressure as free text such as
+// "120/80". Once migrated it is renamed to keptBloodPressureColumn, so that
+// readings that could not be parsed are not lost.
+const (
+	legacyBloodPressureColumn = "blood_pressure"
+	keptBloodPressureColumn   = "blood_pressure_text"
+)
+
+// UnparsedBloodPressure is a legacy reading the migration could not read
+type UnparsedBloodPressure struct {
+	TriageID   uint      `json:"triage_id"`
+	VisitID    uint      `json:"visit_id"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Text       string    `json:"blood_pressure_text"`
+}
+
+// MigrateBloodPressure moves the free-text blood_pressure column into the
+// bp_systolic, bp_diastolic and bp_mean_arterial_pressure columns and
+// renames it to blood_pressure_text. It returns how many readings were
+// moved and those it could not parse, which are left for someone to
+// correct. It does nothing once the column has been renamed.
+func MigrateBloodPressure(db *gorm.DB) (int, []UnparsedBloodPressure, error) {
+	if !db.Migrator().HasColumn(&triage.Triage{}, legacyBloodPressureColumn) {
+		return 0, nil, nil
+	}
+
+	migrated := 0
+	var unparsed []UnparsedBloodPressure
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var rows []struct {
+			ID            uint
+			BloodPressure string
+		}
+		err := tx.Unscoped().Model(&triage.Triage{}).
+			Select("id", legacyBloodPressureColumn).
+			Where("TRIM(COALESCE(blood_pressure, '')) <> ''").
+			Find(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			reading, err := triage.ParseBloodPressure(row.BloodPressure)
+			if err != nil {
+				unparsed = append(unparsed, UnparsedBloodPressure{TriageID: row.ID, Text: row.BloodPressure})
+				continue
+			}
+			err = tx.Unscoped().Model(&triage.Triage{}).Where("id = ?", row.ID).UpdateColumns(map[string]interface{}{
+				"bp_systolic":               reading.Systolic,
+				"bp_diastolic":              reading.Diastolic,
+				"bp_mean_arterial_pressure": reading.MeanArterialPressure,
+			}).Error
+			if err != nil {
+				return err
+			}
+			migrated++
+		}
+
+		return tx.Migrator().RenameColumn(&triage.Triage{}, legacyBloodPressureColumn, keptBloodPressureColumn)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return migrated, unparsed, nil
+}
+
+// UnparsedBloodPressures lists the legacy readings in blood_pressure_text
+// that the migration could not read and that have not been corrected
+// since, oldest first. A reading is corrected by updating the triage with
+// its systolic and diastolic pressure. db may be scoped, for instance by
+// joining visits.
+func UnparsedBloodPressures(db *gorm.DB) ([]UnparsedBloodPressure, error) {
+	if !db.Migrator().HasColumn(&triage.Triage{}, keptBloodPressureColumn) {
+		return nil, nil
+	}
+
+	table := TableName(&triage.Triage{})
+	var readings []UnparsedBloodPressure
+	err := db.Model(&triage.Triage{}).
+		Select(table + ".id AS triage_id, " + table + ".visit_id, " + table + ".created_at AS recorded_at, " +
+			table + "." + keptBloodPressureColumn + " AS text").
+		Where("TRIM(COALESCE(" + table + "." + keptBloodPressureColumn + ", '')) <> ''").
+		Where(table + ".bp_systolic IS NULL").
+		Order(table + ".id").
+		Scan(&readings).Error
+	return readings, err
+}
+
+// legacyOverrideReason explains the priorities kept from before scoring
+const legacyOverrideReason = "Priority entered before triage was scored"
+
+// legacyDefaultPriority is the priority triage was given before scoring
+// when none was chosen. It cannot be told apart from a nurse choosing it,
+// so it is taken as not chosen.
+const legacyDefaultPriority = "normal"
+
+// ScoreTriages scores triage recorded before scoring existed, using the
+// patient's age when triage was recorded. The priority the nurse chose
+// then is kept, as an override when it differs from the computed one,
+// unless it is the old default. Triage already scored is skipped, which
+// makes it safe to run at every startup. The old default kept as an
+// override by earlier versions is cleared.
+func ScoreTriages(db *gorm.DB) (int, error) {
+	err := db.Model(&triage.Triage{}).
+		Where("priority_override = ? AND override_reason = ?", legacyDefaultPriority, legacyOverrideReason).
+		UpdateColumns(map[string]interface{}{
+			"priority_override": "",
+			"override_reason":   "",
+			"priority_level":    gorm.Expr("computed_priority"),
+		}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var scored int
+	var batch []triage.Triage
+	err = db.Where("score_version IS NULL OR score_version = ''").
+		FindInBatches(&batch, 200, func(_ *gorm.DB, _ int) error {
+			for i := range batch {
+				t := &batch[i]
+				t.Patient = patientAt(db, t.VisitID, t.CreatedAt)
+
+				entered := t.PriorityLevel
+				if err := t.Score(); err != nil {
+					return err
+				}
+				if entered != "" && entered != legacyDefaultPriority && entered != t.ComputedPriority {
+					t.PriorityOverride, t.OverrideReason = entered, legacyOverrideReason
+					t.PriorityLevel = entered
+				}
+
+				err := db.Model(t).UpdateColumns(map[string]interface{}{
+					"news2":             t.NEWS2,
+					"esi_level":         t.ESILevel,
+					"computed_priority": t.ComputedPriority,
+					"score_breakdown":   t.ScoreBreakdown,
+					"score_version":     t.ScoreVersion,
+					"priority_override": t.PriorityOverride,
+					"override_reason":   t.OverrideReason,
+					"priority_level":    t.PriorityLevel,
+				}).Error
+				if err != nil {
+					return err
+				}
+				scored++
+			}
+			return nil
+		}).Error
+	return scored, err
+}
+
+// FlagVitals flags the vital signs of triage and observations saved before
+// reference ranges existed, using the patient's age when they were taken.
+// No alerts are raised for them. Readings already flagged are skipped,
+// which makes it safe to run at every startup.
+func FlagVitals(db *gorm.DB) (int, error) {
+	var flagged int
+
+	var triages []triage.Triage
+	err := db.Where("flags IS NULL").
+		FindInBatches(&triages, 200, func(_ *gorm.DB, _ int) error {
+			for i := range triages {
+				t := &triages[i]
+				t.Patient = patientAt(db, t.VisitID, t.CreatedAt)
+				if err := t.Flag(); err != nil {
+					return err
+				}
+				if err := db.Model(t).UpdateColumn("flags", t.Flags).Error; err != nil {
+					return err
+				}
+				flagged++
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return flagged, err
+	}
+
+	var observations []triage.Observation
+	err = db.Where("flags IS NULL").
+		FindInBatches(&observations, 200, func(_ *gorm.DB, _ int) error {
+			for i := range observations {
+				o := &observations[i]
+				o.Patient = patientAt(db, o.VisitID, o.ObservedAt)
+				if err := o.Flag(); err != nil {
+					return err
+				}
+				if err := db.Model(o).UpdateColumn("flags", o.Flags).Error; err != nil {
+					return err
+				}
+				flagged++
+			}
+			return nil
+		}).Error
+	return flagged, err
+}
+
+// patientAt returns the sex and age at a given time of the patient a visit
+// is for, or nil when the visit's patient cannot be found
+func patientAt(db *gorm.DB, visitID uint, at time.Time) *demographics.Patient {
+	var user models.User
+	err := db.Select("users.id", "users.sex", "users.date_of_birth").
+		Joins("JOIN visits ON visits.user_id = users.id").
+		Where("visits.id = ?", visitID).
+		First(&user).Error
+	if err != nil {
+		return nil
+	}
+	return demographics.NewPatient(user.ID, user.Sex, user.DateOfBirth, at)
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"barman/internal/models"
+	"barman/internal/models/triage"
+	"barman/internal/visitflow"
+
+	"gorm.io/gorm"
+)
+
+// legacyVisitTypes are the types visits were given by what was recorded at
+// them, before that was told by their records and state, and the kind of
+// visit each stands for. Triage was only recorded in the emergency
+// department.
+var legacyVisitTypes = map[string]string{
+	"triage":       models.VisitEmergency,
+	"doctor_visit": models.VisitRegular,
+	"prescription": models.VisitRegular,
+}
+
+// BackfillVisitFlow gives visits created before the visit flow existed a
+// state and state times worked out from their records: registered at the
+// visit date, triaged when triage was recorded and discharged when the
+// doctor's report was written. Visits with a registration time are
+// skipped, which makes it safe to run at every startup. Visits with a
+// legacy type are given the kind it stands for, and counted in retyped.
+func BackfillVisitFlow(db *gorm.DB) (updated, retyped int, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for legacy, visitType := range legacyVisitTypes {
+			result := tx.Exec(`UPDATE visits SET type = ? WHERE type = ?`, visitType, legacy)
+			if result.Error != nil {
+				return result.Error
+			}
+			retyped += int(result.RowsAffected)
+		}
+
+		// Visits are told apart by registered_at, so it is filled in last
+		err := tx.Exec(`UPDATE visits
+			SET status = ?, in_consultation_at = d.created_at, discharged_at = d.created_at
+			FROM (SELECT visit_id, MIN(created_at) AS created_at FROM diagnoses WHERE deleted_at IS NULL GROUP BY visit_id) d
+			WHERE d.visit_id = visits.id AND visits.registered_at IS NULL`, visitflow.Discharged).Error
+		if err != nil {
+			return err
+		}
+
+		// The triage table is named by the model
+		triaged := tx.Model(&triage.Triage{}).Select("visit_id, MIN(created_at) AS created_at").Group("visit_id")
+		err = tx.Exec(`UPDATE visits
+			SET triaged_at = t.created_at, status = CASE WHEN visits.status = ? THEN ? ELSE visits.status END
+			FROM (?) t
+			WHERE t.visit_id = visits.id AND visits.registered_at IS NULL`, visitflow.Registered, visitflow.Triaged, triaged).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Exec(`UPDATE visits SET registered_at = COALESCE(date, created_at) WHERE registered_at IS NULL`)
+		updated = int(result.RowsAffected)
+		return result.Error
+	})
+	return updated, retyped, err
+}
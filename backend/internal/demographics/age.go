@@ -0,0 +1,99 @@
+package demographics
+
+import (
+	"fmt"
+	"time"
+)
+
+// AgeBand groups ages for age-dependent rules such as vital sign norms
+type AgeBand string
+
+const (
+	BandNeonate AgeBand = "neonate" // first 28 days
+	BandInfant  AgeBand = "infant"  // under 1 year
+	BandChild   AgeBand = "child"   // under 18 years
+	BandAdult   AgeBand = "adult"   // under 65 years
+	BandElderly AgeBand = "elderly"
+)
+
+// Bands lists the age bands from youngest to oldest
+var Bands = []AgeBand{BandNeonate, BandInfant, BandChild, BandAdult, BandElderly}
+
+// neonatalDays is the length of the neonatal period
+const neonatalDays = 28
+
+// BandSQL is a PostgreSQL expression giving the age band, as AgeAt does, of
+// the date in column on the day bound to the named argument today. It is
+// NULL for a missing date or one after that day.
+func BandSQL(column string) string {
+	years := fmt.Sprintf("date_part('year', age(CAST(@today AS date), %s))", column)
+	return fmt.Sprintf("CASE WHEN %[1]s IS NULL OR %[1]s > CAST(@today AS date) THEN NULL"+
+		" WHEN CAST(@today AS date) - %[1]s < %[2]d THEN '%[3]s'"+
+		" WHEN %[4]s < 1 THEN '%[5]s'"+
+		" WHEN %[4]s < 18 THEN '%[6]s'"+
+		" WHEN %[4]s < 65 THEN '%[7]s'"+
+		" ELSE '%[8]s' END",
+		column, neonatalDays, BandNeonate, years, BandInfant, BandChild, BandAdult, BandElderly)
+}
+
+// Age is a patient's age on a given day. Years and Months are completed
+// years and months since birth; Days counts every day since birth. Text is
+// the form used clinically: days for neonates, months under two years and
+// years after that.
+type Age struct {
+	Years  int     `json:"years"`
+	Months int     `json:"months"`
+	Days   int     `json:"days"`
+	Text   string  `json:"text"`
+	Band   AgeBand `json:"band"`
+}
+
+// AgeAt returns the age on the day of at of someone born on birth. It
+// returns nil for a birth date after at.
+func AgeAt(birth Date, at time.Time) *Age {
+	today := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	born := birth.Time
+	if today.Before(born) {
+		return nil
+	}
+
+	months := (today.Year()-born.Year())*12 + int(today.Month()-born.Month())
+	if today.Day() < born.Day() {
+		months--
+	}
+	age := &Age{
+		Years:  months / 12,
+		Months: months,
+		Days:   int(today.Sub(born).Hours() / 24),
+	}
+
+	switch {
+	case age.Days < neonatalDays:
+		age.Text = plural(age.Days, "day")
+	case age.Months < 24:
+		age.Text = plural(age.Months, "month")
+	default:
+		age.Text = plural(age.Years, "year")
+	}
+
+	switch {
+	case age.Days < neonatalDays:
+		age.Band = BandNeonate
+	case age.Years < 1:
+		age.Band = BandInfant
+	case age.Years < 18:
+		age.Band = BandChild
+	case age.Years < 65:
+		age.Band = BandAdult
+	default:
+		age.Band = BandElderly
+	}
+	return age
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
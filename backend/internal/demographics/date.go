@@ -0,0 +1,116 @@
+// Package demographics holds the patient attributes that clinical rules
+// depend on — date of birth, sex and age — in a form shared by the patient
+// record, triage, prescribing and reporting.
+package demographics
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"barman/internal/jalali"
+	"barman/internal/textnorm"
+)
+
+// jalaliYearLimit separates the two calendars: any year below it is read
+// as Jalali (the current Jalali year is around 1400)
+const jalaliYearLimit = 1700
+
+var ErrInvalidDate = errors.New("must be a date such as 1990-05-12 or 1369/02/22")
+
+// Date is a calendar date without a time of day. It is written as a
+// Gregorian date (2006-01-02) and read from either a Gregorian or a Jalali
+// date, in ASCII or Persian digits.
+type Date struct {
+	time.Time
+}
+
+// ParseDate reads a Gregorian (1990-05-12) or Jalali (1369/02/22) date
+func ParseDate(value string) (Date, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return Date{time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}, nil
+	}
+
+	parts := strings.FieldsFunc(textnorm.Normalize(value), func(r rune) bool { return !unicode.IsDigit(r) })
+	if len(parts) != 3 {
+		return Date{}, ErrInvalidDate
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return Date{}, ErrInvalidDate
+		}
+		numbers[i] = n
+	}
+	year, month, day := numbers[0], numbers[1], numbers[2]
+
+	if year < jalaliYearLimit {
+		t, err := jalali.Date(year, month, day)
+		if err != nil {
+			return Date{}, ErrInvalidDate
+		}
+		return Date{t}, nil
+	}
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Year() != year || int(t.Month()) != month || t.Day() != day {
+		// time.Date normalises 2023-02-30 to March
+		return Date{}, ErrInvalidDate
+	}
+	return Date{t}, nil
+}
+
+// Jalali formats the date in the Jalali calendar, e.g. 1369/02/22
+func (d Date) Jalali() string {
+	return jalali.Format(d.Time)
+}
+
+func (d Date) String() string {
+	return d.Format("2006-01-02")
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return ErrInvalidDate
+	}
+	parsed, err := ParseDate(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Value implements driver.Valuer
+func (d Date) Value() (driver.Value, error) {
+	return d.Time, nil
+}
+
+// Scan implements sql.Scanner
+func (d *Date) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case time.Time:
+		d.Time = time.Date(v.Year(), v.Month(), v.Day(), 0, 0, 0, 0, time.UTC)
+		return nil
+	case string:
+		parsed, err := ParseDate(v)
+		*d = parsed
+		return err
+	case []byte:
+		parsed, err := ParseDate(string(v))
+		*d = parsed
+		return err
+	}
+	return fmt.Errorf("cannot scan %T into Date", value)
+}
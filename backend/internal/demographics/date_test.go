@@ -0,0 +1,46 @@
+package demographics
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   error
+	}{
+		{"1990-05-12", "1990-05-12", nil},
+		{"1990-05-12T08:00:00Z", "1990-05-12", nil},
+		{"1369/02/22", "1990-05-12", nil},
+		{"1369-2-22", "1990-05-12", nil},
+		{"۱۳۶۹/۰۲/۲۲", "1990-05-12", nil},
+		{"1399/12/30", "2021-03-20", nil},
+		{"1402/12/30", "", ErrInvalidDate},
+		{"2023-02-30", "", ErrInvalidDate},
+		{"1369/02", "", ErrInvalidDate},
+		{"", "", ErrInvalidDate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			date, err := ParseDate(tt.value)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && date.String() != tt.want {
+				t.Errorf("ParseDate = %s, want %s", date, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateJalali(t *testing.T) {
+	date, err := ParseDate("1990-05-12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if date.Jalali() != "1369/02/22" {
+		t.Errorf("Jalali = %s, want 1369/02/22", date.Jalali())
+	}
+}
@@ -0,0 +1,21 @@
+package demographics
+
+import "time"
+
+// Patient is the subset of a patient record needed by age- and
+// sex-dependent rules, such as pediatric dosing or vital sign norms
+type Patient struct {
+	UserID      uint   `json:"user_id"`
+	Sex         string `json:"sex,omitempty"`
+	DateOfBirth *Date  `json:"date_of_birth,omitempty"`
+	Age         *Age   `json:"age,omitempty"`
+}
+
+// NewPatient describes a patient on the day of at
+func NewPatient(userID uint, sex string, birth *Date, at time.Time) *Patient {
+	patient := &Patient{UserID: userID, Sex: sex, DateOfBirth: birth}
+	if birth != nil {
+		patient.Age = AgeAt(*birth, at)
+	}
+	return patient
+}
@@ -0,0 +1,30 @@
+package demographics
+
+import (
+	"errors"
+
+	"barman/internal/textnorm"
+)
+
+// Sex values
+const (
+	SexMale   = "male"
+	SexFemale = "female"
+	SexOther  = "other"
+)
+
+var ErrInvalidSex = errors.New("must be male, female or other")
+
+// ParseSex returns the canonical sex for English or Persian input such as
+// "F", "Male", "زن" or "مذکر"
+func ParseSex(value string) (string, error) {
+	switch textnorm.Normalize(value) {
+	case "male", "m", "مرد", "مذکر":
+		return SexMale, nil
+	case "female", "f", "زن", "مونث":
+		return SexFemale, nil
+	case "other", "o", "سایر", "دیگر":
+		return SexOther, nil
+	}
+	return "", ErrInvalidSex
+}
@@ -0,0 +1,170 @@
+// Package duplicates finds pairs of patient registrations that probably
+// belong to the same person, such as a second registration made with a typo
+// in the national ID or without the father's name. The caller finds the
+// pairs worth comparing, and this package scores them.
+package duplicates
+
+import (
+	"math"
+	"sort"
+
+	"barman/internal/textnorm"
+)
+
+// Weights of each component in a match score. Components that cannot be
+// compared because one side is blank are left out of the average.
+const (
+	weightFirstName  = 0.2
+	weightLastName   = 0.2
+	weightFatherName = 0.15
+	weightPhone      = 0.2
+	weightNationalID = 0.25
+
+	// nameOnlyFactor discounts pairs that agree on names alone, since
+	// namesakes are common
+	nameOnlyFactor = 0.8
+)
+
+// Patient holds the decrypted fields compared between registrations
+type Patient struct {
+	ID         uint
+	FirstName  string
+	LastName   string
+	FatherName string
+	NationalID string
+	Phones     []string
+}
+
+// Match is a scored pair of registrations. Components holds the similarity
+// of each field that could be compared, between 0 and 1.
+type Match struct {
+	A          uint               `json:"a"`
+	B          uint               `json:"b"`
+	Score      float64            `json:"score"`
+	Components map[string]float64 `json:"components"`
+}
+
+// Compare scores how likely a and b are the same person
+func Compare(a, b Patient) Match {
+	components := make(map[string]float64)
+	total, weights := 0.0, 0.0
+	add := func(name string, weight, score float64) {
+		components[name] = round(score)
+		total += weight * score
+		weights += weight
+	}
+
+	add("first_name", weightFirstName, nameSimilarity(a.FirstName, b.FirstName))
+	add("last_name", weightLastName, nameSimilarity(a.LastName, b.LastName))
+	nameOnly := true
+
+	if a.FatherName != "" && b.FatherName != "" {
+		add("father_name", weightFatherName, nameSimilarity(a.FatherName, b.FatherName))
+	}
+	if phonesA, phonesB := phoneKeys(a.Phones), phoneKeys(b.Phones); len(phonesA) > 0 && len(phonesB) > 0 {
+		add("phone", weightPhone, phoneSimilarity(phonesA, phonesB))
+		nameOnly = false
+	}
+	if idA, idB := textnorm.Digits(a.NationalID), textnorm.Digits(b.NationalID); idA != "" && idB != "" {
+		add("national_id", weightNationalID, nationalIDSimilarity(idA, idB))
+		nameOnly = false
+	}
+
+	score := total / weights
+	if nameOnly {
+		score *= nameOnlyFactor
+	}
+	return Match{A: a.ID, B: b.ID, Score: round(score), Components: components}
+}
+
+// Pair is two registrations that share a key, such as a phone number or a
+// similar name, and so are worth comparing
+type Pair struct {
+	A uint
+	B uint
+}
+
+// Score compares each pair of registrations and returns those scoring at
+// least minScore, best first. Pairs naming a registration missing from
+// patients are skipped.
+func Score(pairs []Pair, patients map[uint]Patient, minScore float64) []Match {
+	var matches []Match
+	for _, pair := range pairs {
+		a, okA := patients[pair.A]
+		b, okB := patients[pair.B]
+		if !okA || !okB || pair.A == pair.B {
+			continue
+		}
+		if match := Compare(a, b); match.Score >= minScore {
+			matches = append(matches, match)
+		}
+	}
+	sortMatches(matches)
+	return matches
+}
+
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		if matches[i].A != matches[j].A {
+			return matches[i].A < matches[j].A
+		}
+		return matches[i].B < matches[j].B
+	})
+}
+
+// nameSimilarity is symmetric, unlike textnorm.Similarity which favours a
+// query that is a prefix of the candidate
+func nameSimilarity(a, b string) float64 {
+	a, b = textnorm.Normalize(a), textnorm.Normalize(b)
+	return max(textnorm.Similarity(a, b), textnorm.Similarity(b, a))
+}
+
+// phoneKeys reduces phone numbers to their last ten digits so that the
+// same number written with or without a country or trunk prefix compares
+// equal
+func phoneKeys(phones []string) []string {
+	var keys []string
+	for _, phone := range phones {
+		digits := textnorm.Digits(phone)
+		if len(digits) < 7 {
+			continue
+		}
+		if len(digits) > 10 {
+			digits = digits[len(digits)-10:]
+		}
+		keys = append(keys, digits)
+	}
+	return keys
+}
+
+func phoneSimilarity(a, b []string) float64 {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// nationalIDSimilarity tolerates a single mistyped or two swapped digits
+func nationalIDSimilarity(a, b string) float64 {
+	switch distance := textnorm.EditDistance(a, b); {
+	case distance == 0:
+		return 1
+	case distance == 1:
+		return 0.85
+	case distance == 2 && len(a) == len(b):
+		return 0.7
+	default:
+		return 0
+	}
+}
+
+func round(score float64) float64 {
+	return math.Round(score*1e4) / 1e4
+}
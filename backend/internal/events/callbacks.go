@@ -0,0 +1,88 @@
+package events
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Register installs GORM callbacks that publish an event on topic whenever
+// a record of one of the models in topics is created, updated or deleted.
+// Updates that do not load a record, such as bulk updates, are not
+// published.
+func Register(db *gorm.DB, topics map[interface{}]string) error {
+	byType := make(map[reflect.Type]string, len(topics))
+	for model, topic := range topics {
+		byType[reflect.Indirect(reflect.ValueOf(model)).Type()] = topic
+	}
+
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:after_create").Register("events:after_create", publisher(byType, Created)); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:after_update").Register("events:after_update", publisher(byType, Updated)); err != nil {
+		return err
+	}
+	return cb.Delete().After("gorm:after_delete").Register("events:after_delete", publisher(byType, Deleted))
+}
+
+func publisher(topics map[reflect.Type]string, action string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		if db.Error != nil || stmt.Schema == nil {
+			return
+		}
+		topic, ok := topics[stmt.Schema.ModelType]
+		if !ok {
+			return
+		}
+
+		var events []Event
+		each(stmt, func(rv reflect.Value) {
+			id, ok := uintField(stmt, rv, stmt.Schema.PrioritizedPrimaryField)
+			if !ok {
+				return
+			}
+			event := Event{Topic: topic, Action: action, EntityID: id}
+			event.VisitID, _ = uintField(stmt, rv, stmt.Schema.LookUpField("VisitID"))
+			events = append(events, event)
+		})
+		if len(events) > 0 {
+			PublishFrom(stmt.Context, events...)
+		}
+	}
+}
+
+func each(stmt *gorm.Statement, fn func(reflect.Value)) {
+	rv := reflect.Indirect(stmt.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if item := reflect.Indirect(rv.Index(i)); item.Type() == stmt.Schema.ModelType {
+				fn(item)
+			}
+		}
+	case reflect.Struct:
+		if rv.Type() == stmt.Schema.ModelType {
+			fn(rv)
+		}
+	}
+}
+
+func uintField(stmt *gorm.Statement, rv reflect.Value, field *schema.Field) (uint, bool) {
+	if field == nil {
+		return 0, false
+	}
+	value, zero := field.ValueOf(stmt.Context, rv)
+	if zero {
+		return 0, false
+	}
+	switch v := reflect.Indirect(reflect.ValueOf(value)); v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uint(v.Uint()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint(v.Int()), v.Int() > 0
+	}
+	return 0, false
+}
@@ -0,0 +1,145 @@
+// Package events publishes changes to records as they happen so that
+// screens such as the waiting-room board can follow them live. Changes
+// made while serving a request are held until the request has succeeded,
+// so subscribers never see a change that was rolled back.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Topics
+const (
+	TopicVisit       = "visit"
+	TopicTriage      = "triage"
+	TopicAppointment = "appointment"
+	// TopicAlert carries alerts about patients, such as critical vital
+	// signs, rather than changes to records
+	TopicAlert = "alert"
+)
+
+// Actions
+const (
+	Created   = "created"
+	Updated   = "updated"
+	Deleted   = "deleted"
+	Raised    = "raised"
+	Escalated = "escalated"
+)
+
+// Event is one change. ID increases with every event published, so a
+// subscriber can tell which events a snapshot already includes.
+type Event struct {
+	ID       uint64 `json:"id"`
+	Topic    string `json:"topic"`
+	Action   string `json:"action"`
+	EntityID uint   `json:"entity_id"`
+	VisitID  uint   `json:"visit_id,omitempty"`
+	// FacilityID is the facility of the visit, so subscribers can tell
+	// whether an event is for them without a query
+	FacilityID *uint       `json:"facility_id,omitempty"`
+	At         time.Time   `json:"at"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+// Broker hands published events to its subscribers
+type Broker struct {
+	mu          sync.Mutex
+	seq         uint64
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events its filter accepts on C. C is closed
+// when the subscription is closed or when the subscriber falls so far
+// behind that events had to be dropped; it must then start again from a
+// snapshot.
+type Subscription struct {
+	C      <-chan Event
+	events chan Event
+	filter func(Event) bool
+	broker *Broker
+}
+
+// Default is the broker used by the package-level functions
+var Default = NewBroker()
+
+// NewBroker returns a broker with no subscribers
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a subscription holding up to buffer undelivered events
+// and the ID of the last event published before it, which every event it
+// receives will follow. A nil filter accepts every event.
+func (b *Broker) Subscribe(buffer int, filter func(Event) bool) (*Subscription, uint64) {
+	events := make(chan Event, buffer)
+	sub := &Subscription{C: events, events: events, filter: filter, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[sub] = struct{}{}
+	return sub, b.seq
+}
+
+// Publish numbers events and sends them to every subscriber that accepts
+// them
+func (b *Broker) Publish(events ...Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		b.seq++
+		event.ID = b.seq
+		if event.At.IsZero() {
+			event.At = time.Now()
+		}
+		for sub := range b.subscribers {
+			if sub.filter != nil && !sub.filter(event) {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				b.drop(sub)
+			}
+		}
+	}
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.drop(s)
+}
+
+func (b *Broker) drop(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Subscribe subscribes to the default broker
+func Subscribe(buffer int, filter func(Event) bool) (*Subscription, uint64) {
+	return Default.Subscribe(buffer, filter)
+}
+
+// Publish publishes to the default broker straight away. Code serving a
+// request should use PublishFrom.
+func Publish(events ...Event) {
+	Default.Publish(events...)
+}
+
+// Topics returns a filter accepting events on any of topics
+func Topics(topics ...string) func(Event) bool {
+	return func(event Event) bool {
+		for _, topic := range topics {
+			if event.Topic == topic {
+				return true
+			}
+		}
+		return false
+	}
+}
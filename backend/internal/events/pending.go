@@ -0,0 +1,53 @@
+package events
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+type contextKey int
+
+const pendingKey contextKey = iota
+
+// pending holds the events of a request until it has succeeded
+type pending struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// PublishFrom publishes events once the request ctx belongs to has
+// succeeded, or straight away outside a request
+func PublishFrom(ctx context.Context, events ...Event) {
+	if ctx != nil {
+		if held, ok := ctx.Value(pendingKey).(*pending); ok {
+			held.mu.Lock()
+			held.events = append(held.events, events...)
+			held.mu.Unlock()
+			return
+		}
+	}
+	Publish(events...)
+}
+
+// Middleware holds the events published while serving a request and
+// publishes them when it succeeds. Requests that fail have usually rolled
+// back their changes, so their events are discarded.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		held := &pending{}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), pendingKey, held))
+
+		c.Next()
+
+		held.mu.Lock()
+		events := held.events
+		held.events = nil
+		held.mu.Unlock()
+		if len(events) > 0 && c.Writer.Status() < http.StatusBadRequest {
+			Publish(events...)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Package fieldcrypt provides transparent at-rest encryption for individual
+// database columns and deterministic blind indexes for looking them up.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// prefix marks a column value as ciphertext; values without it are
+// treated as legacy plaintext so existing rows stay readable
+const prefix = "enc:"
+
+var (
+	ErrNotConfigured = errors.New("field encryption keys are not configured")
+	ErrUnknownKey    = errors.New("ciphertext was encrypted with an unknown key version")
+	ErrMalformed     = errors.New("malformed ciphertext")
+)
+
+var (
+	keys          map[string]cipher.AEAD
+	activeVersion string
+	indexKey      []byte
+)
+
+// Init loads the encryption keys from the environment.
+//
+// ENCRYPTION_KEYS is a comma-separated list of version:base64key pairs
+// holding 32-byte AES keys. The first entry encrypts new values; the rest
+// are kept only to decrypt values written before a key rotation.
+// BLIND_INDEX_KEY is a base64 key used to build lookup indexes.
+func Init() {
+	if err := Configure(os.Getenv("ENCRYPTION_KEYS"), os.Getenv("BLIND_INDEX_KEY")); err != nil {
+		log.Fatal("Invalid encryption configuration: ", err)
+	}
+}
+
+// Configure sets up the key ring from the raw configuration values
+func Configure(encryptionKeys, blindIndexKey string) error {
+	ring := make(map[string]cipher.AEAD)
+	active := ""
+
+	for _, entry := range strings.Split(encryptionKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		version, encoded, found := strings.Cut(entry, ":")
+		if !found || version == "" {
+			return fmt.Errorf("key entry %q must have the form version:base64key", entry)
+		}
+		if _, exists := ring[version]; exists {
+			return fmt.Errorf("duplicate key version %q", version)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("key version %q must be 32 bytes encoded as base64", version)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		ring[version] = aead
+		if active == "" {
+			active = version
+		}
+	}
+	if active == "" {
+		return errors.New("ENCRYPTION_KEYS is empty")
+	}
+
+	index, err := base64.StdEncoding.DecodeString(blindIndexKey)
+	if err != nil || len(index) < 32 {
+		return errors.New("BLIND_INDEX_KEY must be at least 32 bytes encoded as base64")
+	}
+
+	keys = ring
+	activeVersion = active
+	indexKey = index
+	return nil
+}
+
+// ActivePrefix is the prefix of values encrypted with the current key, used
+// to find rows that still need to be re-encrypted after a rotation
+func ActivePrefix() string {
+	return prefix + activeVersion + ":"
+}
+
+// Encrypt encrypts plaintext with the active key
+func Encrypt(plaintext string) (string, error) {
+	aead, ok := keys[activeVersion]
+	if !ok {
+		return "", ErrNotConfigured
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(activeVersion))
+	return ActivePrefix() + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt. Values without the ciphertext prefix are
+// returned unchanged.
+func Decrypt(value string) (string, error) {
+	rest, found := strings.CutPrefix(value, prefix)
+	if !found {
+		return value, nil
+	}
+
+	version, encoded, found := strings.Cut(rest, ":")
+	if !found {
+		return "", ErrMalformed
+	}
+	aead, ok := keys[version]
+	if !ok {
+		if keys == nil {
+			return "", ErrNotConfigured
+		}
+		return "", ErrUnknownKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(version))
+	if err != nil {
+		return "", ErrMalformed
+	}
+	return string(plaintext), nil
+}
+
+// BlindIndex returns a deterministic keyed hash of value so that encrypted
+// columns can still be matched exactly without storing the plaintext
+func BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EncryptedString is a string column that is encrypted when written to the
+// database and decrypted when read back. Empty strings are stored as-is.
+type EncryptedString string
+
+// Value implements driver.Valuer
+func (s EncryptedString) Value() (driver.Value, error) {
+	if s == "" {
+		return "", nil
+	}
+	return Encrypt(string(s))
+}
+
+// Scan implements sql.Scanner
+func (s *EncryptedString) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into EncryptedString", value)
+	}
+
+	plaintext, err := Decrypt(raw)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plaintext)
+	return nil
+}
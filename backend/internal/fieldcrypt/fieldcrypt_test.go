@@ -0,0 +1,171 @@
+package fieldcrypt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func configure(t *testing.T, encryptionKeys string) {
+	t.Helper()
+	if err := Configure(encryptionKeys, key('i')); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	configure(t, "v1:"+key('a'))
+
+	tests := []string{"0012345679", "09121234567", "تهران، خیابان آزادی", "a", strings.Repeat("x", 1000)}
+	for _, plaintext := range tests {
+		t.Run(plaintext[:min(len(plaintext), 20)], func(t *testing.T) {
+			ciphertext, err := Encrypt(plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Short plaintexts can turn up in base64 by chance
+			if !strings.HasPrefix(ciphertext, "enc:v1:") || len(plaintext) > 4 && strings.Contains(ciphertext, plaintext) {
+				t.Errorf("ciphertext = %q", ciphertext)
+			}
+			decrypted, err := Decrypt(ciphertext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decrypted != plaintext {
+				t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+			}
+		})
+	}
+}
+
+func TestEncryptIsRandomised(t *testing.T) {
+	configure(t, "v1:"+key('a'))
+	first, _ := Encrypt("0012345679")
+	second, _ := Encrypt("0012345679")
+	if first == second {
+		t.Error("the same plaintext was encrypted to the same ciphertext")
+	}
+}
+
+func TestDecryptAfterRotation(t *testing.T) {
+	configure(t, "v1:"+key('a'))
+	old, err := Encrypt("0012345679")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configure(t, "v2:"+key('b')+",v1:"+key('a'))
+	if !strings.HasPrefix(ActivePrefix(), "enc:v2:") {
+		t.Errorf("active prefix = %q, want the first key", ActivePrefix())
+	}
+	if decrypted, err := Decrypt(old); err != nil || decrypted != "0012345679" {
+		t.Errorf("Decrypt = %q, %v after rotation", decrypted, err)
+	}
+
+	configure(t, "v2:"+key('b'))
+	if _, err := Decrypt(old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt with the key retired = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	configure(t, "v1:"+key('a'))
+	sealed, _ := Encrypt("secret")
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, "enc:v1:"))
+	raw[len(raw)-1] ^= 1
+	tampered := "enc:v1:" + base64.StdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		err   error
+	}{
+		{"legacy plaintext", "0012345679", "0012345679", nil},
+		{"empty", "", "", nil},
+		{"no version", "enc:abc", "", ErrMalformed},
+		{"not base64", "enc:v1:!!!", "", ErrMalformed},
+		{"too short", "enc:v1:" + base64.StdEncoding.EncodeToString([]byte("short")), "", ErrMalformed},
+		{"tampered", tampered, "", ErrMalformed},
+		{"unknown key", "enc:v9:" + strings.TrimPrefix(sealed, "enc:v1:"), "", ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decrypt(tt.value)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Decrypt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncryptedStringValueAndScan(t *testing.T) {
+	configure(t, "v1:"+key('a'))
+
+	for _, plaintext := range []string{"09121234567", ""} {
+		value, err := EncryptedString(plaintext).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext == "" && value != "" {
+			t.Errorf("empty string stored as %q", value)
+		}
+		var scanned EncryptedString
+		if err := scanned.Scan([]byte(value.(string))); err != nil {
+			t.Fatal(err)
+		}
+		if string(scanned) != plaintext {
+			t.Errorf("scanned = %q, want %q", scanned, plaintext)
+		}
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	configure(t, "v1:"+key('a'))
+	index := BlindIndex("0012345679")
+	if index != BlindIndex("0012345679") {
+		t.Error("blind index is not deterministic")
+	}
+	if index == BlindIndex("0012345678") {
+		t.Error("different values share a blind index")
+	}
+	if err := Configure("v1:"+key('a'), key('j')); err != nil {
+		t.Fatal(err)
+	}
+	if index == BlindIndex("0012345679") {
+		t.Error("blind index does not depend on the key")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		name           string
+		encryptionKeys string
+		blindIndexKey  string
+		ok             bool
+	}{
+		{"one key", "v1:" + key('a'), key('i'), true},
+		{"two keys", "v2:" + key('b') + ", v1:" + key('a'), key('i'), true},
+		{"no keys", "", key('i'), false},
+		{"no version", key('a'), key('i'), false},
+		{"repeated version", "v1:" + key('a') + ",v1:" + key('b'), key('i'), false},
+		{"short key", "v1:" + base64.StdEncoding.EncodeToString([]byte("short")), key('i'), false},
+		{"short index key", "v1:" + key('a'), base64.StdEncoding.EncodeToString([]byte("short")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Configure(tt.encryptionKeys, tt.blindIndexKey)
+			if (err == nil) != tt.ok {
+				t.Errorf("Configure = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
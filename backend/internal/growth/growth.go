@@ -0,0 +1,283 @@
+// Package growth scores children's measurements against the WHO Child
+// Growth Standards using the LMS method.
+//
+// The bundled tables in tables/ are an abridged transcription of the WHO
+// LMS tables for 0 to 60 months at selected ages, with parameters
+// interpolated in between. They use the WHO file layout (Month, L, M, S),
+// so the official monthly or daily tables can be dropped into the
+// directory named by GROWTH_TABLES_DIR to replace them.
+package growth
+
+import (
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"barman/internal/demographics"
+)
+
+// Indicator is a growth measure scored against age
+type Indicator string
+
+const (
+	WeightForAge            Indicator = "weight_for_age"
+	LengthHeightForAge      Indicator = "length_height_for_age"
+	HeadCircumferenceForAge Indicator = "head_circumference_for_age"
+	BMIForAge               Indicator = "bmi_for_age"
+)
+
+// tableFiles names the file of each indicator's table, followed by
+// _boys.csv or _girls.csv. A missing table means the indicator is not
+// scored.
+var tableFiles = map[Indicator]string{
+	WeightForAge:            "wfa",
+	LengthHeightForAge:      "lhfa",
+	HeadCircumferenceForAge: "hcfa",
+	BMIForAge:               "bfa",
+}
+
+// daysPerMonth converts ages in days to the months used by the tables
+const daysPerMonth = 30.4375
+
+var (
+	ErrNoReference = errors.New("no growth reference for this age and sex")
+	ErrNoTable     = errors.New("no reference table for this indicator")
+	ErrOutsideAges = errors.New("age is outside the reference table")
+
+	//go:embed tables/*.csv
+	bundled embed.FS
+
+	loadOnce sync.Once
+	tables   map[string][]lms
+	loadErr  error
+)
+
+// lms holds the Box-Cox power (L), median (M) and coefficient of variation
+// (S) of a measurement at one age
+type lms struct {
+	month   float64
+	l, m, s float64
+}
+
+// Result is a measurement's position on the reference distribution
+type Result struct {
+	Indicator  Indicator `json:"indicator"`
+	Value      float64   `json:"value"`
+	ZScore     float64   `json:"z_score"`
+	Percentile float64   `json:"percentile"`
+}
+
+// Load reads the reference tables, from GROWTH_TABLES_DIR when it is set
+// and from the bundled tables otherwise. It is called on first use and may
+// be called at startup to surface errors early.
+func Load() error {
+	loadOnce.Do(func() {
+		var files fs.FS
+		if dir := os.Getenv("GROWTH_TABLES_DIR"); dir != "" {
+			files = os.DirFS(dir)
+		} else {
+			files, _ = fs.Sub(bundled, "tables")
+		}
+		tables, loadErr = readTables(files)
+	})
+	return loadErr
+}
+
+func readTables(files fs.FS) (map[string][]lms, error) {
+	loaded := make(map[string][]lms)
+	for _, prefix := range tableFiles {
+		for _, sex := range []string{"boys", "girls"} {
+			name := prefix + "_" + sex + ".csv"
+			file, err := files.Open(name)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			rows, err := readTable(file)
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			loaded[name] = rows
+		}
+	}
+	return loaded, nil
+}
+
+// readTable parses a WHO LMS table. The age column may be Month or Day;
+// any further columns, such as the SD or percentile curves, are ignored.
+func readTable(r io.Reader) ([]lms, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("table is empty")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	ageColumn, perDay := columns["month"], false
+	if _, ok := columns["month"]; !ok {
+		if ageColumn, perDay = columns["day"]; !perDay {
+			return nil, errors.New("missing Month or Day column")
+		}
+	}
+	for _, name := range []string{"l", "m", "s"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing %s column", strings.ToUpper(name))
+		}
+	}
+
+	rows := make([]lms, 0, len(records)-1)
+	for line, record := range records[1:] {
+		var values [4]float64
+		for i, column := range []int{ageColumn, columns["l"], columns["m"], columns["s"]} {
+			if column >= len(record) {
+				return nil, fmt.Errorf("line %d: too few columns", line+2)
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(record[column]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line+2, err)
+			}
+			values[i] = value
+		}
+		if perDay {
+			values[0] /= daysPerMonth
+		}
+		rows = append(rows, lms{month: values[0], l: values[1], m: values[2], s: values[3]})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].month < rows[j].month })
+	return rows, nil
+}
+
+// NotScored is a measurement that has no reference to be scored against,
+// such as BMI without a BMI-for-age table or a measurement taken past the
+// ages a table covers
+type NotScored struct {
+	Indicator Indicator `json:"indicator"`
+	Value     float64   `json:"value"`
+	Reason    string    `json:"reason"`
+}
+
+// Assess scores a measurement taken at ageDays old. value is in kg for
+// weight, cm for length, height and head circumference, and kg/m² for BMI.
+// It returns ErrNoTable or ErrOutsideAges when there is nothing to score
+// the measurement against.
+func Assess(indicator Indicator, sex string, ageDays int, value float64) (*Result, error) {
+	if err := Load(); err != nil {
+		return nil, err
+	}
+
+	var suffix string
+	switch sex {
+	case demographics.SexMale:
+		suffix = "_boys.csv"
+	case demographics.SexFemale:
+		suffix = "_girls.csv"
+	default:
+		return nil, ErrNoReference
+	}
+	rows, ok := tables[tableFiles[indicator]+suffix]
+	if !ok {
+		return nil, ErrNoTable
+	}
+
+	params, ok := interpolate(rows, float64(ageDays)/daysPerMonth)
+	if !ok {
+		return nil, ErrOutsideAges
+	}
+
+	z := params.zScore(value)
+	return &Result{
+		Indicator:  indicator,
+		Value:      value,
+		ZScore:     math.Round(z*100) / 100,
+		Percentile: math.Round(percentile(z)*10) / 10,
+	}, nil
+}
+
+// All lists every indicator, whether or not it has a reference table
+var All = []Indicator{WeightForAge, LengthHeightForAge, HeadCircumferenceForAge, BMIForAge}
+
+// Indicators lists the indicators that have reference tables
+func Indicators() []Indicator {
+	if Load() != nil {
+		return nil
+	}
+	var available []Indicator
+	for _, indicator := range All {
+		if _, ok := tables[tableFiles[indicator]+"_boys.csv"]; ok {
+			available = append(available, indicator)
+		}
+	}
+	return available
+}
+
+// interpolate returns the LMS parameters at an age in months, linearly
+// interpolated between the surrounding rows of the table
+func interpolate(rows []lms, month float64) (lms, bool) {
+	if len(rows) == 0 || month < rows[0].month || month > rows[len(rows)-1].month {
+		return lms{}, false
+	}
+	i := sort.Search(len(rows), func(i int) bool { return rows[i].month >= month })
+	if rows[i].month == month || i == 0 {
+		return rows[i], true
+	}
+
+	before, after := rows[i-1], rows[i]
+	t := (month - before.month) / (after.month - before.month)
+	mix := func(a, b float64) float64 { return a + t*(b-a) }
+	return lms{month: month, l: mix(before.l, after.l), m: mix(before.m, after.m), s: mix(before.s, after.s)}, true
+}
+
+// zScore applies the LMS formula. For skewed measures (L ≠ 1), WHO fixes
+// the distance between SD lines beyond ±3 SD so that extreme values are
+// not compressed.
+func (p lms) zScore(value float64) float64 {
+	z := p.z(value)
+	if p.l == 1 || math.Abs(z) <= 3 {
+		return z
+	}
+	if z > 3 {
+		sd3, sd2 := p.value(3), p.value(2)
+		return 3 + (value-sd3)/(sd3-sd2)
+	}
+	sd3, sd2 := p.value(-3), p.value(-2)
+	return -3 + (value-sd3)/(sd2-sd3)
+}
+
+func (p lms) z(value float64) float64 {
+	if p.l == 0 {
+		return math.Log(value/p.m) / p.s
+	}
+	return (math.Pow(value/p.m, p.l) - 1) / (p.l * p.s)
+}
+
+// value is the measurement at z standard deviations
+func (p lms) value(z float64) float64 {
+	if p.l == 0 {
+		return p.m * math.Exp(p.s*z)
+	}
+	return p.m * math.Pow(1+p.l*p.s*z, 1/p.l)
+}
+
+// percentile converts a z-score to a percentile of the normal distribution
+func percentile(z float64) float64 {
+	return 50 * (1 + math.Erf(z/math.Sqrt2))
+}
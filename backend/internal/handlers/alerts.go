@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"barman/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamAlerts streams alerts as Server-Sent Events, such as a triage or
+// observation saved with vital signs in the critical range. Only alerts
+// about visits at the signed-in staff member's facility are sent. Alerts
+// are not kept, so a client only receives those raised while it is
+// connected.
+func StreamAlerts(c *gin.Context) {
+	sub, seq := events.Subscribe(256, events.Topics(events.TopicAlert))
+	defer sub.Close()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	if err := writeServerEvent(c, seq, "ready", gin.H{}); err != nil {
+		return
+	}
+
+	facilityID := currentFacilityID(c)
+	heartbeat := time.NewTicker(boardHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !alertVisible(facilityID, event) {
+				continue
+			}
+			if err := writeServerEvent(c, event.ID, event.Action, event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// alertVisible reports whether an alert is about a visit at facilityID,
+// the signed-in staff member's facility. Staff without one see every alert.
+func alertVisible(facilityID *uint, event events.Event) bool {
+	if event.VisitID == 0 || facilityID == nil {
+		return true
+	}
+	return event.FacilityID != nil && *event.FacilityID == *facilityID
+}
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"barman/internal/auth"
+	"barman/internal/demographics"
+	"barman/internal/growth"
+	"barman/internal/listing"
+	"barman/internal/models"
+	"barman/internal/models/triage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errMeasurementMismatch = errors.New("Triage, visit and patient do not match")
+
+// trendPoint is a measurement with its change since the previous one
+type trendPoint struct {
+	models.Anthropometric
+	WeightChangeKg    *float64 `json:"weight_change_kg,omitempty"`
+	HeightChangeCm    *float64 `json:"height_change_cm,omitempty"`
+	BMIChange         *float64 `json:"bmi_change,omitempty"`
+	DaysSincePrevious *int     `json:"days_since_previous,omitempty"`
+}
+
+type trendSummary struct {
+	FirstMeasuredAt     *time.Time `json:"first_measured_at"`
+	LastMeasuredAt      *time.Time `json:"last_measured_at"`
+	WeightChangeKg      *float64   `json:"weight_change_kg,omitempty"`
+	WeightChangePercent *float64   `json:"weight_change_percent,omitempty"`
+	HeightChangeCm      *float64   `json:"height_change_cm,omitempty"`
+	BMIChange           *float64   `json:"bmi_change,omitempty"`
+}
+
+type growthPoint struct {
+	MeasurementID uint               `json:"measurement_id"`
+	MeasuredAt    time.Time          `json:"measured_at"`
+	Age           *demographics.Age  `json:"age"`
+	Results       []growth.Result    `json:"results"`
+	NotScored     []growth.NotScored `json:"not_scored,omitempty"`
+}
+
+func RecordAnthropometrics(c *gin.Context) {
+	var measurement models.Anthropometric
+	if err := c.ShouldBindJSON(&measurement); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A triage record identifies the visit, and a visit the patient
+	if measurement.TriageID != nil {
+		var triageData triage.Triage
+		if err := db(c).First(&triageData, *measurement.TriageID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid triage ID"})
+			return
+		}
+		if measurement.VisitID != nil && *measurement.VisitID != triageData.VisitID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMeasurementMismatch.Error()})
+			return
+		}
+		measurement.VisitID = &triageData.VisitID
+	}
+	if measurement.VisitID != nil {
+		var visit models.Visit
+		if err := db(c).First(&visit, *measurement.VisitID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid visit ID"})
+			return
+		}
+		if measurement.UserID != 0 && measurement.UserID != visit.UserID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMeasurementMismatch.Error()})
+			return
+		}
+		measurement.UserID = visit.UserID
+	}
+	if measurement.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID, visit ID or triage ID is required"})
+		return
+	}
+
+	if measurement.MeasuredAt.IsZero() {
+		measurement.MeasuredAt = time.Now()
+	}
+	if staff := auth.CurrentStaff(c); staff != nil {
+		measurement.RecordedByID = &staff.ID
+	}
+	if err := measurement.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&measurement).Error; err != nil {
+			return err
+		}
+		return updateCurrentMeasurements(tx, measurement.UserID)
+	})
+	if errors.Is(err, errPatientNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, measurement)
+}
+
+func GetUserAnthropometrics(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	page, err := listing.Find[models.Anthropometric](c, db(c).Where("user_id = ?", userID), listing.Spec{
+		Sorts:       map[string]string{"measured_at": "measured_at"},
+		DefaultSort: "-measured_at",
+		Filters: map[string]listing.Filter{
+			"visit_id":  listing.Equals("visit_id"),
+			"date_from": listing.From("measured_at"),
+			"date_to":   listing.To("measured_at"),
+		},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func DeleteAnthropometric(c *gin.Context) {
+	id := c.Param("id")
+	var measurement models.Anthropometric
+
+	if err := db(c).First(&measurement, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Measurement not found"})
+		return
+	}
+
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&measurement).Error; err != nil {
+			return err
+		}
+		return updateCurrentMeasurements(tx, measurement.UserID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Measurement deleted successfully"})
+}
+
+// GetAnthropometricTrend returns a patient's measurements oldest first, each
+// with its change since the previous value, and the change over the period
+func GetAnthropometricTrend(c *gin.Context) {
+	query, ok := userMeasurements(c)
+	if !ok {
+		return
+	}
+
+	var measurements []models.Anthropometric
+	if err := query.Order("measured_at ASC, id ASC").Find(&measurements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	points := make([]trendPoint, len(measurements))
+	var lastWeight, lastHeight, lastBMI *float64
+	var lastAt *time.Time
+	var summary trendSummary
+	var firstWeight, firstHeight, firstBMI *float64
+
+	for i, measurement := range measurements {
+		point := trendPoint{Anthropometric: measurement}
+		point.WeightChangeKg = change(lastWeight, measurement.WeightKg)
+		point.HeightChangeCm = change(lastHeight, measurement.HeightCm)
+		point.BMIChange = change(lastBMI, measurement.BMI)
+		if lastAt != nil {
+			days := int(measurement.MeasuredAt.Sub(*lastAt).Hours() / 24)
+			point.DaysSincePrevious = &days
+		}
+		points[i] = point
+
+		lastWeight = latest(lastWeight, measurement.WeightKg)
+		lastHeight = latest(lastHeight, measurement.HeightCm)
+		lastBMI = latest(lastBMI, measurement.BMI)
+		firstWeight = earliest(firstWeight, measurement.WeightKg)
+		firstHeight = earliest(firstHeight, measurement.HeightCm)
+		firstBMI = earliest(firstBMI, measurement.BMI)
+		measuredAt := measurement.MeasuredAt
+		lastAt = &measuredAt
+	}
+
+	if len(measurements) > 0 {
+		summary.FirstMeasuredAt = &measurements[0].MeasuredAt
+		summary.LastMeasuredAt = &measurements[len(measurements)-1].MeasuredAt
+		summary.WeightChangeKg = change(firstWeight, lastWeight)
+		summary.HeightChangeCm = change(firstHeight, lastHeight)
+		summary.BMIChange = change(firstBMI, lastBMI)
+		if summary.WeightChangeKg != nil && *firstWeight > 0 {
+			percent := round1(*summary.WeightChangeKg / *firstWeight * 100)
+			summary.WeightChangePercent = &percent
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"points": points, "summary": summary})
+}
+
+// GetGrowthAssessment scores a child's measurements against the WHO growth
+// standards. Measurements taken outside the ages covered by the reference
+// tables are left out.
+func GetGrowthAssessment(c *gin.Context) {
+	query, ok := userMeasurements(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	patient, err := patientDemographics(c, uint(userID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if patient.DateOfBirth == nil || (patient.Sex != demographics.SexMale && patient.Sex != demographics.SexFemale) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Growth charts need the patient's date of birth and sex"})
+		return
+	}
+
+	var measurements []models.Anthropometric
+	if err := query.Order("measured_at ASC, id ASC").Find(&measurements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	points := make([]growthPoint, 0, len(measurements))
+	for _, measurement := range measurements {
+		age := demographics.AgeAt(*patient.DateOfBirth, measurement.MeasuredAt)
+		if age == nil {
+			continue
+		}
+
+		values := map[growth.Indicator]*float64{
+			growth.WeightForAge:            measurement.WeightKg,
+			growth.LengthHeightForAge:      measurement.HeightCm,
+			growth.HeadCircumferenceForAge: measurement.HeadCircumferenceCm,
+			growth.BMIForAge:               measurement.BMI,
+		}
+		point := growthPoint{
+			MeasurementID: measurement.ID,
+			MeasuredAt:    measurement.MeasuredAt,
+			Age:           age,
+			Results:       []growth.Result{},
+		}
+		for _, indicator := range growth.All {
+			value := values[indicator]
+			if value == nil {
+				continue
+			}
+			result, err := growth.Assess(indicator, patient.Sex, age.Days, *value)
+			switch {
+			case errors.Is(err, growth.ErrNoTable), errors.Is(err, growth.ErrOutsideAges):
+				point.NotScored = append(point.NotScored, growth.NotScored{Indicator: indicator, Value: *value, Reason: err.Error()})
+			case err != nil:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			default:
+				point.Results = append(point.Results, *result)
+			}
+		}
+		if len(point.Results) == 0 && len(point.NotScored) == 0 {
+			continue
+		}
+		points = append(points, point)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"patient":    patient,
+		"reference":  "WHO Child Growth Standards, 0 to 60 months",
+		"indicators": growth.Indicators(),
+		"points":     points,
+	})
+}
+
+// userMeasurements starts a query on a patient's measurements within the
+// optional date_from and date_to parameters
+func userMeasurements(c *gin.Context) (*gorm.DB, bool) {
+	query := db(c).Where("user_id = ?", c.Param("user_id"))
+	for param, filter := range map[string]listing.Filter{
+		"date_from": listing.From("measured_at"),
+		"date_to":   listing.To("measured_at"),
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		filtered, err := filter(query, value)
+		if err != nil {
+			listError(c, &listing.ParamError{Param: param, Message: err.Error()})
+			return nil, false
+		}
+		query = filtered
+	}
+	return query, true
+}
+
+// updateCurrentMeasurements copies a patient's most recent height and
+// weight onto the patient record
+func updateCurrentMeasurements(tx *gorm.DB, userID uint) error {
+	var user models.User
+	if err := tx.First(&user, userID).Error; err != nil {
+		return errPatientNotFound
+	}
+
+	current := func(column string) float64 {
+		var values []float64
+		tx.Model(&models.Anthropometric{}).
+			Where("user_id = ? AND "+column+" IS NOT NULL", userID).
+			Order("measured_at DESC, id DESC").
+			Limit(1).
+			Pluck(column, &values)
+		if len(values) == 0 {
+			return 0
+		}
+		return values[0]
+	}
+
+	height, weight := current("height_cm"), current("weight_kg")
+	if height == user.Height && weight == user.Weight {
+		return nil
+	}
+	return tx.Model(&user).Updates(map[string]interface{}{"height": height, "weight": weight}).Error
+}
+
+// measurementsFromUser returns the height and weight entered on the patient
+// record as a measurement, or nil when neither changed
+func measurementsFromUser(c *gin.Context, user *models.User, previousHeight, previousWeight float64) *models.Anthropometric {
+	measurement := models.Anthropometric{UserID: user.ID, MeasuredAt: time.Now()}
+	if user.Height != 0 && user.Height != previousHeight {
+		height := user.Height
+		measurement.HeightCm = &height
+	}
+	if user.Weight != 0 && user.Weight != previousWeight {
+		weight := user.Weight
+		measurement.WeightKg = &weight
+	}
+	if measurement.HeightCm == nil && measurement.WeightKg == nil {
+		return nil
+	}
+	if staff := auth.CurrentStaff(c); staff != nil {
+		measurement.RecordedByID = &staff.ID
+	}
+	return &measurement
+}
+
+func change(from, to *float64) *float64 {
+	if from == nil || to == nil {
+		return nil
+	}
+	delta := round1(*to - *from)
+	return &delta
+}
+
+func latest(current, next *float64) *float64 {
+	if next != nil {
+		return next
+	}
+	return current
+}
+
+func earliest(current, next *float64) *float64 {
+	if current != nil {
+		return current
+	}
+	return next
+}
+
+func round1(value float64) float64 {
+	return math.Round(value*10) / 10
+}
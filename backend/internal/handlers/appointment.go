@@ -4,7 +4,7 @@ import (
 	"net/http"
 	"time"
 
-	"barman/internal/database"
+	"barman/internal/listing"
 	"barman/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -23,7 +23,17 @@ func CreateAppointment(c *gin.Context) {
 		return
 	}
 
-	result := database.DB.Create(&appointment)
+	if err := assignFacility(c, &appointment.FacilityID, &appointment.DepartmentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := resolvePractitioner(c, &appointment.PractitionerID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := db(c).Create(&appointment)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
@@ -36,7 +46,7 @@ func GetAppointment(c *gin.Context) {
 	id := c.Param("id")
 	var appointment models.Appointment
 
-	result := database.DB.First(&appointment, id)
+	result := db(c).Preload("Practitioner").First(&appointment, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
 		return
@@ -49,7 +59,7 @@ func UpdateAppointment(c *gin.Context) {
 	id := c.Param("id")
 	var appointment models.Appointment
 
-	if err := database.DB.First(&appointment, id).Error; err != nil {
+	if err := db(c).First(&appointment, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
 		return
 	}
@@ -65,7 +75,14 @@ func UpdateAppointment(c *gin.Context) {
 		return
 	}
 
-	database.DB.Save(&appointment)
+	if appointment.PractitionerID != nil {
+		if _, err := resolvePractitioner(c, &appointment.PractitionerID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	db(c).Save(&appointment)
 	c.JSON(http.StatusOK, appointment)
 }
 
@@ -73,43 +90,56 @@ func DeleteAppointment(c *gin.Context) {
 	id := c.Param("id")
 	var appointment models.Appointment
 
-	if err := database.DB.First(&appointment, id).Error; err != nil {
+	if err := db(c).First(&appointment, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
 		return
 	}
 
-	database.DB.Delete(&appointment)
+	db(c).Delete(&appointment)
 	c.JSON(http.StatusOK, gin.H{"message": "Appointment deleted successfully"})
 }
 
+var appointmentListSpec = listing.Spec{
+	Sorts:       map[string]string{"date": "date", "created_at": "created_at"},
+	DefaultSort: "date",
+	Filters: map[string]listing.Filter{
+		"status":          listing.OneOf("status"),
+		"practitioner_id": listing.Equals("practitioner_id"),
+		"department_id":   listing.Equals("department_id"),
+		"date_from":       listing.From("date"),
+		"date_to":         listing.To("date"),
+	},
+	Preloads: []string{"Practitioner"},
+}
+
 func GetUserAppointments(c *gin.Context) {
 	userID := c.Param("user_id")
-	var appointments []models.Appointment
 
-	result := database.DB.Where("user_id = ? AND date >= ?", userID, time.Now()).
-		Order("date ASC").
-		Find(&appointments)
+	query := db(c).Where("user_id = ?", userID)
+	if c.Query("date_from") == "" && c.Query("date_to") == "" {
+		// Only upcoming appointments unless a date range is asked for
+		query = query.Where("date >= ?", time.Now())
+	}
 
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	page, err := listing.Find[models.Appointment](c, query, appointmentListSpec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, appointments)
+	c.JSON(http.StatusOK, page)
 }
 
 func GetUpcomingAppointments(c *gin.Context) {
-	var appointments []models.Appointment
-
-	result := database.DB.Where("date >= ?", time.Now()).
-		Preload("User").
-		Order("date ASC").
-		Find(&appointments)
+	query := db(c).Where("date >= ?", time.Now()).Scopes(facilityScope(c, "appointments"))
 
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	spec := appointmentListSpec
+	spec.Preloads = []string{"User", "Practitioner"}
+	page, err := listing.Find[models.Appointment](c, query, spec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, appointments)
+	c.JSON(http.StatusOK, page)
 }
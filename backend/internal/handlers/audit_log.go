@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+
+	"barman/internal/listing"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAuditLogs lists audit entries filtered by patient, actor, action,
+// entity type and date range, newest first
+func GetAuditLogs(c *gin.Context) {
+	query := db(c).Model(&models.AuditLog{})
+
+	if patientID := c.Query("patient_id"); patientID != "" {
+		query = query.Where("patient_id = ?", patientID)
+	}
+	if actorID := c.Query("actor_id"); actorID != "" {
+		query = query.Where("actor_id = ?", actorID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if entityType := c.Query("entity_type"); entityType != "" {
+		query = query.Where("entity_type = ?", entityType)
+	}
+
+	page, err := listing.Find[models.AuditLog](c, query, listing.Spec{
+		Sorts:       map[string]string{"created_at": "created_at"},
+		DefaultSort: "-created_at",
+		Filters: map[string]listing.Filter{
+			"from":      listing.From("created_at"),
+			"to":        listing.To("created_at"),
+			"date_from": listing.From("created_at"),
+			"date_to":   listing.To("created_at"),
+		},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
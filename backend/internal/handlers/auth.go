@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"barman/internal/auth"
+	"barman/internal/database"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Login(c *gin.Context) {
+	var credentials struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var staff models.Staff
+	err := database.DB.Where("username = ?", credentials.Username).First(&staff).Error
+	if err != nil || !staff.Active || !auth.CheckPassword(staff.PasswordHash, credentials.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+
+	token, expiresAt, err := auth.IssueToken(&staff)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"expires_at": expiresAt,
+		"staff":      staff,
+	})
+}
+
+// IssueStreamTicket issues a ticket for opening an event stream, passed as
+// ?access_token= by browsers, which cannot set headers on an EventSource
+func IssueStreamTicket(c *gin.Context) {
+	ticket, expiresAt, err := auth.IssueStreamTicket(auth.CurrentStaff(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_at": expiresAt,
+	})
+}
+
+func GetCurrentStaff(c *gin.Context) {
+	c.JSON(http.StatusOK, auth.CurrentStaff(c))
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"barman/internal/audit"
+	"barman/internal/events"
+	"barman/internal/models"
+	"barman/internal/visitflow"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// boardHeartbeat keeps idle board streams from being closed by proxies
+const boardHeartbeat = 15 * time.Second
+
+// boardQueues are the visit states shown on each queue of the board
+var boardQueues = map[string][]string{
+	"triage": {visitflow.Registered, visitflow.WaitingTriage},
+	"doctor": {visitflow.Triaged, visitflow.WaitingDoctor, visitflow.InConsultation},
+	"all": {visitflow.Registered, visitflow.WaitingTriage, visitflow.Triaged,
+		visitflow.WaitingDoctor, visitflow.InConsultation},
+}
+
+// boardFilter is what a board shows: visits in the queue's states and the
+// day's appointments, for one department or all of them
+type boardFilter struct {
+	DepartmentID *uint
+	Queue        string
+}
+
+// boardVisit is a patient waiting or being seen
+type boardVisit struct {
+	VisitID        uint                 `json:"visit_id"`
+	UserID         uint                 `json:"user_id"`
+	PatientName    string               `json:"patient_name"`
+	Type           string               `json:"type"`
+	Status         string               `json:"status"`
+	PriorityLevel  string               `json:"priority_level,omitempty"`
+	DepartmentID   *uint                `json:"department_id"`
+	PractitionerID *uint                `json:"practitioner_id"`
+	RegisteredAt   *time.Time           `json:"registered_at"`
+	Durations      *visitflow.Durations `json:"durations"`
+}
+
+// boardAppointment is an appointment due today
+type boardAppointment struct {
+	AppointmentID  uint      `json:"appointment_id"`
+	UserID         uint      `json:"user_id"`
+	PatientName    string    `json:"patient_name"`
+	Date           time.Time `json:"date"`
+	Status         string    `json:"status"`
+	DepartmentID   *uint     `json:"department_id"`
+	PractitionerID *uint     `json:"practitioner_id"`
+}
+
+type boardSnapshot struct {
+	Visits       []boardVisit       `json:"visits"`
+	Appointments []boardAppointment `json:"appointments"`
+}
+
+// boardDelta is a change to one entry of the board. Entries are upserted
+// with their new value or removed when they no longer belong on the board.
+type boardDelta struct {
+	Action        string            `json:"action"` // upsert, remove
+	VisitID       uint              `json:"visit_id,omitempty"`
+	AppointmentID uint              `json:"appointment_id,omitempty"`
+	Visit         *boardVisit       `json:"visit,omitempty"`
+	Appointment   *boardAppointment `json:"appointment,omitempty"`
+}
+
+// GetBoard returns what a waiting-room board shows, for clients that poll.
+// ?queue= is triage, doctor or all (the default) and ?department_id= limits
+// it to one department.
+func GetBoard(c *gin.Context) {
+	filter, err := boardRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	snapshot, err := loadBoard(c, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// StreamBoard streams a board as Server-Sent Events. The first event is a
+// snapshot of the whole board; visit and appointment events then upsert or
+// remove single entries. Every connection starts with a fresh snapshot, so
+// a client that reconnects replaces what it had. Event IDs increase, and
+// deltas with an ID at or below the snapshot's are already included in it.
+func StreamBoard(c *gin.Context) {
+	filter, err := boardRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Subscribe before loading the snapshot so no change falls between them
+	sub, seq := events.Subscribe(256, events.Topics(events.TopicVisit, events.TopicTriage, events.TopicAppointment))
+	defer sub.Close()
+
+	snapshot, err := loadBoard(c, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	if err := writeServerEvent(c, seq, "snapshot", snapshot); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(boardHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			// Patients shown on the board are audited as the stream goes
+			audit.FlushReads(c)
+		case event, ok := <-sub.C:
+			if !ok {
+				// Fell behind; the client reconnects and gets a new snapshot
+				return
+			}
+			name, delta, err := boardChange(c, filter, event)
+			if err != nil {
+				return
+			}
+			if name == "" {
+				continue
+			}
+			if err := writeServerEvent(c, event.ID, name, delta); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func boardRequest(c *gin.Context) (boardFilter, error) {
+	filter := boardFilter{Queue: c.DefaultQuery("queue", "all")}
+	if _, ok := boardQueues[filter.Queue]; !ok {
+		return filter, errors.New("queue must be triage, doctor or all")
+	}
+	if value := c.Query("department_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return filter, errors.New("Invalid department ID")
+		}
+		departmentID := uint(id)
+		filter.DepartmentID = &departmentID
+	}
+	return filter, nil
+}
+
+// boardVisits selects the visits on a board
+func boardVisits(c *gin.Context, filter boardFilter) *gorm.DB {
+	query := db(c).Preload("User").Preload("TriageData").
+		Scopes(facilityScope(c, "visits")).
+		Where("visits.status IN ?", boardQueues[filter.Queue])
+	if filter.DepartmentID != nil {
+		query = query.Where("visits.department_id = ?", *filter.DepartmentID)
+	}
+	return query
+}
+
+// boardAppointments selects the appointments on a board, those due today
+// that are neither completed nor cancelled
+func boardAppointments(c *gin.Context, filter boardFilter) *gorm.DB {
+	today, tomorrow := localDay(time.Now())
+	query := db(c).Preload("User").
+		Scopes(facilityScope(c, "appointments")).
+		Where("appointments.date >= ? AND appointments.date < ?", today, tomorrow).
+		Where("appointments.status NOT IN ?", []string{"completed", "cancelled"})
+	if filter.DepartmentID != nil {
+		query = query.Where("appointments.department_id = ?", *filter.DepartmentID)
+	}
+	return query
+}
+
+func loadBoard(c *gin.Context, filter boardFilter) (boardSnapshot, error) {
+	var visits []models.Visit
+	if err := boardVisits(c, filter).Order("visits.registered_at").Find(&visits).Error; err != nil {
+		return boardSnapshot{}, err
+	}
+	var appointments []models.Appointment
+	if err := boardAppointments(c, filter).Order("appointments.date").Find(&appointments).Error; err != nil {
+		return boardSnapshot{}, err
+	}
+
+	snapshot := boardSnapshot{
+		Visits:       make([]boardVisit, len(visits)),
+		Appointments: make([]boardAppointment, len(appointments)),
+	}
+	for i := range visits {
+		snapshot.Visits[i] = toBoardVisit(&visits[i])
+	}
+	for i := range appointments {
+		snapshot.Appointments[i] = toBoardAppointment(&appointments[i])
+	}
+	return snapshot, nil
+}
+
+// boardChange turns an event into the change it makes to a board, reading
+// the entry afresh so that it reflects everything committed so far. Events
+// that touch no board entry give no name.
+func boardChange(c *gin.Context, filter boardFilter, event events.Event) (string, boardDelta, error) {
+	if event.Topic == events.TopicAppointment {
+		delta := boardDelta{Action: "remove", AppointmentID: event.EntityID}
+		var appointment models.Appointment
+		err := boardAppointments(c, filter).Where("appointments.id = ?", event.EntityID).First(&appointment).Error
+		switch {
+		case err == nil:
+			entry := toBoardAppointment(&appointment)
+			delta.Action, delta.Appointment = "upsert", &entry
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return "", delta, err
+		}
+		return "appointment", delta, nil
+	}
+
+	visitID := event.EntityID
+	if event.Topic != events.TopicVisit {
+		visitID = event.VisitID
+	}
+	if visitID == 0 {
+		return "", boardDelta{}, nil
+	}
+	delta := boardDelta{Action: "remove", VisitID: visitID}
+	var visit models.Visit
+	err := boardVisits(c, filter).Where("visits.id = ?", visitID).First(&visit).Error
+	switch {
+	case err == nil:
+		entry := toBoardVisit(&visit)
+		delta.Action, delta.Visit = "upsert", &entry
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return "", delta, err
+	}
+	return "visit", delta, nil
+}
+
+func toBoardVisit(visit *models.Visit) boardVisit {
+	entry := boardVisit{
+		VisitID:        visit.ID,
+		UserID:         visit.UserID,
+		Type:           visit.Type,
+		Status:         visit.Status,
+		DepartmentID:   visit.DepartmentID,
+		PractitionerID: visit.PractitionerID,
+		RegisteredAt:   visit.RegisteredAt,
+		Durations:      visit.Durations,
+	}
+	if visit.User != nil {
+		entry.PatientName = patientName(visit.User)
+	}
+	if visit.TriageData != nil {
+		entry.PriorityLevel = visit.TriageData.PriorityLevel
+	}
+	return entry
+}
+
+func toBoardAppointment(appointment *models.Appointment) boardAppointment {
+	entry := boardAppointment{
+		AppointmentID:  appointment.ID,
+		UserID:         appointment.UserID,
+		Date:           appointment.Date,
+		Status:         appointment.Status,
+		DepartmentID:   appointment.DepartmentID,
+		PractitionerID: appointment.PractitionerID,
+	}
+	if appointment.User != nil {
+		entry.PatientName = patientName(appointment.User)
+	}
+	return entry
+}
+
+func patientName(user *models.User) string {
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+// writeServerEvent sends one Server-Sent Event and flushes it to the client
+func writeServerEvent(c *gin.Context, id uint64, name string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", id, name, payload); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
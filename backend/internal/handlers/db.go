@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"barman/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// db returns the database handle bound to the request context so that
+// audit callbacks can attribute reads and writes to the signed-in staff
+func db(c *gin.Context) *gorm.DB {
+	return database.DB.WithContext(c.Request.Context())
+}
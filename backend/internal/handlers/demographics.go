@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"barman/internal/demographics"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// patientDemographics loads the sex and age of a patient for age- and
+// sex-dependent rules
+func patientDemographics(c *gin.Context, userID uint) (*demographics.Patient, error) {
+	var user models.User
+	if err := db(c).Select("id", "sex", "date_of_birth").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	return user.Demographics(), nil
+}
+
+// visitDemographics loads the sex and age of the patient seen in a visit
+func visitDemographics(c *gin.Context, visitID uint) (*demographics.Patient, error) {
+	var user models.User
+	err := db(c).Select("users.id", "users.sex", "users.date_of_birth").
+		Joins("JOIN visits ON visits.user_id = users.id").
+		Where("visits.id = ?", visitID).
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return user.Demographics(), nil
+}
@@ -3,7 +3,7 @@ package handlers
 import (
 	"net/http"
 
-	"barman/internal/database"
+	"barman/internal/listing"
 	"barman/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -16,7 +16,12 @@ func CreateDoctorReport(c *gin.Context) {
 		return
 	}
 
-	result := database.DB.Create(&diagnosis)
+	if _, err := resolvePractitioner(c, &diagnosis.PractitionerID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := db(c).Create(&diagnosis)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
@@ -29,7 +34,7 @@ func GetDoctorReport(c *gin.Context) {
 	id := c.Param("id")
 	var diagnosis models.Diagnosis
 
-	result := database.DB.First(&diagnosis, id)
+	result := db(c).Preload("Practitioner").First(&diagnosis, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor report not found"})
 		return
@@ -42,7 +47,7 @@ func UpdateDoctorReport(c *gin.Context) {
 	id := c.Param("id")
 	var diagnosis models.Diagnosis
 
-	if err := database.DB.First(&diagnosis, id).Error; err != nil {
+	if err := db(c).First(&diagnosis, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor report not found"})
 		return
 	}
@@ -52,7 +57,14 @@ func UpdateDoctorReport(c *gin.Context) {
 		return
 	}
 
-	database.DB.Save(&diagnosis)
+	if diagnosis.PractitionerID != nil {
+		if _, err := resolvePractitioner(c, &diagnosis.PractitionerID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	db(c).Save(&diagnosis)
 	c.JSON(http.StatusOK, diagnosis)
 }
 
@@ -60,12 +72,12 @@ func DeleteDoctorReport(c *gin.Context) {
 	id := c.Param("id")
 	var diagnosis models.Diagnosis
 
-	if err := database.DB.First(&diagnosis, id).Error; err != nil {
+	if err := db(c).First(&diagnosis, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor report not found"})
 		return
 	}
 
-	database.DB.Delete(&diagnosis)
+	db(c).Delete(&diagnosis)
 	c.JSON(http.StatusOK, gin.H{"message": "Doctor report deleted successfully"})
 }
 
@@ -73,7 +85,7 @@ func GetVisitDoctorReport(c *gin.Context) {
 	visitID := c.Param("visit_id")
 	var diagnosis models.Diagnosis
 
-	result := database.DB.Where("visit_id = ?", visitID).First(&diagnosis)
+	result := db(c).Where("visit_id = ?", visitID).Preload("Practitioner").First(&diagnosis)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor report not found for this visit"})
 		return
@@ -84,16 +96,25 @@ func GetVisitDoctorReport(c *gin.Context) {
 
 func GetUserDoctorReports(c *gin.Context) {
 	userID := c.Param("user_id")
-	var diagnoses []models.Diagnosis
-
-	result := database.DB.Where("user_id = ?", userID).
-		Order("created_at DESC").
-		Find(&diagnoses)
 
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	// Diagnoses belong to a visit, so filter on the visit's patient
+	query := db(c).Joins("JOIN visits ON visits.id = diagnoses.visit_id").
+		Where("visits.user_id = ?", userID)
+
+	page, err := listing.Find[models.Diagnosis](c, query, listing.Spec{
+		Sorts:       map[string]string{"created_at": "created_at"},
+		DefaultSort: "-created_at",
+		Filters: map[string]listing.Filter{
+			"practitioner_id": listing.Equals("diagnoses.practitioner_id"),
+			"date_from":       listing.From("diagnoses.created_at"),
+			"date_to":         listing.To("diagnoses.created_at"),
+		},
+		Preloads: []string{"Practitioner"},
+	})
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, diagnoses)
+	c.JSON(http.StatusOK, page)
 }
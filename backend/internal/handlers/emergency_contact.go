@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errContactNotFound = errors.New("Emergency contact not found")
+
+func AddEmergencyContact(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var contact models.EmergencyContact
+	if err := c.ShouldBindJSON(&contact); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	contact.UserID = uint(userID)
+	if contact.Priority == 0 {
+		// Called last; reorderContacts numbers it after the others
+		contact.Priority = math.MaxInt32
+	}
+	if err := contact.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("id = ?", contact.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errPatientNotFound
+		}
+		if err := tx.Create(&contact).Error; err != nil {
+			return err
+		}
+		return reorderContacts(tx, contact.UserID, &contact)
+	})
+	if errors.Is(err, errPatientNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, contact)
+}
+
+// GetEmergencyContacts lists a patient's contacts in the order they are to
+// be called
+func GetEmergencyContacts(c *gin.Context) {
+	contacts, err := emergencyContacts(db(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, contacts)
+}
+
+func UpdateEmergencyContact(c *gin.Context) {
+	var contact models.EmergencyContact
+
+	if err := db(c).Where("user_id = ?", c.Param("id")).First(&contact, c.Param("contact_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errContactNotFound.Error()})
+		return
+	}
+	userID := contact.UserID
+
+	if err := c.ShouldBindJSON(&contact); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	contact.UserID = userID
+	if err := contact.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&contact).Error; err != nil {
+			return err
+		}
+		return reorderContacts(tx, contact.UserID, &contact)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+func DeleteEmergencyContact(c *gin.Context) {
+	var contact models.EmergencyContact
+
+	if err := db(c).Where("user_id = ?", c.Param("id")).First(&contact, c.Param("contact_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errContactNotFound.Error()})
+		return
+	}
+
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&contact).Error; err != nil {
+			return err
+		}
+		return reorderContacts(tx, contact.UserID, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Emergency contact deleted successfully"})
+}
+
+// emergencyContacts loads a patient's contacts in priority order
+func emergencyContacts(tx *gorm.DB, userID interface{}) ([]models.EmergencyContact, error) {
+	contacts := []models.EmergencyContact{}
+	err := tx.Where("user_id = ?", userID).Order("priority, id").Find(&contacts).Error
+	return contacts, err
+}
+
+// reorderContacts numbers a patient's contacts 1, 2, 3... without gaps.
+// A contact just saved takes the priority it was given, moving the others
+// down, or goes last when that is past the end.
+func reorderContacts(tx *gorm.DB, userID uint, saved *models.EmergencyContact) error {
+	contacts, err := emergencyContacts(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	ordered := make([]*models.EmergencyContact, 0, len(contacts))
+	for i := range contacts {
+		if saved == nil || contacts[i].ID != saved.ID {
+			ordered = append(ordered, &contacts[i])
+		}
+	}
+	if saved != nil {
+		position := len(ordered)
+		if saved.Priority > 0 && saved.Priority <= len(ordered) {
+			position = saved.Priority - 1
+		}
+		ordered = append(ordered[:position], append([]*models.EmergencyContact{saved}, ordered[position:]...)...)
+	}
+
+	for i, contact := range ordered {
+		if contact.Priority == i+1 {
+			continue
+		}
+		contact.Priority = i + 1
+		if err := tx.Model(contact).Update("priority", contact.Priority).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachEmergencyContacts adds the patient's emergency contacts to
+// emergency visits, so that staff can reach family straight away
+func attachEmergencyContacts(c *gin.Context, visits ...*models.Visit) error {
+	var userIDs []uint
+	for _, visit := range visits {
+		if visit.Type == models.VisitEmergency {
+			userIDs = append(userIDs, visit.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	var contacts []models.EmergencyContact
+	if err := db(c).Where("user_id IN ?", userIDs).Order("priority, id").Find(&contacts).Error; err != nil {
+		return err
+	}
+	byUser := make(map[uint][]models.EmergencyContact)
+	for _, contact := range contacts {
+		byUser[contact.UserID] = append(byUser[contact.UserID], contact)
+	}
+	for _, visit := range visits {
+		if visit.Type == models.VisitEmergency {
+			visit.EmergencyContacts = byUser[visit.UserID]
+			if visit.EmergencyContacts == nil {
+				visit.EmergencyContacts = []models.EmergencyContact{}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"barman/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReencryptPatientData re-encrypts patient fields with the active key,
+// typically after a new key has been added to ENCRYPTION_KEYS
+func ReencryptPatientData(c *gin.Context) {
+	updated, err := database.ReencryptUsers(db(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "updated": updated})
+		return
+	}
+	contacts, err := database.ReencryptEmergencyContacts(db(c))
+	updated += contacts
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "updated": updated})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"barman/internal/auth"
+	"barman/internal/listing"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errDepartmentNotFound      = errors.New("Department not found")
+	errDepartmentNotInFacility = errors.New("Department does not belong to this facility")
+	errOtherFacility           = errors.New("You cannot create records for another facility")
+)
+
+// Facility handlers
+
+func CreateFacility(c *gin.Context) {
+	var facility models.Facility
+	if err := c.ShouldBindJSON(&facility); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if facility.Name == "" || facility.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and code are required"})
+		return
+	}
+
+	result := db(c).Create(&facility)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, facility)
+}
+
+func GetAllFacilities(c *gin.Context) {
+	page, err := listing.Find[models.Facility](c, db(c), listing.Spec{
+		Sorts:       map[string]string{"name": "name", "code": "code"},
+		DefaultSort: "name",
+		Filters:     map[string]listing.Filter{"active": listing.Equals("active")},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func GetFacility(c *gin.Context) {
+	id := c.Param("id")
+	var facility models.Facility
+
+	result := db(c).Preload("Departments").First(&facility, id)
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Facility not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, facility)
+}
+
+func UpdateFacility(c *gin.Context) {
+	id := c.Param("id")
+	var facility models.Facility
+
+	if err := db(c).First(&facility, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Facility not found"})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&facility); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db(c).Save(&facility)
+	c.JSON(http.StatusOK, facility)
+}
+
+func DeleteFacility(c *gin.Context) {
+	id := c.Param("id")
+	var facility models.Facility
+
+	if err := db(c).First(&facility, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Facility not found"})
+		return
+	}
+
+	db(c).Delete(&facility)
+	c.JSON(http.StatusOK, gin.H{"message": "Facility deleted successfully"})
+}
+
+// Department handlers
+
+func AddDepartment(c *gin.Context) {
+	var department models.Department
+	if err := c.ShouldBindJSON(&department); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var facility models.Facility
+	if err := db(c).First(&facility, department.FacilityID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid facility ID"})
+		return
+	}
+
+	result := db(c).Create(&department)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, department)
+}
+
+func GetDepartment(c *gin.Context) {
+	id := c.Param("id")
+	var department models.Department
+
+	result := db(c).Preload("Facility").First(&department, id)
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, department)
+}
+
+func UpdateDepartment(c *gin.Context) {
+	id := c.Param("id")
+	var department models.Department
+
+	if err := db(c).First(&department, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&department); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db(c).Save(&department)
+	c.JSON(http.StatusOK, department)
+}
+
+func DeleteDepartment(c *gin.Context) {
+	id := c.Param("id")
+	var department models.Department
+
+	if err := db(c).First(&department, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+		return
+	}
+
+	db(c).Delete(&department)
+	c.JSON(http.StatusOK, gin.H{"message": "Department deleted successfully"})
+}
+
+func GetFacilityDepartments(c *gin.Context) {
+	facilityID := c.Param("id")
+
+	page, err := listing.Find[models.Department](c, db(c).Where("facility_id = ?", facilityID), listing.Spec{
+		Sorts:       map[string]string{"name": "name", "code": "code"},
+		DefaultSort: "name",
+		Filters:     map[string]listing.Filter{"active": listing.Equals("active")},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// currentFacilityID returns the facility a request is scoped to. Staff
+// assigned to a facility always see that facility; staff without one (such
+// as organisation-wide admins) may pick one with ?facility_id= or see all
+// facilities when it is omitted, in which case nil is returned.
+func currentFacilityID(c *gin.Context) *uint {
+	if staff := auth.CurrentStaff(c); staff != nil && staff.FacilityID != nil {
+		return staff.FacilityID
+	}
+	if id, err := strconv.ParseUint(c.Query("facility_id"), 10, 64); err == nil {
+		facilityID := uint(id)
+		return &facilityID
+	}
+	return nil
+}
+
+// facilityScope limits a query on table to the request's facility
+func facilityScope(c *gin.Context, table string) func(*gorm.DB) *gorm.DB {
+	facilityID := currentFacilityID(c)
+	return func(tx *gorm.DB) *gorm.DB {
+		if facilityID == nil {
+			return tx
+		}
+		return tx.Where(table+".facility_id = ?", *facilityID)
+	}
+}
+
+// assignFacility fills in the facility of a new visit or appointment from
+// the signed-in staff member and checks that the department, if any, belongs
+// to that facility
+func assignFacility(c *gin.Context, facilityID, departmentID **uint) error {
+	if *facilityID == nil {
+		*facilityID = currentFacilityID(c)
+	} else if staff := auth.CurrentStaff(c); staff != nil && staff.FacilityID != nil && *staff.FacilityID != **facilityID {
+		return errOtherFacility
+	}
+	if *departmentID == nil {
+		return nil
+	}
+
+	var department models.Department
+	if err := db(c).First(&department, **departmentID).Error; err != nil {
+		return errDepartmentNotFound
+	}
+	if *facilityID == nil {
+		*facilityID = &department.FacilityID
+	} else if **facilityID != department.FacilityID {
+		return errDepartmentNotInFacility
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"barman/internal/demographics"
+	"barman/internal/models"
+	"barman/internal/pedigree"
+	"barman/internal/textnorm"
+	"barman/internal/validation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultPedigreeDegree reaches first cousins, grandparents' siblings
+	// and great-grandparents
+	defaultPedigreeDegree = 3
+	maxPedigreeDegree     = 6
+)
+
+var (
+	errRelativeNotFound = errors.New("Relative not found")
+	errAlreadyRelated   = errors.New("Patients are already linked")
+)
+
+// pedigreePerson is a patient in a pedigree
+type pedigreePerson struct {
+	UserID        uint   `json:"user_id"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Sex           string `json:"sex,omitempty"`
+	Relationship  string `json:"relationship"`
+	Path          string `json:"path,omitempty"`
+	Degree        int    `json:"degree"`
+	Line          string `json:"line,omitempty"`
+	BloodRelative bool   `json:"blood_relative"`
+}
+
+// affectedRelative is one family member with a hereditary condition. UserID
+// is empty for relatives who are not registered as patients, and Degree for
+// registered relatives outside the pedigree.
+type affectedRelative struct {
+	HereditaryDiseaseID uint   `json:"hereditary_disease_id"`
+	RecordedForID       uint   `json:"recorded_for_id"`
+	UserID              *uint  `json:"user_id,omitempty"`
+	FirstName           string `json:"first_name,omitempty"`
+	LastName            string `json:"last_name,omitempty"`
+	Relationship        string `json:"relationship"`
+	Degree              *int   `json:"degree,omitempty"`
+	Line                string `json:"line,omitempty"`
+	AgeAtOnset          *int   `json:"age_at_onset,omitempty"`
+}
+
+type pedigreeCondition struct {
+	Name string `json:"name"`
+	// ClosestDegree is the degree of the closest affected relative
+	ClosestDegree *int               `json:"closest_degree,omitempty"`
+	FirstDegree   int                `json:"first_degree_relatives"`
+	Affected      []affectedRelative `json:"affected"`
+}
+
+// AddFamilyRelation links the patient in the URL to another patient and
+// records the inverse link on the relative
+func AddFamilyRelation(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var relation models.FamilyRelation
+	if err := c.ShouldBindJSON(&relation); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	relation.UserID = uint(userID)
+	if err := relation.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("id = ?", relation.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errPatientNotFound
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", relation.RelativeID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errRelativeNotFound
+		}
+
+		if err := tx.Model(&models.FamilyRelation{}).
+			Where("user_id = ? AND relative_id = ?", relation.UserID, relation.RelativeID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errAlreadyRelated
+		}
+
+		inverse := models.FamilyRelation{
+			UserID:       relation.RelativeID,
+			RelativeID:   relation.UserID,
+			Relationship: pedigree.Inverse(relation.Relationship),
+			Notes:        relation.Notes,
+		}
+		// A link made at the same time by another request breaks the
+		// unique index
+		if err := tx.Create(&relation).Error; err != nil {
+			if isUniqueViolation(err) {
+				return errAlreadyRelated
+			}
+			return err
+		}
+		if err := tx.Create(&inverse).Error; err != nil {
+			if isUniqueViolation(err) {
+				return errAlreadyRelated
+			}
+			return err
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errPatientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, errRelativeNotFound):
+		validationError(c, validation.Errors{"relative_id": "is not a registered patient"})
+	case errors.Is(err, errAlreadyRelated):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusCreated, relation)
+	}
+}
+
+func GetFamilyRelations(c *gin.Context) {
+	var relations []models.FamilyRelation
+	result := db(c).Preload("Relative").
+		Where("user_id = ?", c.Param("id")).
+		Order("relationship, relative_id").
+		Find(&relations)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, relations)
+}
+
+// DeleteFamilyRelation removes the link between two patients from both sides
+func DeleteFamilyRelation(c *gin.Context) {
+	userID, relativeID := c.Param("id"), c.Param("relative_id")
+
+	var relations []models.FamilyRelation
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("(user_id = ? AND relative_id = ?) OR (user_id = ? AND relative_id = ?)",
+			userID, relativeID, relativeID, userID).Find(&relations).Error
+		if err != nil {
+			return err
+		}
+		if len(relations) == 0 {
+			return errRelativeNotFound
+		}
+		for i := range relations {
+			if err := tx.Delete(&relations[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errRelativeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Family relation not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Family relation deleted successfully"})
+	}
+}
+
+// GetPedigree walks the patient's family links up to ?max_degree= (default
+// 3) and groups the hereditary conditions recorded for the patient and
+// their blood relatives by condition, closest affected relative first.
+func GetPedigree(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	patientID := uint(userID)
+
+	maxDegree := defaultPedigreeDegree
+	if value := c.Query("max_degree"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPedigreeDegree {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("max_degree must be between 1 and %d", maxPedigreeDegree)})
+			return
+		}
+		maxDegree = parsed
+	}
+
+	relatives, err := pedigree.Walk(patientID, maxDegree, func(ids []uint) ([]pedigree.Link, error) {
+		var relations []models.FamilyRelation
+		if err := db(c).Where("user_id IN ?", ids).Find(&relations).Error; err != nil {
+			return nil, err
+		}
+		links := make([]pedigree.Link, len(relations))
+		for i, relation := range relations {
+			links[i] = pedigree.Link{From: relation.UserID, To: relation.RelativeID, Relationship: relation.Relationship}
+		}
+		return links, nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Names and sex of everyone in the pedigree
+	ids := []uint{patientID}
+	for _, relative := range relatives {
+		ids = append(ids, relative.ID)
+		if relative.Via != 0 {
+			ids = append(ids, relative.Via)
+		}
+	}
+	var users []models.User
+	if err := db(c).Select("id", "first_name", "last_name", "sex").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	byID := make(map[uint]models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+	patient, ok := byID[patientID]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	kinships := map[uint]pedigree.Kinship{patientID: {}}
+	members := []uint{patientID}
+	people := make([]pedigreePerson, 0, len(relatives))
+	for _, relative := range relatives {
+		user := byID[relative.ID]
+		person := pedigreePerson{
+			UserID:        relative.ID,
+			FirstName:     user.FirstName,
+			LastName:      user.LastName,
+			Sex:           user.Sex,
+			Relationship:  pedigree.Spouse,
+			BloodRelative: relative.BloodRelative,
+		}
+		if relative.BloodRelative {
+			relative.Kinship.Line = lineOf(relative, byID)
+			kinships[relative.ID] = relative.Kinship
+			members = append(members, relative.ID)
+			person.Relationship = relative.Kinship.Name()
+			person.Path = pedigree.Describe(relative.Steps)
+			person.Degree = relative.Kinship.Degree()
+			person.Line = relative.Kinship.Line
+		}
+		people = append(people, person)
+	}
+
+	var diseases []models.HereditaryDisease
+	if err := db(c).Where("user_id IN ?", members).Order("id").Find(&diseases).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Registered relatives named in the patient's own family history who
+	// are not linked within the pedigree
+	var outside []uint
+	for _, disease := range diseases {
+		if disease.RelativeID != nil {
+			if _, ok := byID[*disease.RelativeID]; !ok {
+				outside = append(outside, *disease.RelativeID)
+			}
+		}
+	}
+	if len(outside) > 0 {
+		var others []models.User
+		if err := db(c).Select("id", "first_name", "last_name", "sex").Where("id IN ?", outside).Find(&others).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, user := range others {
+			byID[user.ID] = user
+		}
+	}
+
+	conditions := make(map[string]*pedigreeCondition)
+	var order []string
+	seen := make(map[string]bool)
+	for _, disease := range diseases {
+		affected, key, ok := affectedBy(disease, patientID, kinships, byID)
+		if !ok {
+			continue
+		}
+
+		name := textnorm.Normalize(disease.Name)
+		if seen[name+"|"+key] {
+			continue
+		}
+		seen[name+"|"+key] = true
+
+		condition, exists := conditions[name]
+		if !exists {
+			condition = &pedigreeCondition{Name: disease.Name}
+			conditions[name] = condition
+			order = append(order, name)
+		}
+		condition.Affected = append(condition.Affected, affected)
+		if affected.Degree != nil {
+			if condition.ClosestDegree == nil || *affected.Degree < *condition.ClosestDegree {
+				degree := *affected.Degree
+				condition.ClosestDegree = &degree
+			}
+			if *affected.Degree == 1 {
+				condition.FirstDegree++
+			}
+		}
+	}
+
+	result := make([]pedigreeCondition, 0, len(order))
+	for _, name := range order {
+		condition := conditions[name]
+		sort.SliceStable(condition.Affected, func(i, j int) bool {
+			return degreeOrMax(condition.Affected[i].Degree) < degreeOrMax(condition.Affected[j].Degree)
+		})
+		result = append(result, *condition)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := degreeOrMax(result[i].ClosestDegree), degreeOrMax(result[j].ClosestDegree)
+		if a != b {
+			return a < b
+		}
+		return len(result[i].Affected) > len(result[j].Affected)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"patient": pedigreePerson{
+			UserID:        patient.ID,
+			FirstName:     patient.FirstName,
+			LastName:      patient.LastName,
+			Sex:           patient.Sex,
+			Relationship:  "self",
+			BloodRelative: true,
+		},
+		// Relatives are listed closest first, spouses last
+		"relatives":  people,
+		"conditions": result,
+		"max_degree": maxDegree,
+	})
+}
+
+// affectedBy works out who a hereditary disease record is about and how
+// they are related to the patient. The key identifies the affected person
+// so that a condition recorded both on a relative's own history and on the
+// patient's is counted once.
+func affectedBy(disease models.HereditaryDisease, patientID uint, kinships map[uint]pedigree.Kinship, users map[uint]models.User) (affectedRelative, string, bool) {
+	affected := affectedRelative{
+		HereditaryDiseaseID: disease.ID,
+		RecordedForID:       disease.UserID,
+		AgeAtOnset:          disease.AgeAtOnset,
+	}
+
+	var personID uint
+	switch {
+	case disease.RelativeID != nil:
+		personID = *disease.RelativeID
+	case disease.Relationship != "":
+		// Unregistered relatives are described relative to whoever's
+		// history they were recorded in, so only the patient's own apply
+		kinship, ok := pedigree.Named(disease.Relationship)
+		if !ok || disease.UserID != patientID {
+			return affected, "", false
+		}
+		degree := kinship.Degree()
+		affected.Relationship = kinship.Name()
+		affected.Degree = &degree
+		affected.Line = kinship.Line
+		return affected, "named:" + disease.Relationship + ":" + strconv.FormatUint(uint64(disease.ID), 10), true
+	default:
+		personID = disease.UserID
+	}
+
+	affected.UserID = &personID
+	if user, ok := users[personID]; ok {
+		affected.FirstName, affected.LastName = user.FirstName, user.LastName
+	}
+	key := "user:" + strconv.FormatUint(uint64(personID), 10)
+
+	if kinship, ok := kinships[personID]; ok {
+		degree := kinship.Degree()
+		affected.Relationship = kinship.Name()
+		affected.Degree = &degree
+		affected.Line = kinship.Line
+		return affected, key, true
+	}
+
+	// A registered relative who is not linked closely enough to appear in
+	// the pedigree; the relationship recorded by the patient still applies
+	if disease.UserID != patientID {
+		return affected, "", false
+	}
+	affected.Relationship = "relative"
+	if kinship, ok := pedigree.Named(disease.Relationship); ok {
+		degree := kinship.Degree()
+		affected.Relationship = kinship.Name()
+		affected.Degree = &degree
+		affected.Line = kinship.Line
+	}
+	return affected, key, true
+}
+
+// lineOf is maternal or paternal for relatives reached through one of the
+// patient's parents, when that parent's sex is known. Siblings and the
+// patient's descendants belong to neither line.
+func lineOf(relative pedigree.Relative, users map[uint]models.User) string {
+	if relative.Via == 0 || (relative.Kinship.Up < 2 && relative.Kinship.Down > 0) {
+		return ""
+	}
+	switch users[relative.Via].Sex {
+	case demographics.SexFemale:
+		return pedigree.LineMaternal
+	case demographics.SexMale:
+		return pedigree.LinePaternal
+	}
+	return ""
+}
+
+func degreeOrMax(degree *int) int {
+	if degree == nil {
+		return maxPedigreeDegree + 1
+	}
+	return *degree
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint
+// violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
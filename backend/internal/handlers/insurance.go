@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"barman/internal/insurance"
+	"barman/internal/listing"
+	"barman/internal/models"
+	"barman/internal/validation"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// eligibilityTimeout bounds how long a visit or prescription waits for an
+// insurer to answer
+const eligibilityTimeout = 5 * time.Second
+
+var (
+	errInsurerNotFound = errors.New("Insurer not found")
+	errPolicyNotFound  = errors.New("Insurance policy not found")
+)
+
+// Insurer handlers
+
+func CreateInsurer(c *gin.Context) {
+	// Active unless the request says otherwise
+	insurer := models.Insurer{Active: true}
+	if err := c.ShouldBindJSON(&insurer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	insurer.CoverageRules = nil
+	if err := insurer.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+
+	result := db(c).Create(&insurer)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, insurer)
+}
+
+func GetAllInsurers(c *gin.Context) {
+	page, err := listing.Find[models.Insurer](c, db(c), listing.Spec{
+		Sorts:       map[string]string{"name": "name", "code": "code"},
+		DefaultSort: "name",
+		Filters:     map[string]listing.Filter{"active": listing.Equals("active")},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func GetInsurer(c *gin.Context) {
+	id := c.Param("id")
+	var insurer models.Insurer
+
+	result := db(c).Preload("CoverageRules").First(&insurer, id)
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errInsurerNotFound.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, insurer)
+}
+
+func UpdateInsurer(c *gin.Context) {
+	id := c.Param("id")
+	var insurer models.Insurer
+
+	if err := db(c).First(&insurer, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errInsurerNotFound.Error()})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&insurer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	insurer.CoverageRules = nil
+	if err := insurer.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+
+	db(c).Save(&insurer)
+	c.JSON(http.StatusOK, insurer)
+}
+
+// Coverage rule handlers
+
+func AddCoverageRule(c *gin.Context) {
+	var insurer models.Insurer
+	if err := db(c).First(&insurer, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errInsurerNotFound.Error()})
+		return
+	}
+
+	var rule models.CoverageRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.InsurerID = insurer.ID
+	if err := rule.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+
+	result := db(c).Create(&rule)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+func DeleteCoverageRule(c *gin.Context) {
+	var rule models.CoverageRule
+
+	if err := db(c).Where("insurer_id = ?", c.Param("id")).First(&rule, c.Param("rule_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coverage rule not found"})
+		return
+	}
+
+	db(c).Delete(&rule)
+	c.JSON(http.StatusOK, gin.H{"message": "Coverage rule deleted successfully"})
+}
+
+// Policy handlers
+
+// AddInsurancePolicy records a policy held by the patient in the URL,
+// together with its dependants
+func AddInsurancePolicy(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	// Active unless the request says otherwise
+	policy := models.InsurancePolicy{Active: true}
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	policy.UserID = uint(userID)
+	if !validPolicy(c, &policy) {
+		return
+	}
+
+	var count int64
+	db(c).Model(&models.User{}).Where("id = ?", policy.UserID).Count(&count)
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Dependants are created along with the policy
+	result := db(c).Create(&policy)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, policy)
+}
+
+// GetUserInsurancePolicies lists the policies a patient holds or is a
+// dependant on
+func GetUserInsurancePolicies(c *gin.Context) {
+	userID := c.Param("id")
+
+	var policies []models.InsurancePolicy
+	result := db(c).Preload("Insurer").Preload("Dependants").
+		Where("user_id = ? OR id IN (?)", userID,
+			db(c).Model(&models.PolicyDependant{}).Select("policy_id").Where("user_id = ?", userID)).
+		Order("active DESC, valid_to DESC NULLS FIRST, id DESC").
+		Find(&policies)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, policies)
+}
+
+// UpdateInsurancePolicy updates a policy; the dependants given replace the
+// existing ones
+func UpdateInsurancePolicy(c *gin.Context) {
+	id := c.Param("id")
+	var policy models.InsurancePolicy
+
+	if err := db(c).First(&policy, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errPolicyNotFound.Error()})
+		return
+	}
+	holderID := policy.UserID
+
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	policy.UserID = holderID
+	if !validPolicy(c, &policy) {
+		return
+	}
+
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		var existing []models.PolicyDependant
+		if err := tx.Where("policy_id = ?", policy.ID).Find(&existing).Error; err != nil {
+			return err
+		}
+		for i := range existing {
+			if err := tx.Delete(&existing[i]).Error; err != nil {
+				return err
+			}
+		}
+		for i := range policy.Dependants {
+			policy.Dependants[i].ID = 0
+			policy.Dependants[i].PolicyID = policy.ID
+		}
+		return tx.Omit("Insurer", "User").Save(&policy).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+func DeleteInsurancePolicy(c *gin.Context) {
+	id := c.Param("id")
+	var policy models.InsurancePolicy
+
+	if err := db(c).First(&policy, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errPolicyNotFound.Error()})
+		return
+	}
+
+	db(c).Delete(&policy)
+	c.JSON(http.StatusOK, gin.H{"message": "Insurance policy deleted successfully"})
+}
+
+// validPolicy validates a policy against its insurer and checks that the
+// dependants are registered patients, answering 400 otherwise
+func validPolicy(c *gin.Context, policy *models.InsurancePolicy) bool {
+	// Only IDs are taken from the request, never nested records
+	policy.Insurer, policy.User = nil, nil
+	for i := range policy.Dependants {
+		policy.Dependants[i].User = nil
+	}
+
+	var insurer models.Insurer
+	if err := db(c).First(&insurer, policy.InsurerID).Error; err != nil {
+		validationError(c, validation.Errors{"insurer_id": "is not a known insurer"})
+		return false
+	}
+	if err := policy.Validate(&insurer); err != nil {
+		validationError(c, err)
+		return false
+	}
+
+	for i, dependant := range policy.Dependants {
+		var count int64
+		db(c).Model(&models.User{}).Where("id = ?", dependant.UserID).Count(&count)
+		if count == 0 {
+			validationError(c, validation.Errors{
+				"dependants[" + strconv.Itoa(i) + "].user_id": "is not a registered patient",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// Eligibility
+
+// CheckEligibility works out the insurer and patient shares of items
+// without recording a visit or prescription, e.g. to quote a price
+func CheckEligibility(c *gin.Context) {
+	var input struct {
+		UserID uint             `json:"user_id" binding:"required"`
+		Date   *time.Time       `json:"date"`
+		Items  []insurance.Item `json:"items" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	date := time.Now()
+	if input.Date != nil {
+		date = *input.Date
+	}
+
+	check, err := eligibility(c, input.UserID, date, input.Items)
+	if errors.Is(err, errPatientNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, check)
+}
+
+// eligibility checks the patient's insurance for items on date. The result
+// is not saved; callers link it to their visit or prescription and create
+// it in the same transaction. A patient without a policy in force, or whose
+// insurer refuses or cannot be reached, pays the full amount.
+func eligibility(c *gin.Context, userID uint, date time.Time, items []insurance.Item) (*models.EligibilityCheck, error) {
+	var user models.User
+	if err := db(c).Select("id", "national_id").First(&user, userID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errPatientNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	check := &models.EligibilityCheck{UserID: userID, CheckedAt: time.Now()}
+	shares := insurance.SelfPay(items)
+
+	policy, err := policyInForce(c, userID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case policy == nil:
+		check.Reason = "No insurance policy in force"
+	case policy.Insurer == nil || !policy.Insurer.Active:
+		check.PolicyID = &policy.ID
+		check.Reason = "Insurer is not active"
+	default:
+		check.PolicyID, check.InsurerID = &policy.ID, &policy.InsurerID
+		check.Adapter = policy.Insurer.Adapter
+
+		var rules []models.CoverageRule
+		if err := db(c).Where("insurer_id = ?", policy.InsurerID).Find(&rules).Error; err != nil {
+			return nil, err
+		}
+		localRules := make([]insurance.Rule, len(rules))
+		for i, rule := range rules {
+			localRules[i] = insurance.Rule{Plan: rule.Plan, Kind: rule.Kind, Category: rule.Category, CoveragePercent: rule.CoveragePercent}
+		}
+		covered := make([]insurance.Share, len(items))
+		for i, item := range items {
+			covered[i] = insurance.Split(item, insurance.Coverage(localRules, policy.Plan, item))
+		}
+
+		response, err := askInsurer(c, policy, string(user.NationalID), date, covered)
+		if err != nil {
+			check.Reason = "Insurer could not be reached: " + err.Error()
+			break
+		}
+		check.Reference = response.Reference
+		check.Eligible = response.Eligible
+		check.Reason = response.Reason
+		if response.Eligible {
+			shares = response.Items
+		}
+	}
+
+	check.InsurerShare, check.PatientShare = insurance.Totals(shares)
+	data, err := json.Marshal(shares)
+	if err != nil {
+		return nil, err
+	}
+	check.Items = data
+	return check, nil
+}
+
+func askInsurer(c *gin.Context, policy *models.InsurancePolicy, nationalID string, date time.Time, items []insurance.Share) (*insurance.Response, error) {
+	adapter, err := insurance.AdapterFor(policy.Insurer.Adapter)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), eligibilityTimeout)
+	defer cancel()
+	return adapter.CheckEligibility(ctx, insurance.Request{
+		InsurerCode:      policy.Insurer.Code,
+		PolicyNumber:     policy.PolicyNumber,
+		Plan:             policy.Plan,
+		MemberNationalID: nationalID,
+		Date:             date,
+		Items:            items,
+	})
+}
+
+// policyInForce finds the policy covering a patient on date, preferring one
+// they hold over one they are a dependant on, then the most recent
+func policyInForce(c *gin.Context, userID uint, date time.Time) (*models.InsurancePolicy, error) {
+	var policies []models.InsurancePolicy
+	err := db(c).Preload("Insurer").
+		Where("user_id = ? OR id IN (?)", userID,
+			db(c).Model(&models.PolicyDependant{}).Select("policy_id").Where("user_id = ?", userID)).
+		Order("id DESC").
+		Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var best *models.InsurancePolicy
+	for i := range policies {
+		policy := &policies[i]
+		if !policy.CoversOn(date) {
+			continue
+		}
+		if best == nil || (policy.UserID == userID && best.UserID != userID) {
+			best = policy
+		}
+	}
+	return best, nil
+}
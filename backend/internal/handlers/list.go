@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"barman/internal/listing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listError answers a failed listing.Find: invalid paging, sort or filter
+// parameters are the client's fault, anything else is a server error
+func listError(c *gin.Context, err error) {
+	var paramErr *listing.ParamError
+	if errors.As(err, &paramErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
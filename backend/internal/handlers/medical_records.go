@@ -1,13 +1,65 @@
 package handlers
 
 import (
-	"barman/internal/database"
+	"barman/internal/listing"
 	"barman/internal/models"
+	"barman/internal/validation"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+// List specs for the medical history endpoints. Their dates are free-text,
+// so date ranges filter on when the record was entered.
+var (
+	medicalHistorySpec = listing.Spec{
+		Sorts:       map[string]string{"created_at": "created_at", "name": "name"},
+		DefaultSort: "-created_at",
+		Filters: map[string]listing.Filter{
+			"date_from": listing.From("created_at"),
+			"date_to":   listing.To("created_at"),
+		},
+	}
+	disabilityListSpec = withFilters(medicalHistorySpec, map[string]listing.Filter{
+		"type": listing.OneOf("type"),
+	})
+	allergyListSpec = withFilters(medicalHistorySpec, map[string]listing.Filter{
+		"severity": listing.OneOf("severity"),
+	})
+	medicalImageListSpec = listing.Spec{
+		Sorts:       map[string]string{"created_at": "created_at", "date": "date"},
+		DefaultSort: "-created_at",
+		Filters: map[string]listing.Filter{
+			"facility_id": listing.Equals("facility_id"),
+			"date_from":   listing.From("created_at"),
+			"date_to":     listing.To("created_at"),
+		},
+	}
+	surgeryListSpec = listing.Spec{
+		Sorts:       map[string]string{"created_at": "created_at", "date": "date", "name": "name"},
+		DefaultSort: "-created_at",
+		Filters: map[string]listing.Filter{
+			"practitioner_id": listing.Equals("practitioner_id"),
+			"date_from":       listing.From("created_at"),
+			"date_to":         listing.To("created_at"),
+		},
+		Preloads: []string{"Practitioner"},
+	}
+)
+
+// withFilters returns a copy of spec accepting additional filters
+func withFilters(spec listing.Spec, filters map[string]listing.Filter) listing.Spec {
+	merged := make(map[string]listing.Filter, len(spec.Filters)+len(filters))
+	for name, filter := range spec.Filters {
+		merged[name] = filter
+	}
+	for name, filter := range filters {
+		merged[name] = filter
+	}
+	spec.Filters = merged
+	return spec
+}
+
 // HereditaryDisease handlers
 
 func AddHereditaryDisease(c *gin.Context) {
@@ -16,8 +68,11 @@ func AddHereditaryDisease(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !validHereditaryDisease(c, &disease) {
+		return
+	}
 
-	result := database.DB.Create(&disease)
+	result := db(c).Create(&disease)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
@@ -30,7 +85,7 @@ func GetHereditaryDisease(c *gin.Context) {
 	id := c.Param("id")
 	var disease models.HereditaryDisease
 
-	result := database.DB.First(&disease, id)
+	result := db(c).First(&disease, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Hereditary disease not found"})
 		return
@@ -43,7 +98,7 @@ func UpdateHereditaryDisease(c *gin.Context) {
 	id := c.Param("id")
 	var disease models.HereditaryDisease
 
-	if err := database.DB.First(&disease, id).Error; err != nil {
+	if err := db(c).First(&disease, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Hereditary disease not found"})
 		return
 	}
@@ -52,35 +107,55 @@ func UpdateHereditaryDisease(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !validHereditaryDisease(c, &disease) {
+		return
+	}
 
-	database.DB.Save(&disease)
+	db(c).Save(&disease)
 	c.JSON(http.StatusOK, disease)
 }
 
+// validHereditaryDisease validates a hereditary disease and checks that an
+// affected relative is a registered patient, answering 400 otherwise
+func validHereditaryDisease(c *gin.Context, disease *models.HereditaryDisease) bool {
+	if err := disease.Validate(); err != nil {
+		validationError(c, err)
+		return false
+	}
+	if disease.RelativeID != nil {
+		var count int64
+		db(c).Model(&models.User{}).Where("id = ?", *disease.RelativeID).Count(&count)
+		if count == 0 {
+			validationError(c, validation.Errors{"relative_id": "is not a registered patient"})
+			return false
+		}
+	}
+	return true
+}
+
 func DeleteHereditaryDisease(c *gin.Context) {
 	id := c.Param("id")
 	var disease models.HereditaryDisease
 
-	if err := database.DB.First(&disease, id).Error; err != nil {
+	if err := db(c).First(&disease, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Hereditary disease not found"})
 		return
 	}
 
-	database.DB.Delete(&disease)
+	db(c).Delete(&disease)
 	c.JSON(http.StatusOK, gin.H{"message": "Hereditary disease deleted successfully"})
 }
 
 func GetUserHereditaryDiseases(c *gin.Context) {
 	userID := c.Param("user_id")
-	var diseases []models.HereditaryDisease
 
-	result := database.DB.Where("user_id = ?", userID).Find(&diseases)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	page, err := listing.Find[models.HereditaryDisease](c, db(c).Where("user_id = ?", userID), medicalHistorySpec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, diseases)
+	c.JSON(http.StatusOK, page)
 }
 
 // Disability handlers
@@ -92,7 +167,7 @@ func AddDisability(c *gin.Context) {
 		return
 	}
 
-	result := database.DB.Create(&disability)
+	result := db(c).Create(&disability)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
@@ -105,7 +180,7 @@ func GetDisability(c *gin.Context) {
 	id := c.Param("id")
 	var disability models.Disability
 
-	result := database.DB.First(&disability, id)
+	result := db(c).First(&disability, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Disability not found"})
 		return
@@ -118,7 +193,7 @@ func UpdateDisability(c *gin.Context) {
 	id := c.Param("id")
 	var disability models.Disability
 
-	if err := database.DB.First(&disability, id).Error; err != nil {
+	if err := db(c).First(&disability, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Disability not found"})
 		return
 	}
@@ -128,7 +203,7 @@ func UpdateDisability(c *gin.Context) {
 		return
 	}
 
-	database.DB.Save(&disability)
+	db(c).Save(&disability)
 	c.JSON(http.StatusOK, disability)
 }
 
@@ -136,26 +211,25 @@ func DeleteDisability(c *gin.Context) {
 	id := c.Param("id")
 	var disability models.Disability
 
-	if err := database.DB.First(&disability, id).Error; err != nil {
+	if err := db(c).First(&disability, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Disability not found"})
 		return
 	}
 
-	database.DB.Delete(&disability)
+	db(c).Delete(&disability)
 	c.JSON(http.StatusOK, gin.H{"message": "Disability deleted successfully"})
 }
 
 func GetUserDisabilities(c *gin.Context) {
 	userID := c.Param("user_id")
-	var disabilities []models.Disability
 
-	result := database.DB.Where("user_id = ?", userID).Find(&disabilities)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	page, err := listing.Find[models.Disability](c, db(c).Where("user_id = ?", userID), disabilityListSpec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, disabilities)
+	c.JSON(http.StatusOK, page)
 }
 
 // Medical Image handlers
@@ -167,7 +241,7 @@ func AddMedicalImage(c *gin.Context) {
 		return
 	}
 
-	result := database.DB.Create(&image)
+	result := db(c).Create(&image)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
@@ -180,7 +254,7 @@ func GetMedicalImage(c *gin.Context) {
 	id := c.Param("id")
 	var image models.MedicalImage
 
-	result := database.DB.First(&image, id)
+	result := db(c).First(&image, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Medical image not found"})
 		return
@@ -193,7 +267,7 @@ func UpdateMedicalImage(c *gin.Context) {
 	id := c.Param("id")
 	var image models.MedicalImage
 
-	if err := database.DB.First(&image, id).Error; err != nil {
+	if err := db(c).First(&image, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Medical image not found"})
 		return
 	}
@@ -203,7 +277,7 @@ func UpdateMedicalImage(c *gin.Context) {
 		return
 	}
 
-	database.DB.Save(&image)
+	db(c).Save(&image)
 	c.JSON(http.StatusOK, image)
 }
 
@@ -211,26 +285,25 @@ func DeleteMedicalImage(c *gin.Context) {
 	id := c.Param("id")
 	var image models.MedicalImage
 
-	if err := database.DB.First(&image, id).Error; err != nil {
+	if err := db(c).First(&image, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Medical image not found"})
 		return
 	}
 
-	database.DB.Delete(&image)
+	db(c).Delete(&image)
 	c.JSON(http.StatusOK, gin.H{"message": "Medical image deleted successfully"})
 }
 
 func GetUserMedicalImages(c *gin.Context) {
 	userID := c.Param("user_id")
-	var images []models.MedicalImage
 
-	result := database.DB.Where("user_id = ?", userID).Find(&images)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	page, err := listing.Find[models.MedicalImage](c, db(c).Where("user_id = ?", userID), medicalImageListSpec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, images)
+	c.JSON(http.StatusOK, page)
 }
 
 // Surgery handlers
@@ -242,7 +315,12 @@ func AddSurgery(c *gin.Context) {
 		return
 	}
 
-	result := database.DB.Create(&surgery)
+	if _, err := resolvePractitioner(c, &surgery.PractitionerID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := db(c).Create(&surgery)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
@@ -255,7 +333,7 @@ func GetSurgery(c *gin.Context) {
 	id := c.Param("id")
 	var surgery models.Surgery
 
-	result := database.DB.First(&surgery, id)
+	result := db(c).First(&surgery, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Surgery not found"})
 		return
@@ -268,7 +346,7 @@ func UpdateSurgery(c *gin.Context) {
 	id := c.Param("id")
 	var surgery models.Surgery
 
-	if err := database.DB.First(&surgery, id).Error; err != nil {
+	if err := db(c).First(&surgery, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Surgery not found"})
 		return
 	}
@@ -278,7 +356,14 @@ func UpdateSurgery(c *gin.Context) {
 		return
 	}
 
-	database.DB.Save(&surgery)
+	if surgery.PractitionerID != nil {
+		if _, err := resolvePractitioner(c, &surgery.PractitionerID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	db(c).Save(&surgery)
 	c.JSON(http.StatusOK, surgery)
 }
 
@@ -286,26 +371,25 @@ func DeleteSurgery(c *gin.Context) {
 	id := c.Param("id")
 	var surgery models.Surgery
 
-	if err := database.DB.First(&surgery, id).Error; err != nil {
+	if err := db(c).First(&surgery, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Surgery not found"})
 		return
 	}
 
-	database.DB.Delete(&surgery)
+	db(c).Delete(&surgery)
 	c.JSON(http.StatusOK, gin.H{"message": "Surgery deleted successfully"})
 }
 
 func GetUserSurgeries(c *gin.Context) {
 	userID := c.Param("user_id")
-	var surgeries []models.Surgery
 
-	result := database.DB.Where("user_id = ?", userID).Find(&surgeries)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	page, err := listing.Find[models.Surgery](c, db(c).Where("user_id = ?", userID), surgeryListSpec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, surgeries)
+	c.JSON(http.StatusOK, page)
 }
 
 // Allergy handlers
@@ -317,7 +401,7 @@ func AddAllergy(c *gin.Context) {
 		return
 	}
 
-	result := database.DB.Create(&allergy)
+	result := db(c).Create(&allergy)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
@@ -330,7 +414,7 @@ func GetAllergy(c *gin.Context) {
 	id := c.Param("id")
 	var allergy models.Allergy
 
-	result := database.DB.First(&allergy, id)
+	result := db(c).First(&allergy, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Allergy not found"})
 		return
@@ -343,7 +427,7 @@ func UpdateAllergy(c *gin.Context) {
 	id := c.Param("id")
 	var allergy models.Allergy
 
-	if err := database.DB.First(&allergy, id).Error; err != nil {
+	if err := db(c).First(&allergy, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Allergy not found"})
 		return
 	}
@@ -353,7 +437,7 @@ func UpdateAllergy(c *gin.Context) {
 		return
 	}
 
-	database.DB.Save(&allergy)
+	db(c).Save(&allergy)
 	c.JSON(http.StatusOK, allergy)
 }
 
@@ -361,26 +445,25 @@ func DeleteAllergy(c *gin.Context) {
 	id := c.Param("id")
 	var allergy models.Allergy
 
-	if err := database.DB.First(&allergy, id).Error; err != nil {
+	if err := db(c).First(&allergy, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Allergy not found"})
 		return
 	}
 
-	database.DB.Delete(&allergy)
+	db(c).Delete(&allergy)
 	c.JSON(http.StatusOK, gin.H{"message": "Allergy deleted successfully"})
 }
 
 func GetUserAllergies(c *gin.Context) {
 	userID := c.Param("user_id")
-	var allergies []models.Allergy
 
-	result := database.DB.Where("user_id = ?", userID).Find(&allergies)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	page, err := listing.Find[models.Allergy](c, db(c).Where("user_id = ?", userID), allergyListSpec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, allergies)
+	c.JSON(http.StatusOK, page)
 }
 
 // Chronic Condition handlers
@@ -392,7 +475,7 @@ func AddChronicCondition(c *gin.Context) {
 		return
 	}
 
-	result := database.DB.Create(&condition)
+	result := db(c).Create(&condition)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
@@ -405,7 +488,7 @@ func GetChronicCondition(c *gin.Context) {
 	id := c.Param("id")
 	var condition models.ChronicCondition
 
-	result := database.DB.First(&condition, id)
+	result := db(c).First(&condition, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Chronic condition not found"})
 		return
@@ -418,7 +501,7 @@ func UpdateChronicCondition(c *gin.Context) {
 	id := c.Param("id")
 	var condition models.ChronicCondition
 
-	if err := database.DB.First(&condition, id).Error; err != nil {
+	if err := db(c).First(&condition, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Chronic condition not found"})
 		return
 	}
@@ -428,7 +511,7 @@ func UpdateChronicCondition(c *gin.Context) {
 		return
 	}
 
-	database.DB.Save(&condition)
+	db(c).Save(&condition)
 	c.JSON(http.StatusOK, condition)
 }
 
@@ -436,24 +519,23 @@ func DeleteChronicCondition(c *gin.Context) {
 	id := c.Param("id")
 	var condition models.ChronicCondition
 
-	if err := database.DB.First(&condition, id).Error; err != nil {
+	if err := db(c).First(&condition, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Chronic condition not found"})
 		return
 	}
 
-	database.DB.Delete(&condition)
+	db(c).Delete(&condition)
 	c.JSON(http.StatusOK, gin.H{"message": "Chronic condition deleted successfully"})
 }
 
 func GetUserChronicConditions(c *gin.Context) {
 	userID := c.Param("user_id")
-	var conditions []models.ChronicCondition
 
-	result := database.DB.Where("user_id = ?", userID).Find(&conditions)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	page, err := listing.Find[models.ChronicCondition](c, db(c).Where("user_id = ?", userID), medicalHistorySpec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, conditions)
+	c.JSON(http.StatusOK, page)
 }
@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"barman/internal/insurance"
+	"barman/internal/listing"
 	"barman/internal/models"
 )
 
@@ -20,6 +25,11 @@ func NewMedicationHandler(db *gorm.DB) *MedicationHandler {
 	return &MedicationHandler{DB: db}
 }
 
+// db binds the handler's database to the request context for auditing
+func (h *MedicationHandler) db(c *gin.Context) *gorm.DB {
+	return h.DB.WithContext(c.Request.Context())
+}
+
 // SearchMedications searches medications in the catalog
 func (h *MedicationHandler) SearchMedications(c *gin.Context) {
 	query := c.Query("q")
@@ -28,17 +38,24 @@ func (h *MedicationHandler) SearchMedications(c *gin.Context) {
 		return
 	}
 
-	var medications []models.MedicationCatalog
-	result := h.DB.Where("generic_name LIKE ? OR brand_name LIKE ?", "%"+query+"%", "%"+query+"%").
-		Limit(20).
-		Find(&medications)
-
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search medications"})
+	pattern := "%" + escapeLike(query) + "%"
+	page, err := listing.Find[models.MedicationCatalog](c,
+		h.db(c).Where("generic_name LIKE ? OR brand_name LIKE ?", pattern, pattern),
+		listing.Spec{
+			Sorts:       map[string]string{"generic_name": "generic_name", "brand_name": "brand_name"},
+			DefaultSort: "generic_name",
+			Filters: map[string]listing.Filter{
+				"category": listing.OneOf("category"),
+				"form":     listing.OneOf("form"),
+				"active":   listing.Equals("active"),
+			},
+		})
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, medications)
+	c.JSON(http.StatusOK, page)
 }
 
 // GetMedication gets a medication by ID
@@ -50,7 +67,7 @@ func (h *MedicationHandler) GetMedication(c *gin.Context) {
 	}
 
 	var medication models.MedicationCatalog
-	result := h.DB.First(&medication, id)
+	result := h.db(c).First(&medication, id)
 
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Medication not found"})
@@ -63,13 +80,14 @@ func (h *MedicationHandler) GetMedication(c *gin.Context) {
 // CreatePrescription creates a new prescription
 func (h *MedicationHandler) CreatePrescription(c *gin.Context) {
 	var input struct {
-		UserID       uint                `json:"user_id" binding:"required"`
-		VisitID      uint                `json:"visit_id" binding:"required"`
-		Notes        string              `json:"notes"`
-		Date         string              `json:"date" binding:"required"`
-		DoctorName   string              `json:"doctor_name"`
-		Instructions string              `json:"instructions"`
-		Medications  []models.Medication `json:"medications"`
+		UserID         uint                `json:"user_id" binding:"required"`
+		VisitID        uint                `json:"visit_id" binding:"required"`
+		Notes          string              `json:"notes"`
+		Date           string              `json:"date" binding:"required"`
+		DoctorName     string              `json:"doctor_name"`
+		PractitionerID *uint               `json:"practitioner_id"`
+		Instructions   string              `json:"instructions"`
+		Medications    []models.Medication `json:"medications"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -79,45 +97,84 @@ func (h *MedicationHandler) CreatePrescription(c *gin.Context) {
 
 	// Verify user exists
 	var user models.User
-	if result := h.DB.First(&user, input.UserID); result.Error != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	if result := h.db(c).First(&user, input.UserID); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
 	// Verify visit exists
 	var visit models.Visit
-	if result := h.DB.First(&visit, input.VisitID); result.Error != nil {
+	if result := h.db(c).First(&visit, input.VisitID); result.Error != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid visit ID"})
 		return
 	}
 
+	// Verify prescriber
+	practitioner, err := resolvePractitioner(c, &input.PractitionerID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if practitioner != nil {
+		input.DoctorName = practitioner.FullName()
+	}
+
 	// Create prescription
 	prescription := models.Prescription{
-		UserID:       input.UserID,
-		VisitID:      input.VisitID,
-		Notes:        input.Notes,
-		Date:         input.Date,
-		DoctorName:   input.DoctorName,
-		Instructions: input.Instructions,
+		UserID:         input.UserID,
+		VisitID:        input.VisitID,
+		Notes:          input.Notes,
+		Date:           input.Date,
+		DoctorName:     input.DoctorName,
+		PractitionerID: input.PractitionerID,
+		Instructions:   input.Instructions,
 	}
 
-	if result := h.DB.Create(&prescription); result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create prescription"})
+	// Insurer and patient shares of each medication
+	items := make([]insurance.Item, len(input.Medications))
+	for i, med := range input.Medications {
+		items[i] = medicationItem(med)
+	}
+	// Ask the insurer before the transaction starts, as it may take a while
+	check, err := eligibility(c, input.UserID, time.Now(), items)
+	if errors.Is(err, errPatientNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check insurance eligibility"})
+		return
+	}
+
+	// The prescription, its eligibility check and its medications are
+	// created together or not at all
+	err = h.db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&prescription).Error; err != nil {
+			return fmt.Errorf("Failed to create prescription: %w", err)
+		}
+
+		check.PrescriptionID = &prescription.ID
+		if err := tx.Create(check).Error; err != nil {
+			return fmt.Errorf("Failed to record insurance eligibility: %w", err)
+		}
 
-	// Add medications to prescription
-	for _, med := range input.Medications {
-		med.PrescriptionID = prescription.ID
-		if result := h.DB.Create(&med); result.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add medication to prescription"})
-			return
+		// Add medications to prescription
+		for _, med := range input.Medications {
+			med.PrescriptionID = prescription.ID
+			if err := tx.Create(&med).Error; err != nil {
+				return fmt.Errorf("Failed to add medication to prescription: %w", err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Return created prescription with medications
 	var createdPrescription models.Prescription
-	h.DB.Preload("User").Preload("Visit").Preload("Medications").First(&createdPrescription, prescription.ID)
+	h.db(c).Preload("User").Preload("Visit").Preload("Practitioner").Preload("Medications").Preload("Eligibility").First(&createdPrescription, prescription.ID)
 
 	c.JSON(http.StatusCreated, createdPrescription)
 }
@@ -131,7 +188,7 @@ func (h *MedicationHandler) GetPrescription(c *gin.Context) {
 	}
 
 	var prescription models.Prescription
-	result := h.DB.Preload("User").Preload("Visit").Preload("Medications").First(&prescription, id)
+	result := h.db(c).Preload("User").Preload("Visit").Preload("Practitioner").Preload("Medications").Preload("Eligibility").First(&prescription, id)
 
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not found"})
@@ -149,15 +206,13 @@ func (h *MedicationHandler) GetUserPrescriptions(c *gin.Context) {
 		return
 	}
 
-	var prescriptions []models.Prescription
-	result := h.DB.Where("user_id = ?", userID).
-		Preload("User").Preload("Visit").Preload("Medications").
-		Find(&prescriptions)
-
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get prescriptions"})
+	spec := prescriptionListSpec
+	spec.Preloads = []string{"User", "Visit", "Practitioner", "Medications"}
+	page, err := listing.Find[models.Prescription](c, h.db(c).Where("user_id = ?", userID), spec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, prescriptions)
+	c.JSON(http.StatusOK, page)
 }
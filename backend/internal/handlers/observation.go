@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"barman/internal/auth"
+	"barman/internal/listing"
+	"barman/internal/models"
+	"barman/internal/models/triage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// observationPoint is a reading in a visit's series with its change since
+// the previous reading. The triage is the first reading of the series.
+type observationPoint struct {
+	Source string `json:"source"` // triage, observation
+	triage.Observation
+	Changes              map[string]float64     `json:"changes,omitempty"`
+	MinutesSincePrevious *float64               `json:"minutes_since_previous,omitempty"`
+	Deteriorations       []triage.Deterioration `json:"deteriorations"`
+}
+
+var observationListSpec = listing.Spec{
+	Sorts:       map[string]string{"observed_at": "observed_at"},
+	DefaultSort: "-observed_at",
+	Filters: map[string]listing.Filter{
+		"date_from": listing.From("observed_at"),
+		"date_to":   listing.To("observed_at"),
+	},
+}
+
+// RecordObservation records a set of vital signs taken during a visit and
+// answers with how it compares with the reading before it. A weight is
+// added to the patient's measurement history as well.
+func RecordObservation(c *gin.Context) {
+	var observation triage.Observation
+	if err := c.ShouldBindJSON(&observation); err != nil {
+		validationError(c, err)
+		return
+	}
+
+	var visit models.Visit
+	if err := db(c).First(&visit, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errVisitNotFound.Error()})
+		return
+	}
+
+	observation.ID = 0
+	observation.VisitID = visit.ID
+	if observation.ObservedAt.IsZero() {
+		observation.ObservedAt = time.Now()
+	}
+	observation.RecordedByID = nil
+	if staff := auth.CurrentStaff(c); staff != nil {
+		observation.RecordedByID = &staff.ID
+	}
+	if err := observation.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+	observation.Patient, _ = patientDemographics(c, visit.UserID)
+
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&observation).Error; err != nil {
+			return err
+		}
+		if observation.WeightKg == nil {
+			return nil
+		}
+		measurement := models.Anthropometric{
+			UserID:       visit.UserID,
+			VisitID:      &visit.ID,
+			MeasuredAt:   observation.ObservedAt,
+			WeightKg:     observation.WeightKg,
+			RecordedByID: observation.RecordedByID,
+			Notes:        "Taken with the visit's observations",
+		}
+		if err := tx.Create(&measurement).Error; err != nil {
+			return err
+		}
+		return updateCurrentMeasurements(tx, visit.UserID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	readings, err := visitReadings(c, visit.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	point := observationPoint{Source: "observation", Observation: observation, Deteriorations: []triage.Deterioration{}}
+	for _, p := range trendPoints(readings) {
+		if p.Source == "observation" && p.ID == observation.ID {
+			point = p
+		}
+	}
+	c.JSON(http.StatusCreated, point)
+}
+
+// GetVisitObservations lists the observations taken during a visit, newest
+// first
+func GetVisitObservations(c *gin.Context) {
+	page, err := listing.Find[triage.Observation](c, db(c).Where("visit_id = ?", c.Param("id")), observationListSpec)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetObservationTrend returns a visit's readings oldest first, starting with
+// the triage, each with its change since the previous reading and the
+// changes that suggest the patient is getting worse
+func GetObservationTrend(c *gin.Context) {
+	var visit models.Visit
+	if err := db(c).First(&visit, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errVisitNotFound.Error()})
+		return
+	}
+	patient, _ := patientDemographics(c, visit.UserID)
+
+	readings, err := visitReadings(c, visit.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	points := trendPoints(readings)
+
+	summary := gin.H{"readings": len(points), "deteriorating": false}
+	if len(points) > 0 {
+		first, last := points[0], points[len(points)-1]
+		summary["first_observed_at"] = first.ObservedAt
+		summary["last_observed_at"] = last.ObservedAt
+		summary["news2"] = last.NEWS2
+		summary["news2_risk"] = last.NEWS2Risk
+		summary["changes"] = last.Observation.Changes(&first.Observation)
+		summary["deteriorating"] = len(last.Deteriorations) > 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{"points": points, "summary": summary, "patient": patient})
+}
+
+// visitReadings returns the triage and observations of a visit as one
+// series, oldest first
+func visitReadings(c *gin.Context, visitID uint) ([]observationPoint, error) {
+	var readings []observationPoint
+
+	var triageData triage.Triage
+	err := db(c).Where("visit_id = ?", visitID).Order("created_at").First(&triageData).Error
+	switch {
+	case err == nil:
+		readings = append(readings, observationPoint{Source: "triage", Observation: triage.ObservationOf(&triageData)})
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	var observations []triage.Observation
+	if err := db(c).Where("visit_id = ?", visitID).Order("observed_at, id").Find(&observations).Error; err != nil {
+		return nil, err
+	}
+	for _, observation := range observations {
+		readings = append(readings, observationPoint{Source: "observation", Observation: observation})
+	}
+	sort.SliceStable(readings, func(i, j int) bool {
+		return readings[i].ObservedAt.Before(readings[j].ObservedAt)
+	})
+	return readings, nil
+}
+
+// trendPoints works out the change of each reading since the one before
+func trendPoints(readings []observationPoint) []observationPoint {
+	for i := range readings {
+		point := &readings[i]
+		point.Deteriorations = []triage.Deterioration{}
+		if i == 0 {
+			continue
+		}
+		previous := &readings[i-1].Observation
+		point.Changes = point.Observation.Changes(previous)
+		point.Deteriorations = point.Observation.Deteriorations(previous)
+		minutes := round1(point.ObservedAt.Sub(previous.ObservedAt).Minutes())
+		point.MinutesSincePrevious = &minutes
+	}
+	return readings
+}
@@ -0,0 +1,536 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"barman/internal/audit"
+	"barman/internal/auth"
+	"barman/internal/database"
+	"barman/internal/demographics"
+	"barman/internal/listing"
+	"barman/internal/models"
+	"barman/internal/models/triage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PatientRetention is how long a deleted patient's records are kept before
+// they can be purged
+var PatientRetention = 10 * 365 * 24 * time.Hour
+
+var (
+	errPatientNotErased  = errors.New("Patient was not deleted; merged registrations are restored by undoing the merge")
+	errPatientAnonymised = errors.New("Patient has already been anonymised")
+	errRetentionNotEnded = errors.New("Patient's retention period has not ended")
+	// errNationalIDTaken is returned when a patient is brought back whose
+	// national ID another current patient has
+	errNationalIDTaken = errors.New("Another current patient has the same national ID")
+)
+
+// patientTable is a table of records that are deleted, restored and purged
+// along with a patient
+type patientTable struct {
+	name string
+	// softDelete soft-deletes the patient's live records and returns their
+	// IDs so the deletion can be undone
+	softDelete func(tx *gorm.DB, userID uint) ([]uint, error)
+	// restore undeletes records one at a time so that each gets its own
+	// audit entry
+	restore func(tx *gorm.DB, ids []uint) ([]uint, error)
+	// purge permanently deletes all of the patient's records
+	purge func(tx *gorm.DB, userID uint) ([]uint, error)
+}
+
+// patientTables are the tables holding a patient's records, with records
+// that refer to another listed before it so they can be purged in order.
+// Family links and policy dependants are included from both sides.
+var patientTables = []patientTable{
+	patientTableOf[models.PrescriptionItem]("prescription_id IN (SELECT id FROM prescriptions WHERE user_id = @user)"),
+	patientTableOf[models.EligibilityCheck]("user_id = @user"),
+	patientTableOf[models.Prescription]("user_id = @user"),
+	patientTableOf[triage.Observation]("visit_id IN (SELECT id FROM visits WHERE user_id = @user)"),
+	patientTableOf[triage.Triage]("visit_id IN (SELECT id FROM visits WHERE user_id = @user)"),
+	patientTableOf[models.Diagnosis]("visit_id IN (SELECT id FROM visits WHERE user_id = @user)"),
+	patientTableOf[models.Anthropometric]("user_id = @user"),
+	patientTableOf[models.Visit]("user_id = @user"),
+	patientTableOf[models.Appointment]("user_id = @user"),
+	patientTableOf[models.Allergy]("user_id = @user"),
+	patientTableOf[models.Surgery]("user_id = @user"),
+	patientTableOf[models.MedicalImage]("user_id = @user"),
+	patientTableOf[models.ChronicCondition]("user_id = @user"),
+	patientTableOf[models.HereditaryDisease]("user_id = @user"),
+	patientTableOf[models.Disability]("user_id = @user"),
+	patientTableOf[models.EmergencyContact]("user_id = @user"),
+	patientTableOf[models.FamilyRelation]("user_id = @user OR relative_id = @user"),
+	patientTableOf[models.PolicyDependant]("user_id = @user OR policy_id IN (SELECT id FROM insurance_policies WHERE user_id = @user)"),
+	patientTableOf[models.InsurancePolicy]("user_id = @user"),
+}
+
+// patientTableOf is a table whose records for a patient are selected by
+// where, in which @user stands for the patient's ID
+func patientTableOf[T any](where string) patientTable {
+	return patientTable{
+		name: database.TableName(new(T)),
+		softDelete: func(tx *gorm.DB, userID uint) ([]uint, error) {
+			var ids []uint
+			err := tx.Model(new(T)).Where(where, sql.Named("user", userID)).Order("id").Pluck("id", &ids).Error
+			if err != nil || len(ids) == 0 {
+				return nil, err
+			}
+			var records []T
+			if err := tx.Where("id IN ?", ids).Find(&records).Error; err != nil {
+				return nil, err
+			}
+			return ids, tx.Delete(&records).Error
+		},
+		restore: func(tx *gorm.DB, ids []uint) ([]uint, error) {
+			// Records deleted on their own since, or purged, stay as they are
+			var records []T
+			if err := tx.Unscoped().Where("id IN ? AND deleted_at IS NOT NULL", ids).Find(&records).Error; err != nil {
+				return nil, err
+			}
+			restored := make([]uint, 0, len(records))
+			for i := range records {
+				if err := tx.Unscoped().Model(&records[i]).Update("deleted_at", nil).Error; err != nil {
+					return nil, err
+				}
+				restored = append(restored, idOf(&records[i]))
+			}
+			return restored, nil
+		},
+		purge: func(tx *gorm.DB, userID uint) ([]uint, error) {
+			return eraseRecords[T](tx, where, userID)
+		},
+	}
+}
+
+// eraseRecords permanently deletes the records selected by where, in which
+// @user stands for the patient's ID
+func eraseRecords[T any](tx *gorm.DB, where string, userID uint) ([]uint, error) {
+	var ids []uint
+	err := tx.Unscoped().Model(new(T)).Where(where, sql.Named("user", userID)).Order("id").Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	var records []T
+	if err := tx.Unscoped().Where("id IN ?", ids).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return ids, tx.Unscoped().Delete(&records).Error
+}
+
+// scrubRecords applies updates to the records selected by where one at a
+// time, so that each change is audited
+func scrubRecords[T any](tx *gorm.DB, where string, userID uint, updates map[string]interface{}) ([]uint, error) {
+	var records []T
+	if err := tx.Unscoped().Where(where, sql.Named("user", userID)).Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(records))
+	for i := range records {
+		if err := tx.Unscoped().Model(&records[i]).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+		ids = append(ids, idOf(&records[i]))
+	}
+	return ids, nil
+}
+
+// DeleteUser soft-deletes a patient together with all of their records.
+// The deletion, with the optional ?reason=, is recorded so that RestoreUser
+// can bring back exactly what it removed.
+func DeleteUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var event models.PatientLifecycleEvent
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return errPatientNotFound
+		}
+
+		deleted := make(map[string][]uint)
+		for _, table := range patientTables {
+			ids, err := table.softDelete(tx, user.ID)
+			if err != nil {
+				return err
+			}
+			if len(ids) > 0 {
+				deleted[table.name] = ids
+			}
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+
+		event, err = recordLifecycle(c, tx, user.ID, models.LifecycleDeleted, c.Query("reason"), deleted)
+		return err
+	})
+
+	switch {
+	case errors.Is(err, errPatientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully", "event": event})
+	}
+}
+
+// RestoreUser undoes the last deletion of a patient, restoring the patient
+// and the records deleted with them. The response reports how many records
+// were restored per table.
+func RestoreUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	restored := make(map[string]int)
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return errPatientNotFound
+		}
+		deletion, err := lastDeletion(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		var deleted map[string][]uint
+		if err := json.Unmarshal(deletion.Records, &deleted); err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+			if isUniqueViolation(err) {
+				return errNationalIDTaken
+			}
+			return err
+		}
+		// Parents first, the reverse of the deletion order
+		ids := make(map[string][]uint)
+		for i := len(patientTables) - 1; i >= 0; i-- {
+			table := patientTables[i]
+			if len(deleted[table.name]) == 0 {
+				continue
+			}
+			tableIDs, err := table.restore(tx, deleted[table.name])
+			if err != nil {
+				return err
+			}
+			ids[table.name] = tableIDs
+			restored[table.name] = len(tableIDs)
+		}
+
+		if _, err := recordLifecycle(c, tx, user.ID, models.LifecycleRestored, input.Reason, ids); err != nil {
+			return err
+		}
+		return audit.Record(tx, models.AuditActionRestore, user.ID, gin.H{"reason": input.Reason, "restored": restored})
+	})
+
+	switch {
+	case errors.Is(err, errPatientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, errPatientNotErased), errors.Is(err, errNationalIDTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"user": user, "restored": restored})
+	}
+}
+
+// AnonymiseUser irreversibly removes what identifies a patient, deleted or
+// not, while keeping their clinical records for statistics. The patient's
+// sex, year of birth and measurements are kept; names, national ID, contact
+// details, emergency contacts, family links and policy numbers are not.
+func AnonymiseUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return errPatientNotFound
+		}
+		if user.AnonymisedAt != nil {
+			return errPatientAnonymised
+		}
+		return anonymise(c, tx, &user, input.Reason)
+	})
+
+	switch {
+	case errors.Is(err, errPatientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, errPatientAnonymised):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+func anonymise(c *gin.Context, tx *gorm.DB, user *models.User, reason string) error {
+	records := make(map[string][]uint)
+	steps := []struct {
+		name string
+		run  func() ([]uint, error)
+	}{
+		{"emergency_contacts", func() ([]uint, error) {
+			return eraseRecords[models.EmergencyContact](tx, "user_id = @user", user.ID)
+		}},
+		{"family_relations", func() ([]uint, error) {
+			return eraseRecords[models.FamilyRelation](tx, "user_id = @user OR relative_id = @user", user.ID)
+		}},
+		{"hereditary_diseases", func() ([]uint, error) {
+			// Family history keeps the relationship but not who the relative is
+			return scrubRecords[models.HereditaryDisease](tx, "relative_id = @user OR (user_id = @user AND relative_id IS NOT NULL)", user.ID,
+				map[string]interface{}{"relative_id": nil})
+		}},
+		{"insurance_policies", func() ([]uint, error) {
+			return scrubRecords[models.InsurancePolicy](tx, "user_id = @user", user.ID, map[string]interface{}{"policy_number": ""})
+		}},
+		{"eligibility_checks", func() ([]uint, error) {
+			return scrubRecords[models.EligibilityCheck](tx, "user_id = @user AND reference <> ''", user.ID, map[string]interface{}{"reference": ""})
+		}},
+	}
+	for _, step := range steps {
+		ids, err := step.run()
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			records[step.name] = ids
+		}
+	}
+
+	now := time.Now()
+	user.FirstName, user.LastName, user.FatherName = "Anonymised", "Patient", ""
+	user.NationalID, user.Address, user.MobilePhone, user.LandlinePhone = "", "", "", ""
+	user.HairColor, user.EyeColor, user.SkinColor, user.Insurance = "", "", "", ""
+	if user.DateOfBirth != nil {
+		user.DateOfBirth = &demographics.Date{Time: time.Date(user.DateOfBirth.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)}
+	}
+	user.AnonymisedAt = &now
+	if err := tx.Unscoped().Omit(clause.Associations).Save(user).Error; err != nil {
+		return err
+	}
+
+	// Entries written before identifying fields were redacted in the audit
+	// log still hold them
+	if err := audit.Scrub(tx, user.ID, &models.User{}, &models.EmergencyContact{}, &models.InsurancePolicy{}); err != nil {
+		return err
+	}
+
+	if _, err := recordLifecycle(c, tx, user.ID, models.LifecycleAnonymised, reason, records); err != nil {
+		return err
+	}
+	return audit.Record(tx, models.AuditActionAnonymise, user.ID, gin.H{"reason": reason, "records": counts(records)})
+}
+
+// PurgeUser permanently deletes a patient who was deleted more than the
+// retention period ago, with all of their records. The patient is
+// anonymised first, which also redacts identifying fields in the audit
+// entries already written about them, so that the audit log holds no
+// names, national ID, contact details or policy numbers. Only the
+// lifecycle events and audit log remain.
+func PurgeUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var purged map[string]int
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		purged, err = purgePatient(c, tx, uint(userID), time.Now())
+		return err
+	})
+
+	switch {
+	case errors.Is(err, errPatientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, errPatientNotErased), errors.Is(err, errRetentionNotEnded):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "User purged successfully", "purged": purged})
+	}
+}
+
+// PurgeExpiredUsers purges every patient whose retention period has ended,
+// each in its own transaction, and lists the IDs purged
+func PurgeExpiredUsers(c *gin.Context) {
+	now := time.Now()
+	var userIDs []uint
+	err := db(c).Model(&models.PatientLifecycleEvent{}).
+		Where("action = ? AND created_at <= ?", models.LifecycleDeleted, now.Add(-PatientRetention)).
+		Where("NOT EXISTS (SELECT 1 FROM patient_lifecycle_events later WHERE later.user_id = patient_lifecycle_events.user_id AND later.id > patient_lifecycle_events.id AND later.action IN ? AND later.deleted_at IS NULL)",
+			[]string{models.LifecycleRestored, models.LifecyclePurged}).
+		Distinct().
+		Order("user_id").
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	purged := []uint{}
+	for _, userID := range userIDs {
+		err := db(c).Transaction(func(tx *gorm.DB) error {
+			_, err := purgePatient(c, tx, userID, now)
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "purged": purged})
+			return
+		}
+		purged = append(purged, userID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+func purgePatient(c *gin.Context, tx *gorm.DB, userID uint, now time.Time) (map[string]int, error) {
+	var user models.User
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+		return nil, errPatientNotFound
+	}
+	deletion, err := lastDeletion(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if deletion.CreatedAt.Add(PatientRetention).After(now) {
+		return nil, errRetentionNotEnded
+	}
+
+	if user.AnonymisedAt == nil {
+		if err := anonymise(c, tx, &user, "purge"); err != nil {
+			return nil, err
+		}
+	}
+
+	records := make(map[string][]uint)
+	for _, table := range patientTables {
+		ids, err := table.purge(tx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) > 0 {
+			records[table.name] = ids
+		}
+	}
+	merges, err := eraseRecords[models.PatientMerge](tx, "survivor_id = @user OR duplicate_id = @user", user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(merges) > 0 {
+		records["patient_merges"] = merges
+	}
+	if err := tx.Unscoped().Delete(&user).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := recordLifecycle(c, tx, user.ID, models.LifecyclePurged, deletion.Reason, records); err != nil {
+		return nil, err
+	}
+	purged := counts(records)
+	return purged, audit.Record(tx, models.AuditActionPurge, user.ID, gin.H{"purged": purged})
+}
+
+// GetUserLifecycle lists when a patient was deleted, restored, anonymised
+// or purged, newest first. It works for patients who have been purged.
+func GetUserLifecycle(c *gin.Context) {
+	page, err := listing.Find[models.PatientLifecycleEvent](c, db(c).Where("user_id = ?", c.Param("id")), listing.Spec{
+		Sorts:       map[string]string{"created_at": "created_at"},
+		DefaultSort: "-created_at",
+		Filters: map[string]listing.Filter{
+			"action": listing.Equals("action"),
+		},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// lastDeletion returns the deletion a deleted patient can be restored or
+// purged from
+func lastDeletion(tx *gorm.DB, userID uint) (*models.PatientLifecycleEvent, error) {
+	var event models.PatientLifecycleEvent
+	err := tx.Where("user_id = ? AND action IN ?", userID,
+		[]string{models.LifecycleDeleted, models.LifecycleRestored, models.LifecyclePurged}).
+		Order("id DESC").
+		First(&event).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errPatientNotErased
+	}
+	if err != nil {
+		return nil, err
+	}
+	if event.Action != models.LifecycleDeleted {
+		return nil, errPatientNotErased
+	}
+	return &event, nil
+}
+
+func recordLifecycle(c *gin.Context, tx *gorm.DB, userID uint, action, reason string, records map[string][]uint) (models.PatientLifecycleEvent, error) {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return models.PatientLifecycleEvent{}, err
+	}
+	event := models.PatientLifecycleEvent{
+		UserID:  userID,
+		Action:  action,
+		Reason:  reason,
+		Records: data,
+	}
+	if staff := auth.CurrentStaff(c); staff != nil {
+		event.ActorID = &staff.ID
+	}
+	return event, tx.Create(&event).Error
+}
+
+func counts(records map[string][]uint) map[string]int {
+	result := make(map[string]int, len(records))
+	for name, ids := range records {
+		result[name] = len(ids)
+	}
+	return result
+}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"barman/internal/auth"
+	"barman/internal/database"
+	"barman/internal/duplicates"
+	"barman/internal/fieldcrypt"
+	"barman/internal/listing"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultDuplicateScore is the lowest score reported as a likely duplicate
+const defaultDuplicateScore = 0.75
+
+var (
+	errPatientNotFound    = errors.New("Patient not found")
+	errDuplicateNotFound  = errors.New("Duplicate patient not found")
+	errMergeNotFound      = errors.New("Merge not found")
+	errMergeAlreadyUndone = errors.New("Merge has already been undone")
+)
+
+// duplicateRow holds the columns compared when looking for duplicates
+type duplicateRow struct {
+	ID            uint
+	CreatedAt     time.Time
+	FirstName     string
+	LastName      string
+	FatherName    string
+	NationalID    fieldcrypt.EncryptedString
+	MobilePhone   fieldcrypt.EncryptedString
+	LandlinePhone fieldcrypt.EncryptedString
+}
+
+type duplicatePatient struct {
+	ID         uint      `json:"id"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	FatherName string    `json:"father_name"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type duplicatePair struct {
+	Score      float64             `json:"score"`
+	Components map[string]float64  `json:"components"`
+	Patients   [2]duplicatePatient `json:"patients"`
+}
+
+// removedFamilyRelations is the key under which a merge records the links
+// between the two registrations that it removed
+const removedFamilyRelations = "family_relations_removed"
+
+// patientRecordType is a kind of record that follows a patient when two
+// registrations are merged
+type patientRecordType struct {
+	name string
+	// ids lists the records owned by a patient, including soft-deleted ones
+	ids func(tx *gorm.DB, userID uint) ([]uint, error)
+	// reassign moves records to another patient one at a time so that each
+	// move gets its own audit entry
+	reassign func(tx *gorm.DB, ids []uint, fromID, toID uint) (int, error)
+}
+
+// patientRecordTypes are moved by a merge. Triage, observations and doctor
+// reports belong to a visit and move with it. Lifecycle events stay with
+// the registration they describe.
+var patientRecordTypes = []patientRecordType{
+	recordType[models.Visit]("visits"),
+	recordType[models.Anthropometric]("anthropometrics"),
+	recordType[models.Prescription]("prescriptions"),
+	recordType[models.Appointment]("appointments"),
+	recordType[models.Allergy]("allergies"),
+	recordType[models.Surgery]("surgeries"),
+	recordType[models.MedicalImage]("medical_images"),
+	recordType[models.ChronicCondition]("chronic_conditions"),
+	recordType[models.HereditaryDisease]("hereditary_diseases"),
+	recordType[models.Disability]("disabilities"),
+	recordType[models.FamilyRelation]("family_relations"),
+	recordType[models.EmergencyContact]("emergency_contacts"),
+	recordType[models.InsurancePolicy]("insurance_policies"),
+	recordType[models.PolicyDependant]("policy_dependants"),
+	recordType[models.EligibilityCheck]("eligibility_checks"),
+	// Links and family history entries in which the patient is the relative
+	recordTypeOn[models.FamilyRelation]("family_relations_as_relative", "relative_id"),
+	recordTypeOn[models.HereditaryDisease]("hereditary_diseases_as_relative", "relative_id"),
+}
+
+func recordType[T any](name string) patientRecordType {
+	return recordTypeOn[T](name, "user_id")
+}
+
+// recordTypeOn is a record type that refers to the patient in column
+func recordTypeOn[T any](name, column string) patientRecordType {
+	return patientRecordType{
+		name: name,
+		ids: func(tx *gorm.DB, userID uint) ([]uint, error) {
+			var ids []uint
+			err := tx.Unscoped().Model(new(T)).Where(column+" = ?", userID).Order("id").Pluck("id", &ids).Error
+			return ids, err
+		},
+		reassign: func(tx *gorm.DB, ids []uint, fromID, toID uint) (int, error) {
+			// Records that have since moved elsewhere are left alone
+			var records []T
+			if err := tx.Unscoped().Where("id IN ? AND "+column+" = ?", ids, fromID).Find(&records).Error; err != nil {
+				return 0, err
+			}
+			for i := range records {
+				if err := tx.Unscoped().Model(&records[i]).Update(column, toID).Error; err != nil {
+					return 0, err
+				}
+			}
+			return len(records), nil
+		},
+	}
+}
+
+// FindDuplicatePatients lists pairs of registrations that probably belong
+// to the same person, best match first. With ?user_id= only pairs involving
+// that patient are returned.
+func FindDuplicatePatients(c *gin.Context) {
+	minScore := defaultDuplicateScore
+	if value := c.Query("min_score"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "min_score must be between 0 and 1"})
+			return
+		}
+		minScore = parsed
+	}
+
+	// Registrations are compared only when they share a mobile phone blind
+	// index or have similar names by pg_trgm, which both use an index
+	users := database.TableName(&models.User{})
+	candidates := db(c).Table(users + " AS a").
+		Select("a.id AS a, b.id AS b").
+		Joins("JOIN " + users + " AS b ON b.id > a.id AND (b.mobile_phone_index = a.mobile_phone_index OR b.search_name % a.search_name)").
+		Where("a.deleted_at IS NULL AND b.deleted_at IS NULL")
+	if value := c.Query("user_id"); value != "" {
+		userID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		var count int64
+		if err := db(c).Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": errPatientNotFound.Error()})
+			return
+		}
+		candidates = candidates.Where("a.id = ? OR b.id = ?", userID, userID)
+	}
+
+	var candidatePairs []duplicates.Pair
+	if err := candidates.Scan(&candidatePairs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Only the registrations in a candidate pair are read and decrypted
+	ids := make([]uint, 0, 2*len(candidatePairs))
+	seen := make(map[uint]bool)
+	for _, pair := range candidatePairs {
+		for _, id := range []uint{pair.A, pair.B} {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	byID := make(map[uint]duplicateRow, len(ids))
+	patients := make(map[uint]duplicates.Patient, len(ids))
+	for start := 0; start < len(ids); start += 1000 {
+		var batch []duplicateRow
+		err := db(c).Model(&models.User{}).
+			Select("id", "created_at", "first_name", "last_name", "father_name", "national_id", "mobile_phone", "landline_phone").
+			Where("id IN ?", ids[start:min(start+1000, len(ids))]).
+			Find(&batch).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, row := range batch {
+			byID[row.ID] = row
+			patients[row.ID] = row.patient()
+		}
+	}
+	matches := duplicates.Score(candidatePairs, patients, minScore)
+
+	page, err := listing.Slice(c, matches, "-score")
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	pairs := make([]duplicatePair, len(page.Data))
+	for i, match := range page.Data {
+		pairs[i] = duplicatePair{
+			Score:      match.Score,
+			Components: match.Components,
+			Patients:   [2]duplicatePatient{toDuplicatePatient(byID[match.A]), toDuplicatePatient(byID[match.B])},
+		}
+	}
+
+	c.JSON(http.StatusOK, listing.Page[duplicatePair]{Data: pairs, Pagination: page.Pagination})
+}
+
+func (row duplicateRow) patient() duplicates.Patient {
+	return duplicates.Patient{
+		ID:         row.ID,
+		FirstName:  row.FirstName,
+		LastName:   row.LastName,
+		FatherName: row.FatherName,
+		NationalID: string(row.NationalID),
+		Phones:     []string{string(row.MobilePhone), string(row.LandlinePhone)},
+	}
+}
+
+func toDuplicatePatient(row duplicateRow) duplicatePatient {
+	return duplicatePatient{
+		ID:         row.ID,
+		FirstName:  row.FirstName,
+		LastName:   row.LastName,
+		FatherName: row.FatherName,
+		CreatedAt:  row.CreatedAt,
+	}
+}
+
+// MergePatients moves every record of the duplicate registration given in
+// the body to the patient in the URL and soft-deletes the duplicate, all in
+// one transaction. The surviving patient's own details are kept.
+func MergePatients(c *gin.Context) {
+	survivorID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var input struct {
+		DuplicateID uint   `json:"duplicate_id" binding:"required"`
+		Reason      string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.DuplicateID == uint(survivorID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A patient cannot be merged into itself"})
+		return
+	}
+
+	var merge models.PatientMerge
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		var survivor, duplicate models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&survivor, survivorID).Error; err != nil {
+			return errPatientNotFound
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&duplicate, input.DuplicateID).Error; err != nil {
+			return errDuplicateNotFound
+		}
+
+		// Links between the two registrations would link the survivor to
+		// itself, so they are removed; undoing the merge restores them
+		moved := make(map[string][]uint)
+		var links []models.FamilyRelation
+		err := tx.Where("(user_id = ? AND relative_id = ?) OR (user_id = ? AND relative_id = ?)",
+			survivor.ID, duplicate.ID, duplicate.ID, survivor.ID).Find(&links).Error
+		if err != nil {
+			return err
+		}
+		if len(links) > 0 {
+			if err := tx.Delete(&links).Error; err != nil {
+				return err
+			}
+			for _, link := range links {
+				moved[removedFamilyRelations] = append(moved[removedFamilyRelations], link.ID)
+			}
+		}
+
+		for _, records := range patientRecordTypes {
+			ids, err := records.ids(tx, duplicate.ID)
+			if err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			if _, err := records.reassign(tx, ids, duplicate.ID, survivor.ID); err != nil {
+				return err
+			}
+			moved[records.name] = ids
+		}
+
+		data, err := json.Marshal(moved)
+		if err != nil {
+			return err
+		}
+		merge = models.PatientMerge{
+			SurvivorID:   survivor.ID,
+			DuplicateID:  duplicate.ID,
+			Reason:       input.Reason,
+			MovedRecords: data,
+		}
+		if staff := auth.CurrentStaff(c); staff != nil {
+			merge.MergedByID = &staff.ID
+		}
+		if err := tx.Create(&merge).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&duplicate).Error
+	})
+
+	switch {
+	case errors.Is(err, errPatientNotFound), errors.Is(err, errDuplicateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusCreated, merge)
+	}
+}
+
+func GetPatientMerges(c *gin.Context) {
+	page, err := listing.Find[models.PatientMerge](c, db(c), listing.Spec{
+		Sorts:       map[string]string{"created_at": "created_at"},
+		DefaultSort: "-created_at",
+		Filters: map[string]listing.Filter{
+			"survivor_id":  listing.Equals("survivor_id"),
+			"duplicate_id": listing.Equals("duplicate_id"),
+			"date_from":    listing.From("created_at"),
+			"date_to":      listing.To("created_at"),
+		},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// UndoPatientMerge restores the duplicate registration and moves its
+// records back from the survivor. Records that have been moved again since
+// the merge stay where they are; the response reports how many were
+// restored per table.
+func UndoPatientMerge(c *gin.Context) {
+	id := c.Param("id")
+
+	var merge models.PatientMerge
+	restored := make(map[string]int)
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&merge, id).Error; err != nil {
+			return errMergeNotFound
+		}
+		if merge.UndoneAt != nil {
+			return errMergeAlreadyUndone
+		}
+
+		var duplicate models.User
+		if err := tx.Unscoped().First(&duplicate, merge.DuplicateID).Error; err != nil {
+			return errDuplicateNotFound
+		}
+		if err := tx.Unscoped().Model(&duplicate).Update("deleted_at", nil).Error; err != nil {
+			if isUniqueViolation(err) {
+				return errNationalIDTaken
+			}
+			return err
+		}
+
+		var moved map[string][]uint
+		if err := json.Unmarshal(merge.MovedRecords, &moved); err != nil {
+			return err
+		}
+		for _, records := range patientRecordTypes {
+			ids := moved[records.name]
+			if len(ids) == 0 {
+				continue
+			}
+			count, err := records.reassign(tx, ids, merge.SurvivorID, merge.DuplicateID)
+			if err != nil {
+				return err
+			}
+			restored[records.name] = count
+		}
+
+		if ids := moved[removedFamilyRelations]; len(ids) > 0 {
+			var links []models.FamilyRelation
+			if err := tx.Unscoped().Where("id IN ?", ids).Find(&links).Error; err != nil {
+				return err
+			}
+			for i := range links {
+				if err := tx.Unscoped().Model(&links[i]).Update("deleted_at", nil).Error; err != nil {
+					return err
+				}
+			}
+			restored[removedFamilyRelations] = len(links)
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{"undone_at": now}
+		if staff := auth.CurrentStaff(c); staff != nil {
+			updates["undone_by_id"] = staff.ID
+		}
+		return tx.Model(&merge).Updates(updates).Error
+	})
+
+	switch {
+	case errors.Is(err, errMergeNotFound), errors.Is(err, errDuplicateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errMergeAlreadyUndone), errors.Is(err, errNationalIDTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"merge": merge, "restored": restored})
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"barman/internal/database"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// unmergedTables refer to a patient but stay with the registration when it
+// is merged
+var unmergedTables = map[string]bool{
+	database.TableName(&models.PatientLifecycleEvent{}): true,
+	database.TableName(&models.PatientMerge{}):          true,
+}
+
+// TestMergeMovesEveryPatientTable checks that every migrated table with a
+// user_id or relative_id column is moved by a merge
+func TestMergeMovesEveryPatientTable(t *testing.T) {
+	merged := make(map[string]bool)
+	for _, records := range patientRecordTypes {
+		merged[records.name] = true
+	}
+
+	for _, model := range database.Models {
+		s, err := schema.Parse(model, &sync.Map{}, database.Naming)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unmergedTables[s.Table] {
+			continue
+		}
+		if s.LookUpField("user_id") != nil && !merged[s.Table] {
+			t.Errorf("%s refers to a patient by user_id but is not moved by a merge", s.Table)
+		}
+		if s.LookUpField("relative_id") != nil && !merged[s.Table+"_as_relative"] {
+			t.Errorf("%s refers to a patient by relative_id but is not moved by a merge", s.Table)
+		}
+	}
+}
+
+// TestMergeAndUndoAgainstSchema merges two registrations and undoes the
+// merge when TEST_DATABASE_URL names a PostgreSQL database. Nothing is
+// kept, as the schema is created in a transaction that is rolled back.
+func TestMergeAndUndoAgainstSchema(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{NamingStrategy: database.Naming})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := conn.Begin()
+	defer tx.Rollback()
+	if err := tx.AutoMigrate(database.Models...); err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DB
+	database.DB = tx
+	defer func() { database.DB = previous }()
+
+	survivor := models.User{FirstName: "Test", LastName: "Patient"}
+	duplicate := models.User{FirstName: "Test", LastName: "Patient"}
+	for _, user := range []*models.User{&survivor, &duplicate} {
+		if err := tx.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	visit := models.Visit{UserID: duplicate.ID, Type: models.VisitRegular}
+	if err := tx.Create(&visit).Error; err != nil {
+		t.Fatal(err)
+	}
+	measurement := models.Anthropometric{UserID: duplicate.ID}
+	if err := tx.Create(&measurement).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.POST("/patients/:id/merge", MergePatients)
+	router.POST("/patient-merges/:id/undo", UndoPatientMerge)
+
+	body, _ := json.Marshal(map[string]uint{"duplicate_id": duplicate.ID})
+	response := serve(router, http.MethodPost, fmt.Sprintf("/patients/%d/merge", survivor.ID), body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("merge: %d %s", response.Code, response.Body)
+	}
+	var merge models.PatientMerge
+	if err := json.Unmarshal(response.Body.Bytes(), &merge); err != nil {
+		t.Fatal(err)
+	}
+	checkOwner(t, tx, &models.Visit{}, visit.ID, survivor.ID)
+	checkOwner(t, tx, &models.Anthropometric{}, measurement.ID, survivor.ID)
+
+	response = serve(router, http.MethodPost, fmt.Sprintf("/patient-merges/%d/undo", merge.ID), nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("undo: %d %s", response.Code, response.Body)
+	}
+	checkOwner(t, tx, &models.Visit{}, visit.ID, duplicate.ID)
+	checkOwner(t, tx, &models.Anthropometric{}, measurement.ID, duplicate.ID)
+}
+
+func serve(router http.Handler, method, path string, body []byte) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	return response
+}
+
+// checkOwner checks that the record of model with id belongs to userID
+func checkOwner(t *testing.T, tx *gorm.DB, model interface{}, id, userID uint) {
+	t.Helper()
+	var owner uint
+	if err := tx.Unscoped().Model(model).Where("id = ?", id).Pluck("user_id", &owner).Error; err != nil {
+		t.Fatal(err)
+	}
+	if owner != userID {
+		t.Errorf("%s %d belongs to patient %d, want %d", database.TableName(model), id, owner, userID)
+	}
+}
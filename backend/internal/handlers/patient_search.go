@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"barman/internal/listing"
+	"barman/internal/models"
+	"barman/internal/textnorm"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// maxSearchCandidates caps how many of the candidates ranked best in SQL
+	// are ranked again in memory per search
+	maxSearchCandidates = 500
+	// minSearchScore drops candidates that only share a few letters
+	minSearchScore = 0.5
+	// minSearchSimilarity is the trigram word similarity a name needs to be
+	// a candidate at all
+	minSearchSimilarity = 0.3
+)
+
+// searchTerm is one normalised word of a name search, optionally restricted
+// to a single name field
+type searchTerm struct {
+	field string // first_name, last_name, father_name or "" for any name
+	token string
+}
+
+// searchCandidate holds just the columns needed to rank a patient, so that
+// candidates which are not returned are never loaded in full
+type searchCandidate struct {
+	ID         uint
+	FirstName  string
+	LastName   string
+	FatherName string
+	score      float64
+}
+
+type userSearchResult struct {
+	models.User
+	Score float64 `json:"score"`
+}
+
+// SearchUsers finds patients by partial name, father's name, mobile phone or
+// national ID. Names are matched after Persian/Arabic normalisation and
+// ranked by fuzzy similarity; results are paged with an opaque cursor.
+func SearchUsers(c *gin.Context) {
+	limit := defaultSearchLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(parsed, maxSearchLimit)
+	}
+
+	query := db(c).Model(&models.User{})
+	var terms []searchTerm
+	hasCriteria := false
+
+	if nationalID := c.Query("national_id"); nationalID != "" {
+		index := models.NationalIDIndex(nationalID)
+		if index == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid national ID"})
+			return
+		}
+		query = query.Where("national_id_index = ?", *index)
+		hasCriteria = true
+	}
+	if mobile := c.Query("mobile"); mobile != "" {
+		index := models.PhoneIndex(mobile)
+		if index == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mobile phone"})
+			return
+		}
+		query = query.Where("mobile_phone_index = ?", *index)
+		hasCriteria = true
+	}
+
+	for _, field := range []string{"first_name", "last_name", "father_name"} {
+		for _, token := range textnorm.Tokens(c.Query(field)) {
+			terms = append(terms, searchTerm{field: field, token: token})
+		}
+	}
+	for _, token := range textnorm.Tokens(c.Query("q")) {
+		if textnorm.IsNumeric(token) && textnorm.Digits(token) != "" {
+			// A bare number is either a national ID or a phone number
+			nationalID, phone := models.NationalIDIndex(token), models.PhoneIndex(token)
+			query = query.Where("national_id_index = ? OR mobile_phone_index = ?", *nationalID, *phone)
+			hasCriteria = true
+			continue
+		}
+		terms = append(terms, searchTerm{token: token})
+	}
+
+	// Candidates are ranked in SQL by their mean trigram word similarity to
+	// the search words, so that those kept are the best rather than the
+	// oldest
+	order := clause.Expr{SQL: "id ASC"}
+	if len(terms) > 0 {
+		// A word matches a name that contains it or is similar to one of its
+		// words, which lets typos anywhere in a name through
+		nameFilter := db(c)
+		scores := make([]string, len(terms))
+		var scoreArgs []interface{}
+		for i, term := range terms {
+			condition := "search_name LIKE ? OR ? <% search_name"
+			pattern := "%" + escapeLike(term.token) + "%"
+			if i == 0 {
+				nameFilter = nameFilter.Where(condition, pattern, term.token)
+			} else {
+				nameFilter = nameFilter.Or(condition, pattern, term.token)
+			}
+			scores[i] = "word_similarity(?, search_name)"
+			scoreArgs = append(scoreArgs, term.token)
+		}
+		order = clause.Expr{
+			SQL:  fmt.Sprintf("(%s) / %d DESC, id ASC", strings.Join(scores, " + "), len(terms)),
+			Vars: scoreArgs,
+		}
+		query = query.Where(nameFilter)
+		hasCriteria = true
+	}
+
+	if !hasCriteria {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one search term is required"})
+		return
+	}
+
+	var candidates []searchCandidate
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		// <% uses this threshold; the default of 0.6 misses short names with
+		// a typo, which the ranking below filters more finely
+		err := tx.Exec("SELECT set_config('pg_trgm.word_similarity_threshold', ?, true)",
+			strconv.FormatFloat(minSearchSimilarity, 'f', -1, 64)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where(query).
+			Select("id", "first_name", "last_name", "father_name").
+			Clauses(clause.OrderBy{Expression: order}).
+			Limit(maxSearchCandidates).
+			Find(&candidates).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ranked := candidates[:0]
+	for _, candidate := range candidates {
+		candidate.score = scoreCandidate(candidate, terms)
+		if candidate.score >= minSearchScore {
+			ranked = append(ranked, candidate)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+
+	total := len(ranked)
+
+	// Skip everything up to and including the cursor position
+	if cursor := c.Query("cursor"); cursor != "" {
+		afterScore, afterID, err := decodeSearchCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		start := sort.Search(len(ranked), func(i int) bool {
+			return ranked[i].score < afterScore || (ranked[i].score == afterScore && ranked[i].ID > afterID)
+		})
+		ranked = ranked[start:]
+	}
+
+	page := ranked[:min(limit, len(ranked))]
+	var nextCursor string
+	if len(ranked) > len(page) {
+		last := page[len(page)-1]
+		nextCursor = encodeSearchCursor(last.score, last.ID)
+	}
+
+	// Load the full records for this page only
+	ids := make([]uint, len(page))
+	for i, candidate := range page {
+		ids[i] = candidate.ID
+	}
+	var users []models.User
+	if len(ids) > 0 {
+		if err := db(c).Where("id IN ?", ids).Find(&users).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	byID := make(map[uint]models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	results := make([]userSearchResult, 0, len(page))
+	for _, candidate := range page {
+		if user, ok := byID[candidate.ID]; ok {
+			results = append(results, userSearchResult{User: user, Score: candidate.score})
+		}
+	}
+
+	meta := listing.Meta{Limit: limit, Total: int64(total), Sort: "-score", NextCursor: nextCursor}
+	if nextCursor != "" {
+		meta.Next = listing.NextURL(c, "cursor", nextCursor)
+	}
+	c.JSON(http.StatusOK, listing.Page[userSearchResult]{Data: results, Pagination: meta})
+}
+
+// scoreCandidate averages the best similarity of each search word against
+// the candidate's names. Identifier-only searches score every match as 1.
+func scoreCandidate(candidate searchCandidate, terms []searchTerm) float64 {
+	if len(terms) == 0 {
+		return 1
+	}
+
+	names := map[string]string{
+		"first_name":  textnorm.Normalize(candidate.FirstName),
+		"last_name":   textnorm.Normalize(candidate.LastName),
+		"father_name": textnorm.Normalize(candidate.FatherName),
+	}
+
+	total := 0.0
+	for _, term := range terms {
+		if term.field != "" {
+			total += textnorm.BestTokenSimilarity(term.token, names[term.field])
+			continue
+		}
+		// Free text prefers the patient's own names over the father's name
+		total += max(
+			textnorm.BestTokenSimilarity(term.token, names["first_name"]),
+			textnorm.BestTokenSimilarity(term.token, names["last_name"]),
+			0.9*textnorm.BestTokenSimilarity(term.token, names["father_name"]),
+		)
+	}
+	return math.Round(total/float64(len(terms))*1e4) / 1e4
+}
+
+func encodeSearchCursor(score float64, id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%.4f:%d", score, id)))
+}
+
+func decodeSearchCursor(cursor string) (float64, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+	scorePart, idPart, found := strings.Cut(string(raw), ":")
+	if !found {
+		return 0, 0, fmt.Errorf("malformed cursor")
+	}
+	score, err := strconv.ParseFloat(scorePart, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return score, uint(id), nil
+}
+
+// escapeLike escapes the LIKE wildcards in user input
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
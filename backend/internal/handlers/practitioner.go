@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"barman/internal/auth"
+	"barman/internal/listing"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errPractitionerNotFound = errors.New("Practitioner not found")
+	errPractitionerInactive = errors.New("Practitioner is not active")
+)
+
+func CreatePractitioner(c *gin.Context) {
+	var practitioner models.Practitioner
+	if err := c.ShouldBindJSON(&practitioner); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if practitioner.FirstName == "" || practitioner.LastName == "" || practitioner.LicenseNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "First name, last name, and license number are required"})
+		return
+	}
+
+	result := db(c).Create(&practitioner)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, practitioner)
+}
+
+func GetAllPractitioners(c *gin.Context) {
+	page, err := listing.Find[models.Practitioner](c, db(c), listing.Spec{
+		Sorts:       map[string]string{"last_name": "last_name", "first_name": "first_name", "created_at": "created_at"},
+		DefaultSort: "last_name",
+		Filters: map[string]listing.Filter{
+			"specialty":     listing.Equals("specialty"),
+			"department_id": listing.Equals("department_id"),
+			"active":        listing.Equals("active"),
+		},
+		Preloads: []string{"Department"},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func GetPractitioner(c *gin.Context) {
+	id := c.Param("id")
+	var practitioner models.Practitioner
+
+	result := db(c).Preload("Department").First(&practitioner, id)
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Practitioner not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, practitioner)
+}
+
+func UpdatePractitioner(c *gin.Context) {
+	id := c.Param("id")
+	var practitioner models.Practitioner
+
+	if err := db(c).First(&practitioner, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Practitioner not found"})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&practitioner); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db(c).Save(&practitioner)
+	c.JSON(http.StatusOK, practitioner)
+}
+
+func DeletePractitioner(c *gin.Context) {
+	id := c.Param("id")
+	var practitioner models.Practitioner
+
+	if err := db(c).First(&practitioner, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Practitioner not found"})
+		return
+	}
+
+	db(c).Delete(&practitioner)
+	c.JSON(http.StatusOK, gin.H{"message": "Practitioner deleted successfully"})
+}
+
+// resolvePractitioner checks that the requested practitioner exists and is
+// active. When none is requested it falls back to the practitioner linked to
+// the signed-in staff member, if any, and updates id accordingly.
+func resolvePractitioner(c *gin.Context, id **uint) (*models.Practitioner, error) {
+	var practitioner models.Practitioner
+
+	if *id == nil {
+		staff := auth.CurrentStaff(c)
+		if staff == nil {
+			return nil, nil
+		}
+		if err := db(c).Where("staff_id = ? AND active = ?", staff.ID, true).First(&practitioner).Error; err != nil {
+			return nil, nil
+		}
+		*id = &practitioner.ID
+		return &practitioner, nil
+	}
+
+	if err := db(c).First(&practitioner, **id).Error; err != nil {
+		return nil, errPractitionerNotFound
+	}
+	if !practitioner.Active {
+		return nil, errPractitionerInactive
+	}
+	return &practitioner, nil
+}
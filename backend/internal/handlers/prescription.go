@@ -1,14 +1,17 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
-	"barman/internal/database"
+	"barman/internal/insurance"
+	"barman/internal/listing"
 	"barman/internal/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // CreatePrescription handles the creation of a new prescription
@@ -25,17 +28,40 @@ func CreatePrescription(c *gin.Context) {
 		return
 	}
 
+	// Age and sex for dose checks
+	patient, err := patientDemographics(c, prescription.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Print the verified prescriber rather than a free-text name
+	practitioner, err := resolvePractitioner(c, &prescription.PractitionerID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if practitioner != nil {
+		prescription.DoctorName = practitioner.FullName()
+	}
+
 	// Check if visit ID is valid
 	if prescription.VisitID > 0 {
 		var visit models.Visit
-		if err := database.DB.First(&visit, prescription.VisitID).Error; err != nil {
+		if err := db(c).First(&visit, prescription.VisitID).Error; err != nil {
 			// Visit doesn't exist, create a new one
 			visit = models.Visit{
-				UserID: prescription.UserID,
-				Type:   "prescription",
-				Date:   time.Now(),
+				UserID:         prescription.UserID,
+				Type:           models.VisitRegular,
+				Date:           time.Now(),
+				FacilityID:     currentFacilityID(c),
+				PractitionerID: prescription.PractitionerID,
 			}
-			if err := database.DB.Create(&visit).Error; err != nil {
+			if err := db(c).Create(&visit).Error; err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create visit"})
 				return
 			}
@@ -44,11 +70,13 @@ func CreatePrescription(c *gin.Context) {
 	} else {
 		// No visit ID provided, create a new one
 		visit := models.Visit{
-			UserID: prescription.UserID,
-			Type:   "prescription",
-			Date:   time.Now(),
+			UserID:         prescription.UserID,
+			Type:           models.VisitRegular,
+			Date:           time.Now(),
+			FacilityID:     currentFacilityID(c),
+			PractitionerID: prescription.PractitionerID,
 		}
-		if err := database.DB.Create(&visit).Error; err != nil {
+		if err := db(c).Create(&visit).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create visit"})
 			return
 		}
@@ -59,16 +87,39 @@ func CreatePrescription(c *gin.Context) {
 	prescription.CreatedAt = time.Now()
 	prescription.UpdatedAt = time.Now()
 
+	// Insurer and patient shares of each medication
+	items := make([]insurance.Item, len(prescription.Items))
+	for i, item := range prescription.Items {
+		items[i] = medicationItem(item.Medication)
+	}
+	check, err := eligibility(c, prescription.UserID, prescription.CreatedAt, items)
+	if errors.Is(err, errPatientNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "details": "Failed to check insurance eligibility"})
+		return
+	}
+
 	// Start a transaction
-	tx := database.DB.Begin()
+	tx := db(c).Begin()
 
 	// Create the prescription
-	if err := tx.Create(&prescription).Error; err != nil {
+	if err := tx.Omit("Eligibility").Create(&prescription).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "details": "Failed to create prescription"})
 		return
 	}
 
+	check.PrescriptionID = &prescription.ID
+	if err := tx.Create(check).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "details": "Failed to record insurance eligibility"})
+		return
+	}
+	prescription.Eligibility = check
+
 	// Create medications if provided
 	if len(prescription.Items) > 0 {
 		for i := range prescription.Items {
@@ -98,15 +149,25 @@ func CreatePrescription(c *gin.Context) {
 		return
 	}
 
+	prescription.Patient = patient
 	c.JSON(http.StatusCreated, prescription)
 }
 
+// medicationItem describes a prescribed medication for the insurer
+func medicationItem(medication models.Medication) insurance.Item {
+	name := medication.GenericName
+	if name == "" {
+		name = medication.BrandName
+	}
+	return insurance.Item{Kind: insurance.KindMedication, Category: medication.Category, Description: name}
+}
+
 // GetPrescription retrieves a prescription by ID
 func GetPrescription(c *gin.Context) {
 	id := c.Param("id")
 	var prescription models.Prescription
 
-	result := database.DB.Preload("User").First(&prescription, id)
+	result := db(c).Preload("User").Preload("Practitioner").Preload("Eligibility").First(&prescription, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not found"})
 		return
@@ -115,32 +176,36 @@ func GetPrescription(c *gin.Context) {
 	c.JSON(http.StatusOK, prescription)
 }
 
+var prescriptionListSpec = listing.Spec{
+	Sorts:       map[string]string{"created_at": "created_at", "date": "date"},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"status":          listing.OneOf("status"),
+		"practitioner_id": listing.Equals("practitioner_id"),
+		"visit_id":        listing.Equals("visit_id"),
+		"date_from":       listing.From("created_at"),
+		"date_to":         listing.To("created_at"),
+	},
+	Preloads: []string{"Practitioner"},
+}
+
 // GetPrescriptionsByUser retrieves all prescriptions for a specific user
 func GetPrescriptionsByUser(c *gin.Context) {
 	userID := c.Param("user_id")
-	var prescriptions []models.Prescription
-
-	// First check if the prescription_items table exists
-	hasTable := database.DB.Migrator().HasTable(&models.PrescriptionItem{})
-
-	// Get prescriptions without items first
-	result := database.DB.Where("user_id = ?", userID).
-		Order("created_at DESC").
-		Find(&prescriptions)
 
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
-		return
+	spec := prescriptionListSpec
+	// Only preload items if the prescription_items table exists
+	if db(c).Migrator().HasTable(&models.PrescriptionItem{}) {
+		spec.Preloads = []string{"Practitioner", "Items"}
 	}
 
-	// Only preload items if the table exists
-	if hasTable {
-		for i := range prescriptions {
-			database.DB.Model(&prescriptions[i]).Association("Items").Find(&prescriptions[i].Items)
-		}
+	page, err := listing.Find[models.Prescription](c, db(c).Where("user_id = ?", userID), spec)
+	if err != nil {
+		listError(c, err)
+		return
 	}
 
-	c.JSON(http.StatusOK, prescriptions)
+	c.JSON(http.StatusOK, page)
 }
 
 // UpdatePrescription updates an existing prescription
@@ -148,7 +213,7 @@ func UpdatePrescription(c *gin.Context) {
 	id := c.Param("id")
 	var prescription models.Prescription
 
-	if err := database.DB.First(&prescription, id).Error; err != nil {
+	if err := db(c).First(&prescription, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not found"})
 		return
 	}
@@ -158,7 +223,16 @@ func UpdatePrescription(c *gin.Context) {
 		return
 	}
 
-	database.DB.Save(&prescription)
+	if prescription.PractitionerID != nil {
+		practitioner, err := resolvePractitioner(c, &prescription.PractitionerID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		prescription.DoctorName = practitioner.FullName()
+	}
+
+	db(c).Save(&prescription)
 	c.JSON(http.StatusOK, prescription)
 }
 
@@ -167,12 +241,12 @@ func DeletePrescription(c *gin.Context) {
 	id := c.Param("id")
 	var prescription models.Prescription
 
-	if err := database.DB.First(&prescription, id).Error; err != nil {
+	if err := db(c).First(&prescription, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not found"})
 		return
 	}
 
-	database.DB.Delete(&prescription)
+	db(c).Delete(&prescription)
 	c.JSON(http.StatusOK, gin.H{"message": "Prescription deleted successfully"})
 }
 
@@ -192,7 +266,7 @@ func AddMedicationToPrescription(c *gin.Context) {
 
 	prescriptionItem.PrescriptionID = uint(prescriptionID)
 
-	result := database.DB.Create(&prescriptionItem)
+	result := db(c).Create(&prescriptionItem)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
@@ -206,11 +280,11 @@ func RemoveMedicationFromPrescription(c *gin.Context) {
 	medicationID := c.Param("medication_id")
 	var prescriptionItem models.PrescriptionItem
 
-	if err := database.DB.First(&prescriptionItem, medicationID).Error; err != nil {
+	if err := db(c).First(&prescriptionItem, medicationID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Medication not found"})
 		return
 	}
 
-	database.DB.Delete(&prescriptionItem)
+	db(c).Delete(&prescriptionItem)
 	c.JSON(http.StatusOK, gin.H{"message": "Medication removed successfully"})
 }
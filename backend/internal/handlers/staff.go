@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"net/http"
+
+	"barman/internal/auth"
+	"barman/internal/listing"
+	"barman/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateStaff(c *gin.Context) {
+	var input struct {
+		Username   string `json:"username" binding:"required"`
+		Password   string `json:"password" binding:"required"`
+		FullName   string `json:"full_name"`
+		Role       string `json:"role" binding:"required"`
+		FacilityID *uint  `json:"facility_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.ValidRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+	if len(input.Password) < auth.MinPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is too short"})
+		return
+	}
+
+	hash, err := auth.HashPassword(input.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	staff := models.Staff{
+		Username:     input.Username,
+		PasswordHash: hash,
+		FullName:     input.FullName,
+		Role:         input.Role,
+		Active:       true,
+		FacilityID:   input.FacilityID,
+	}
+
+	result := db(c).Create(&staff)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, staff)
+}
+
+func GetAllStaff(c *gin.Context) {
+	page, err := listing.Find[models.Staff](c, db(c), listing.Spec{
+		Sorts:       map[string]string{"username": "username", "full_name": "full_name", "created_at": "created_at"},
+		DefaultSort: "username",
+		Filters: map[string]listing.Filter{
+			"role":        listing.OneOf("role"),
+			"active":      listing.Equals("active"),
+			"facility_id": listing.Equals("facility_id"),
+		},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func GetStaff(c *gin.Context) {
+	id := c.Param("id")
+	var staff models.Staff
+
+	result := db(c).First(&staff, id)
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Staff member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, staff)
+}
+
+func UpdateStaff(c *gin.Context) {
+	id := c.Param("id")
+	var staff models.Staff
+
+	if err := db(c).First(&staff, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Staff member not found"})
+		return
+	}
+
+	var input struct {
+		FullName *string `json:"full_name"`
+		Role     *string `json:"role"`
+		Active   *bool   `json:"active"`
+		Password *string `json:"password"`
+		// FacilityID moves the staff member to another facility; use 0 to
+		// give them access to all facilities
+		FacilityID *uint `json:"facility_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.FullName != nil {
+		staff.FullName = *input.FullName
+	}
+	if input.Role != nil {
+		if !models.ValidRole(*input.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+		staff.Role = *input.Role
+	}
+	if input.Active != nil {
+		staff.Active = *input.Active
+	}
+	if input.FacilityID != nil {
+		if *input.FacilityID == 0 {
+			staff.FacilityID = nil
+		} else {
+			staff.FacilityID = input.FacilityID
+		}
+	}
+	if input.Password != nil {
+		if len(*input.Password) < auth.MinPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password is too short"})
+			return
+		}
+		hash, err := auth.HashPassword(*input.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		staff.PasswordHash = hash
+	}
+
+	db(c).Save(&staff)
+	c.JSON(http.StatusOK, staff)
+}
+
+func DeleteStaff(c *gin.Context) {
+	id := c.Param("id")
+	var staff models.Staff
+
+	if err := db(c).First(&staff, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Staff member not found"})
+		return
+	}
+
+	if current := auth.CurrentStaff(c); current != nil && current.ID == staff.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account"})
+		return
+	}
+
+	db(c).Delete(&staff)
+	c.JSON(http.StatusOK, gin.H{"message": "Staff member deleted successfully"})
+}
@@ -1,14 +1,16 @@
 package handlers
 
 import (
+	"database/sql"
 	"net/http"
 	"time"
 
-	"barman/internal/database"
+	"barman/internal/demographics"
 	"barman/internal/models"
 	"barman/internal/models/triage"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func GetStats(c *gin.Context) {
@@ -22,27 +24,109 @@ func GetStats(c *gin.Context) {
 		TodayAppointments    int64 `json:"today_appointments"`
 		PendingTriage        int64 `json:"pending_triage"`
 		UpcomingAppointments int64 `json:"upcoming_appointments"`
+
+		PatientsBySex     map[string]int64 `json:"patients_by_sex"`
+		PatientsByAgeBand map[string]int64 `json:"patients_by_age_band"`
+	}
+
+	// Patients are shared between facilities; everything else is counted
+	// for the signed-in staff member's facility
+	visits := func() *gorm.DB {
+		return db(c).Model(&models.Visit{}).Scopes(facilityScope(c, "visits"))
+	}
+	prescriptions := func() *gorm.DB {
+		return db(c).Model(&models.Prescription{}).
+			Joins("JOIN visits ON visits.id = prescriptions.visit_id").
+			Scopes(facilityScope(c, "visits"))
+	}
+	appointments := func() *gorm.DB {
+		return db(c).Model(&models.Appointment{}).Scopes(facilityScope(c, "appointments"))
 	}
 
 	// Get total counts
-	database.DB.Model(&models.User{}).Count(&stats.TotalPatients)
-	database.DB.Model(&models.Visit{}).Count(&stats.TotalVisits)
-	database.DB.Model(&models.Prescription{}).Count(&stats.TotalPrescriptions)
-	database.DB.Model(&models.Appointment{}).Count(&stats.TotalAppointments)
+	var err error
+	count := func(query *gorm.DB, n *int64) {
+		if err == nil {
+			err = query.Count(n).Error
+		}
+	}
+	count(db(c).Model(&models.User{}), &stats.TotalPatients)
+	count(visits(), &stats.TotalVisits)
+	count(prescriptions(), &stats.TotalPrescriptions)
+	count(appointments(), &stats.TotalAppointments)
 
 	// Get today's counts
-	today := time.Now().Truncate(24 * time.Hour)
-	tomorrow := today.Add(24 * time.Hour)
+	today, tomorrow := localDay(time.Now())
 
-	database.DB.Model(&models.Visit{}).Where("created_at >= ? AND created_at < ?", today, tomorrow).Count(&stats.TodayVisits)
-	database.DB.Model(&models.Prescription{}).Where("created_at >= ? AND created_at < ?", today, tomorrow).Count(&stats.TodayPrescriptions)
-	database.DB.Model(&models.Appointment{}).Where("date >= ? AND date < ?", today, tomorrow).Count(&stats.TodayAppointments)
+	count(visits().Where("visits.created_at >= ? AND visits.created_at < ?", today, tomorrow), &stats.TodayVisits)
+	count(prescriptions().Where("prescriptions.created_at >= ? AND prescriptions.created_at < ?", today, tomorrow), &stats.TodayPrescriptions)
+	count(appointments().Where("date >= ? AND date < ?", today, tomorrow), &stats.TodayAppointments)
 
 	// Get pending triage count - using type instead of status
-	database.DB.Model(&triage.Triage{}).Where("type = ?", "pending").Count(&stats.PendingTriage)
+	count(db(c).Model(&triage.Triage{}).
+		Where("type = ?", "pending").
+		Where("visit_id IN (?)", visits().Select("visits.id")), &stats.PendingTriage)
 
 	// Get upcoming appointments count
-	database.DB.Model(&models.Appointment{}).Where("date >= ? AND status = ?", time.Now(), "scheduled").Count(&stats.UpcomingAppointments)
+	count(appointments().Where("date >= ? AND status = ?", time.Now(), "scheduled"), &stats.UpcomingAppointments)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Patient demographics; patients without a recorded sex or date of
+	// birth are counted as unknown
+	var bySex []struct {
+		Sex   string
+		Count int64
+	}
+	err = db(c).Model(&models.User{}).Select("sex, count(*) AS count").Group("sex").Scan(&bySex).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stats.PatientsBySex = make(map[string]int64)
+	for _, row := range bySex {
+		sex := row.Sex
+		if sex == "" {
+			sex = "unknown"
+		}
+		stats.PatientsBySex[sex] += row.Count
+	}
+
+	// Ages are banded in the database as of the local date
+	var byBand []struct {
+		Band  *string
+		Count int64
+	}
+	err = db(c).Model(&models.User{}).
+		Select(demographics.BandSQL("date_of_birth")+" AS band, count(*) AS count",
+			sql.Named("today", today.Format(time.DateOnly))).
+		Group("band").
+		Scan(&byBand).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stats.PatientsByAgeBand = map[string]int64{"unknown": 0}
+	for _, band := range demographics.Bands {
+		stats.PatientsByAgeBand[string(band)] = 0
+	}
+	for _, row := range byBand {
+		band := "unknown"
+		if row.Band != nil {
+			band = *row.Band
+		}
+		stats.PatientsByAgeBand[band] += row.Count
+	}
 
 	c.JSON(http.StatusOK, stats)
 }
+
+// localDay returns the local midnight that starts the day of t and the one
+// that starts the next day
+func localDay(t time.Time) (time.Time, time.Time) {
+	t = t.Local()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	return start, start.AddDate(0, 0, 1)
+}
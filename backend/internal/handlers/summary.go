@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"barman/internal/demographics"
+	"barman/internal/models"
+	"barman/internal/models/triage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// How many upcoming appointments and recent diagnoses a summary shows
+const (
+	summaryAppointments = 5
+	summaryDiagnoses    = 10
+)
+
+// patientSummary is what a doctor needs at a glance. Each part is loaded
+// with its own query so the full patient record is never loaded.
+type patientSummary struct {
+	Patient              summaryPatient            `json:"patient"`
+	ChronicConditions    []models.ChronicCondition `json:"chronic_conditions"`
+	SevereAllergies      []models.Allergy          `json:"severe_allergies"`
+	CurrentMedications   []currentMedication       `json:"current_medications"`
+	LatestVitals         *triage.Triage            `json:"latest_vitals"`
+	UpcomingAppointments []models.Appointment      `json:"upcoming_appointments"`
+	RecentDiagnoses      []models.Diagnosis        `json:"recent_diagnoses"`
+	EmergencyContacts    []models.EmergencyContact `json:"emergency_contacts"`
+	Insurance            *models.InsurancePolicy   `json:"insurance"`
+}
+
+type summaryPatient struct {
+	ID          uint               `json:"id"`
+	FirstName   string             `json:"first_name"`
+	LastName    string             `json:"last_name"`
+	NationalID  string             `json:"national_id"`
+	DateOfBirth *demographics.Date `json:"date_of_birth"`
+	Age         *demographics.Age  `json:"age,omitempty"`
+	Sex         string             `json:"sex"`
+	BloodType   string             `json:"blood_type"`
+	Height      float64            `json:"height"`
+	Weight      float64            `json:"weight"`
+	MobilePhone string             `json:"mobile_phone"`
+}
+
+// currentMedication is a medication on a prescription that is still being
+// taken
+type currentMedication struct {
+	PrescriptionID uint              `json:"prescription_id"`
+	VisitID        uint              `json:"visit_id"`
+	PrescribedOn   string            `json:"prescribed_on"`
+	DoctorName     string            `json:"doctor_name"`
+	Medication     models.Medication `json:"medication"`
+	Dosage         string            `json:"dosage"`
+	Frequency      string            `json:"frequency"`
+	Duration       string            `json:"duration"`
+	Instructions   string            `json:"instructions"`
+}
+
+// GetUserSummary returns a patient's demographics, active chronic
+// conditions, severe allergies, current medications, latest triage vitals,
+// upcoming appointments, recent diagnoses, emergency contacts and the
+// insurance policy in force today
+func GetUserSummary(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	if err := db(c).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary := patientSummary{
+		Patient: summaryPatient{
+			ID:          user.ID,
+			FirstName:   user.FirstName,
+			LastName:    user.LastName,
+			NationalID:  string(user.NationalID),
+			DateOfBirth: user.DateOfBirth,
+			Age:         user.Age,
+			Sex:         user.Sex,
+			BloodType:   user.BloodType,
+			Height:      user.Height,
+			Weight:      user.Weight,
+			MobilePhone: string(user.MobilePhone),
+		},
+	}
+	if err := loadSummary(c, &user, &summary); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+func loadSummary(c *gin.Context, user *models.User, summary *patientSummary) error {
+	summary.ChronicConditions = []models.ChronicCondition{}
+	if err := db(c).Where("user_id = ?", user.ID).Order("created_at DESC").Find(&summary.ChronicConditions).Error; err != nil {
+		return err
+	}
+
+	summary.SevereAllergies = []models.Allergy{}
+	if err := db(c).Where("user_id = ? AND LOWER(severity) = ?", user.ID, "severe").Order("name").Find(&summary.SevereAllergies).Error; err != nil {
+		return err
+	}
+
+	medications, err := currentMedications(c, user.ID)
+	if err != nil {
+		return err
+	}
+	summary.CurrentMedications = medications
+
+	var vitals triage.Triage
+	err = latestVitals(db(c), user.ID).First(&vitals).Error
+	switch {
+	case err == nil:
+		vitals.Patient = user.Demographics()
+		summary.LatestVitals = &vitals
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+
+	summary.UpcomingAppointments = []models.Appointment{}
+	err = db(c).Preload("Practitioner").Preload("Facility").Preload("Department").
+		Where("user_id = ? AND date >= ? AND status NOT IN ?", user.ID, time.Now(), []string{"completed", "cancelled"}).
+		Order("date").
+		Limit(summaryAppointments).
+		Find(&summary.UpcomingAppointments).Error
+	if err != nil {
+		return err
+	}
+
+	summary.RecentDiagnoses = []models.Diagnosis{}
+	err = db(c).Preload("Practitioner").
+		Joins("JOIN visits ON visits.id = diagnoses.visit_id AND visits.deleted_at IS NULL").
+		Where("visits.user_id = ?", user.ID).
+		Order("diagnoses.created_at DESC").
+		Limit(summaryDiagnoses).
+		Find(&summary.RecentDiagnoses).Error
+	if err != nil {
+		return err
+	}
+
+	contacts, err := emergencyContacts(db(c), user.ID)
+	if err != nil {
+		return err
+	}
+	summary.EmergencyContacts = contacts
+
+	policy, err := policyInForce(c, user.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	summary.Insurance = policy
+	return nil
+}
+
+// latestVitals selects the patient's triage records, newest first
+func latestVitals(tx *gorm.DB, userID uint) *gorm.DB {
+	return tx.Model(&triage.Triage{}).
+		Joins("JOIN visits ON visits.id = "+triageTable+".visit_id AND visits.deleted_at IS NULL").
+		Where("visits.user_id = ?", userID).
+		Order(triageTable + ".created_at DESC")
+}
+
+// currentMedications lists the items of the patient's prescriptions that
+// are neither completed nor cancelled, newest first
+func currentMedications(c *gin.Context, userID uint) ([]currentMedication, error) {
+	var prescriptions []models.Prescription
+	err := db(c).Preload("Items.Medication").
+		Where("user_id = ? AND status NOT IN ?", userID, []string{"completed", "cancelled"}).
+		Order("created_at DESC").
+		Find(&prescriptions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	medications := []currentMedication{}
+	for _, prescription := range prescriptions {
+		for _, item := range prescription.Items {
+			medications = append(medications, currentMedication{
+				PrescriptionID: prescription.ID,
+				VisitID:        prescription.VisitID,
+				PrescribedOn:   prescription.Date,
+				DoctorName:     prescription.DoctorName,
+				Medication:     item.Medication,
+				Dosage:         item.Dosage,
+				Frequency:      item.Frequency,
+				Duration:       item.Duration,
+				Instructions:   item.Instructions,
+			})
+		}
+	}
+	return medications, nil
+}
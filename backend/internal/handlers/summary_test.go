@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"barman/internal/database"
+	"barman/internal/models"
+	"barman/internal/models/triage"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// qualifiedTable finds the table of each qualified column, such as visits
+// in visits.user_id
+var qualifiedTable = regexp.MustCompile(`"?([a-z_]+)"?\."?[a-z_]+"?`)
+
+func TestLatestVitalsNamesItsTables(t *testing.T) {
+	dryRun, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}),
+		&gorm.Config{NamingStrategy: database.Naming, DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt := latestVitals(dryRun, 1).First(&triage.Triage{}).Statement
+	tables := map[string]bool{
+		database.TableName(&triage.Triage{}): true,
+		database.TableName(&models.Visit{}):  true,
+	}
+	for _, match := range qualifiedTable.FindAllStringSubmatch(stmt.SQL.String(), -1) {
+		if !tables[match[1]] {
+			t.Errorf("query refers to table %s, which it does not select from: %s", match[1], stmt.SQL.String())
+		}
+	}
+}
+
+// TestLatestVitalsAgainstSchema runs the query against a migrated schema
+// when TEST_DATABASE_URL names a PostgreSQL database. Nothing is kept, as
+// the schema is created in a transaction that is rolled back.
+func TestLatestVitalsAgainstSchema(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{NamingStrategy: database.Naming})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := conn.Begin()
+	defer tx.Rollback()
+	if err := tx.AutoMigrate(&models.User{}, &models.Visit{}, &triage.Triage{}); err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{FirstName: "Test", LastName: "Patient"}
+	if err := tx.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	visit := models.Visit{UserID: user.ID, Type: "emergency"}
+	if err := tx.Create(&visit).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, heartRate := range []int{80, 95} {
+		record := triage.Triage{VisitID: visit.ID, Type: "pending"}
+		record.HeartRate = &heartRate
+		if err := tx.Create(&record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var vitals triage.Triage
+	if err := latestVitals(tx, user.ID).First(&vitals).Error; err != nil {
+		t.Fatal(err)
+	}
+	if vitals.HeartRate == nil || *vitals.HeartRate != 95 {
+		t.Errorf("latest heart rate = %v, want 95", vitals.HeartRate)
+	}
+}
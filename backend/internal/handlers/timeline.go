@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"barman/internal/database"
+	"barman/internal/listing"
+	"barman/internal/models"
+	"barman/internal/models/triage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Timeline event actions
+const (
+	actionRecorded = "recorded"
+	actionRemoved  = "removed"
+)
+
+// timelineEvent is one entry in a patient's timeline. Data is the record
+// the event is about.
+type timelineEvent struct {
+	Type       string      `json:"type"`
+	Action     string      `json:"action"`
+	ID         uint        `json:"id"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	VisitID    *uint       `json:"visit_id,omitempty"`
+	Title      string      `json:"title"`
+	Data       interface{} `json:"data"`
+
+	// source breaks ties between events at the same moment
+	source string
+}
+
+// timelineCursor is the position after the last event of a page
+type timelineCursor struct {
+	At     time.Time `json:"t"`
+	Source string    `json:"s"`
+	ID     uint      `json:"id"`
+}
+
+// timelineSource is a table that contributes events. Each source is queried
+// in time order with a limit and the results merged, so a page never loads
+// more than a page from any one table.
+type timelineSource struct {
+	name   string // unique, orders sources for events at the same moment
+	typ    string // the event type clients filter on
+	table  string // the table of the model
+	column string // when the event happened, qualified with the table
+	model  func() interface{}
+	scope  timelineScope
+	fetch  func(query *gorm.DB) ([]timelineEvent, error)
+}
+
+// timelineScope limits the records of table to those of a patient
+type timelineScope func(tx *gorm.DB, table string, userID uint) *gorm.DB
+
+// timelineSources are the event sources in a patient's timeline. Surgeries,
+// images and conditions only have free-text dates, so they appear when they
+// were recorded.
+var timelineSources = []timelineSource{
+	timelineSourceOf("visit", "visit", "date", byUser,
+		func(visit *models.Visit) timelineEvent {
+			return timelineEvent{OccurredAt: visit.Date, VisitID: &visit.ID, Title: strings.TrimSpace(visit.Type + " visit")}
+		}),
+	timelineSourceOf("triage", "triage", "created_at", byVisit,
+		func(record *triage.Triage) timelineEvent {
+			return timelineEvent{OccurredAt: record.CreatedAt, VisitID: &record.VisitID, Title: "Triage, priority " + record.PriorityLevel}
+		}),
+	timelineSourceOf("diagnosis", "diagnosis", "created_at", byVisit,
+		func(diagnosis *models.Diagnosis) timelineEvent {
+			return timelineEvent{OccurredAt: diagnosis.CreatedAt, VisitID: &diagnosis.VisitID, Title: diagnosis.Diagnosis}
+		}),
+	timelineSourceOf("prescription", "prescription", "created_at", byUser,
+		func(prescription *models.Prescription) timelineEvent {
+			return timelineEvent{OccurredAt: prescription.CreatedAt, VisitID: &prescription.VisitID, Title: "Prescription by " + prescription.DoctorName}
+		}),
+	timelineSourceOf("surgery", "surgery", "created_at", byUser,
+		func(surgery *models.Surgery) timelineEvent {
+			return timelineEvent{OccurredAt: surgery.CreatedAt, Title: surgery.Name}
+		}),
+	timelineSourceOf("medical_image", "medical_image", "created_at", byUser,
+		func(image *models.MedicalImage) timelineEvent {
+			return timelineEvent{OccurredAt: image.CreatedAt, Title: image.Description}
+		}),
+	timelineSourceOf("appointment", "appointment", "date", byUser,
+		func(appointment *models.Appointment) timelineEvent {
+			return timelineEvent{OccurredAt: appointment.Date, Action: appointment.Status, Title: "Appointment"}
+		}),
+	conditionSource("allergy", func(allergy *models.Allergy) string { return allergy.Name }),
+	removedConditionSource("allergy", func(allergy *models.Allergy) string { return allergy.Name }),
+	conditionSource("chronic_condition", func(condition *models.ChronicCondition) string { return condition.Name }),
+	removedConditionSource("chronic_condition", func(condition *models.ChronicCondition) string { return condition.Name }),
+	conditionSource("disability", func(disability *models.Disability) string { return disability.Name }),
+	removedConditionSource("disability", func(disability *models.Disability) string { return disability.Name }),
+	conditionSource("hereditary_disease", func(disease *models.HereditaryDisease) string { return disease.Name }),
+	removedConditionSource("hereditary_disease", func(disease *models.HereditaryDisease) string { return disease.Name }),
+}
+
+// timelineTypes lists the event types in the order they are documented
+func timelineTypes() []string {
+	var types []string
+	seen := make(map[string]bool)
+	for _, source := range timelineSources {
+		if !seen[source.typ] {
+			seen[source.typ] = true
+			types = append(types, source.typ)
+		}
+	}
+	return types
+}
+
+// timelineSourceOf is a source of records of T, with column, unqualified,
+// telling when each happened
+func timelineSourceOf[T any](name, typ, column string, scope timelineScope, describe func(*T) timelineEvent) timelineSource {
+	table := database.TableName(new(T))
+	return timelineSource{
+		name:   name,
+		typ:    typ,
+		table:  table,
+		column: table + "." + column,
+		model:  func() interface{} { return new(T) },
+		scope:  scope,
+		fetch: func(query *gorm.DB) ([]timelineEvent, error) {
+			var rows []T
+			if err := query.Find(&rows).Error; err != nil {
+				return nil, err
+			}
+			events := make([]timelineEvent, len(rows))
+			for i := range rows {
+				event := describe(&rows[i])
+				event.Type, event.source, event.Data = typ, name, &rows[i]
+				event.ID = idOf(&rows[i])
+				if event.Action == "" {
+					event.Action = actionRecorded
+				}
+				events[i] = event
+			}
+			return events, nil
+		},
+	}
+}
+
+// conditionSource adds an event when a condition is recorded, including
+// conditions that have since been removed
+func conditionSource[T any](typ string, name func(*T) string) timelineSource {
+	scope := func(tx *gorm.DB, table string, userID uint) *gorm.DB {
+		return byUser(tx.Unscoped(), table, userID)
+	}
+	return timelineSourceOf(typ, typ, "created_at", scope, func(record *T) timelineEvent {
+		return timelineEvent{OccurredAt: modelOf(record).CreatedAt, Title: name(record)}
+	})
+}
+
+// removedConditionSource adds an event when a condition is removed from
+// the patient's history
+func removedConditionSource[T any](typ string, name func(*T) string) timelineSource {
+	scope := func(tx *gorm.DB, table string, userID uint) *gorm.DB {
+		return tx.Unscoped().Where(table+".user_id = ? AND "+table+".deleted_at IS NOT NULL", userID)
+	}
+	return timelineSourceOf(typ+":removed", typ, "deleted_at", scope, func(record *T) timelineEvent {
+		return timelineEvent{OccurredAt: modelOf(record).DeletedAt.Time, Action: actionRemoved, Title: name(record)}
+	})
+}
+
+func byUser(tx *gorm.DB, table string, userID uint) *gorm.DB {
+	return tx.Where(table+".user_id = ?", userID)
+}
+
+func byVisit(tx *gorm.DB, table string, userID uint) *gorm.DB {
+	return tx.Joins("JOIN visits ON visits.id = "+table+".visit_id AND visits.deleted_at IS NULL").
+		Where("visits.user_id = ?", userID)
+}
+
+// modelOf returns the embedded gorm.Model of a record
+func modelOf(record interface{}) *gorm.Model {
+	return reflect.Indirect(reflect.ValueOf(record)).FieldByName("Model").Addr().Interface().(*gorm.Model)
+}
+
+func idOf(record interface{}) uint {
+	return modelOf(record).ID
+}
+
+// GetUserTimeline merges a patient's visits, triage, diagnoses,
+// prescriptions, surgeries, images, appointments and changes to their
+// conditions into one stream, newest first unless ?sort=occurred_at.
+// ?types= takes a comma-separated list of event types, and date_from and
+// date_to limit the period. Pages are fetched with ?cursor=.
+func GetUserTimeline(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var count int64
+	if err := db(c).Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	sources, err := timelineRequest(c)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+	limit, sortName, descending, after, err := timelinePaging(c)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	var total int64
+	var events []timelineEvent
+	for _, source := range sources {
+		query, err := timelineQuery(c, source, uint(userID))
+		if err != nil {
+			listError(c, err)
+			return
+		}
+
+		var sourceTotal int64
+		if err := query.Session(&gorm.Session{}).Model(source.model()).Count(&sourceTotal).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		total += sourceTotal
+
+		page := query.Session(&gorm.Session{}).Model(source.model())
+		if after != nil {
+			page = afterTimelineCursor(page, source, after, descending)
+		}
+		idColumn := source.table + ".id"
+		direction := "ASC"
+		if descending {
+			direction = "DESC"
+		}
+		page = page.Order(source.column + " " + direction + ", " + idColumn + " " + direction).Limit(limit + 1)
+
+		sourceEvents, err := source.fetch(page)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		events = append(events, sourceEvents...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if descending {
+			return timelineLess(events[j], events[i])
+		}
+		return timelineLess(events[i], events[j])
+	})
+
+	meta := listing.Meta{Limit: limit, Total: total, Sort: sortName}
+	if len(events) > limit {
+		events = events[:limit]
+		last := events[limit-1]
+		meta.NextCursor = encodeTimelineCursor(timelineCursor{At: last.OccurredAt, Source: last.source, ID: last.ID})
+		meta.Next = listing.NextURL(c, "cursor", meta.NextCursor)
+	}
+	if events == nil {
+		events = []timelineEvent{}
+	}
+
+	c.JSON(http.StatusOK, listing.Page[timelineEvent]{Data: events, Pagination: meta})
+}
+
+// timelineRequest returns the sources of the requested event types
+func timelineRequest(c *gin.Context) ([]timelineSource, error) {
+	value := c.Query("types")
+	if value == "" {
+		return timelineSources, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, typ := range strings.Split(value, ",") {
+		wanted[strings.TrimSpace(typ)] = true
+	}
+	var sources []timelineSource
+	for _, source := range timelineSources {
+		if wanted[source.typ] {
+			sources = append(sources, source)
+			delete(wanted, source.typ)
+		}
+	}
+	for typ := range wanted {
+		if !containsString(timelineTypes(), typ) {
+			return nil, &listing.ParamError{Param: "types", Message: fmt.Sprintf("unknown type %q; expected %s", typ, strings.Join(timelineTypes(), ", "))}
+		}
+	}
+	return sources, nil
+}
+
+func timelinePaging(c *gin.Context) (limit int, sortName string, descending bool, after *timelineCursor, err error) {
+	limit = listing.DefaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, parseErr := strconv.Atoi(value)
+		if parseErr != nil || parsed < 1 {
+			return 0, "", false, nil, &listing.ParamError{Param: "limit", Message: "must be a positive integer"}
+		}
+		limit = min(parsed, listing.MaxLimit)
+	}
+
+	sortName = c.DefaultQuery("sort", "-occurred_at")
+	switch sortName {
+	case "occurred_at":
+	case "-occurred_at":
+		descending = true
+	default:
+		return 0, "", false, nil, &listing.ParamError{Param: "sort", Message: "must be occurred_at or -occurred_at"}
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		after, err = decodeTimelineCursor(value)
+		if err != nil {
+			return 0, "", false, nil, &listing.ParamError{Param: "cursor", Message: "malformed cursor"}
+		}
+	}
+	return limit, sortName, descending, after, nil
+}
+
+// timelineQuery scopes a source to the patient and the requested period
+func timelineQuery(c *gin.Context, source timelineSource, userID uint) (*gorm.DB, error) {
+	query := source.scope(db(c).Model(source.model()), source.table, userID)
+	for param, filter := range map[string]listing.Filter{
+		"date_from": listing.From(source.column),
+		"date_to":   listing.To(source.column),
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		filtered, err := filter(query, value)
+		if err != nil {
+			return nil, &listing.ParamError{Param: param, Message: err.Error()}
+		}
+		query = filtered
+	}
+	return query, nil
+}
+
+// afterTimelineCursor keeps the events that come after the cursor in the
+// order (time, source, id)
+func afterTimelineCursor(query *gorm.DB, source timelineSource, after *timelineCursor, descending bool) *gorm.DB {
+	comparison := ">"
+	if descending {
+		comparison = "<"
+	}
+	idColumn := source.table + ".id"
+
+	switch {
+	case source.name == after.Source:
+		return query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", source.column, comparison, source.column, idColumn, comparison),
+			after.At, after.At, after.ID)
+	case (source.name > after.Source) != descending:
+		// This source sorts after the cursor's among events at the same time
+		return query.Where(fmt.Sprintf("%s %s= ?", source.column, comparison), after.At)
+	default:
+		return query.Where(fmt.Sprintf("%s %s ?", source.column, comparison), after.At)
+	}
+}
+
+func timelineLess(a, b timelineEvent) bool {
+	if !a.OccurredAt.Equal(b.OccurredAt) {
+		return a.OccurredAt.Before(b.OccurredAt)
+	}
+	if a.source != b.source {
+		return a.source < b.source
+	}
+	return a.ID < b.ID
+}
+
+func encodeTimelineCursor(cursor timelineCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeTimelineCursor(raw string) (*timelineCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cursor timelineCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,24 +1,51 @@
 package handlers
 
 import (
+	"barman/internal/auth"
 	"barman/internal/database"
+	"barman/internal/listing"
 	"barman/internal/models"
 	"barman/internal/models/triage"
+	"barman/internal/triagequeue"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func CreateTriage(c *gin.Context) {
 	var triageData triage.Triage
 	if err := c.ShouldBindJSON(&triageData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		validationError(c, err)
+		return
+	}
+	if err := triageData.Validate(); err != nil {
+		validationError(c, err)
 		return
 	}
+	// New triage has not been escalated, whatever the client sent
+	triageData.Model, triageData.Escalations, triageData.EscalatedAt = gorm.Model{}, 0, nil
 
-	result := database.DB.Create(&triageData)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	patient, err := visitDemographics(c, triageData.VisitID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid visit ID"})
+		return
+	}
+	triageData.Patient = patient
+	noteOverride(c, &triageData, triage.Triage{})
+
+	// Recording triage moves the visit on to triaged
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&triageData).Error; err != nil {
+			return err
+		}
+		return advanceToTriaged(tx, triageData.VisitID, triageData.CreatedAt)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -29,12 +56,13 @@ func GetTriage(c *gin.Context) {
 	id := c.Param("id")
 	var triageData triage.Triage
 
-	result := database.DB.First(&triageData, id)
+	result := db(c).First(&triageData, id)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Triage not found"})
 		return
 	}
 
+	triageData.Patient, _ = visitDemographics(c, triageData.VisitID)
 	c.JSON(http.StatusOK, triageData)
 }
 
@@ -42,30 +70,62 @@ func UpdateTriage(c *gin.Context) {
 	id := c.Param("id")
 	var triageData triage.Triage
 
-	if err := database.DB.First(&triageData, id).Error; err != nil {
+	if err := db(c).First(&triageData, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Triage not found"})
 		return
 	}
+	previous := triageData
 
-	if err := c.ShouldBindJSON(&triageData); err != nil {
+	body, err := c.GetRawData()
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := triageData.Update(body); err != nil {
+		validationError(c, err)
+		return
+	}
+	if err := triageData.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+	triageData.Patient, _ = visitDemographics(c, triageData.VisitID)
+	noteOverride(c, &triageData, previous)
 
-	database.DB.Save(&triageData)
+	// Saving scores the triage again
+	if err := db(c).Save(&triageData).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, triageData)
 }
 
+// noteOverride records who overrode the computed priority and when, if the
+// override or its reason changed since previous. Otherwise whatever was
+// recorded before is kept, whatever the client sent.
+func noteOverride(c *gin.Context, t *triage.Triage, previous triage.Triage) {
+	if t.PriorityOverride == previous.PriorityOverride && t.OverrideReason == previous.OverrideReason {
+		t.OverriddenByID, t.OverriddenAt = previous.OverriddenByID, previous.OverriddenAt
+		return
+	}
+
+	now := time.Now()
+	t.OverriddenByID, t.OverriddenAt = nil, &now
+	if staff := auth.CurrentStaff(c); staff != nil {
+		t.OverriddenByID = &staff.ID
+	}
+}
+
 func DeleteTriage(c *gin.Context) {
 	id := c.Param("id")
 	var triageData triage.Triage
 
-	if err := database.DB.First(&triageData, id).Error; err != nil {
+	if err := db(c).First(&triageData, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Triage not found"})
 		return
 	}
 
-	database.DB.Delete(&triageData)
+	db(c).Delete(&triageData)
 	c.JSON(http.StatusOK, gin.H{"message": "Triage deleted successfully"})
 }
 
@@ -73,29 +133,45 @@ func GetVisitTriage(c *gin.Context) {
 	visitID := c.Param("visit_id")
 	var triageData triage.Triage
 
-	result := database.DB.Where("visit_id = ?", visitID).First(&triageData)
+	result := db(c).Where("visit_id = ?", visitID).First(&triageData)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Triage not found for this visit"})
 		return
 	}
 
+	triageData.Patient, _ = visitDemographics(c, triageData.VisitID)
 	c.JSON(http.StatusOK, triageData)
 }
 
+// triageTable is the table triage is stored in, for SQL that joins it
+var triageTable = database.TableName(&triage.Triage{})
+
+var triageListSpec = listing.Spec{
+	Sorts:       map[string]string{"created_at": "created_at", "priority_level": "priority_level"},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"type":           listing.OneOf(triageTable + ".type"),
+		"priority_level": listing.OneOf(triageTable + ".priority_level"),
+		"department_id":  listing.Equals("visits.department_id"),
+		"date_from":      listing.From(triageTable + ".created_at"),
+		"date_to":        listing.To(triageTable + ".created_at"),
+	},
+}
+
 func GetUserTriageHistory(c *gin.Context) {
 	userID := c.Param("user_id")
-	var triageHistory []triage.Triage
 
-	result := database.DB.Where("user_id = ?", userID).
-		Order("created_at DESC").
-		Find(&triageHistory)
+	// Triage records belong to a visit, so filter on the visit's patient
+	query := db(c).Joins("JOIN visits ON visits.id = "+triageTable+".visit_id").
+		Where("visits.user_id = ?", userID)
 
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	page, err := listing.Find[triage.Triage](c, query, triageListSpec)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, triageHistory)
+	c.JSON(http.StatusOK, page)
 }
 
 func GetLatestTriageByUser(c *gin.Context) {
@@ -103,9 +179,10 @@ func GetLatestTriageByUser(c *gin.Context) {
 
 	// First find the latest visit with triage data
 	var visit models.Visit
-	result := database.DB.Where("user_id = ? AND type = ?", userID, "triage").
+	result := db(c).Joins("JOIN "+triageTable+" ON "+triageTable+".visit_id = visits.id AND "+triageTable+".deleted_at IS NULL").
+		Where("visits.user_id = ?", userID).
 		Preload("TriageData").
-		Order("created_at DESC").
+		Order("visits.created_at DESC").
 		First(&visit)
 
 	if result.Error != nil {
@@ -120,32 +197,111 @@ func GetLatestTriageByUser(c *gin.Context) {
 
 	// Add visit_id to the response
 	triageData := map[string]interface{}{
-		"visit_id":          visit.ID,
-		"heart_rate":        visit.TriageData.HeartRate,
-		"blood_pressure":    visit.TriageData.BloodPressure,
-		"temperature":       visit.TriageData.Temperature,
-		"respiratory_rate":  visit.TriageData.RespiratoryRate,
-		"oxygen_saturation": visit.TriageData.OxygenSaturation,
-		"pain_level":        visit.TriageData.PainLevel,
-		"symptoms":          visit.TriageData.Symptoms,
-		"priority_level":    visit.TriageData.PriorityLevel,
-		"created_at":        visit.CreatedAt,
+		"visit_id":               visit.ID,
+		"heart_rate":             visit.TriageData.HeartRate,
+		"blood_pressure":         visit.TriageData.BloodPressureText,
+		"systolic":               visit.TriageData.Systolic,
+		"diastolic":              visit.TriageData.Diastolic,
+		"mean_arterial_pressure": visit.TriageData.MeanArterialPressure,
+		"bp_position":            visit.TriageData.Position,
+		"bp_cuff_site":           visit.TriageData.CuffSite,
+		"temperature":            visit.TriageData.Temperature,
+		"respiratory_rate":       visit.TriageData.RespiratoryRate,
+		"oxygen_saturation":      visit.TriageData.OxygenSaturation,
+		"pain_level":             visit.TriageData.PainLevel,
+		"symptoms":               visit.TriageData.Symptoms,
+		"priority_level":         visit.TriageData.PriorityLevel,
+		"flags":                  visit.TriageData.Flags,
+		"created_at":             visit.CreatedAt,
+	}
+	if patient, err := patientDemographics(c, visit.UserID); err == nil {
+		triageData["patient"] = patient
 	}
 
 	c.JSON(http.StatusOK, triageData)
 }
 
+// triageQueueEntry is a patient waiting with pending triage, with how long
+// they have waited against the target for their priority
+type triageQueueEntry struct {
+	Position    int    `json:"position"`
+	UserID      uint   `json:"user_id"`
+	PatientName string `json:"patient_name"`
+	VisitType   string `json:"visit_type"`
+	VisitStatus string `json:"visit_status"`
+	triage.Triage
+	triagequeue.Wait
+}
+
+// GetPendingTriage returns the queue of patients waiting with pending
+// triage, most urgent first and then in the order they arrived, with each
+// patient's wait. ?department_id= and ?priority_level= narrow it down.
 func GetPendingTriage(c *gin.Context) {
-	var pendingTriage []triage.Triage
+	query := db(c).Preload("User").Scopes(facilityScope(c, "visits"))
+	if value := c.Query("department_id"); value != "" {
+		departmentID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+			return
+		}
+		query = query.Where("visits.department_id = ?", departmentID)
+	}
+	var priorities []string
+	if value := c.Query("priority_level"); value != "" {
+		priorities = strings.Split(value, ",")
+	}
 
-	result := database.DB.Where("status = ?", "pending").
-		Order("created_at ASC").
-		Find(&pendingTriage)
+	now := time.Now()
+	visits, err := triagequeue.Pending(query, now, priorities...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	queue := make([]triageQueueEntry, 0, len(visits))
+	for i := range visits {
+		visit := &visits[i]
+		entry := triageQueueEntry{
+			Position:    len(queue) + 1,
+			UserID:      visit.UserID,
+			VisitType:   visit.Type,
+			VisitStatus: visit.Status,
+			Triage:      *visit.TriageData,
+			Wait:        triagequeue.Measure(visit, now),
+		}
+		if visit.User != nil {
+			entry.PatientName = patientName(visit.User)
+		}
+		queue = append(queue, entry)
+	}
+
+	page, err := listing.Slice(c, queue, "priority_level,arrived_at")
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetUnparsedBloodPressures lists the blood pressure readings recorded as
+// free text before blood pressure had its own columns that could not be
+// read, at the signed-in staff member's facility. Each stays listed until
+// its triage is updated with the systolic and diastolic pressure.
+func GetUnparsedBloodPressures(c *gin.Context) {
+	query := db(c).Joins("JOIN visits ON visits.id = " + triageTable + ".visit_id").
+		Scopes(facilityScope(c, "visits"))
+	readings, err := database.UnparsedBloodPressures(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := listing.Slice(c, readings, "triage_id")
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, pendingTriage)
+	c.JSON(http.StatusOK, page)
 }
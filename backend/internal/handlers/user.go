@@ -1,24 +1,31 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
-	"barman/internal/database"
+	"barman/internal/demographics"
+	"barman/internal/listing"
 	"barman/internal/models"
+	"barman/internal/validation"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func CreateUser(c *gin.Context) {
 	var user models.User
 	if err := c.ShouldBindJSON(&user); err != nil {
+		if errors.Is(err, demographics.ErrInvalidDate) {
+			validationError(c, validation.Errors{"date_of_birth": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Validate required fields
-	if user.FirstName == "" || user.LastName == "" || user.NationalID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "First name, last name, and national ID are required"})
+	if err := user.Validate(); err != nil {
+		validationError(c, err)
 		return
 	}
 
@@ -30,9 +37,18 @@ func CreateUser(c *gin.Context) {
 		user.Weight = 0
 	}
 
-	result := database.DB.Create(&user)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	// Height and weight given at registration start the measurement history
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if measurement := measurementsFromUser(c, &user, 0, 0); measurement != nil {
+			return tx.Create(measurement).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -43,12 +59,13 @@ func GetUser(c *gin.Context) {
 	id := c.Param("id")
 	var user models.User
 
-	result := database.DB.Preload("HereditaryDiseases").
+	result := db(c).Preload("HereditaryDiseases").
 		Preload("Disabilities").
 		Preload("MedicalImages").
 		Preload("Surgeries").
 		Preload("Visits").
 		Preload("Prescriptions").
+		Preload("EmergencyContacts", func(tx *gorm.DB) *gorm.DB { return tx.Order("priority, id") }).
 		First(&user, id)
 
 	if result.Error != nil {
@@ -63,7 +80,13 @@ func GetUserByNationalID(c *gin.Context) {
 	nationalID := c.Param("national_id")
 	var user models.User
 
-	result := database.DB.Where("national_id = ?", nationalID).First(&user)
+	index := models.NationalIDIndex(nationalID)
+	if index == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	result := db(c).Where("national_id_index = ?", *index).First(&user)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -76,35 +99,57 @@ func UpdateUser(c *gin.Context) {
 	id := c.Param("id")
 	var user models.User
 
-	if err := database.DB.First(&user, id).Error; err != nil {
+	if err := db(c).First(&user, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
+	previousHeight, previousWeight := user.Height, user.Weight
 
 	if err := c.ShouldBindJSON(&user); err != nil {
+		if errors.Is(err, demographics.ErrInvalidDate) {
+			validationError(c, validation.Errors{"date_of_birth": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	database.DB.Save(&user)
+	if err := user.Validate(); err != nil {
+		validationError(c, err)
+		return
+	}
+
+	// A changed height or weight is recorded as a new measurement
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if measurement := measurementsFromUser(c, &user, previousHeight, previousWeight); measurement != nil {
+			return tx.Create(measurement).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, user)
 }
 
 func GetAllUsers(c *gin.Context) {
-	var users []models.User
-	database.DB.Find(&users)
-	c.JSON(http.StatusOK, users)
-}
-
-func DeleteUser(c *gin.Context) {
-	id := c.Param("id")
-	var user models.User
-
-	if err := database.DB.First(&user, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	page, err := listing.Find[models.User](c, db(c), listing.Spec{
+		Sorts:       map[string]string{"id": "id", "created_at": "created_at", "last_name": "last_name", "first_name": "first_name"},
+		DefaultSort: "id",
+		Filters: map[string]listing.Filter{
+			"date_from": listing.From("created_at"),
+			"date_to":   listing.To("created_at"),
+		},
+	})
+	if err != nil {
+		listError(c, err)
 		return
 	}
 
-	database.DB.Delete(&user)
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	c.JSON(http.StatusOK, page)
 }
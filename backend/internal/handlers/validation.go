@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"barman/internal/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validationError answers a request whose input failed validation. Problems
+// with individual fields are listed under "fields" so that clients can mark
+// each bad input. A JSON value of the wrong type, such as text where a
+// number belongs, is reported against its field too.
+func validationError(c *gin.Context, err error) {
+	var fields validation.Errors
+	if errors.As(err, &fields) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "fields": fields})
+		return
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		fields = validation.Errors{typeErr.Field: "must be " + jsonKind(typeErr.Type.Kind().String())}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "fields": fields})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// jsonKind names a Go kind the way a JSON client would know it
+func jsonKind(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "string":
+		return "text"
+	case kind == "bool":
+		return "true or false"
+	case kind == "slice", kind == "array":
+		return "a list"
+	}
+	return "an object"
+}
@@ -1,38 +1,90 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
-	"barman/internal/database"
+	"barman/internal/insurance"
+	"barman/internal/listing"
 	"barman/internal/models"
 	"barman/internal/models/triage"
+	"barman/internal/validation"
 
 	"github.com/gin-gonic/gin"
 )
 
+// errVisitType is reported for a visit type other than models.VisitTypes
+var errVisitType = validation.Errors{"type": "must be one of " + strings.Join(models.VisitTypes, ", ")}
+
 func CreateVisit(c *gin.Context) {
 	var visitReq struct {
-		UserID     uint                    `json:"user_id" binding:"required"`
-		Type       string                  `json:"type" binding:"required"`
-		Date       time.Time               `json:"date"`
-		TriageData *map[string]interface{} `json:"triage_data"`
+		UserID         uint           `json:"user_id" binding:"required"`
+		Type           string         `json:"type" binding:"required"`
+		Date           time.Time      `json:"date"`
+		FacilityID     *uint          `json:"facility_id"`
+		DepartmentID   *uint          `json:"department_id"`
+		PractitionerID *uint          `json:"practitioner_id"`
+		TriageData     *triage.Triage `json:"triage_data"`
+		// Charge is the visit fee, used to work out the insurer's share
+		Charge *float64 `json:"charge"`
 	}
 
 	if err := c.ShouldBindJSON(&visitReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		validationError(c, err)
+		return
+	}
+	if !models.IsVisitType(visitReq.Type) {
+		validationError(c, errVisitType)
 		return
 	}
 
+	// Triage sent with the visit is held to the same rules as triage
+	// recorded on its own
+	if visitReq.TriageData != nil {
+		var fields validation.Errors
+		if err := visitReq.TriageData.Validate(); errors.As(err, &fields) {
+			validationError(c, fields.Prefix("triage_data"))
+			return
+		}
+	}
+
 	// Create the visit
 	visit := models.Visit{
-		UserID: visitReq.UserID,
-		Type:   visitReq.Type,
-		Date:   time.Now(),
+		UserID:         visitReq.UserID,
+		Type:           visitReq.Type,
+		Date:           time.Now(),
+		FacilityID:     visitReq.FacilityID,
+		DepartmentID:   visitReq.DepartmentID,
+		PractitionerID: visitReq.PractitionerID,
+	}
+
+	if err := assignFacility(c, &visit.FacilityID, &visit.DepartmentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := resolvePractitioner(c, &visit.PractitionerID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Ask the insurer before the transaction starts, as it may take a while
+	check, err := eligibility(c, visit.UserID, visit.Date, []insurance.Item{
+		{Kind: insurance.KindService, Category: visit.Type, Description: "Visit", Amount: visitReq.Charge},
+	})
+	if errors.Is(err, errPatientNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Start a transaction
-	tx := database.DB.Begin()
+	tx := db(c).Begin()
 
 	// Create the visit first
 	if err := tx.Create(&visit).Error; err != nil {
@@ -41,29 +93,39 @@ func CreateVisit(c *gin.Context) {
 		return
 	}
 
+	check.VisitID = &visit.ID
+	if err := tx.Create(check).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	// If triage data is provided, create it
 	if visitReq.TriageData != nil {
-		data := *visitReq.TriageData
+		data := visitReq.TriageData
 
 		// Create a new triage record
 		triageData := triage.Triage{
 			VisitID:          visit.ID,
-			HeartRate:        int(data["heart_rate"].(float64)),
-			BloodPressure:    data["blood_pressure"].(string),
-			Temperature:      data["temperature"].(float64),
-			RespiratoryRate:  int(data["respiratory_rate"].(float64)),
-			OxygenSaturation: int(data["oxygen_saturation"].(float64)),
-			PainLevel:        int(data["pain_level"].(float64)),
-			Symptoms:         data["symptoms"].(string),
-			PriorityLevel:    data["priority_level"].(string),
+			Vitals:           data.Vitals,
+			Symptoms:         data.Symptoms,
+			PriorityOverride: data.PriorityOverride,
+			OverrideReason:   data.OverrideReason,
 			Type:             "completed",
 		}
+		triageData.Patient, _ = patientDemographics(c, visit.UserID)
+		noteOverride(c, &triageData, triage.Triage{})
 
 		if err := tx.Create(&triageData).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if err := advanceToTriaged(tx, visit.ID, triageData.CreatedAt); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
 	// Commit the transaction
@@ -73,9 +135,12 @@ func CreateVisit(c *gin.Context) {
 	}
 
 	// Load the created visit with its relationships
-	database.DB.Preload("TriageData").
+	db(c).Preload("TriageData").
 		Preload("DoctorReport").
+		Preload("Practitioner").
+		Preload("Eligibility").
 		First(&visit, visit.ID)
+	attachEmergencyContacts(c, &visit)
 
 	c.JSON(http.StatusCreated, visit)
 }
@@ -84,14 +149,20 @@ func GetVisit(c *gin.Context) {
 	id := c.Param("id")
 	var visit models.Visit
 
-	result := database.DB.Preload("TriageData").
+	result := db(c).Preload("TriageData").
 		Preload("DoctorReport").
+		Preload("Practitioner").
+		Preload("Eligibility").
 		First(&visit, id)
 
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Visit not found"})
 		return
 	}
+	if err := attachEmergencyContacts(c, &visit); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, visit)
 }
@@ -100,17 +171,32 @@ func UpdateVisit(c *gin.Context) {
 	id := c.Param("id")
 	var visit models.Visit
 
-	if err := database.DB.First(&visit, id).Error; err != nil {
+	if err := db(c).First(&visit, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Visit not found"})
 		return
 	}
 
+	// The status only changes through ChangeVisitStatus
+	status, times := visit.Status, visit.Times
 	if err := c.ShouldBindJSON(&visit); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	visit.Status, visit.Times = status, times
+	if !models.IsVisitType(visit.Type) {
+		validationError(c, errVisitType)
+		return
+	}
+
+	if visit.PractitionerID != nil {
+		if _, err := resolvePractitioner(c, &visit.PractitionerID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
-	database.DB.Save(&visit)
+	db(c).Save(&visit)
+	attachEmergencyContacts(c, &visit)
 	c.JSON(http.StatusOK, visit)
 }
 
@@ -119,8 +205,8 @@ func GetLatestTriage(c *gin.Context) {
 	var visit models.Visit
 
 	// Find the latest visit with triage data
-	result := database.DB.Where("user_id = ?", userID).
-		Joins("JOIN triage_triages ON visits.id = triage_triages.visit_id").
+	result := db(c).Where("user_id = ?", userID).
+		Joins("JOIN " + triageTable + " ON visits.id = " + triageTable + ".visit_id").
 		Order("visits.created_at DESC").
 		Preload("TriageData").
 		First(&visit)
@@ -135,23 +221,39 @@ func GetLatestTriage(c *gin.Context) {
 		return
 	}
 
+	visit.TriageData.Patient, _ = patientDemographics(c, visit.UserID)
 	c.JSON(http.StatusOK, visit.TriageData)
 }
 
 func GetUserVisits(c *gin.Context) {
 	userID := c.Param("user_id")
-	var visits []models.Visit
 
-	result := database.DB.Where("user_id = ?", userID).
-		Preload("TriageData").
-		Preload("DoctorReport").
-		Order("created_at DESC").
-		Find(&visits)
+	page, err := listing.Find[models.Visit](c, db(c).Where("user_id = ?", userID), listing.Spec{
+		Sorts:       map[string]string{"created_at": "created_at", "date": "date"},
+		DefaultSort: "-created_at",
+		Filters: map[string]listing.Filter{
+			"type":            listing.OneOf("type"),
+			"status":          listing.OneOf("status"),
+			"practitioner_id": listing.Equals("practitioner_id"),
+			"department_id":   listing.Equals("department_id"),
+			"date_from":       listing.From("date"),
+			"date_to":         listing.To("date"),
+		},
+		Preloads: []string{"TriageData", "DoctorReport", "Prescription", "Practitioner"},
+	})
+	if err != nil {
+		listError(c, err)
+		return
+	}
 
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	visits := make([]*models.Visit, len(page.Data))
+	for i := range page.Data {
+		visits[i] = &page.Data[i]
+	}
+	if err := attachEmergencyContacts(c, visits...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, visits)
+	c.JSON(http.StatusOK, page)
 }
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"barman/internal/listing"
+	"barman/internal/models"
+	"barman/internal/models/triage"
+	"barman/internal/visitflow"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errVisitNotFound = errors.New("Visit not found")
+
+// ChangeVisitStatus moves a visit to the next state of its flow, such as
+// from waiting for the doctor to in consultation. A practitioner_id may be
+// given when the consultation starts.
+func ChangeVisitStatus(c *gin.Context) {
+	var input struct {
+		Status         string `json:"status" binding:"required"`
+		PractitionerID *uint  `json:"practitioner_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.PractitionerID != nil {
+		if _, err := resolvePractitioner(c, &input.PractitionerID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var visit models.Visit
+	err := db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&visit, c.Param("id")).Error; err != nil {
+			return errVisitNotFound
+		}
+		if input.PractitionerID != nil {
+			if err := tx.Model(&visit).Update("practitioner_id", *input.PractitionerID).Error; err != nil {
+				return err
+			}
+			visit.PractitionerID = input.PractitionerID
+		}
+		return moveVisit(tx, &visit, input.Status, time.Now())
+	})
+
+	var transitionErr *visitflow.TransitionError
+	switch {
+	case errors.Is(err, errVisitNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, visitflow.ErrUnknownState):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "states": visitflow.States})
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "allowed": visitflow.Next(transitionErr.From)})
+	case errors.Is(err, visitflow.ErrNeedsTriage), errors.Is(err, visitflow.ErrNeedsPractitioner):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		visit.MeasureFlow()
+		c.JSON(http.StatusOK, visit)
+	}
+}
+
+// moveVisit moves a locked visit to state, if the state machine allows it,
+// and records when
+func moveVisit(tx *gorm.DB, visit *models.Visit, state string, at time.Time) error {
+	var triageCount int64
+	if err := tx.Model(&triage.Triage{}).Where("visit_id = ?", visit.ID).Count(&triageCount).Error; err != nil {
+		return err
+	}
+	facts := visitflow.Facts{
+		HasTriage:       triageCount > 0,
+		HasPractitioner: visit.PractitionerID != nil,
+	}
+	if err := visitflow.Check(visit.Status, state, facts); err != nil {
+		return err
+	}
+
+	visit.Status = state
+	visit.Times.Set(state, at)
+	return tx.Model(visit).Updates(map[string]interface{}{
+		"status":                state,
+		visitflow.Column(state): at,
+	}).Error
+}
+
+// advanceToTriaged marks a visit triaged once its triage is recorded,
+// passing through waiting for triage if the visit had not got that far.
+// Visits further along, or that skipped triage, are left as they are.
+func advanceToTriaged(tx *gorm.DB, visitID uint, at time.Time) error {
+	var visit models.Visit
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&visit, visitID).Error; err != nil {
+		return err
+	}
+	if visit.Status == visitflow.Registered {
+		if err := moveVisit(tx, &visit, visitflow.WaitingTriage, at); err != nil {
+			return err
+		}
+	}
+	if visit.Status != visitflow.WaitingTriage {
+		return nil
+	}
+	return moveVisit(tx, &visit, visitflow.Triaged, at)
+}
+
+// flowSummary is the average and median of one duration over many visits
+type flowSummary struct {
+	Visits  int      `json:"visits"`
+	Average *float64 `json:"average"`
+	Median  *float64 `json:"median"`
+}
+
+// flowDurations are the durations summarised by the flow stats, each
+// between the columns of two state times
+var flowDurations = []struct {
+	name, from, to string
+}{
+	{"door_to_triage", flowColumn(visitflow.Registered), flowColumn(visitflow.Triaged)},
+	{"door_to_doctor", flowColumn(visitflow.Registered), flowColumn(visitflow.InConsultation)},
+	{"triage_to_doctor", flowColumn(visitflow.Triaged), flowColumn(visitflow.InConsultation)},
+	{"length_of_stay", flowColumn(visitflow.Registered), "COALESCE(" + flowColumn(visitflow.Discharged) + ", " +
+		flowColumn(visitflow.Admitted) + ", " + flowColumn(visitflow.LeftWithoutBeingSeen) + ")"},
+}
+
+func flowColumn(state string) string {
+	return "visits." + visitflow.Column(state)
+}
+
+// GetPatientFlowStats reports how long patients registered between
+// date_from and date_to (default today) waited for triage and for the
+// doctor, how long they stayed, and how many left without being seen.
+// Durations are in minutes and are summarised by the database.
+func GetPatientFlowStats(c *gin.Context) {
+	query := db(c).Model(&models.Visit{}).Scopes(facilityScope(c, "visits"))
+	if c.Query("date_from") == "" && c.Query("date_to") == "" {
+		today, tomorrow := localDay(time.Now())
+		query = query.Where("visits.registered_at >= ? AND visits.registered_at < ?", today, tomorrow)
+	}
+	for param, filter := range map[string]listing.Filter{
+		"date_from": listing.From("visits.registered_at"),
+		"date_to":   listing.To("visits.registered_at"),
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		filtered, err := filter(query, value)
+		if err != nil {
+			listError(c, &listing.ParamError{Param: param, Message: err.Error()})
+			return
+		}
+		query = filtered
+	}
+
+	var statuses []struct {
+		Status string
+		Visits int
+	}
+	if err := query.Session(&gorm.Session{}).Select("visits.status, COUNT(*) AS visits").
+		Group("visits.status").Scan(&statuses).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	byStatus := make(map[string]int)
+	visits := 0
+	for _, status := range statuses {
+		byStatus[status.Status] = status.Visits
+		visits += status.Visits
+	}
+
+	var leftRate *float64
+	if visits > 0 {
+		rate := round1(float64(byStatus[visitflow.LeftWithoutBeingSeen]) * 100 / float64(visits))
+		leftRate = &rate
+	}
+
+	stats := gin.H{
+		"visits":                          visits,
+		"by_status":                       byStatus,
+		"left_without_being_seen_percent": leftRate,
+	}
+	for _, duration := range flowDurations {
+		summary, err := summarise(query, duration.from, duration.to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		stats[duration.name] = summary
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// summarise works out the average and median minutes from one column to
+// another over the visits query selects, leaving out visits missing either
+func summarise(query *gorm.DB, from, to string) (flowSummary, error) {
+	minutes := "EXTRACT(EPOCH FROM " + to + " - " + from + ")::float8 / 60"
+	var summary flowSummary
+	err := query.Session(&gorm.Session{}).
+		Select("COUNT(" + minutes + ") AS visits, AVG(" + minutes + ") AS average, " +
+			"percentile_cont(0.5) WITHIN GROUP (ORDER BY " + minutes + ") AS median").
+		Scan(&summary).Error
+	if err != nil {
+		return summary, err
+	}
+	for _, value := range []*float64{summary.Average, summary.Median} {
+		if value != nil {
+			*value = round1(*value)
+		}
+	}
+	return summary, nil
+}
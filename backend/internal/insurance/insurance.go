@@ -0,0 +1,180 @@
+// Package insurance works out how much of a visit or prescription an
+// insurer covers. Coverage is decided from the policy and coverage rules
+// kept in the database, and confirmed with the insurer through an Adapter,
+// so that each insurer can be connected to its own online service.
+package insurance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of billable item
+const (
+	KindService    = "service"
+	KindMedication = "medication"
+)
+
+// ErrUnknownAdapter is returned for an insurer whose adapter is not
+// registered
+var ErrUnknownAdapter = errors.New("unknown insurer adapter")
+
+// Rule is the share of a kind of item that an insurer covers. An empty Plan
+// applies to every plan and an empty Category to every category.
+type Rule struct {
+	Plan            string
+	Kind            string
+	Category        string
+	CoveragePercent float64
+}
+
+// Item is one thing being paid for: a visit of a type, or a medication of
+// a category. Amount is optional; shares are given as amounts only when it
+// is known.
+type Item struct {
+	Kind        string   `json:"kind"`
+	Category    string   `json:"category"`
+	Description string   `json:"description,omitempty"`
+	Amount      *float64 `json:"amount,omitempty"`
+}
+
+// Request asks an insurer whether a policy covers items on a date. Items
+// carry the coverage worked out from the local rules, which an adapter may
+// confirm or override.
+type Request struct {
+	InsurerCode  string
+	PolicyNumber string
+	Plan         string
+	// MemberNationalID identifies the patient, who may be a dependant of
+	// the policy holder
+	MemberNationalID string
+	Date             time.Time
+	Items            []Share
+}
+
+// Share is how an item is split between insurer and patient
+type Share struct {
+	Item
+	CoveragePercent float64  `json:"coverage_percent"`
+	InsurerShare    *float64 `json:"insurer_share,omitempty"`
+	PatientShare    *float64 `json:"patient_share,omitempty"`
+}
+
+// Response is an insurer's answer
+type Response struct {
+	Eligible bool
+	// Reason explains a refusal
+	Reason string
+	// Reference is the insurer's reference for the check, if it gives one
+	Reference string
+	Items     []Share
+}
+
+// Adapter connects to an insurer's eligibility service
+type Adapter interface {
+	CheckEligibility(ctx context.Context, request Request) (*Response, error)
+}
+
+var (
+	adaptersMu sync.RWMutex
+	adapters   = map[string]Adapter{}
+)
+
+// Register makes an adapter available under name for insurers that name it
+func Register(name string, adapter Adapter) {
+	adaptersMu.Lock()
+	defer adaptersMu.Unlock()
+	adapters[name] = adapter
+}
+
+// AdapterFor returns the adapter registered under name
+func AdapterFor(name string) (Adapter, error) {
+	adaptersMu.RLock()
+	defer adaptersMu.RUnlock()
+	adapter, ok := adapters[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownAdapter, name)
+	}
+	return adapter, nil
+}
+
+// Adapters lists the registered adapter names
+func Adapters() []string {
+	adaptersMu.RLock()
+	defer adaptersMu.RUnlock()
+	names := make([]string, 0, len(adapters))
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Coverage returns the percentage of item covered under plan. A rule for
+// the plan beats one for every plan, and a rule for the category beats one
+// for every category; items no rule matches are not covered.
+func Coverage(rules []Rule, plan string, item Item) float64 {
+	best, bestScore := 0.0, -1
+	for _, rule := range rules {
+		if rule.Kind != item.Kind ||
+			(rule.Plan != "" && rule.Plan != plan) ||
+			(rule.Category != "" && rule.Category != item.Category) {
+			continue
+		}
+		score := 0
+		if rule.Category != "" {
+			score += 2
+		}
+		if rule.Plan != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = rule.CoveragePercent, score
+		}
+	}
+	return best
+}
+
+// Split divides an item between insurer and patient at percent
+func Split(item Item, percent float64) Share {
+	share := Share{Item: item, CoveragePercent: percent}
+	if item.Amount != nil {
+		insurer := round(*item.Amount * percent / 100)
+		patient := round(*item.Amount - insurer)
+		share.InsurerShare, share.PatientShare = &insurer, &patient
+	}
+	return share
+}
+
+// Totals adds up the shares of every item, or returns nil when an amount is
+// missing
+func Totals(shares []Share) (insurer, patient *float64) {
+	var insurerTotal, patientTotal float64
+	for _, share := range shares {
+		if share.InsurerShare == nil {
+			return nil, nil
+		}
+		insurerTotal += *share.InsurerShare
+		patientTotal += *share.PatientShare
+	}
+	insurerTotal, patientTotal = round(insurerTotal), round(patientTotal)
+	return &insurerTotal, &patientTotal
+}
+
+// SelfPay is every item paid in full by the patient
+func SelfPay(items []Item) []Share {
+	shares := make([]Share, len(items))
+	for i, item := range items {
+		shares[i] = Split(item, 0)
+	}
+	return shares
+}
+
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,64 @@
+package insurance
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// MockAdapterName is the adapter used by insurers that do not name one
+const MockAdapterName = "mock"
+
+func init() {
+	Register(MockAdapterName, NewMock())
+}
+
+// Mock stands in for an insurer's online service. It accepts every policy
+// except suspended ones and confirms the coverage worked out from the local
+// rules, so the whole eligibility flow can be exercised without an insurer.
+type Mock struct {
+	mu        sync.RWMutex
+	suspended map[string]string
+}
+
+// NewMock returns a mock insurer with no suspended policies
+func NewMock() *Mock {
+	return &Mock{suspended: map[string]string{}}
+}
+
+// Suspend makes the mock refuse a policy number with reason
+func (m *Mock) Suspend(policyNumber, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.suspended[strings.ToUpper(policyNumber)] = reason
+}
+
+// Reinstate undoes Suspend
+func (m *Mock) Reinstate(policyNumber string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.suspended, strings.ToUpper(policyNumber))
+}
+
+func (m *Mock) CheckEligibility(ctx context.Context, request Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(request.InsurerCode + "|" + request.PolicyNumber + "|" + request.Date.Format("2006-01-02")))
+	response := &Response{Reference: "MOCK-" + strings.ToUpper(hex.EncodeToString(sum[:6]))}
+
+	m.mu.RLock()
+	reason, suspended := m.suspended[strings.ToUpper(request.PolicyNumber)]
+	m.mu.RUnlock()
+	if suspended {
+		response.Reason = reason
+		return response, nil
+	}
+
+	response.Eligible = true
+	response.Items = request.Items
+	return response, nil
+}
@@ -0,0 +1,91 @@
+// Package jalali converts between the Jalali (Solar Hijri) calendar used in
+// Iran and the Gregorian calendar.
+package jalali
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidDate = errors.New("invalid Jalali date")
+
+// ToGregorian converts a Jalali date to the Gregorian calendar
+func ToGregorian(jy, jm, jd int) (gy, gm, gd int) {
+	jy += 1595
+	days := -355668 + 365*jy + (jy/33)*8 + (jy%33+3)/4 + jd
+	if jm < 7 {
+		days += (jm - 1) * 31
+	} else {
+		days += (jm-7)*30 + 186
+	}
+
+	gy = 400 * (days / 146097)
+	days %= 146097
+	if days > 36524 {
+		days--
+		gy += 100 * (days / 36524)
+		days %= 36524
+		if days >= 365 {
+			days++
+		}
+	}
+	gy += 4 * (days / 1461)
+	days %= 1461
+	if days > 365 {
+		gy += (days - 1) / 365
+		days = (days - 1) % 365
+	}
+
+	gd = days + 1
+	monthDays := []int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+	if gy%4 == 0 && gy%100 != 0 || gy%400 == 0 {
+		monthDays[1] = 29
+	}
+	for gm = 0; gm < 12 && gd > monthDays[gm]; gm++ {
+		gd -= monthDays[gm]
+	}
+	return gy, gm + 1, gd
+}
+
+// FromGregorian converts a Gregorian date to the Jalali calendar
+func FromGregorian(gy, gm, gd int) (jy, jm, jd int) {
+	daysBeforeMonth := []int{0, 31, 59, 90, 120, 151, 181, 212, 243, 273, 304, 334}
+	gy2 := gy
+	if gm > 2 {
+		gy2 = gy + 1
+	}
+	days := 355666 + 365*gy + (gy2+3)/4 - (gy2+99)/100 + (gy2+399)/400 + gd + daysBeforeMonth[gm-1]
+
+	jy = -1595 + 33*(days/12053)
+	days %= 12053
+	jy += 4 * (days / 1461)
+	days %= 1461
+	if days > 365 {
+		jy += (days - 1) / 365
+		days = (days - 1) % 365
+	}
+	if days < 186 {
+		return jy, 1 + days/31, 1 + days%31
+	}
+	return jy, 7 + (days-186)/30, 1 + (days-186)%30
+}
+
+// Date returns the Gregorian time at midnight UTC of a Jalali date, and
+// rejects dates that do not exist such as 1402/12/30
+func Date(jy, jm, jd int) (time.Time, error) {
+	if jm < 1 || jm > 12 || jd < 1 || jd > 31 || jm > 6 && jd > 30 {
+		return time.Time{}, ErrInvalidDate
+	}
+	gy, gm, gd := ToGregorian(jy, jm, jd)
+	if y, m, d := FromGregorian(gy, gm, gd); y != jy || m != jm || d != jd {
+		return time.Time{}, ErrInvalidDate
+	}
+	return time.Date(gy, time.Month(gm), gd, 0, 0, 0, 0, time.UTC), nil
+}
+
+// Format writes t as a Jalali date, e.g. 1369/02/22
+func Format(t time.Time) string {
+	jy, jm, jd := FromGregorian(t.Year(), int(t.Month()), t.Day())
+	return fmt.Sprintf("%04d/%02d/%02d", jy, jm, jd)
+}
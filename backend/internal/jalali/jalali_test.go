@@ -0,0 +1,80 @@
+package jalali
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConversion(t *testing.T) {
+	tests := []struct {
+		jy, jm, jd int
+		gy, gm, gd int
+	}{
+		{1348, 10, 11, 1970, 1, 1},
+		{1357, 11, 22, 1979, 2, 11},
+		{1369, 2, 22, 1990, 5, 12},
+		{1379, 10, 12, 2001, 1, 1},
+		{1395, 1, 1, 2016, 3, 20},
+		{1399, 12, 30, 2021, 3, 20},
+		{1400, 1, 1, 2021, 3, 21},
+		{1402, 12, 29, 2024, 3, 19},
+		{1403, 1, 1, 2024, 3, 20},
+		{1403, 6, 31, 2024, 9, 21},
+		{1403, 7, 1, 2024, 9, 22},
+		{1403, 12, 30, 2025, 3, 20},
+		{1404, 1, 1, 2025, 3, 21},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%02d/%02d", tt.jy, tt.jm, tt.jd), func(t *testing.T) {
+			gy, gm, gd := ToGregorian(tt.jy, tt.jm, tt.jd)
+			if gy != tt.gy || gm != tt.gm || gd != tt.gd {
+				t.Errorf("ToGregorian = %d-%02d-%02d, want %d-%02d-%02d", gy, gm, gd, tt.gy, tt.gm, tt.gd)
+			}
+			jy, jm, jd := FromGregorian(tt.gy, tt.gm, tt.gd)
+			if jy != tt.jy || jm != tt.jm || jd != tt.jd {
+				t.Errorf("FromGregorian = %d/%02d/%02d, want %d/%02d/%02d", jy, jm, jd, tt.jy, tt.jm, tt.jd)
+			}
+		})
+	}
+}
+
+// TestRoundTrip converts every day of ten years there and back
+func TestRoundTrip(t *testing.T) {
+	day := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	for ; day.Year() < 2025; day = day.AddDate(0, 0, 1) {
+		jy, jm, jd := FromGregorian(day.Year(), int(day.Month()), day.Day())
+		back, err := Date(jy, jm, jd)
+		if err != nil {
+			t.Fatalf("%s is %d/%02d/%02d, which Date rejects: %v", day.Format("2006-01-02"), jy, jm, jd, err)
+		}
+		if !back.Equal(day) {
+			t.Fatalf("%s is %d/%02d/%02d, which converts back to %s", day.Format("2006-01-02"), jy, jm, jd, back.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestDateRejectsMissingDays(t *testing.T) {
+	tests := []struct{ jy, jm, jd int }{
+		{1402, 12, 30},
+		{1402, 7, 31},
+		{1402, 1, 32},
+		{1402, 13, 1},
+		{1402, 0, 10},
+		{1402, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%02d/%02d", tt.jy, tt.jm, tt.jd), func(t *testing.T) {
+			if _, err := Date(tt.jy, tt.jm, tt.jd); !errors.Is(err, ErrInvalidDate) {
+				t.Errorf("Date = %v, want %v", err, ErrInvalidDate)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if got := Format(time.Date(1990, 5, 12, 15, 30, 0, 0, time.UTC)); got != "1369/02/22" {
+		t.Errorf("Format = %q, want 1369/02/22", got)
+	}
+}
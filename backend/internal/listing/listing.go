@@ -0,0 +1,313 @@
+// Package listing implements the pagination, sorting and filtering shared by
+// every list endpoint, and the response envelope they return.
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// ParamError reports an invalid query parameter; handlers answer it with 400
+type ParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Message)
+}
+
+// Filter applies one whitelisted query parameter to a query
+type Filter func(tx *gorm.DB, value string) (*gorm.DB, error)
+
+// Spec describes what a list endpoint accepts
+type Spec struct {
+	// Sorts maps the public sort names to columns
+	Sorts map[string]string
+	// DefaultSort is used when no sort is given, e.g. "-created_at"
+	DefaultSort string
+	// Filters maps query parameter names to filters
+	Filters map[string]Filter
+	// Preloads are loaded for the returned page only
+	Preloads []string
+}
+
+// Meta describes the page that was returned and how to get the next one
+type Meta struct {
+	Limit      int    `json:"limit"`
+	Total      int64  `json:"total"`
+	Page       int    `json:"page,omitempty"`
+	Sort       string `json:"sort"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Next       string `json:"next,omitempty"`
+}
+
+// Page is the envelope returned by list endpoints
+type Page[T any] struct {
+	Data       []T  `json:"data"`
+	Pagination Meta `json:"pagination"`
+}
+
+// cursor is the position after the last row of a page: its sort value, nil
+// when the row has none, and primary key, which breaks ties between rows
+// with the same sort value
+type cursor struct {
+	Value interface{} `json:"v"`
+	ID    uint        `json:"id"`
+}
+
+var schemaCache sync.Map
+
+// Find runs query with the filters, sort and page requested in the query
+// string. It accepts either ?page= (offset paging) or ?cursor= (keyset
+// paging, stable while rows are being added) together with ?limit= and
+// ?sort=name or ?sort=-name for descending order.
+func Find[T any](c *gin.Context, query *gorm.DB, spec Spec) (*Page[T], error) {
+	var model T
+	modelSchema, err := schema.Parse(&model, &schemaCache, query.NamingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	table := modelSchema.Table
+
+	limit, err := intParam(c, "limit", DefaultLimit)
+	if err != nil {
+		return nil, err
+	}
+	limit = min(limit, MaxLimit)
+
+	// Filters
+	for name, filter := range spec.Filters {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		if query, err = filter(query, value); err != nil {
+			return nil, &ParamError{Param: name, Message: err.Error()}
+		}
+	}
+
+	// Sort
+	sortName := c.DefaultQuery("sort", spec.DefaultSort)
+	name, descending := strings.CutPrefix(sortName, "-")
+	column, ok := spec.Sorts[name]
+	if !ok {
+		return nil, &ParamError{Param: "sort", Message: fmt.Sprintf("cannot sort by %q", name)}
+	}
+	if !strings.Contains(column, ".") {
+		column = table + "." + column
+	}
+	idColumn := table + "." + modelSchema.PrioritizedPrimaryField.DBName
+	direction, comparison := "ASC", ">"
+	if descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Model(&model).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	// Rows without a value come last either way, which the cursor relies on
+	meta := Meta{Limit: limit, Total: total, Sort: sortName}
+	page := query.Session(&gorm.Session{}).
+		Order(fmt.Sprintf("%s %s NULLS LAST, %s %s", column, direction, idColumn, direction)).
+		Limit(limit + 1)
+
+	rawCursor := c.Query("cursor")
+	if rawCursor != "" {
+		after, err := decodeCursor(rawCursor)
+		if err != nil {
+			return nil, &ParamError{Param: "cursor", Message: "malformed cursor"}
+		}
+		if after.Value == nil {
+			page = page.Where(fmt.Sprintf("(%s IS NULL AND %s %s ?)", column, idColumn, comparison), after.ID)
+		} else {
+			page = page.Where(
+				fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?) OR %s IS NULL)",
+					column, comparison, column, idColumn, comparison, column),
+				after.Value, after.Value, after.ID,
+			)
+		}
+	} else {
+		pageNumber, err := intParam(c, "page", 1)
+		if err != nil {
+			return nil, err
+		}
+		meta.Page = pageNumber
+		page = page.Offset((pageNumber - 1) * limit)
+	}
+
+	for _, preload := range spec.Preloads {
+		page = page.Preload(preload)
+	}
+
+	rows := make([]T, 0, limit+1)
+	if err := page.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[limit-1]
+		meta.NextCursor, err = encodeCursor(c, modelSchema, column, &last)
+		if err != nil {
+			return nil, err
+		}
+		if rawCursor != "" {
+			meta.Next = NextURL(c, "cursor", meta.NextCursor)
+		} else {
+			meta.Next = NextURL(c, "page", strconv.Itoa(meta.Page+1))
+		}
+	}
+
+	return &Page[T]{Data: rows, Pagination: meta}, nil
+}
+
+// Slice pages through rows that are computed in Go rather than queried, so
+// only ?limit= and ?page= apply. sort describes the order rows are in.
+func Slice[T any](c *gin.Context, rows []T, sort string) (*Page[T], error) {
+	limit, err := intParam(c, "limit", DefaultLimit)
+	if err != nil {
+		return nil, err
+	}
+	limit = min(limit, MaxLimit)
+	pageNumber, err := intParam(c, "page", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := Meta{Limit: limit, Total: int64(len(rows)), Page: pageNumber, Sort: sort}
+	start := min((pageNumber-1)*limit, len(rows))
+	end := min(start+limit, len(rows))
+	if end < len(rows) {
+		meta.Next = NextURL(c, "page", strconv.Itoa(pageNumber+1))
+	}
+
+	data := make([]T, end-start)
+	copy(data, rows[start:end])
+	return &Page[T]{Data: data, Pagination: meta}, nil
+}
+
+func encodeCursor(c *gin.Context, modelSchema *schema.Schema, column string, row interface{}) (string, error) {
+	_, dbName, _ := strings.Cut(column, ".")
+	field := modelSchema.LookUpField(dbName)
+	if field == nil {
+		return "", fmt.Errorf("sort column %s is not a field of %s", column, modelSchema.Name)
+	}
+
+	rv := reflect.ValueOf(row)
+	value, _ := field.ValueOf(c, rv)
+	id, _ := modelSchema.PrioritizedPrimaryField.ValueOf(c, rv)
+	primaryKey, _ := id.(uint)
+
+	data, err := json.Marshal(cursor{Value: value, ID: primaryKey})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(raw string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var after cursor
+	if err := json.Unmarshal(data, &after); err != nil {
+		return nil, err
+	}
+	return &after, nil
+}
+
+// NextURL is the current request URL with one query parameter replaced
+func NextURL(c *gin.Context, param, value string) string {
+	query := c.Request.URL.Query()
+	query.Set(param, value)
+	if param == "cursor" {
+		query.Del("page")
+	}
+	next := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+	return next.String()
+}
+
+func intParam(c *gin.Context, name string, fallback int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		return 0, &ParamError{Param: name, Message: "must be a positive integer"}
+	}
+	return parsed, nil
+}
+
+// Equals matches column exactly
+func Equals(column string) Filter {
+	return func(tx *gorm.DB, value string) (*gorm.DB, error) {
+		return tx.Where(column+" = ?", value), nil
+	}
+}
+
+// OneOf matches column against a comma-separated list of values
+func OneOf(column string) Filter {
+	return func(tx *gorm.DB, value string) (*gorm.DB, error) {
+		return tx.Where(column+" IN ?", strings.Split(value, ",")), nil
+	}
+}
+
+// From keeps rows where column is on or after a date or timestamp
+func From(column string) Filter {
+	return func(tx *gorm.DB, value string) (*gorm.DB, error) {
+		start, _, err := ParseTime(value)
+		if err != nil {
+			return nil, err
+		}
+		return tx.Where(column+" >= ?", start), nil
+	}
+}
+
+// To keeps rows where column is before a timestamp, or on or before a date
+func To(column string) Filter {
+	return func(tx *gorm.DB, value string) (*gorm.DB, error) {
+		end, dateOnly, err := ParseTime(value)
+		if err != nil {
+			return nil, err
+		}
+		if dateOnly {
+			// Include the whole day
+			end = end.Add(24 * time.Hour)
+		}
+		return tx.Where(column+" < ?", end), nil
+	}
+}
+
+// ParseTime accepts either a date (2006-01-02) or an RFC 3339 timestamp and
+// reports whether the value was a plain date
+func ParseTime(value string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("expected a date (2006-01-02) or RFC 3339 timestamp")
+	}
+	return t, false, nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"math"
+	"time"
+
+	"barman/internal/validation"
+
+	"gorm.io/gorm"
+)
+
+// Plausible head circumference; see user_validation.go for height and weight
+const (
+	MinHeadCircumferenceCm = 20
+	MaxHeadCircumferenceCm = 70
+)
+
+// Anthropometric is one set of body measurements, usually taken at a visit
+// or during triage. Measurements are never overwritten so that growth and
+// weight trends can be followed; User.Height and User.Weight hold the most
+// recent values.
+type Anthropometric struct {
+	gorm.Model
+	UserID              uint      `json:"user_id" gorm:"index"`
+	VisitID             *uint     `json:"visit_id" gorm:"index"`
+	TriageID            *uint     `json:"triage_id" gorm:"index"`
+	MeasuredAt          time.Time `json:"measured_at" gorm:"index"`
+	HeightCm            *float64  `json:"height_cm"`
+	WeightKg            *float64  `json:"weight_kg"`
+	HeadCircumferenceCm *float64  `json:"head_circumference_cm"`
+	BMI                 *float64  `json:"bmi"` // computed from height and weight
+	RecordedByID        *uint     `json:"recorded_by_id"`
+	Notes               string    `json:"notes"`
+	User                *User     `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Visit               *Visit    `json:"visit,omitempty" gorm:"foreignKey:VisitID"`
+}
+
+// BeforeSave computes the BMI when both height and weight were measured
+func (a *Anthropometric) BeforeSave(tx *gorm.DB) error {
+	a.BMI = nil
+	if a.HeightCm != nil && a.WeightKg != nil && *a.HeightCm > 0 {
+		meters := *a.HeightCm / 100
+		bmi := math.Round(*a.WeightKg/(meters*meters)*10) / 10
+		a.BMI = &bmi
+	}
+	return nil
+}
+
+// Validate checks that something was measured and that each measurement is
+// plausible
+func (a *Anthropometric) Validate() error {
+	errs := validation.Errors{}
+	if a.HeightCm == nil && a.WeightKg == nil && a.HeadCircumferenceCm == nil {
+		errs.Add("weight_kg", "at least one of height, weight or head circumference is required")
+	}
+	if a.HeightCm != nil {
+		errs.Check("height_cm", validation.Range(*a.HeightCm, MinHeightCm, MaxHeightCm, "cm"))
+	}
+	if a.WeightKg != nil {
+		errs.Check("weight_kg", validation.Range(*a.WeightKg, MinWeightKg, MaxWeightKg, "kg"))
+	}
+	if a.HeadCircumferenceCm != nil {
+		errs.Check("head_circumference_cm", validation.Range(*a.HeadCircumferenceCm, MinHeadCircumferenceCm, MaxHeadCircumferenceCm, "cm"))
+	}
+	if a.MeasuredAt.After(time.Now().Add(time.Minute)) {
+		errs.Add("measured_at", "cannot be in the future")
+	}
+	return errs.Err()
+}
@@ -1,15 +1,21 @@
 package models
 
 import (
-	"time"
 	"gorm.io/gorm"
+	"time"
 )
 
 type Appointment struct {
 	gorm.Model
-	UserID    uint      `json:"user_id"`
-	Date      time.Time `json:"date"`
-	Status    string    `json:"status"` // scheduled, completed, cancelled
-	Notes     string    `json:"notes"`
-	User      *User     `json:"user" gorm:"foreignKey:UserID"`
-} 
\ No newline at end of file
+	UserID         uint          `json:"user_id"`
+	Date           time.Time     `json:"date"`
+	Status         string        `json:"status"` // scheduled, completed, cancelled
+	Notes          string        `json:"notes"`
+	FacilityID     *uint         `json:"facility_id" gorm:"index"`
+	DepartmentID   *uint         `json:"department_id" gorm:"index"`
+	PractitionerID *uint         `json:"practitioner_id" gorm:"index"`
+	Practitioner   *Practitioner `json:"practitioner,omitempty" gorm:"foreignKey:PractitionerID"`
+	User           *User         `json:"user" gorm:"foreignKey:UserID"`
+	Facility       *Facility     `json:"facility,omitempty" gorm:"foreignKey:FacilityID"`
+	Department     *Department   `json:"department,omitempty" gorm:"foreignKey:DepartmentID"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit actions
+const (
+	AuditActionCreate = "create"
+	AuditActionRead   = "read"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	// Patient lifecycle actions, recorded once for the whole patient on top
+	// of the entries for each record they touch
+	AuditActionRestore   = "restore"
+	AuditActionAnonymise = "anonymise"
+	AuditActionPurge     = "purge"
+)
+
+// AuditLog is an append-only record of who read or changed which record.
+// Entries are never updated or deleted, so it does not embed gorm.Model.
+type AuditLog struct {
+	ID         uint            `json:"id" gorm:"primarykey"`
+	CreatedAt  time.Time       `json:"created_at" gorm:"index"`
+	ActorID    *uint           `json:"actor_id" gorm:"index"`
+	ActorRole  string          `json:"actor_role"`
+	Action     string          `json:"action" gorm:"index"` // create, read, update, delete, restore, anonymise, purge
+	EntityType string          `json:"entity_type" gorm:"index"`
+	EntityID   *uint           `json:"entity_id"`
+	PatientID  *uint           `json:"patient_id" gorm:"index"`
+	ClientIP   string          `json:"client_ip"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Changes    json.RawMessage `json:"changes,omitempty" gorm:"type:jsonb"`
+}
@@ -6,10 +6,12 @@ import (
 
 type Diagnosis struct {
 	gorm.Model
-	VisitID   uint   `json:"visit_id"`
-	Diagnosis string `json:"diagnosis"`
-	Notes     string `json:"notes"`
-	Visit     *Visit `json:"visit" gorm:"foreignKey:VisitID"`
+	VisitID        uint          `json:"visit_id"`
+	Diagnosis      string        `json:"diagnosis"`
+	Notes          string        `json:"notes"`
+	Visit          *Visit        `json:"visit" gorm:"foreignKey:VisitID"`
+	PractitionerID *uint         `json:"practitioner_id" gorm:"index"`
+	Practitioner   *Practitioner `json:"practitioner,omitempty" gorm:"foreignKey:PractitionerID"`
 	// Remove the incorrect foreign key relationship
 	// Prescription *Prescription `json:"prescription" gorm:"foreignKey:DiagnosisID"`
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"strings"
+
+	"barman/internal/fieldcrypt"
+	"barman/internal/validation"
+
+	"gorm.io/gorm"
+)
+
+// EmergencyContact is someone to notify about a patient. Contacts are
+// called in Priority order, 1 first, and only those authorised by the
+// patient may be told about their condition and treatment. The name and
+// notes identify the contact and are encrypted like their phone numbers.
+type EmergencyContact struct {
+	gorm.Model
+	UserID                   uint                       `json:"user_id" gorm:"index"`
+	Name                     fieldcrypt.EncryptedString `json:"name"`
+	Relationship             string                     `json:"relationship"` // e.g. spouse, mother, friend
+	MobilePhone              fieldcrypt.EncryptedString `json:"mobile_phone"`
+	AlternatePhone           fieldcrypt.EncryptedString `json:"alternate_phone"`
+	Address                  fieldcrypt.EncryptedString `json:"address"`
+	Priority                 int                        `json:"priority"`
+	AuthorisedForMedicalInfo bool                       `json:"authorised_for_medical_info"`
+	Notes                    fieldcrypt.EncryptedString `json:"notes"`
+	User                     *User                      `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// Validate checks that a contact can be reached and rewrites the phone
+// numbers in E.164
+func (e *EmergencyContact) Validate() error {
+	errs := validation.Errors{}
+
+	e.Name = fieldcrypt.EncryptedString(strings.TrimSpace(string(e.Name)))
+	e.Relationship = strings.TrimSpace(e.Relationship)
+	if e.Name == "" {
+		errs.Add("name", "is required")
+	}
+	if strings.TrimSpace(string(e.MobilePhone)) == "" && strings.TrimSpace(string(e.AlternatePhone)) == "" {
+		errs.Add("mobile_phone", "at least one phone number is required")
+	}
+	e.MobilePhone = normalisePhone(errs, "mobile_phone", e.MobilePhone, validation.Mobile)
+	e.AlternatePhone = normalisePhone(errs, "alternate_phone", e.AlternatePhone, validation.Landline)
+	if e.Priority < 1 {
+		errs.Add("priority", "must be 1 or more")
+	}
+
+	return errs.Err()
+}
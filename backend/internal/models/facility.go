@@ -0,0 +1,27 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Facility is a clinic or hospital site. Patients are shared between
+// facilities; visits, appointments and staff belong to one.
+type Facility struct {
+	gorm.Model
+	Name        string       `json:"name"`
+	Code        string       `json:"code" gorm:"uniqueIndex"`
+	Address     string       `json:"address"`
+	Phone       string       `json:"phone"`
+	Active      bool         `json:"active" gorm:"default:true"`
+	Departments []Department `json:"departments,omitempty" gorm:"foreignKey:FacilityID"`
+}
+
+// Department is a unit within a facility, e.g. emergency or cardiology
+type Department struct {
+	gorm.Model
+	FacilityID uint      `json:"facility_id" gorm:"index"`
+	Name       string    `json:"name"`
+	Code       string    `json:"code"`
+	Active     bool      `json:"active" gorm:"default:true"`
+	Facility   *Facility `json:"facility,omitempty" gorm:"foreignKey:FacilityID"`
+}
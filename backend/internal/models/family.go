@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"barman/internal/pedigree"
+	"barman/internal/validation"
+
+	"gorm.io/gorm"
+)
+
+// FamilyRelation links a patient to a relative who is also registered as a
+// patient. Each relationship is stored from both sides, so a parent link
+// from a child always has a matching child link from the parent. A patient
+// is linked to a given relative at most once, which a unique index added by
+// database.UniqueFamilyRelations enforces.
+type FamilyRelation struct {
+	gorm.Model
+	UserID       uint   `json:"user_id" gorm:"index"`
+	RelativeID   uint   `json:"relative_id" gorm:"index"`
+	Relationship string `json:"relationship"` // what the relative is to the patient: parent, child, sibling, spouse
+	Notes        string `json:"notes"`
+	User         *User  `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Relative     *User  `json:"relative,omitempty" gorm:"foreignKey:RelativeID"`
+}
+
+// Validate checks the relationship and that a patient is not linked to
+// themselves
+func (r *FamilyRelation) Validate() error {
+	errs := validation.Errors{}
+	r.Relationship = strings.ToLower(strings.TrimSpace(r.Relationship))
+	if r.RelativeID == 0 {
+		errs.Add("relative_id", "is required")
+	} else if r.RelativeID == r.UserID {
+		errs.Add("relative_id", "cannot be the patient")
+	}
+	if pedigree.Inverse(r.Relationship) == "" {
+		errs.Add("relationship", "must be one of "+strings.Join(pedigree.Relationships, ", "))
+	}
+	return errs.Err()
+}
+
+// Validate checks the affected relative and age at onset of a hereditary
+// disease
+func (d *HereditaryDisease) Validate() error {
+	errs := validation.Errors{}
+	d.Name = strings.TrimSpace(d.Name)
+	d.Relationship = strings.ToLower(strings.TrimSpace(d.Relationship))
+	if d.Name == "" {
+		errs.Add("name", "is required")
+	}
+	if d.RelativeID != nil && *d.RelativeID == d.UserID {
+		// The patient is affected; leave the relative out
+		d.RelativeID = nil
+	}
+	if d.Relationship != "" {
+		if _, ok := pedigree.Named(d.Relationship); !ok {
+			errs.Add("relationship", "must be one of "+strings.Join(pedigree.Names(), ", "))
+		}
+	}
+	if d.AgeAtOnset != nil && (*d.AgeAtOnset < 0 || *d.AgeAtOnset > MaxAgeYears) {
+		errs.Add("age_at_onset", fmt.Sprintf("must be between 0 and %d years", MaxAgeYears))
+	}
+	return errs.Err()
+}
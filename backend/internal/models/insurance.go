@@ -0,0 +1,174 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"barman/internal/demographics"
+	"barman/internal/insurance"
+	"barman/internal/validation"
+
+	"gorm.io/gorm"
+)
+
+// Insurer is an insurance company or fund. Adapter names the
+// insurance.Adapter used to confirm eligibility with it.
+type Insurer struct {
+	gorm.Model
+	Name    string `json:"name"`
+	Code    string `json:"code" gorm:"uniqueIndex"`
+	Adapter string `json:"adapter"`
+	// PolicyNumberPattern is a regular expression that the insurer's
+	// policy numbers match, if they follow a known format
+	PolicyNumberPattern string         `json:"policy_number_pattern"`
+	Phone               string         `json:"phone"`
+	Active              bool           `json:"active"`
+	CoverageRules       []CoverageRule `json:"coverage_rules,omitempty" gorm:"foreignKey:InsurerID"`
+}
+
+// CoverageRule is the percentage an insurer pays for a kind of item. Kind is
+// service, for visits, whose category is the visit type, or medication,
+// whose category is the medication category. An empty Plan or Category
+// applies to all.
+type CoverageRule struct {
+	gorm.Model
+	InsurerID       uint    `json:"insurer_id" gorm:"index"`
+	Plan            string  `json:"plan"`
+	Kind            string  `json:"kind"`
+	Category        string  `json:"category"`
+	CoveragePercent float64 `json:"coverage_percent"`
+}
+
+// InsurancePolicy is a patient's insurance. The policy holder is UserID;
+// dependants such as children are covered by the same policy.
+type InsurancePolicy struct {
+	gorm.Model
+	UserID       uint               `json:"user_id" gorm:"index"`
+	InsurerID    uint               `json:"insurer_id" gorm:"index"`
+	PolicyNumber string             `json:"policy_number" gorm:"index" audit:"redact"`
+	Plan         string             `json:"plan"`
+	ValidFrom    *demographics.Date `json:"valid_from" gorm:"type:date"`
+	ValidTo      *demographics.Date `json:"valid_to" gorm:"type:date"`
+	Active       bool               `json:"active"`
+	Dependants   []PolicyDependant  `json:"dependants" gorm:"foreignKey:PolicyID"`
+	Insurer      *Insurer           `json:"insurer,omitempty" gorm:"foreignKey:InsurerID"`
+	User         *User              `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// PolicyDependant is a patient covered by someone else's policy
+type PolicyDependant struct {
+	gorm.Model
+	PolicyID     uint   `json:"policy_id" gorm:"index"`
+	UserID       uint   `json:"user_id" gorm:"index"`
+	Relationship string `json:"relationship"` // e.g. spouse, child
+	User         *User  `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// EligibilityCheck records how a visit or prescription was split between
+// insurer and patient when it was created. Shares are amounts only when the
+// price of every item was known.
+type EligibilityCheck struct {
+	gorm.Model
+	UserID         uint            `json:"user_id" gorm:"index"`
+	VisitID        *uint           `json:"visit_id" gorm:"index"`
+	PrescriptionID *uint           `json:"prescription_id" gorm:"index"`
+	PolicyID       *uint           `json:"policy_id"`
+	InsurerID      *uint           `json:"insurer_id"`
+	Adapter        string          `json:"adapter,omitempty"`
+	Reference      string          `json:"reference,omitempty"`
+	CheckedAt      time.Time       `json:"checked_at"`
+	Eligible       bool            `json:"eligible"`
+	Reason         string          `json:"reason,omitempty"`
+	InsurerShare   *float64        `json:"insurer_share"`
+	PatientShare   *float64        `json:"patient_share"`
+	Items          json.RawMessage `json:"items" gorm:"type:jsonb"`
+}
+
+// Validate checks an insurer's code, adapter and policy number pattern
+func (i *Insurer) Validate() error {
+	errs := validation.Errors{}
+	i.Name = strings.TrimSpace(i.Name)
+	i.Code = strings.ToUpper(strings.TrimSpace(i.Code))
+	if i.Name == "" {
+		errs.Add("name", "is required")
+	}
+	if i.Code == "" {
+		errs.Add("code", "is required")
+	}
+	if i.Adapter == "" {
+		i.Adapter = insurance.MockAdapterName
+	}
+	if _, err := insurance.AdapterFor(i.Adapter); err != nil {
+		errs.Add("adapter", "must be one of "+strings.Join(insurance.Adapters(), ", "))
+	}
+	if _, err := i.PolicyPattern(); err != nil {
+		errs.Add("policy_number_pattern", "is not a valid regular expression")
+	}
+	return errs.Err()
+}
+
+// PolicyPattern compiles PolicyNumberPattern, anchored to the whole number
+func (i *Insurer) PolicyPattern() (*regexp.Regexp, error) {
+	if i.PolicyNumberPattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + i.PolicyNumberPattern + ")$")
+}
+
+// Validate checks a coverage rule
+func (r *CoverageRule) Validate() error {
+	errs := validation.Errors{}
+	r.Plan = strings.TrimSpace(r.Plan)
+	r.Category = strings.TrimSpace(r.Category)
+	if r.Kind != insurance.KindService && r.Kind != insurance.KindMedication {
+		errs.Add("kind", "must be service or medication")
+	}
+	errs.Check("coverage_percent", validation.Range(r.CoveragePercent, 0, 100, "percent"))
+	return errs.Err()
+}
+
+// Validate checks a policy against its insurer's policy number format and
+// rewrites the number in canonical form
+func (p *InsurancePolicy) Validate(insurer *Insurer) error {
+	errs := validation.Errors{}
+	p.Plan = strings.TrimSpace(p.Plan)
+
+	pattern, _ := insurer.PolicyPattern()
+	if number, err := validation.PolicyNumber(p.PolicyNumber, pattern); err != nil {
+		errs.Check("policy_number", err)
+	} else {
+		p.PolicyNumber = number
+	}
+
+	if p.ValidFrom != nil && p.ValidTo != nil && p.ValidTo.Before(p.ValidFrom.Time) {
+		errs.Add("valid_to", "cannot be before valid_from")
+	}
+	for i, dependant := range p.Dependants {
+		field := fmt.Sprintf("dependants[%d].user_id", i)
+		switch {
+		case dependant.UserID == 0:
+			errs.Add(field, "is required")
+		case dependant.UserID == p.UserID:
+			errs.Add(field, "cannot be the policy holder")
+		}
+	}
+	return errs.Err()
+}
+
+// CoversOn reports whether the policy is in force on the day of at
+func (p *InsurancePolicy) CoversOn(at time.Time) bool {
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	if !p.Active {
+		return false
+	}
+	if p.ValidFrom != nil && day.Before(p.ValidFrom.Time) {
+		return false
+	}
+	if p.ValidTo != nil && day.After(p.ValidTo.Time) {
+		return false
+	}
+	return true
+}
@@ -4,11 +4,19 @@ import (
 	"gorm.io/gorm"
 )
 
+// HereditaryDisease is a condition in the patient's family history. It
+// affects the patient unless a relative is given, either a registered
+// patient in RelativeID or, for relatives who are not registered, one of
+// the names accepted by pedigree.Named in Relationship.
 type HereditaryDisease struct {
 	gorm.Model
-	UserID uint   `json:"user_id"`
-	Name   string `json:"name"`
-	User   *User  `json:"user" gorm:"foreignKey:UserID"`
+	UserID       uint   `json:"user_id"`
+	Name         string `json:"name"`
+	RelativeID   *uint  `json:"relative_id" gorm:"index"`
+	Relationship string `json:"relationship"`
+	AgeAtOnset   *int   `json:"age_at_onset"` // in years
+	User         *User  `json:"user" gorm:"foreignKey:UserID"`
+	Relative     *User  `json:"relative,omitempty" gorm:"foreignKey:RelativeID"`
 }
 
 type Disability struct {
@@ -25,17 +33,20 @@ type MedicalImage struct {
 	ImageURL    string `json:"image_url"`
 	Description string `json:"description"`
 	CenterName  string `json:"center_name"`
+	FacilityID  *uint  `json:"facility_id" gorm:"index"` // set when the image was taken at one of our facilities
 	Date        string `json:"date"`
 	User        *User  `json:"user" gorm:"foreignKey:UserID"`
 }
 
 type Surgery struct {
 	gorm.Model
-	UserID      uint   `json:"user_id"`
-	Name        string `json:"name"`
-	Date        string `json:"date"`
-	Description string `json:"description"`
-	User        *User  `json:"user" gorm:"foreignKey:UserID"`
+	UserID         uint          `json:"user_id"`
+	Name           string        `json:"name"`
+	Date           string        `json:"date"`
+	Description    string        `json:"description"`
+	User           *User         `json:"user" gorm:"foreignKey:UserID"`
+	PractitionerID *uint         `json:"practitioner_id" gorm:"index"`
+	Practitioner   *Practitioner `json:"practitioner,omitempty" gorm:"foreignKey:PractitionerID"`
 }
 
 type Allergy struct {
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"barman/internal/demographics"
+
 	"gorm.io/gorm"
 )
 
@@ -44,18 +46,24 @@ type MedicationCatalog struct {
 // Prescription represents a doctor's prescription for a patient
 type Prescription struct {
 	gorm.Model
-	UserID       uint               `json:"user_id" gorm:"index"`
-	User         User               `json:"user"`
-	VisitID      uint               `json:"visit_id" gorm:"index"`
-	Visit        Visit              `json:"visit"`
-	DiagnosisID  uint               `json:"diagnosis_id" gorm:"index"`
-	Diagnosis    *Diagnosis         `json:"diagnosis" gorm:"foreignKey:DiagnosisID"`
-	Items        []PrescriptionItem `json:"items" gorm:"foreignKey:PrescriptionID"`
-	Notes        string             `json:"notes"`
-	Date         string             `json:"date"`
-	DoctorName   string             `json:"doctor_name"`
-	Status       string             `json:"status"` // e.g. active, completed, cancelled
-	Instructions string             `json:"instructions"`
+	UserID         uint               `json:"user_id" gorm:"index"`
+	User           User               `json:"user"`
+	VisitID        uint               `json:"visit_id" gorm:"index"`
+	Visit          Visit              `json:"visit"`
+	DiagnosisID    uint               `json:"diagnosis_id" gorm:"index"`
+	Diagnosis      *Diagnosis         `json:"diagnosis" gorm:"foreignKey:DiagnosisID"`
+	Items          []PrescriptionItem `json:"items" gorm:"foreignKey:PrescriptionID"`
+	Notes          string             `json:"notes"`
+	Date           string             `json:"date"`
+	DoctorName     string             `json:"doctor_name"` // copied from the practitioner when one is set
+	PractitionerID *uint              `json:"practitioner_id" gorm:"index"`
+	Practitioner   *Practitioner      `json:"practitioner,omitempty" gorm:"foreignKey:PractitionerID"`
+	Status         string             `json:"status"` // e.g. active, completed, cancelled
+	Instructions   string             `json:"instructions"`
+	Eligibility    *EligibilityCheck  `json:"eligibility,omitempty" gorm:"foreignKey:PrescriptionID"`
+
+	// Patient is the sex and age of the patient, for pediatric dosing
+	Patient *demographics.Patient `json:"patient,omitempty" gorm:"-"`
 }
 
 // PrescriptionItem represents a single medication in a prescription
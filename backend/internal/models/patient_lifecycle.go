@@ -0,0 +1,28 @@
+package models
+
+import (
+	"encoding/json"
+
+	"gorm.io/gorm"
+)
+
+// Patient lifecycle actions
+const (
+	LifecycleDeleted    = "deleted"
+	LifecycleRestored   = "restored"
+	LifecycleAnonymised = "anonymised"
+	LifecyclePurged     = "purged"
+)
+
+// PatientLifecycleEvent records a patient being deleted, restored,
+// anonymised or purged. Records lists the IDs affected, keyed by table, so
+// that a deletion restores exactly what it removed. It has no foreign key
+// to the patient as it outlives a purge.
+type PatientLifecycleEvent struct {
+	gorm.Model
+	UserID  uint            `json:"user_id" gorm:"index"`
+	Action  string          `json:"action"`
+	Reason  string          `json:"reason"`
+	Records json.RawMessage `json:"records" gorm:"type:jsonb"` // e.g. {"visits": [4, 9]}
+	ActorID *uint           `json:"actor_id"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PatientMerge records that a duplicate registration was folded into a
+// surviving patient. MovedRecords lists the IDs moved from the duplicate,
+// keyed by table, so that the merge can be undone.
+type PatientMerge struct {
+	gorm.Model
+	SurvivorID   uint            `json:"survivor_id" gorm:"index"`
+	DuplicateID  uint            `json:"duplicate_id" gorm:"index"`
+	Reason       string          `json:"reason"`
+	MovedRecords json.RawMessage `json:"moved_records" gorm:"type:jsonb"` // e.g. {"visits": [4, 9]}
+	MergedByID   *uint           `json:"merged_by_id"`
+	UndoneAt     *time.Time      `json:"undone_at"`
+	UndoneByID   *uint           `json:"undone_by_id"`
+	Survivor     *User           `json:"survivor,omitempty" gorm:"foreignKey:SurvivorID"`
+	Duplicate    *User           `json:"duplicate,omitempty" gorm:"foreignKey:DuplicateID"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Practitioner is a clinician who can be recorded as responsible for visits,
+// diagnoses, prescriptions, appointments and surgeries
+type Practitioner struct {
+	gorm.Model
+	FirstName         string      `json:"first_name"`
+	LastName          string      `json:"last_name"`
+	Specialty         string      `json:"specialty" gorm:"index"`
+	LicenseNumber     string      `json:"license_number" gorm:"uniqueIndex"` // medical council number
+	DepartmentID      *uint       `json:"department_id" gorm:"index"`
+	Department        *Department `json:"department,omitempty" gorm:"foreignKey:DepartmentID"`
+	SignatureImageURL string      `json:"signature_image_url"`
+	Active            bool        `json:"active" gorm:"default:true"`
+	StaffID           *uint       `json:"staff_id" gorm:"uniqueIndex"` // login account of the practitioner, if any
+	Staff             *Staff      `json:"staff,omitempty" gorm:"foreignKey:StaffID"`
+}
+
+// FullName returns the practitioner's display name
+func (p Practitioner) FullName() string {
+	return strings.TrimSpace(p.FirstName + " " + p.LastName)
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Staff roles
+const (
+	RoleAdmin        = "admin"
+	RoleDoctor       = "doctor"
+	RoleNurse        = "nurse"
+	RoleReceptionist = "receptionist"
+	RolePharmacist   = "pharmacist"
+)
+
+// Staff represents a member of staff who can sign in to the system
+type Staff struct {
+	gorm.Model
+	Username     string    `json:"username" gorm:"uniqueIndex;not null"`
+	PasswordHash string    `json:"-" gorm:"not null"`
+	FullName     string    `json:"full_name"`
+	Role         string    `json:"role" gorm:"index;not null"` // admin, doctor, nurse, receptionist, pharmacist
+	Active       bool      `json:"active" gorm:"default:true"`
+	FacilityID   *uint     `json:"facility_id" gorm:"index"` // nil for staff who work across all facilities
+	Facility     *Facility `json:"facility,omitempty" gorm:"foreignKey:FacilityID"`
+}
+
+// ValidRole reports whether role is one of the known staff roles
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleDoctor, RoleNurse, RoleReceptionist, RolePharmacist:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package triage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"barman/internal/validation"
+)
+
+// Positions the patient may be in when blood pressure is taken
+var Positions = []string{"sitting", "standing", "lying"}
+
+// CuffSites are where the cuff may be placed
+var CuffSites = []string{"left_arm", "right_arm", "left_wrist", "right_wrist", "left_thigh", "right_thigh"}
+
+var errBloodPressureFormat = errors.New("must be written as systolic/diastolic, e.g. 120/80")
+
+// BloodPressure is a blood pressure reading in mmHg, kept in columns of its
+// own so that it can be charted and scored
+type BloodPressure struct {
+	Systolic  *int `json:"systolic"`
+	Diastolic *int `json:"diastolic"`
+	// MeanArterialPressure is worked out from the systolic and diastolic
+	// pressures
+	MeanArterialPressure *int   `json:"mean_arterial_pressure"`
+	Position             string `json:"bp_position"`
+	CuffSite             string `json:"bp_cuff_site"`
+}
+
+// ParseBloodPressure reads a reading written as systolic/diastolic, such as
+// "120/80" or "120 / 80 mmHg"
+func ParseBloodPressure(text string) (BloodPressure, error) {
+	text = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(text)), "mmhg"))
+	systolic, diastolic, found := strings.Cut(text, "/")
+	sys, sysErr := strconv.Atoi(strings.TrimSpace(systolic))
+	dia, diaErr := strconv.Atoi(strings.TrimSpace(diastolic))
+	if !found || sysErr != nil || diaErr != nil {
+		return BloodPressure{}, errBloodPressureFormat
+	}
+	reading := BloodPressure{Systolic: &sys, Diastolic: &dia}
+	reading.measure()
+	return reading, nil
+}
+
+// String writes the reading as systolic/diastolic, or "" when it was not
+// taken
+func (b BloodPressure) String() string {
+	if b.Systolic == nil || b.Diastolic == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", *b.Systolic, *b.Diastolic)
+}
+
+// measure works out the mean arterial pressure as a third of the way from
+// the diastolic to the systolic pressure
+func (b *BloodPressure) measure() {
+	b.MeanArterialPressure = nil
+	if b.Systolic != nil && b.Diastolic != nil {
+		mean := int(math.Round(float64(*b.Systolic+2**b.Diastolic) / 3))
+		b.MeanArterialPressure = &mean
+	}
+}
+
+// Clear removes the reading, keeping where and how it was taken
+func (b *BloodPressure) Clear() {
+	b.Systolic, b.Diastolic, b.MeanArterialPressure = nil, nil, nil
+}
+
+// merge takes the systolic and diastolic pressures from text, which must
+// agree with any given separately
+func (b *BloodPressure) merge(text string) error {
+	if text == "" {
+		return nil
+	}
+	parsed, err := ParseBloodPressure(text)
+	if err != nil {
+		return err
+	}
+	if b.Systolic != nil && *b.Systolic != *parsed.Systolic || b.Diastolic != nil && *b.Diastolic != *parsed.Diastolic {
+		return errors.New("does not match systolic and diastolic")
+	}
+	b.Systolic, b.Diastolic = parsed.Systolic, parsed.Diastolic
+	return nil
+}
+
+// check records what is wrong with the reading in errs
+func (b *BloodPressure) check(errs validation.Errors) {
+	switch {
+	case b.Systolic == nil && b.Diastolic != nil:
+		errs.Add("systolic", "is required with diastolic")
+	case b.Systolic != nil && b.Diastolic == nil:
+		errs.Add("diastolic", "is required with systolic")
+	case b.Systolic != nil:
+		errs.Check("systolic", validation.Range(float64(*b.Systolic), 40, 300, "mmHg"))
+		errs.Check("diastolic", validation.Range(float64(*b.Diastolic), 20, 200, "mmHg"))
+		if *b.Diastolic >= *b.Systolic {
+			errs.Add("diastolic", "must be lower than systolic")
+		}
+	}
+
+	b.Position = strings.ToLower(strings.TrimSpace(b.Position))
+	if b.Position != "" && !oneOf(b.Position, Positions) {
+		errs.Add("bp_position", "must be one of "+strings.Join(Positions, ", "))
+	}
+	b.CuffSite = strings.ToLower(strings.TrimSpace(b.CuffSite))
+	if b.CuffSite != "" && !oneOf(b.CuffSite, CuffSites) {
+		errs.Add("bp_cuff_site", "must be one of "+strings.Join(CuffSites, ", "))
+	}
+	b.measure()
+}
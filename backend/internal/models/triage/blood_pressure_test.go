@@ -0,0 +1,155 @@
+package triage
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseBloodPressure(t *testing.T) {
+	tests := []struct {
+		text      string
+		systolic  int
+		diastolic int
+		mean      int
+		ok        bool
+	}{
+		{"120/80", 120, 80, 93, true},
+		{" 120 / 80 ", 120, 80, 93, true},
+		{"120/80 mmHg", 120, 80, 93, true},
+		{"130/85MMHG", 130, 85, 100, true},
+		{"90/60", 90, 60, 70, true},
+		{"", 0, 0, 0, false},
+		{"120", 0, 0, 0, false},
+		{"120/", 0, 0, 0, false},
+		{"/80", 0, 0, 0, false},
+		{"high/80", 0, 0, 0, false},
+		{"120-80", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			reading, err := ParseBloodPressure(tt.text)
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("parsed %q as %s, want an error", tt.text, reading)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkReading(t, reading, tt.systolic, tt.diastolic)
+			if reading.MeanArterialPressure == nil || *reading.MeanArterialPressure != tt.mean {
+				t.Errorf("mean arterial pressure = %v, want %d", reading.MeanArterialPressure, tt.mean)
+			}
+		})
+	}
+}
+
+func TestBloodPressureOnCreate(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		systolic  int
+		diastolic int
+		ok        bool
+	}{
+		{"text", `{"blood_pressure":"120/80"}`, 120, 80, true},
+		{"fields", `{"systolic":120,"diastolic":80}`, 120, 80, true},
+		{"text and fields that agree", `{"blood_pressure":"120/80","systolic":120,"diastolic":80}`, 120, 80, true},
+		{"text and fields that differ", `{"blood_pressure":"120/80","systolic":130,"diastolic":80}`, 0, 0, false},
+		{"unreadable text", `{"blood_pressure":"high"}`, 0, 0, false},
+		{"systolic alone", `{"systolic":120}`, 0, 0, false},
+		{"diastolic above systolic", `{"systolic":80,"diastolic":120}`, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var record Triage
+			if err := json.Unmarshal([]byte(tt.body), &record); err != nil {
+				t.Fatal(err)
+			}
+			err := record.Validate()
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("accepted %s as %s", tt.body, record.BloodPressure)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkReading(t, record.BloodPressure, tt.systolic, tt.diastolic)
+			if record.BloodPressureText != record.BloodPressure.String() {
+				t.Errorf("blood_pressure = %q, want %q", record.BloodPressureText, record.BloodPressure.String())
+			}
+		})
+	}
+}
+
+// TestBloodPressureOnUpdate updates a triage stored as 120/80 sitting
+func TestBloodPressureOnUpdate(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		systolic  int
+		diastolic int
+		ok        bool
+	}{
+		{"text", `{"blood_pressure":"130/85"}`, 130, 85, true},
+		{"same text", `{"blood_pressure":"120/80"}`, 120, 80, true},
+		{"fields", `{"systolic":130,"diastolic":85}`, 130, 85, true},
+		{"text and fields that agree", `{"blood_pressure":"130/85","systolic":130,"diastolic":85}`, 130, 85, true},
+		{"text and fields that differ", `{"blood_pressure":"130/85","systolic":140,"diastolic":85}`, 0, 0, false},
+		{"text with one field", `{"blood_pressure":"130/85","systolic":130}`, 130, 85, true},
+		{"other vital signs", `{"heart_rate":90}`, 120, 80, true},
+		{"empty text", `{"blood_pressure":""}`, 120, 80, true},
+		{"unreadable text", `{"blood_pressure":"high"}`, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := storedTriage(t)
+			if err := record.Update([]byte(tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			err := record.Validate()
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("accepted %s as %s", tt.body, record.BloodPressure)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkReading(t, record.BloodPressure, tt.systolic, tt.diastolic)
+			if record.Position != "sitting" {
+				t.Errorf("bp_position = %q, want it kept as sitting", record.Position)
+			}
+		})
+	}
+}
+
+// storedTriage is a triage as loaded from the database, with a blood
+// pressure of 120/80 taken sitting
+func storedTriage(t *testing.T) Triage {
+	t.Helper()
+	reading, err := ParseBloodPressure("120/80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reading.Position = "sitting"
+	record := Triage{VisitID: 1, Type: "pending"}
+	record.BloodPressure = reading
+	record.BloodPressureText = reading.String()
+	return record
+}
+
+func checkReading(t *testing.T, reading BloodPressure, systolic, diastolic int) {
+	t.Helper()
+	if reading.Systolic == nil || *reading.Systolic != systolic ||
+		reading.Diastolic == nil || *reading.Diastolic != diastolic {
+		t.Errorf("reading = %s, want %d/%d", reading, systolic, diastolic)
+	}
+	if want := (systolic + 2*diastolic + 1) / 3; reading.MeanArterialPressure == nil || *reading.MeanArterialPressure != want {
+		t.Errorf("mean arterial pressure = %v, want %d", reading.MeanArterialPressure, want)
+	}
+}
@@ -0,0 +1,101 @@
+package triage
+
+import (
+	"encoding/json"
+
+	"barman/internal/demographics"
+	"barman/internal/events"
+	"barman/internal/vitalnorms"
+
+	"gorm.io/gorm"
+)
+
+// Alert is the data of an alert event, raised when a reading is saved with
+// vital signs in the critical range
+type Alert struct {
+	Source    string `json:"source"` // triage, observation
+	PatientID uint   `json:"patient_id,omitempty"`
+	// Critical holds the flags of the critical vital signs, keyed by their
+	// JSON name
+	Critical vitalnorms.Flags `json:"critical"`
+}
+
+// flagging holds what a reading needs to raise each critical value once:
+// the critical flags already raised or stored, and those to raise once the
+// reading is saved
+type flagging struct {
+	raised  vitalnorms.Flags
+	pending vitalnorms.Flags
+}
+
+// values returns the vital signs by their JSON name, for flagging
+func (v *Vitals) values() map[string]*float64 {
+	return map[string]*float64{
+		"heart_rate":        intValue(v.HeartRate),
+		"temperature":       v.Temperature,
+		"respiratory_rate":  intValue(v.RespiratoryRate),
+		"oxygen_saturation": intValue(v.OxygenSaturation),
+		"systolic":          intValue(v.Systolic),
+		"diastolic":         intValue(v.Diastolic),
+	}
+}
+
+// flag flags values against the patient's reference ranges, returning the
+// flags to store. Critical values that differ from those already raised
+// are kept to raise once the reading is saved.
+func (f *flagging) flag(values map[string]*float64, patient *demographics.Patient) (json.RawMessage, error) {
+	flags := vitalnorms.AssessAll(values, patient)
+	stored, err := json.Marshal(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	f.pending = make(vitalnorms.Flags)
+	for parameter, flag := range flags.Critical() {
+		if raised, ok := f.raised[parameter]; !ok || raised.Value != flag.Value {
+			f.pending[parameter] = flag
+		}
+	}
+	return stored, nil
+}
+
+// loaded notes the critical values of stored flags as already raised
+func (f *flagging) loaded(stored json.RawMessage) {
+	var flags vitalnorms.Flags
+	if len(stored) > 0 && json.Unmarshal(stored, &flags) == nil {
+		f.raised = flags.Critical()
+	}
+}
+
+// raise publishes an alert for the critical values pending, once the
+// request saving the reading has succeeded
+func (f *flagging) raise(tx *gorm.DB, source string, id, visitID uint, patient *demographics.Patient) {
+	if len(f.pending) == 0 {
+		return
+	}
+	alert := Alert{Source: source, Critical: f.pending}
+	if patient != nil {
+		alert.PatientID = patient.UserID
+	}
+	var facilityIDs []*uint
+	tx.Session(&gorm.Session{NewDB: true}).Table("visits").Where("id = ?", visitID).Pluck("facility_id", &facilityIDs)
+	event := events.Event{
+		Topic:    events.TopicAlert,
+		Action:   events.Raised,
+		EntityID: id,
+		VisitID:  visitID,
+		Data:     alert,
+	}
+	if len(facilityIDs) > 0 {
+		event.FacilityID = facilityIDs[0]
+	}
+	events.PublishFrom(tx.Statement.Context, event)
+
+	if f.raised == nil {
+		f.raised = make(vitalnorms.Flags)
+	}
+	for parameter, flag := range f.pending {
+		f.raised[parameter] = flag
+	}
+	f.pending = nil
+}
@@ -0,0 +1,226 @@
+package triage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"barman/internal/acuity"
+	"barman/internal/demographics"
+	"barman/internal/validation"
+
+	"gorm.io/gorm"
+)
+
+// Observation is a set of vital signs taken during a visit after triage.
+// Emergency patients are re-assessed every 15 to 60 minutes, and the series
+// shows whether they are getting worse.
+type Observation struct {
+	gorm.Model
+	VisitID    uint      `json:"visit_id" gorm:"index"`
+	ObservedAt time.Time `json:"observed_at" gorm:"index"`
+	Vitals     `gorm:"embedded"`
+	// GCS is the Glasgow Coma Scale, 3 to 15
+	GCS         *int     `json:"gcs" gorm:"column:gcs"`
+	GlucoseMgDl *float64 `json:"glucose_mg_dl"`
+	WeightKg    *float64 `json:"weight_kg"`
+	Notes       string   `json:"notes"`
+	// NEWS2 is scored whenever the observation is saved
+	NEWS2        *int   `json:"news2" gorm:"column:news2"`
+	NEWS2Risk    string `json:"news2_risk" gorm:"column:news2_risk"`
+	ScoreVersion string `json:"score_version"`
+	// Flags compares each measurement with the reference range for the
+	// patient's age band, keyed by its JSON name
+	Flags json.RawMessage `json:"flags" gorm:"type:jsonb"`
+	// RecordedByID is the member of staff who took the observation
+	RecordedByID *uint `json:"recorded_by_id"`
+
+	// Patient is the sex and age of the patient, for age-specific norms
+	Patient *demographics.Patient `json:"patient,omitempty" gorm:"-"`
+
+	flagging flagging
+}
+
+// Validate checks that something was measured and that each measurement is
+// plausible
+func (o *Observation) Validate() error {
+	errs := validation.Errors{}
+	o.Vitals.check(errs)
+	if !o.Vitals.Measured() && o.GCS == nil && o.GlucoseMgDl == nil && o.WeightKg == nil {
+		errs.Add("heart_rate", "at least one vital sign, GCS, glucose or weight is required")
+	}
+	if o.GCS != nil {
+		errs.Check("gcs", validation.Range(float64(*o.GCS), 3, 15, "points"))
+	}
+	if o.GlucoseMgDl != nil {
+		errs.Check("glucose_mg_dl", validation.Range(*o.GlucoseMgDl, 10, 1500, "mg/dL"))
+	}
+	if o.WeightKg != nil {
+		errs.Check("weight_kg", validation.Range(*o.WeightKg, 0.3, 350, "kg"))
+	}
+	if o.ObservedAt.After(time.Now().Add(5 * time.Minute)) {
+		errs.Add("observed_at", "cannot be in the future")
+	}
+	return errs.Err()
+}
+
+// BeforeSave scores and flags the observation
+func (o *Observation) BeforeSave(tx *gorm.DB) error {
+	o.BloodPressure.measure()
+	vitals := o.Vitals.acuity()
+	vitals.GCS = o.GCS
+	if o.Patient != nil {
+		vitals.Age = o.Patient.Age
+	}
+	result := acuity.Score(vitals)
+	o.NEWS2, o.NEWS2Risk, o.ScoreVersion = result.NEWS2, result.NEWS2Risk, result.Version
+	return o.Flag()
+}
+
+// AfterSave raises an alert for measurements in the critical range
+func (o *Observation) AfterSave(tx *gorm.DB) error {
+	o.flagging.raise(tx, "observation", o.ID, o.VisitID, o.Patient)
+	return nil
+}
+
+// AfterFind writes the blood pressure out as text for older clients
+func (o *Observation) AfterFind(tx *gorm.DB) error {
+	o.BloodPressureText = o.BloodPressure.String()
+	o.flagging.loaded(o.Flags)
+	return nil
+}
+
+// Flag compares the measurements with the reference ranges for the
+// patient's age band, or the adult ranges when the age is not known
+func (o *Observation) Flag() error {
+	values := o.Vitals.values()
+	values["gcs"] = intValue(o.GCS)
+	values["glucose_mg_dl"] = o.GlucoseMgDl
+	flags, err := o.flagging.flag(values, o.Patient)
+	if err != nil {
+		return err
+	}
+	o.Flags = flags
+	return nil
+}
+
+// Deterioration is a change between two readings that suggests the patient
+// is getting worse
+type Deterioration struct {
+	Parameter string `json:"parameter"`
+	Message   string `json:"message"`
+}
+
+// deteriorationRule flags a change in one parameter of at least Threshold
+// in the direction of Sign, +1 for a rise and -1 for a fall
+type deteriorationRule struct {
+	Parameter string
+	Label     string
+	Value     func(*Observation) *float64
+	Sign      float64
+	Threshold float64
+	Unit      string
+}
+
+var deteriorationRules = []deteriorationRule{
+	{"news2", "NEWS2", func(o *Observation) *float64 { return intValue(o.NEWS2) }, 1, 2, "points"},
+	{"heart_rate", "Heart rate", func(o *Observation) *float64 { return intValue(o.HeartRate) }, 1, 20, "beats per minute"},
+	{"respiratory_rate", "Respiratory rate", func(o *Observation) *float64 { return intValue(o.RespiratoryRate) }, 1, 5, "breaths per minute"},
+	{"oxygen_saturation", "Oxygen saturation", func(o *Observation) *float64 { return intValue(o.OxygenSaturation) }, -1, 3, "%"},
+	{"systolic", "Systolic pressure", func(o *Observation) *float64 { return intValue(o.Systolic) }, -1, 20, "mmHg"},
+	{"temperature", "Temperature", func(o *Observation) *float64 { return o.Temperature }, 1, 1, "°C"},
+	{"gcs", "GCS", func(o *Observation) *float64 { return intValue(o.GCS) }, -1, 2, "points"},
+	{"pain_level", "Pain", func(o *Observation) *float64 { return intValue(o.PainLevel) }, 1, 3, "points"},
+}
+
+// Changes returns how much each parameter measured in both previous and o
+// changed, keyed by its JSON name
+func (o *Observation) Changes(previous *Observation) map[string]float64 {
+	changes := make(map[string]float64)
+	for _, rule := range deteriorationRules {
+		before, after := rule.Value(previous), rule.Value(o)
+		if before != nil && after != nil {
+			changes[rule.Parameter] = roundTenth(*after - *before)
+		}
+	}
+	if previous.GlucoseMgDl != nil && o.GlucoseMgDl != nil {
+		changes["glucose_mg_dl"] = roundTenth(*o.GlucoseMgDl - *previous.GlucoseMgDl)
+	}
+	if previous.WeightKg != nil && o.WeightKg != nil {
+		changes["weight_kg"] = roundTenth(*o.WeightKg - *previous.WeightKg)
+	}
+	return changes
+}
+
+// Deteriorations lists the changes since previous that suggest the patient
+// is getting worse: a NEWS2 up by 2 or more, heart rate up by 20, breathing
+// up by 5, oxygen saturation down by 3 points, systolic pressure down by 20,
+// temperature up by 1 °C, GCS down by 2 or pain up by 3. A NEWS2 that
+// reaches a higher risk band is flagged too.
+func (o *Observation) Deteriorations(previous *Observation) []Deterioration {
+	flags := []Deterioration{}
+	changes := o.Changes(previous)
+	for _, rule := range deteriorationRules {
+		change, ok := changes[rule.Parameter]
+		if !ok || change*rule.Sign < rule.Threshold {
+			continue
+		}
+		direction := "risen"
+		if rule.Sign < 0 {
+			direction = "fallen"
+		}
+		flags = append(flags, Deterioration{
+			Parameter: rule.Parameter,
+			Message:   fmt.Sprintf("%s has %s by %g %s", rule.Label, direction, change*rule.Sign, rule.Unit),
+		})
+	}
+	if riskRank(o.NEWS2Risk) > riskRank(previous.NEWS2Risk) && previous.NEWS2Risk != "" {
+		flags = append(flags, Deterioration{
+			Parameter: "news2_risk",
+			Message:   fmt.Sprintf("NEWS2 risk has risen from %s to %s", previous.NEWS2Risk, o.NEWS2Risk),
+		})
+	}
+	return flags
+}
+
+// ObservationOf returns the vital signs taken at triage as the first
+// reading of a visit's series
+func ObservationOf(t *Triage) Observation {
+	observation := Observation{
+		Model:        t.Model,
+		VisitID:      t.VisitID,
+		ObservedAt:   t.CreatedAt,
+		Vitals:       t.Vitals,
+		NEWS2:        t.NEWS2,
+		ScoreVersion: t.ScoreVersion,
+		Flags:        t.Flags,
+		Patient:      t.Patient,
+	}
+	var result acuity.Result
+	if json.Unmarshal(t.ScoreBreakdown, &result) == nil {
+		observation.NEWS2Risk = result.NEWS2Risk
+	}
+	return observation
+}
+
+func riskRank(risk string) int {
+	for i, r := range []string{acuity.RiskLow, acuity.RiskLowMedium, acuity.RiskMedium, acuity.RiskHigh} {
+		if r == risk {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func intValue(v *int) *float64 {
+	if v == nil {
+		return nil
+	}
+	f := float64(*v)
+	return &f
+}
+
+func roundTenth(v float64) float64 {
+	return math.Round(v*10) / 10
+}
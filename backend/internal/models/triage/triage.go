@@ -1,19 +1,196 @@
 package triage
 
 import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"barman/internal/acuity"
+	"barman/internal/demographics"
+	"barman/internal/validation"
+
 	"gorm.io/gorm"
 )
 
+// Priority levels, most urgent first
+const (
+	PriorityCritical  = "critical"
+	PriorityUrgent    = "urgent"
+	PriorityNormal    = "normal"
+	PriorityNonUrgent = "non-urgent"
+)
+
+// Priorities lists the priority levels, most urgent first
+var Priorities = []string{PriorityCritical, PriorityUrgent, PriorityNormal, PriorityNonUrgent}
+
+// esiPriorities gives the priority of each acuity level, from 1 to 5
+var esiPriorities = []string{PriorityCritical, PriorityUrgent, PriorityNormal, PriorityNonUrgent, PriorityNonUrgent}
+
+// Triage is a nurse's assessment of a visit. Vital signs are nil when they
+// could not be measured.
 type Triage struct {
 	gorm.Model
-	VisitID          uint    `json:"visit_id"`
-	HeartRate        int     `json:"heart_rate"`
-	BloodPressure    string  `json:"blood_pressure"`
-	Temperature      float64 `json:"temperature"`
-	RespiratoryRate  int     `json:"respiratory_rate"`
-	OxygenSaturation int     `json:"oxygen_saturation"`
-	PainLevel        int     `json:"pain_level"`
-	Symptoms         string  `json:"symptoms"`
-	PriorityLevel    string  `json:"priority_level" gorm:"default:'normal'"`
-	Type             string  `json:"type" gorm:"default:'pending'"` // pending, completed, cancelled
+	VisitID  uint   `json:"visit_id"`
+	Symptoms string `json:"symptoms"`
+	Type     string `json:"type" gorm:"default:'pending'"` // pending, completed, cancelled
+	Vitals   `gorm:"embedded"`
+
+	// PriorityLevel is the priority the patient is seen with: the computed
+	// priority unless a nurse overrode it
+	PriorityLevel string `json:"priority_level" gorm:"default:'normal'"`
+	// The score worked out from the vital signs whenever they are saved;
+	// ScoreBreakdown is the acuity.Result
+	NEWS2            *int            `json:"news2" gorm:"column:news2"`
+	ESILevel         *int            `json:"esi_level"`
+	ComputedPriority string          `json:"computed_priority"`
+	ScoreBreakdown   json.RawMessage `json:"score_breakdown" gorm:"type:jsonb"`
+	ScoreVersion     string          `json:"score_version"`
+	// A nurse may replace the computed priority, giving a reason
+	PriorityOverride string     `json:"priority_override"`
+	OverrideReason   string     `json:"override_reason"`
+	OverriddenByID   *uint      `json:"overridden_by_id"`
+	OverriddenAt     *time.Time `json:"overridden_at"`
+	// A patient who waits longer than the target for their priority is
+	// escalated: raised one priority level, or reported to the nurse in
+	// charge once critical
+	Escalations int        `json:"escalations" gorm:"not null;default:0"`
+	EscalatedAt *time.Time `json:"escalated_at"`
+	// Flags compares each vital sign with the reference range for the
+	// patient's age band, keyed by its JSON name
+	Flags json.RawMessage `json:"flags" gorm:"type:jsonb"`
+
+	// Patient is the sex and age of the patient, for age-specific norms
+	Patient *demographics.Patient `json:"patient,omitempty" gorm:"-"`
+
+	flagging flagging
+}
+
+// Validate checks that the vital signs given are physiologically plausible
+// and that the status and any priority override are known. Vital signs
+// that were not measured may be left out.
+func (t *Triage) Validate() error {
+	errs := validation.Errors{}
+	t.Vitals.check(errs)
+
+	t.PriorityOverride = strings.ToLower(strings.TrimSpace(t.PriorityOverride))
+	t.OverrideReason = strings.TrimSpace(t.OverrideReason)
+	if t.PriorityOverride != "" {
+		if !oneOf(t.PriorityOverride, Priorities) {
+			errs.Add("priority_override", "must be one of "+strings.Join(Priorities, ", "))
+		}
+		if t.OverrideReason == "" {
+			errs.Add("override_reason", "is required to override the computed priority")
+		}
+	}
+	if t.Type != "" && !oneOf(t.Type, []string{"pending", "completed", "cancelled"}) {
+		errs.Add("type", "must be pending, completed or cancelled")
+	}
+	return errs.Err()
+}
+
+// Update binds the JSON body of an update over t as stored. A blood
+// pressure sent as text replaces the stored reading rather than being
+// checked against it; systolic and diastolic sent with it must agree.
+// Fields the server keeps, such as the visit, the score and the
+// escalations, stay as stored whatever the body holds.
+func (t *Triage) Update(body []byte) error {
+	var sent struct {
+		BloodPressure string `json:"blood_pressure"`
+	}
+	if err := json.Unmarshal(body, &sent); err != nil {
+		return err
+	}
+	stored := *t
+	// Decoding writes through pointers, which stored shares
+	t.NEWS2, t.ESILevel, t.EscalatedAt, t.OverriddenByID, t.OverriddenAt = nil, nil, nil, nil, nil
+	t.BloodPressureText = ""
+	if strings.TrimSpace(sent.BloodPressure) != "" {
+		t.BloodPressure.Clear()
+	}
+	if err := json.Unmarshal(body, t); err != nil {
+		return err
+	}
+
+	t.Model, t.VisitID = stored.Model, stored.VisitID
+	t.PriorityLevel, t.NEWS2, t.ESILevel = stored.PriorityLevel, stored.NEWS2, stored.ESILevel
+	t.ComputedPriority, t.ScoreBreakdown, t.ScoreVersion = stored.ComputedPriority, stored.ScoreBreakdown, stored.ScoreVersion
+	t.OverriddenByID, t.OverriddenAt = stored.OverriddenByID, stored.OverriddenAt
+	t.Escalations, t.EscalatedAt = stored.Escalations, stored.EscalatedAt
+	t.Flags = stored.Flags
+	return nil
+}
+
+// BeforeSave scores and flags the vital signs, so that the priority always
+// follows the latest measurements
+func (t *Triage) BeforeSave(tx *gorm.DB) error {
+	t.BloodPressure.measure()
+	if err := t.Score(); err != nil {
+		return err
+	}
+	return t.Flag()
+}
+
+// AfterSave raises an alert for vital signs that have become critical
+func (t *Triage) AfterSave(tx *gorm.DB) error {
+	t.flagging.raise(tx, "triage", t.ID, t.VisitID, t.Patient)
+	return nil
+}
+
+// AfterFind writes the blood pressure out as text for older clients
+func (t *Triage) AfterFind(tx *gorm.DB) error {
+	t.BloodPressureText = t.BloodPressure.String()
+	t.flagging.loaded(t.Flags)
+	return nil
+}
+
+// Flag compares the vital signs with the reference ranges for the
+// patient's age band, or the adult ranges when the age is not known
+func (t *Triage) Flag() error {
+	flags, err := t.flagging.flag(t.Vitals.values(), t.Patient)
+	if err != nil {
+		return err
+	}
+	t.Flags = flags
+	return nil
+}
+
+// Score works out NEWS2 and the acuity level from the vital signs and sets
+// the priority from them, unless it was overridden. Patient, when known,
+// gives the age for age-specific thresholds.
+func (t *Triage) Score() error {
+	vitals := t.Vitals.acuity()
+	if t.Patient != nil {
+		vitals.Age = t.Patient.Age
+	}
+
+	result := acuity.Score(vitals)
+	breakdown, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	t.NEWS2, t.ESILevel = result.NEWS2, result.ESILevel
+	t.ScoreBreakdown, t.ScoreVersion = breakdown, result.Version
+
+	// Without any vital signs there is nothing to go on
+	t.ComputedPriority = PriorityNormal
+	if result.ESILevel != nil {
+		t.ComputedPriority = esiPriorities[*result.ESILevel-1]
+	}
+
+	t.PriorityLevel = t.ComputedPriority
+	if t.PriorityOverride != "" {
+		t.PriorityLevel = t.PriorityOverride
+	} else {
+		t.OverrideReason, t.OverriddenByID, t.OverriddenAt = "", nil, nil
+	}
+	return nil
+}
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,52 @@
+package triage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestUpdateKeepsServerFields updates a stored triage with a body that
+// tries to change what only the server sets
+func TestUpdateKeepsServerFields(t *testing.T) {
+	record := storedTriage(t)
+	record.ID = 7
+	escalatedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	record.Escalations, record.EscalatedAt = 2, &escalatedAt
+	news2, esi := 1, 4
+	record.NEWS2, record.ESILevel = &news2, &esi
+	record.ComputedPriority, record.PriorityLevel = PriorityNonUrgent, PriorityNonUrgent
+	record.ScoreVersion, record.ScoreBreakdown = "v1", json.RawMessage(`{}`)
+	record.Flags = json.RawMessage(`{}`)
+
+	body := `{"ID":99,"visit_id":5,"escalations":0,"escalated_at":null,"news2":9,"esi_level":1,
+		"computed_priority":"critical","priority_level":"critical","score_version":"forged",
+		"score_breakdown":{"forged":true},"flags":{"forged":true},"overridden_by_id":3,"heart_rate":88}`
+	if err := record.Update([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		field     string
+		got, want interface{}
+	}{
+		{"ID", record.ID, uint(7)},
+		{"visit_id", record.VisitID, uint(1)},
+		{"escalations", record.Escalations, 2},
+		{"escalated_at", record.EscalatedAt != nil && record.EscalatedAt.Equal(escalatedAt), true},
+		{"news2", *record.NEWS2, 1},
+		{"esi_level", *record.ESILevel, 4},
+		{"computed_priority", record.ComputedPriority, PriorityNonUrgent},
+		{"priority_level", record.PriorityLevel, PriorityNonUrgent},
+		{"score_version", record.ScoreVersion, "v1"},
+		{"score_breakdown", string(record.ScoreBreakdown), "{}"},
+		{"flags", string(record.Flags), "{}"},
+		{"overridden_by_id", record.OverriddenByID == nil, true},
+		{"heart_rate", record.HeartRate != nil && *record.HeartRate == 88, true},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.field, tt.got, tt.want)
+		}
+	}
+}
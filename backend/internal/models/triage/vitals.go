@@ -0,0 +1,66 @@
+package triage
+
+import (
+	"strings"
+
+	"barman/internal/acuity"
+	"barman/internal/validation"
+)
+
+// Vitals are the vital signs taken at triage and at every observation
+// after it. Each is nil when it could not be measured.
+type Vitals struct {
+	HeartRate        *int     `json:"heart_rate"`
+	Temperature      *float64 `json:"temperature"`
+	RespiratoryRate  *int     `json:"respiratory_rate"`
+	OxygenSaturation *int     `json:"oxygen_saturation"`
+	PainLevel        *int     `json:"pain_level"`
+
+	// BloodPressure is stored as bp_systolic, bp_diastolic and so on.
+	// BloodPressureText is the same reading as "systolic/diastolic", which
+	// clients may send instead.
+	BloodPressure     `gorm:"embedded;embeddedPrefix:bp_"`
+	BloodPressureText string `json:"blood_pressure" gorm:"-"`
+}
+
+// Measured reports whether any vital sign was taken
+func (v *Vitals) Measured() bool {
+	return v.HeartRate != nil || v.Temperature != nil || v.RespiratoryRate != nil ||
+		v.OxygenSaturation != nil || v.PainLevel != nil || v.Systolic != nil
+}
+
+// check records the vital signs that are not physiologically plausible in
+// errs, and reads a blood pressure sent as text
+func (v *Vitals) check(errs validation.Errors) {
+	if v.HeartRate != nil {
+		errs.Check("heart_rate", validation.Range(float64(*v.HeartRate), 20, 300, "beats per minute"))
+	}
+	if v.Temperature != nil {
+		errs.Check("temperature", validation.Range(*v.Temperature, 25, 45, "°C"))
+	}
+	if v.RespiratoryRate != nil {
+		errs.Check("respiratory_rate", validation.Range(float64(*v.RespiratoryRate), 2, 80, "breaths per minute"))
+	}
+	if v.OxygenSaturation != nil {
+		errs.Check("oxygen_saturation", validation.Range(float64(*v.OxygenSaturation), 50, 100, "%"))
+	}
+	if v.PainLevel != nil {
+		errs.Check("pain_level", validation.Range(float64(*v.PainLevel), 0, 10, "on the pain scale"))
+	}
+
+	errs.Check("blood_pressure", v.BloodPressure.merge(strings.TrimSpace(v.BloodPressureText)))
+	v.BloodPressure.check(errs)
+	v.BloodPressureText = v.BloodPressure.String()
+}
+
+// acuity returns the vital signs to score
+func (v *Vitals) acuity() acuity.Vitals {
+	return acuity.Vitals{
+		HeartRate:        v.HeartRate,
+		RespiratoryRate:  v.RespiratoryRate,
+		OxygenSaturation: v.OxygenSaturation,
+		Temperature:      v.Temperature,
+		PainLevel:        v.PainLevel,
+		Systolic:         v.Systolic,
+	}
+}
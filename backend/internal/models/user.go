@@ -1,27 +1,41 @@
 package models
 
 import (
+	"strings"
+	"time"
+
+	"barman/internal/demographics"
+	"barman/internal/fieldcrypt"
+	"barman/internal/textnorm"
+	"barman/internal/validation"
+
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
-	FirstName         string  `json:"first_name"`
-	LastName          string  `json:"last_name"`
-	NationalID        string  `json:"national_id" gorm:"unique"`
-	FatherName        string  `json:"father_name"`
-	Address           string  `json:"address"`
-	MobilePhone       string  `json:"mobile_phone"`
-	LandlinePhone     string  `json:"landline_phone"`
-	EmergencyContact1 string  `json:"emergency_contact1"`
-	EmergencyContact2 string  `json:"emergency_contact2"`
-	Height            float64 `json:"height"`
-	Weight            float64 `json:"weight"`
-	HairColor         string  `json:"hair_color"`
-	EyeColor          string  `json:"eye_color"`
-	SkinColor         string  `json:"skin_color"`
-	BloodType         string  `json:"blood_type"`
-	Insurance         string  `json:"insurance"`
+	FirstName         string                     `json:"first_name" audit:"redact"`
+	LastName          string                     `json:"last_name" audit:"redact"`
+	NationalID        fieldcrypt.EncryptedString `json:"national_id"`
+	NationalIDIndex   *string                    `json:"-" gorm:"index:idx_users_national_id_lookup"`
+	FatherName        string                     `json:"father_name" audit:"redact"`
+	DateOfBirth       *demographics.Date         `json:"date_of_birth" gorm:"type:date" audit:"redact"`
+	DateOfBirthJalali string                     `json:"date_of_birth_jalali,omitempty" gorm:"-"`
+	Sex               string                     `json:"sex"` // male, female, other
+	Age               *demographics.Age          `json:"age,omitempty" gorm:"-"`
+	SearchName        string                     `json:"-" gorm:"index"` // normalised first, last and father name
+	Address           fieldcrypt.EncryptedString `json:"address"`
+	MobilePhone       fieldcrypt.EncryptedString `json:"mobile_phone"`
+	MobilePhoneIndex  *string                    `json:"-" gorm:"index"`
+	LandlinePhone     fieldcrypt.EncryptedString `json:"landline_phone"`
+	Height            float64                    `json:"height"`
+	Weight            float64                    `json:"weight"`
+	HairColor         string                     `json:"hair_color"`
+	EyeColor          string                     `json:"eye_color"`
+	SkinColor         string                     `json:"skin_color"`
+	BloodType         string                     `json:"blood_type"`
+	Insurance         string                     `json:"insurance"` // free-text insurer name; see InsurancePolicy
+	AnonymisedAt      *time.Time                 `json:"anonymised_at,omitempty"`
 
 	// Relationships
 	Visits             []Visit             `json:"visits" gorm:"foreignKey:UserID"`
@@ -33,4 +47,74 @@ type User struct {
 	Surgeries          []Surgery           `json:"surgeries" gorm:"foreignKey:UserID"`
 	Allergies          []Allergy           `json:"allergies" gorm:"foreignKey:UserID"`
 	ChronicConditions  []ChronicCondition  `json:"chronic_conditions" gorm:"foreignKey:UserID"`
+	EmergencyContacts  []EmergencyContact  `json:"emergency_contacts,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// BeforeSave keeps the blind indexes and search column in step with the
+// encrypted and plaintext fields they are derived from
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	u.NationalIDIndex = NationalIDIndex(string(u.NationalID))
+	u.MobilePhoneIndex = PhoneIndex(string(u.MobilePhone))
+	u.SearchName = textnorm.Normalize(strings.Join([]string{u.FirstName, u.LastName, u.FatherName}, " "))
+	return nil
+}
+
+// AfterFind fills in the age and Jalali birth date, which are computed
+// rather than stored
+func (u *User) AfterFind(tx *gorm.DB) error {
+	u.setComputedFields()
+	return nil
+}
+
+// AfterSave refreshes the computed fields for the response to a write
+func (u *User) AfterSave(tx *gorm.DB) error {
+	u.setComputedFields()
+	return nil
+}
+
+func (u *User) setComputedFields() {
+	u.Age, u.DateOfBirthJalali = nil, ""
+	if u.DateOfBirth != nil {
+		u.Age = demographics.AgeAt(*u.DateOfBirth, time.Now())
+		u.DateOfBirthJalali = u.DateOfBirth.Jalali()
+	}
+}
+
+// Demographics returns the patient's sex and age today
+func (u *User) Demographics() *demographics.Patient {
+	return demographics.NewPatient(u.ID, u.Sex, u.DateOfBirth, time.Now())
+}
+
+// NationalIDIndex returns the blind index used to look up a national ID,
+// or nil when the ID is empty
+func NationalIDIndex(nationalID string) *string {
+	digits := textnorm.Digits(nationalID)
+	if digits == "" {
+		return nil
+	}
+	// Validate stores codes that lost their leading zeros padded back to 10
+	// digits, so they are looked up the same way
+	if code, err := validation.NationalCode(digits); err == nil {
+		digits = code
+	}
+	index := fieldcrypt.BlindIndex(digits)
+	return &index
+}
+
+// PhoneIndex returns the blind index used to look up a phone number, or nil
+// when the number is empty
+func PhoneIndex(phone string) *string {
+	digits := textnorm.Digits(phone)
+	switch {
+	case strings.HasPrefix(digits, "0098"):
+		digits = "0" + digits[4:]
+	case strings.HasPrefix(digits, "98") && len(digits) == 12:
+		digits = "0" + digits[2:]
+	}
+	if digits == "" {
+		return nil
+	}
+	// Prefixed so a phone number never shares an index with a national ID
+	index := fieldcrypt.BlindIndex("phone:" + digits)
+	return &index
 }
@@ -0,0 +1,21 @@
+package models
+
+import "testing"
+
+func TestNationalIDIndexMatchesStoredCode(t *testing.T) {
+	// Validate stores 0012345679 for each of these
+	stored := NationalIDIndex("0012345679")
+	tests := []string{"0012345679", "12345679", "012345679", "001-234567-9", "۰۰۱۲۳۴۵۶۷۹", "۱۲۳۴۵۶۷۹"}
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			index := NationalIDIndex(input)
+			if index == nil || *index != *stored {
+				t.Errorf("index of %q differs from the index of the stored code", input)
+			}
+		})
+	}
+
+	if NationalIDIndex("") != nil || NationalIDIndex(" - ") != nil {
+		t.Error("an empty national ID has an index")
+	}
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"barman/internal/demographics"
+	"barman/internal/fieldcrypt"
+	"barman/internal/validation"
+)
+
+// Plausible body measurements; zero means not recorded
+const (
+	MinHeightCm = 20
+	MaxHeightCm = 250
+	MinWeightKg = 0.3
+	MaxWeightKg = 350
+	MaxAgeYears = 130
+)
+
+// Validate checks a patient's details before they are saved and rewrites
+// them in canonical form: the national code as 10 digits, phone numbers in
+// E.164, the blood type as ABO/Rh and sex as male, female or other. All
+// problems are reported together as validation.Errors keyed by JSON field
+// name. Insurance numbers belong to the patient's policies and are checked
+// by InsurancePolicy.Validate.
+func (u *User) Validate() error {
+	errs := validation.Errors{}
+
+	u.FirstName = strings.TrimSpace(u.FirstName)
+	u.LastName = strings.TrimSpace(u.LastName)
+	u.FatherName = strings.TrimSpace(u.FatherName)
+	if u.FirstName == "" {
+		errs.Add("first_name", "is required")
+	}
+	if u.LastName == "" {
+		errs.Add("last_name", "is required")
+	}
+
+	if strings.TrimSpace(string(u.NationalID)) == "" {
+		errs.Add("national_id", "is required")
+	} else if code, err := validation.NationalCode(string(u.NationalID)); err != nil {
+		errs.Check("national_id", err)
+	} else {
+		u.NationalID = fieldcrypt.EncryptedString(code)
+	}
+
+	if u.DateOfBirth != nil {
+		today := time.Now()
+		switch {
+		case u.DateOfBirth.After(today):
+			errs.Add("date_of_birth", "cannot be in the future")
+		case u.DateOfBirth.Before(today.AddDate(-MaxAgeYears, 0, 0)):
+			errs.Add("date_of_birth", fmt.Sprintf("cannot be more than %d years ago", MaxAgeYears))
+		}
+	}
+	if strings.TrimSpace(u.Sex) != "" {
+		sex, err := demographics.ParseSex(u.Sex)
+		errs.Check("sex", err)
+		if err == nil {
+			u.Sex = sex
+		}
+	}
+
+	u.MobilePhone = normalisePhone(errs, "mobile_phone", u.MobilePhone, validation.Mobile)
+	u.LandlinePhone = normalisePhone(errs, "landline_phone", u.LandlinePhone, validation.Landline)
+
+	if strings.TrimSpace(u.BloodType) != "" {
+		bloodType, err := validation.BloodType(u.BloodType)
+		errs.Check("blood_type", err)
+		if err == nil {
+			u.BloodType = bloodType
+		}
+	}
+
+	if u.Height != 0 {
+		errs.Check("height", validation.Range(u.Height, MinHeightCm, MaxHeightCm, "cm"))
+	}
+	if u.Weight != 0 {
+		errs.Check("weight", validation.Range(u.Weight, MinWeightKg, MaxWeightKg, "kg"))
+	}
+
+	return errs.Err()
+}
+
+func normalisePhone(errs validation.Errors, field string, phone fieldcrypt.EncryptedString, kind validation.PhoneKind) fieldcrypt.EncryptedString {
+	if strings.TrimSpace(string(phone)) == "" {
+		return ""
+	}
+	normalised, err := validation.Phone(string(phone), kind)
+	if err != nil {
+		errs.Check(field, err)
+		return phone
+	}
+	return fieldcrypt.EncryptedString(normalised)
+}
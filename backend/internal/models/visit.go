@@ -2,18 +2,84 @@ package models
 
 import (
 	"barman/internal/models/triage"
+	"barman/internal/visitflow"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// Visit types
+const (
+	VisitRegular   = "regular"
+	VisitEmergency = "emergency"
+	VisitFollowUp  = "follow-up"
+)
+
+// VisitTypes are the kinds of visit. How far a visit has got is its Status,
+// and what was recorded at it is its triage, report and prescription.
+var VisitTypes = []string{VisitRegular, VisitEmergency, VisitFollowUp}
+
+// IsVisitType reports whether t is one of VisitTypes
+func IsVisitType(t string) bool {
+	for _, visitType := range VisitTypes {
+		if visitType == t {
+			return true
+		}
+	}
+	return false
+}
+
 type Visit struct {
 	gorm.Model
-	UserID       uint           `json:"user_id"`
-	Date         time.Time      `json:"date"`
-	Type         string         `json:"type"` // regular, emergency, follow-up
-	TriageData   *triage.Triage `json:"triage_data" gorm:"foreignKey:VisitID"`
-	DoctorReport *Diagnosis     `json:"doctor_report" gorm:"foreignKey:VisitID"`
-	Prescription *Prescription  `json:"prescription" gorm:"foreignKey:VisitID"`
-	User         *User          `json:"user" gorm:"foreignKey:UserID"`
+	UserID         uint              `json:"user_id"`
+	Date           time.Time         `json:"date"`
+	Type           string            `json:"type"` // regular, emergency, follow-up
+	FacilityID     *uint             `json:"facility_id" gorm:"index"`
+	DepartmentID   *uint             `json:"department_id" gorm:"index"`
+	PractitionerID *uint             `json:"practitioner_id" gorm:"index"`
+	Practitioner   *Practitioner     `json:"practitioner,omitempty" gorm:"foreignKey:PractitionerID"`
+	TriageData     *triage.Triage    `json:"triage_data" gorm:"foreignKey:VisitID"`
+	DoctorReport   *Diagnosis        `json:"doctor_report" gorm:"foreignKey:VisitID"`
+	Prescription   *Prescription     `json:"prescription" gorm:"foreignKey:VisitID"`
+	User           *User             `json:"user" gorm:"foreignKey:UserID"`
+	Facility       *Facility         `json:"facility,omitempty" gorm:"foreignKey:FacilityID"`
+	Department     *Department       `json:"department,omitempty" gorm:"foreignKey:DepartmentID"`
+	Eligibility    *EligibilityCheck `json:"eligibility,omitempty" gorm:"foreignKey:VisitID"`
+
+	// Status is where the visit is in visitflow.States, and Times are when
+	// it entered each state
+	Status          string `json:"status" gorm:"index;default:'registered'"`
+	visitflow.Times `gorm:"embedded"`
+	// Durations are worked out from Times when the visit is loaded
+	Durations *visitflow.Durations `json:"durations,omitempty" gorm:"-"`
+
+	// EmergencyContacts are included on emergency visits
+	EmergencyContacts []EmergencyContact `json:"emergency_contacts,omitempty" gorm:"-"`
+}
+
+// BeforeCreate starts every visit registered, at the time of the visit
+func (v *Visit) BeforeCreate(tx *gorm.DB) error {
+	if v.Status == "" {
+		v.Status = visitflow.Registered
+	}
+	if v.RegisteredAt == nil {
+		at := v.Date
+		if at.IsZero() {
+			at = time.Now()
+		}
+		v.RegisteredAt = &at
+	}
+	return nil
+}
+
+// AfterFind works out how long the visit has taken so far
+func (v *Visit) AfterFind(tx *gorm.DB) error {
+	v.MeasureFlow()
+	return nil
+}
+
+// MeasureFlow fills in Durations from the state times
+func (v *Visit) MeasureFlow() {
+	durations := v.Times.Measure(v.Status, time.Now())
+	v.Durations = &durations
 }
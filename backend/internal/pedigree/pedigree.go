@@ -0,0 +1,299 @@
+// Package pedigree works out how patients linked as family members are
+// related to one another, and how closely, for judging hereditary risk.
+package pedigree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Relationships between two linked patients. A link says what the relative
+// is to the patient, so "parent" means the relative is the patient's parent.
+const (
+	Parent  = "parent"
+	Child   = "child"
+	Sibling = "sibling"
+	Spouse  = "spouse"
+)
+
+// Relationships lists the kinds of family link
+var Relationships = []string{Parent, Child, Sibling, Spouse}
+
+// Maternal and paternal lines of descent
+const (
+	LineMaternal = "maternal"
+	LinePaternal = "paternal"
+)
+
+// Inverse returns the relationship seen from the other side of a link, or
+// "" for an unknown relationship
+func Inverse(relationship string) string {
+	switch relationship {
+	case Parent:
+		return Child
+	case Child:
+		return Parent
+	case Sibling, Spouse:
+		return relationship
+	}
+	return ""
+}
+
+// Kinship places a blood relative relative to the patient: Up generations
+// to the closest common ancestor and Down generations from there. A parent
+// is {1, 0}, a sibling {1, 1} and a first cousin {2, 2}.
+type Kinship struct {
+	Up   int    `json:"up"`
+	Down int    `json:"down"`
+	Line string `json:"line,omitempty"` // maternal or paternal, when known
+}
+
+// Degree is the degree of relationship: 1 for parents, siblings and
+// children, who share half their genes with the patient, 2 for
+// grandparents, aunts, uncles, nieces, nephews and grandchildren, and so on
+func (k Kinship) Degree() int {
+	if k.Up > 0 && k.Down > 0 {
+		return k.Up + k.Down - 1
+	}
+	return k.Up + k.Down
+}
+
+// Name describes the relative in plain words, such as "grandparent" or
+// "parent's sibling"
+func (k Kinship) Name() string {
+	switch {
+	case k.Up == 0 && k.Down == 0:
+		return "self"
+	case k.Down == 0:
+		return greats(k.Up, "parent")
+	case k.Up == 0:
+		return greats(k.Down, "child")
+	case k.Up == 1 && k.Down == 1:
+		return "sibling"
+	case k.Up == 1:
+		return "sibling's " + greats(k.Down-1, "child")
+	case k.Down == 1:
+		return greats(k.Up-1, "parent") + "'s sibling"
+	case k.Up == k.Down:
+		return fmt.Sprintf("%s cousin", ordinal(k.Up-1))
+	}
+	return fmt.Sprintf("%s cousin %s removed", ordinal(min(k.Up, k.Down)-1), times(abs(k.Up-k.Down)))
+}
+
+// Named kinships accepted when the affected relative is not registered as a
+// patient, keyed by the name stored on the hereditary disease record
+var named = map[string]Kinship{
+	"mother":               {Up: 1, Line: LineMaternal},
+	"father":               {Up: 1, Line: LinePaternal},
+	"sibling":              {Up: 1, Down: 1},
+	"child":                {Down: 1},
+	"maternal_grandparent": {Up: 2, Line: LineMaternal},
+	"paternal_grandparent": {Up: 2, Line: LinePaternal},
+	"maternal_aunt_uncle":  {Up: 2, Down: 1, Line: LineMaternal},
+	"paternal_aunt_uncle":  {Up: 2, Down: 1, Line: LinePaternal},
+	"niece_nephew":         {Up: 1, Down: 2},
+	"grandchild":           {Down: 2},
+	"maternal_cousin":      {Up: 2, Down: 2, Line: LineMaternal},
+	"paternal_cousin":      {Up: 2, Down: 2, Line: LinePaternal},
+}
+
+// Named returns the kinship of a relative described by name, such as
+// "mother" or "paternal_aunt_uncle"
+func Named(name string) (Kinship, bool) {
+	kinship, ok := named[name]
+	return kinship, ok
+}
+
+// Names lists the relative names accepted by Named
+func Names() []string {
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Link is a recorded family relationship: To is From's Relationship
+type Link struct {
+	From         uint
+	To           uint
+	Relationship string
+}
+
+// Relative is a patient reached by walking family links
+type Relative struct {
+	ID      uint
+	Kinship Kinship
+	// Steps are the relationships walked from the patient, e.g. parent,
+	// sibling for an aunt or uncle
+	Steps []string
+	// Via is the patient's parent on the path, which decides the maternal
+	// or paternal line; 0 when the path does not go through a parent
+	Via uint
+	// BloodRelative is false for spouses
+	BloodRelative bool
+}
+
+// Walk finds the patient's relatives up to maxDegree, loading the links of
+// each round of the search with links. It follows blood relationships
+// only; the patient's own spouses are included but not walked through.
+// Relatives reachable several ways are reported by their closest path.
+func Walk(patientID uint, maxDegree int, links func(ids []uint) ([]Link, error)) ([]Relative, error) {
+	self := &Relative{ID: patientID, BloodRelative: true}
+	found := map[uint]*Relative{patientID: self}
+	frontier := []*Relative{self}
+
+	for len(frontier) > 0 {
+		ids := make([]uint, len(frontier))
+		byID := make(map[uint]*Relative, len(frontier))
+		for i, relative := range frontier {
+			ids[i] = relative.ID
+			byID[relative.ID] = relative
+		}
+		batch, err := links(ids)
+		if err != nil {
+			return nil, err
+		}
+		// Visit links in a fixed order so that ties resolve the same way
+		sort.Slice(batch, func(i, j int) bool {
+			if batch[i].From != batch[j].From {
+				return batch[i].From < batch[j].From
+			}
+			return batch[i].To < batch[j].To
+		})
+
+		var next []*Relative
+		for _, link := range batch {
+			from := byID[link.From]
+			if from == nil || !from.BloodRelative {
+				continue
+			}
+			candidate, ok := step(from, link)
+			if !ok {
+				continue
+			}
+			if existing, seen := found[link.To]; seen {
+				// Keep the closer path; a spouse who is also a blood
+				// relative is reported as the latter
+				if !candidate.BloodRelative || existing.ID == patientID ||
+					(existing.BloodRelative && existing.Kinship.Degree() <= candidate.Kinship.Degree()) {
+					continue
+				}
+			}
+			if candidate.BloodRelative && candidate.Kinship.Degree() > maxDegree {
+				continue
+			}
+			found[link.To] = candidate
+			if candidate.BloodRelative {
+				next = append(next, candidate)
+			}
+		}
+		frontier = next
+	}
+
+	relatives := make([]Relative, 0, len(found)-1)
+	for id, relative := range found {
+		if id != patientID {
+			relatives = append(relatives, *relative)
+		}
+	}
+	sort.Slice(relatives, func(i, j int) bool {
+		a, b := relatives[i], relatives[j]
+		if a.BloodRelative != b.BloodRelative {
+			return a.BloodRelative
+		}
+		if a.Kinship.Degree() != b.Kinship.Degree() {
+			return a.Kinship.Degree() < b.Kinship.Degree()
+		}
+		return a.ID < b.ID
+	})
+	return relatives, nil
+}
+
+// step extends the path to from by one link. It reports false for links
+// that lead back into the patient's own line or away from blood relatives:
+// going up again after coming down reaches in-laws or relatives who are
+// already reachable the short way.
+func step(from *Relative, link Link) (*Relative, bool) {
+	kinship := from.Kinship
+	switch link.Relationship {
+	case Parent:
+		if kinship.Down > 0 {
+			return nil, false
+		}
+		kinship.Up++
+	case Child:
+		kinship.Down++
+	case Sibling:
+		if kinship.Down > 0 {
+			return nil, false
+		}
+		kinship.Up++
+		kinship.Down = 1
+	case Spouse:
+		if from.Kinship != (Kinship{}) {
+			return nil, false
+		}
+		return &Relative{ID: link.To, Steps: []string{Spouse}}, true
+	default:
+		return nil, false
+	}
+
+	relative := &Relative{
+		ID:            link.To,
+		Kinship:       kinship,
+		Steps:         append(append([]string(nil), from.Steps...), link.Relationship),
+		Via:           from.Via,
+		BloodRelative: true,
+	}
+	if relative.Via == 0 && link.Relationship == Parent {
+		relative.Via = link.To
+	}
+	return relative, true
+}
+
+// Describe joins the steps of a path, e.g. "parent's sibling"
+func Describe(steps []string) string {
+	return strings.Join(steps, "'s ")
+}
+
+func greats(generations int, base string) string {
+	switch generations {
+	case 0:
+		return "self"
+	case 1:
+		return base
+	}
+	return strings.Repeat("great-", generations-2) + "grand" + base
+}
+
+func ordinal(n int) string {
+	switch n {
+	case 1:
+		return "first"
+	case 2:
+		return "second"
+	case 3:
+		return "third"
+	}
+	return fmt.Sprintf("%dth", n)
+}
+
+func times(n int) string {
+	switch n {
+	case 1:
+		return "once"
+	case 2:
+		return "twice"
+	}
+	return fmt.Sprintf("%d times", n)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,147 @@
+// Package textnorm normalises Persian and Arabic text so that names typed
+// on different keyboards compare equal, and scores fuzzy matches.
+package textnorm
+
+import (
+	"strings"
+	"unicode"
+)
+
+// zwnj is the zero-width non-joiner used inside Persian words
+const zwnj = '\u200c'
+
+// replacer folds Arabic code points onto their Persian equivalents and
+// Persian/Arabic-Indic digits onto ASCII digits
+var replacer = strings.NewReplacer(
+	"ي", "ی", "ى", "ی", "ئ", "ی",
+	"ك", "ک",
+	"ة", "ه", "ۀ", "ه",
+	"آ", "ا", "أ", "ا", "إ", "ا", "ٱ", "ا",
+	"ؤ", "و",
+	"۰", "0", "۱", "1", "۲", "2", "۳", "3", "۴", "4",
+	"۵", "5", "۶", "6", "۷", "7", "۸", "8", "۹", "9",
+	"٠", "0", "١", "1", "٢", "2", "٣", "3", "٤", "4",
+	"٥", "5", "٦", "6", "٧", "7", "٨", "8", "٩", "9",
+)
+
+// Normalize returns a canonical form of s for searching: Arabic letters are
+// folded to Persian, digits to ASCII, diacritics, tatweel and ZWNJ removed,
+// Latin letters lower-cased and runs of whitespace collapsed
+func Normalize(s string) string {
+	s = replacer.Replace(s)
+
+	var b strings.Builder
+	b.Grow(len(s))
+	space := false
+	for _, r := range s {
+		switch {
+		case r == zwnj:
+			// "می‌روم" and "میروم" should match
+			continue
+		case r == '\u0640' || unicode.Is(unicode.Mn, r):
+			// tatweel and harakat
+			continue
+		case unicode.IsSpace(r) || r == '-' || r == '_' || r == '.':
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// Digits returns only the digits of s after converting Persian and Arabic
+// digits to ASCII
+func Digits(s string) string {
+	s = replacer.Replace(s)
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// IsNumeric reports whether s consists only of digits, spaces, dashes and a
+// leading plus sign once normalised, i.e. looks like an ID or phone number
+func IsNumeric(s string) bool {
+	s = strings.TrimSpace(replacer.Replace(s))
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r >= '0' && r <= '9' || r == ' ' || r == '-' || (r == '+' && i == 0) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// Tokens splits normalised text into words
+func Tokens(s string) []string {
+	return strings.Fields(Normalize(s))
+}
+
+// Similarity returns a score between 0 and 1 for how closely two normalised
+// strings match, based on edit distance. A prefix match scores highly so
+// that partially typed names rank well.
+func Similarity(query, candidate string) float64 {
+	if query == "" || candidate == "" {
+		return 0
+	}
+	if query == candidate {
+		return 1
+	}
+	if strings.HasPrefix(candidate, query) {
+		return 0.9
+	}
+	if strings.Contains(candidate, query) {
+		return 0.8
+	}
+
+	q, c := []rune(query), []rune(candidate)
+	distance := levenshtein(q, c)
+	longest := max(len(q), len(c))
+	return 0.75 * (1 - float64(distance)/float64(longest))
+}
+
+// BestTokenSimilarity scores query against each word of candidate and
+// returns the best score
+func BestTokenSimilarity(query, candidate string) float64 {
+	best := Similarity(query, candidate)
+	for _, word := range strings.Fields(candidate) {
+		best = max(best, Similarity(query, word))
+	}
+	return best
+}
+
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// EditDistance returns the Levenshtein distance between a and b in runes
+func EditDistance(a, b string) int {
+	return levenshtein([]rune(a), []rune(b))
+}
@@ -0,0 +1,337 @@
+// Package triagequeue orders the patients waiting after triage by priority
+// and then by arrival, measures how long each has waited against the
+// target for their priority, and escalates those who have waited longer.
+//
+// The target maximum waits default to 5 minutes for critical, 15 for
+// urgent, an hour for normal and two hours for non-urgent patients.
+// TRIAGE_TARGET_WAITS overrides any of them as priority=duration pairs,
+// such as "urgent=10m,non-urgent=3h". Patients stay in the queue for a day
+// after they arrive, or TRIAGE_QUEUE_WINDOW.
+package triagequeue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"barman/internal/audit"
+	"barman/internal/database"
+	"barman/internal/events"
+	"barman/internal/models"
+	"barman/internal/models/triage"
+	"barman/internal/visitflow"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Waiting lists the visit states of patients not yet seen by a doctor
+var Waiting = []string{visitflow.Registered, visitflow.WaitingTriage, visitflow.Triaged, visitflow.WaitingDoctor}
+
+var defaultTargets = map[string]time.Duration{
+	triage.PriorityCritical:  5 * time.Minute,
+	triage.PriorityUrgent:    15 * time.Minute,
+	triage.PriorityNormal:    time.Hour,
+	triage.PriorityNonUrgent: 2 * time.Hour,
+}
+
+// defaultWindow is how long after arriving a patient stays in the queue.
+// Visits older than that were most likely left open by mistake, and are
+// neither listed nor escalated.
+const defaultWindow = 24 * time.Hour
+
+var (
+	loadOnce sync.Once
+	targets  map[string]time.Duration
+	window   = defaultWindow
+	loadErr  error
+)
+
+// Load reads the target waits from TRIAGE_TARGET_WAITS and the queue
+// window from TRIAGE_QUEUE_WINDOW. It is called on first use and may be
+// called at startup to surface errors early.
+func Load() error {
+	loadOnce.Do(func() {
+		targets, loadErr = parseTargets(os.Getenv("TRIAGE_TARGET_WAITS"))
+		if loadErr != nil {
+			loadErr = fmt.Errorf("TRIAGE_TARGET_WAITS: %w", loadErr)
+			return
+		}
+		if text := os.Getenv("TRIAGE_QUEUE_WINDOW"); text != "" {
+			d, err := time.ParseDuration(text)
+			if err != nil || d <= 0 {
+				loadErr = fmt.Errorf("TRIAGE_QUEUE_WINDOW: %q must be a positive duration, such as 12h", text)
+				return
+			}
+			window = d
+		}
+	})
+	return loadErr
+}
+
+func parseTargets(text string) (map[string]time.Duration, error) {
+	parsed := make(map[string]time.Duration, len(defaultTargets))
+	for priority, target := range defaultTargets {
+		parsed[priority] = target
+	}
+	for _, pair := range strings.Split(text, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		priority, value, found := strings.Cut(pair, "=")
+		priority = strings.ToLower(strings.TrimSpace(priority))
+		if _, known := defaultTargets[priority]; !found || !known {
+			return nil, fmt.Errorf("%q must be a priority level and a duration, such as urgent=15m", pair)
+		}
+		target, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || target <= 0 {
+			return nil, fmt.Errorf("%q must give a positive duration, such as urgent=15m", pair)
+		}
+		parsed[priority] = target
+	}
+	return parsed, nil
+}
+
+// Targets returns the target maximum wait of each priority level
+func Targets() map[string]time.Duration {
+	if Load() != nil {
+		return defaultTargets
+	}
+	return targets
+}
+
+// Target returns the target maximum wait for priority. Priorities that are
+// not known are given the normal target.
+func Target(priority string) time.Duration {
+	all := Targets()
+	if target, ok := all[priority]; ok {
+		return target
+	}
+	return all[triage.PriorityNormal]
+}
+
+// rank orders priorities, most urgent first, with unknown ones as normal
+func rank(priority string) int {
+	for i, p := range triage.Priorities {
+		if p == priority {
+			return i
+		}
+	}
+	return rank(triage.PriorityNormal)
+}
+
+// Wait is how long a patient has waited against the target for their
+// priority
+type Wait struct {
+	ArrivedAt         time.Time `json:"arrived_at"`
+	WaitMinutes       float64   `json:"wait_minutes"`
+	TargetWaitMinutes float64   `json:"target_wait_minutes"`
+	// DueAt is when the patient should be seen by: the target after they
+	// arrived, or after they were last escalated
+	DueAt          time.Time `json:"due_at"`
+	Overdue        bool      `json:"overdue"`
+	OverdueMinutes float64   `json:"overdue_minutes"`
+}
+
+// Arrival returns when the patient of a visit arrived
+func Arrival(visit *models.Visit) time.Time {
+	if visit.RegisteredAt != nil {
+		return *visit.RegisteredAt
+	}
+	return visit.CreatedAt
+}
+
+// Measure works out how long the patient of a visit with pending triage
+// has waited at now
+func Measure(visit *models.Visit, now time.Time) Wait {
+	t := visit.TriageData
+	target := Target(t.PriorityLevel)
+	wait := Wait{
+		ArrivedAt:         Arrival(visit),
+		TargetWaitMinutes: minutes(target),
+	}
+	wait.WaitMinutes = minutes(now.Sub(wait.ArrivedAt))
+
+	from := wait.ArrivedAt
+	if t.EscalatedAt != nil {
+		from = *t.EscalatedAt
+	}
+	wait.DueAt = from.Add(target)
+	if now.After(wait.DueAt) {
+		wait.Overdue = true
+		wait.OverdueMinutes = minutes(now.Sub(wait.DueAt))
+	}
+	return wait
+}
+
+func minutes(d time.Duration) float64 {
+	return math.Round(d.Minutes()*10) / 10
+}
+
+// Window returns how long after arriving a patient stays in the queue
+func Window() time.Duration {
+	if Load() != nil {
+		return defaultWindow
+	}
+	return window
+}
+
+// Pending returns the visits in query that are waiting with pending
+// triage, most urgent first and then in the order the patients arrived.
+// Patients who arrived longer than the window before now are left out, and
+// priorities, if any are given, limit the queue to those priority levels.
+func Pending(query *gorm.DB, now time.Time, priorities ...string) ([]models.Visit, error) {
+	table := database.TableName(&triage.Triage{})
+	pending := "FROM " + table + " WHERE " + table + ".visit_id = visits.id AND " + table + ".type = 'pending' AND " +
+		table + ".deleted_at IS NULL"
+	var pendingVars []interface{}
+	if len(priorities) > 0 {
+		pending += " AND " + table + ".priority_level IN ?"
+		pendingVars = append(pendingVars, priorities)
+	}
+
+	// The rank of the most urgent pending triage of each visit
+	rankSQL := "CASE " + table + ".priority_level"
+	var rankVars []interface{}
+	for i, priority := range triage.Priorities {
+		rankSQL += " WHEN ? THEN " + strconv.Itoa(i)
+		rankVars = append(rankVars, priority)
+	}
+	rankSQL += " ELSE " + strconv.Itoa(rank(triage.PriorityNormal)) + " END"
+
+	var visits []models.Visit
+	err := query.Preload("TriageData", "type = ?", "pending").
+		Where("visits.status IN ?", Waiting).
+		Where("COALESCE(visits.registered_at, visits.created_at) >= ?", now.Add(-Window())).
+		Where("EXISTS (SELECT 1 "+pending+")", pendingVars...).
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "(SELECT MIN(" + rankSQL + ") " + pending + "), COALESCE(visits.registered_at, visits.created_at), visits.id",
+			Vars: append(rankVars, pendingVars...),
+		}}).
+		Find(&visits).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Triage that stopped pending since the visits were selected
+	found := visits[:0]
+	for _, visit := range visits {
+		if visit.TriageData != nil {
+			found = append(found, visit)
+		}
+	}
+	return found, nil
+}
+
+// Escalation is the data of an alert event raised for a patient who has
+// waited longer than the target for their priority
+type Escalation struct {
+	Source            string  `json:"source"` // triage_queue
+	PatientID         uint    `json:"patient_id"`
+	TriageID          uint    `json:"triage_id"`
+	From              string  `json:"from"`
+	To                string  `json:"to"`
+	WaitMinutes       float64 `json:"wait_minutes"`
+	TargetWaitMinutes float64 `json:"target_wait_minutes"`
+	Message           string  `json:"message"`
+}
+
+// Escalate escalates every waiting patient who is overdue at now: a
+// patient below critical is raised one priority level, kept as an
+// override with the reason, and a critical patient is reported again.
+// Either way an alert is raised for the nurse in charge, and the next
+// escalation is due a full target after this one.
+func Escalate(db *gorm.DB, now time.Time) (int, error) {
+	visits, err := Pending(db.Model(&models.Visit{}), now)
+	if err != nil {
+		return 0, err
+	}
+
+	escalated := 0
+	for i := range visits {
+		visit := &visits[i]
+		wait := Measure(visit, now)
+		if !wait.Overdue {
+			continue
+		}
+
+		t := visit.TriageData
+		escalation := Escalation{
+			Source:            "triage_queue",
+			PatientID:         visit.UserID,
+			TriageID:          t.ID,
+			From:              t.PriorityLevel,
+			To:                t.PriorityLevel,
+			WaitMinutes:       wait.WaitMinutes,
+			TargetWaitMinutes: wait.TargetWaitMinutes,
+		}
+		updates := map[string]interface{}{
+			"escalations":  t.Escalations + 1,
+			"escalated_at": now,
+		}
+		if r := rank(t.PriorityLevel); r > 0 {
+			escalation.To = triage.Priorities[r-1]
+			escalation.Message = fmt.Sprintf("Waited %g minutes, beyond the %g minute target for %s; raised to %s",
+				wait.WaitMinutes, wait.TargetWaitMinutes, escalation.From, escalation.To)
+			updates["priority_override"] = escalation.To
+			updates["override_reason"] = escalation.Message
+			updates["overridden_by_id"] = nil
+			updates["overridden_at"] = now
+			updates["priority_level"] = escalation.To
+		} else {
+			escalation.Message = fmt.Sprintf("Waited %g minutes, beyond the %g minute target for %s",
+				wait.WaitMinutes, wait.TargetWaitMinutes, escalation.From)
+		}
+
+		// Only the escalation changes, so the triage is not scored again.
+		// The update only applies if no one has changed or escalated the
+		// triage since it was loaded, such as a nurse or another instance.
+		result := db.Model(t).Where("escalations = ? AND updated_at = ?", t.Escalations, t.UpdatedAt).
+			UpdateColumns(updates)
+		if result.Error != nil {
+			return escalated, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		events.PublishFrom(db.Statement.Context, events.Event{
+			Topic:      events.TopicAlert,
+			Action:     events.Escalated,
+			EntityID:   t.ID,
+			VisitID:    visit.ID,
+			FacilityID: visit.FacilityID,
+			At:         now,
+			Data:       escalation,
+		})
+		escalated++
+	}
+	return escalated, nil
+}
+
+// Run escalates overdue patients every interval until ctx is done. The
+// changes are audited as made by the system.
+func Run(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	db = db.WithContext(audit.WithActor(ctx, audit.Actor{Role: "system", Path: "triage escalation"}))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			escalated, err := Escalate(db, now)
+			if err != nil {
+				log.Printf("Triage escalation failed: %v", err)
+			} else if escalated > 0 {
+				log.Printf("Escalated %d overdue patients in the triage queue", escalated)
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package validation checks and normalises patient input such as Iranian
+// national codes, phone numbers and insurance policy numbers, and collects
+// errors per field so that clients can point at each bad input.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"barman/internal/textnorm"
+)
+
+// Errors maps JSON field names to what is wrong with them
+type Errors map[string]string
+
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = field + ": " + e[field]
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records a problem with field, keeping the first one reported
+func (e Errors) Add(field, message string) {
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+}
+
+// Check records err against field when it is not nil
+func (e Errors) Check(field string, err error) {
+	if err != nil {
+		e.Add(field, err.Error())
+	}
+}
+
+// Prefix returns the errors with each field placed under parent, such as
+// heart_rate becoming triage_data.heart_rate
+func (e Errors) Prefix(parent string) Errors {
+	prefixed := make(Errors, len(e))
+	for field, message := range e {
+		prefixed[parent+"."+field] = message
+	}
+	return prefixed
+}
+
+// Err returns e as an error, or nil when nothing was reported
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+var (
+	ErrNationalCodeLength   = errors.New("must be 10 digits")
+	ErrNationalCodeChecksum = errors.New("is not a valid national code")
+	ErrPhoneInvalid         = errors.New("is not a valid phone number")
+	ErrNotMobile            = errors.New("is not a mobile number")
+	ErrPhoneAreaCode        = errors.New("must include the area code")
+	ErrBloodType            = errors.New("must be one of A+, A-, B+, B-, AB+, AB-, O+, O-")
+	ErrPolicyNumber         = errors.New("must be 4 to 30 letters, digits, - or /")
+	ErrPolicyNumberFormat   = errors.New("does not match the insurer's policy number format")
+)
+
+// NationalCode validates an Iranian national code (کد ملی) and returns it
+// as 10 ASCII digits. Persian digits and separators are accepted, and codes
+// that lost their leading zeros are padded back to 10 digits.
+func NationalCode(code string) (string, error) {
+	digits := textnorm.Digits(code)
+	if len(digits) < 8 || len(digits) > 10 {
+		return "", ErrNationalCodeLength
+	}
+	digits = strings.Repeat("0", 10-len(digits)) + digits
+
+	if strings.Count(digits, digits[:1]) == 10 {
+		// 0000000000, 1111111111, ... pass the checksum but are not issued
+		return "", ErrNationalCodeChecksum
+	}
+
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(digits[i]-'0') * (10 - i)
+	}
+	check := int(digits[9] - '0')
+	if remainder := sum % 11; remainder < 2 && check != remainder || remainder >= 2 && check != 11-remainder {
+		return "", ErrNationalCodeChecksum
+	}
+	return digits, nil
+}
+
+// PhoneKind says which kind of Iranian number a phone field expects
+type PhoneKind int
+
+const (
+	Mobile PhoneKind = iota
+	Landline
+)
+
+// Phone normalises a phone number to E.164, such as +989121234567. Iranian
+// numbers may be written in the local form (09121234567, 021 8877 6655)
+// or with the country code; other countries need their country code.
+func Phone(number string, kind PhoneKind) (string, error) {
+	trimmed := strings.TrimSpace(number)
+	digits := textnorm.Digits(trimmed)
+	international := strings.HasPrefix(trimmed, "+") || strings.HasPrefix(digits, "00")
+	digits = strings.TrimPrefix(digits, "00")
+
+	var national string
+	switch {
+	case international && strings.HasPrefix(digits, "98"):
+		national = digits[2:]
+	case international:
+		// E.164 allows up to 15 digits including the country code
+		if len(digits) < 8 || len(digits) > 15 {
+			return "", ErrPhoneInvalid
+		}
+		return "+" + digits, nil
+	case strings.HasPrefix(digits, "98") && len(digits) == 12:
+		national = digits[2:]
+	case strings.HasPrefix(digits, "0"):
+		national = digits[1:]
+	case kind == Landline && len(digits) == 8:
+		return "", ErrPhoneAreaCode
+	default:
+		national = digits
+	}
+
+	// Iranian numbers have 10 digits after the trunk prefix: mobiles start
+	// with 9, landlines with a two-digit area code such as 21
+	if len(national) != 10 {
+		return "", ErrPhoneInvalid
+	}
+	if mobile := national[0] == '9'; kind == Mobile && !mobile {
+		return "", ErrNotMobile
+	}
+	if national[0] == '0' {
+		return "", ErrPhoneInvalid
+	}
+	return "+98" + national, nil
+}
+
+var bloodTypeSuffixes = strings.NewReplacer(
+	"POSITIVE", "+", "POS", "+",
+	"NEGATIVE", "-", "NEG", "-",
+	"RH", "", " ", "",
+)
+
+// BloodType returns the canonical ABO/Rh form of a blood group, accepting
+// variants such as "a pos", "AB Rh-" or "0+"
+func BloodType(value string) (string, error) {
+	canonical := bloodTypeSuffixes.Replace(strings.ToUpper(strings.TrimSpace(value)))
+	canonical = strings.Replace(canonical, "0", "O", 1)
+
+	switch canonical {
+	case "A+", "A-", "B+", "B-", "AB+", "AB-", "O+", "O-":
+		return canonical, nil
+	}
+	return "", ErrBloodType
+}
+
+// PolicyNumber returns an insurance policy number in canonical form: upper
+// case with ASCII digits and without spaces. When the insurer has a known
+// format, pattern must match the whole number.
+func PolicyNumber(number string, pattern *regexp.Regexp) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(number) {
+		switch {
+		case unicode.IsSpace(r):
+			continue
+		case unicode.IsDigit(r):
+			b.WriteString(textnorm.Digits(string(r)))
+		case r >= 'A' && r <= 'Z', r == '-', r == '/':
+			b.WriteRune(r)
+		default:
+			return "", ErrPolicyNumber
+		}
+	}
+
+	canonical := b.String()
+	if len(canonical) < 4 || len(canonical) > 30 {
+		return "", ErrPolicyNumber
+	}
+	if pattern != nil && !pattern.MatchString(canonical) {
+		return "", ErrPolicyNumberFormat
+	}
+	return canonical, nil
+}
+
+// Range checks that a measurement lies within [low, high]
+func Range(value, low, high float64, unit string) error {
+	if value < low || value > high {
+		return fmt.Errorf("must be between %g and %g %s", low, high, unit)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Package visitflow is the state machine a visit moves through, from
+// registration to leaving, and the times measured along the way such as
+// door-to-triage and door-to-doctor.
+package visitflow
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Visit states
+const (
+	Registered           = "registered"
+	WaitingTriage        = "waiting_triage"
+	Triaged              = "triaged"
+	WaitingDoctor        = "waiting_doctor"
+	InConsultation       = "in_consultation"
+	Discharged           = "discharged"
+	Admitted             = "admitted"
+	LeftWithoutBeingSeen = "left_without_being_seen"
+)
+
+// States lists the states in the order a visit moves through them
+var States = []string{
+	Registered, WaitingTriage, Triaged, WaitingDoctor, InConsultation,
+	Discharged, Admitted, LeftWithoutBeingSeen,
+}
+
+// next lists the states each state can move to. Visits that need no triage
+// go straight to the doctor's queue, and a patient can leave without being
+// seen at any point before the consultation.
+var next = map[string][]string{
+	Registered:     {WaitingTriage, WaitingDoctor, LeftWithoutBeingSeen},
+	WaitingTriage:  {Triaged, LeftWithoutBeingSeen},
+	Triaged:        {WaitingDoctor, LeftWithoutBeingSeen},
+	WaitingDoctor:  {InConsultation, LeftWithoutBeingSeen},
+	InConsultation: {Discharged, Admitted},
+}
+
+var (
+	ErrUnknownState = errors.New("unknown visit state")
+	// ErrNeedsTriage is returned when a visit is marked triaged before its
+	// triage is recorded
+	ErrNeedsTriage = errors.New("triage must be recorded before the visit is triaged")
+	// ErrNeedsPractitioner is returned when a consultation starts without a
+	// practitioner
+	ErrNeedsPractitioner = errors.New("a practitioner must be assigned before the consultation starts")
+)
+
+// TransitionError is returned for a move the state machine does not allow
+type TransitionError struct {
+	From, To string
+}
+
+func (e *TransitionError) Error() string {
+	allowed := Next(e.From)
+	if len(allowed) == 0 {
+		return fmt.Sprintf("a %s visit cannot change state", e.From)
+	}
+	return fmt.Sprintf("a %s visit can only move to %s, not %s", e.From, strings.Join(allowed, ", "), e.To)
+}
+
+// Facts are what the guards on a transition check
+type Facts struct {
+	HasTriage       bool
+	HasPractitioner bool
+}
+
+// Next lists the states a visit in state can move to
+func Next(state string) []string {
+	return next[state]
+}
+
+// IsFinal reports whether a visit in state has left
+func IsFinal(state string) bool {
+	return state == Discharged || state == Admitted || state == LeftWithoutBeingSeen
+}
+
+// Valid reports whether state is a visit state
+func Valid(state string) bool {
+	for _, s := range States {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error unless a visit in from can move to to
+func Check(from, to string, facts Facts) error {
+	if !Valid(to) {
+		return fmt.Errorf("%w %q", ErrUnknownState, to)
+	}
+	allowed := false
+	for _, s := range next[from] {
+		if s == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return &TransitionError{From: from, To: to}
+	}
+
+	switch {
+	case to == Triaged && !facts.HasTriage:
+		return ErrNeedsTriage
+	case to == InConsultation && !facts.HasPractitioner:
+		return ErrNeedsPractitioner
+	}
+	return nil
+}
+
+// Times are when a visit entered each state. They are stored as columns of
+// the visit.
+type Times struct {
+	RegisteredAt           *time.Time `json:"registered_at"`
+	WaitingTriageAt        *time.Time `json:"waiting_triage_at"`
+	TriagedAt              *time.Time `json:"triaged_at"`
+	WaitingDoctorAt        *time.Time `json:"waiting_doctor_at"`
+	InConsultationAt       *time.Time `json:"in_consultation_at"`
+	DischargedAt           *time.Time `json:"discharged_at"`
+	AdmittedAt             *time.Time `json:"admitted_at"`
+	LeftWithoutBeingSeenAt *time.Time `json:"left_without_being_seen_at"`
+}
+
+// field returns where the time of entering state is kept
+func (t *Times) field(state string) **time.Time {
+	switch state {
+	case Registered:
+		return &t.RegisteredAt
+	case WaitingTriage:
+		return &t.WaitingTriageAt
+	case Triaged:
+		return &t.TriagedAt
+	case WaitingDoctor:
+		return &t.WaitingDoctorAt
+	case InConsultation:
+		return &t.InConsultationAt
+	case Discharged:
+		return &t.DischargedAt
+	case Admitted:
+		return &t.AdmittedAt
+	case LeftWithoutBeingSeen:
+		return &t.LeftWithoutBeingSeenAt
+	}
+	return nil
+}
+
+// Set records when a visit entered state
+func (t *Times) Set(state string, at time.Time) {
+	if field := t.field(state); field != nil {
+		*field = &at
+	}
+}
+
+// At returns when a visit entered state, if it has
+func (t *Times) At(state string) *time.Time {
+	if field := t.field(state); field != nil {
+		return *field
+	}
+	return nil
+}
+
+// Column is the column holding the time of entering state
+func Column(state string) string {
+	return state + "_at"
+}
+
+// Durations are the times measured through a visit, in minutes. Each is
+// nil until both of its ends have happened.
+type Durations struct {
+	DoorToTriage   *float64 `json:"door_to_triage"`
+	DoorToDoctor   *float64 `json:"door_to_doctor"`
+	TriageToDoctor *float64 `json:"triage_to_doctor"`
+	LengthOfStay   *float64 `json:"length_of_stay"`
+	// InState is how long the visit has been in its current state, for
+	// visits that have not left
+	InState *float64 `json:"in_state"`
+}
+
+// Measure works out the durations of a visit in state at now
+func (t Times) Measure(state string, now time.Time) Durations {
+	left := t.DischargedAt
+	if left == nil {
+		left = t.AdmittedAt
+	}
+	if left == nil {
+		left = t.LeftWithoutBeingSeenAt
+	}
+
+	durations := Durations{
+		DoorToTriage:   minutes(t.RegisteredAt, t.TriagedAt),
+		DoorToDoctor:   minutes(t.RegisteredAt, t.InConsultationAt),
+		TriageToDoctor: minutes(t.TriagedAt, t.InConsultationAt),
+		LengthOfStay:   minutes(t.RegisteredAt, left),
+	}
+	if !IsFinal(state) {
+		durations.InState = minutes(t.At(state), &now)
+	}
+	return durations
+}
+
+func minutes(from, to *time.Time) *float64 {
+	if from == nil || to == nil {
+		return nil
+	}
+	m := math.Round(to.Sub(*from).Minutes()*10) / 10
+	return &m
+}
@@ -0,0 +1,100 @@
+package visitflow
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	both := Facts{HasTriage: true, HasPractitioner: true}
+	tests := []struct {
+		name     string
+		from, to string
+		facts    Facts
+		err      error
+	}{
+		{"registered to waiting triage", Registered, WaitingTriage, Facts{}, nil},
+		{"registered straight to the doctor", Registered, WaitingDoctor, Facts{}, nil},
+		{"registered leaves", Registered, LeftWithoutBeingSeen, Facts{}, nil},
+		{"triaged with triage", WaitingTriage, Triaged, Facts{HasTriage: true}, nil},
+		{"triaged without triage", WaitingTriage, Triaged, Facts{HasPractitioner: true}, ErrNeedsTriage},
+		{"triaged to the doctor", Triaged, WaitingDoctor, Facts{}, nil},
+		{"consultation with practitioner", WaitingDoctor, InConsultation, Facts{HasPractitioner: true}, nil},
+		{"consultation without practitioner", WaitingDoctor, InConsultation, Facts{HasTriage: true}, ErrNeedsPractitioner},
+		{"waiting doctor leaves", WaitingDoctor, LeftWithoutBeingSeen, Facts{}, nil},
+		{"discharged", InConsultation, Discharged, Facts{}, nil},
+		{"admitted", InConsultation, Admitted, Facts{}, nil},
+		{"unknown state", Registered, "waiting", both, ErrUnknownState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.from, tt.to, tt.facts)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Check(%s, %s) = %v, want %v", tt.from, tt.to, err, tt.err)
+			}
+		})
+	}
+}
+
+// TestCheckRefusesSkips checks moves the state machine does not allow,
+// whatever is recorded
+func TestCheckRefusesSkips(t *testing.T) {
+	both := Facts{HasTriage: true, HasPractitioner: true}
+	tests := []struct {
+		from, to string
+	}{
+		{Registered, Triaged},
+		{Registered, InConsultation},
+		{Registered, Discharged},
+		{WaitingTriage, WaitingDoctor},
+		{Triaged, InConsultation},
+		{WaitingDoctor, Triaged},
+		{InConsultation, LeftWithoutBeingSeen},
+		{InConsultation, WaitingDoctor},
+		{Discharged, Admitted},
+		{Admitted, Discharged},
+		{LeftWithoutBeingSeen, WaitingDoctor},
+		{Triaged, Triaged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			var transition *TransitionError
+			if err := Check(tt.from, tt.to, both); !errors.As(err, &transition) {
+				t.Errorf("Check(%s, %s) = %v, want a transition error", tt.from, tt.to, err)
+			}
+		})
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	at := func(minutes int) *time.Time {
+		t := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC).Add(time.Duration(minutes) * time.Minute)
+		return &t
+	}
+	times := Times{RegisteredAt: at(0), TriagedAt: at(12), InConsultationAt: at(45), DischargedAt: at(90)}
+
+	durations := times.Measure(Discharged, *at(120))
+	checkMinutes(t, "door to triage", durations.DoorToTriage, 12)
+	checkMinutes(t, "door to doctor", durations.DoorToDoctor, 45)
+	checkMinutes(t, "triage to doctor", durations.TriageToDoctor, 33)
+	checkMinutes(t, "length of stay", durations.LengthOfStay, 90)
+	if durations.InState != nil {
+		t.Errorf("in state = %g, want none for a discharged visit", *durations.InState)
+	}
+
+	waiting := Times{RegisteredAt: at(0), TriagedAt: at(12)}
+	durations = waiting.Measure(Triaged, *at(30))
+	checkMinutes(t, "in state", durations.InState, 18)
+	if durations.DoorToDoctor != nil || durations.LengthOfStay != nil {
+		t.Errorf("door to doctor = %v, length of stay = %v, want none before the consultation",
+			durations.DoorToDoctor, durations.LengthOfStay)
+	}
+}
+
+func checkMinutes(t *testing.T, name string, got *float64, want float64) {
+	t.Helper()
+	if got == nil || *got != want {
+		t.Errorf("%s = %v, want %g", name, got, want)
+	}
+}
@@ -0,0 +1,211 @@
+// Package vitalnorms holds the reference ranges of vital signs for each age
+// band, and for one sex where they differ, and flags measurements that fall
+// outside them.
+//
+// The bundled ranges.csv gives, per parameter, age band and optionally sex,
+// the normal range (low to high) and the values at or beyond which a
+// measurement is critical. VITAL_NORMS_FILE names a file in the same layout
+// to use instead. A row with an empty sex applies to both sexes; a row for
+// one sex takes precedence over it.
+package vitalnorms
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"barman/internal/demographics"
+)
+
+// Flag statuses
+const (
+	Normal   = "normal"
+	Low      = "low"
+	High     = "high"
+	Critical = "critical"
+)
+
+var (
+	//go:embed ranges.csv
+	bundled string
+
+	loadOnce sync.Once
+	ranges   map[key]Range
+	loadErr  error
+)
+
+type key struct {
+	parameter string
+	band      demographics.AgeBand
+	sex       string
+}
+
+// Range is the reference range of one parameter. A measurement at or below
+// CriticalLow, or at or above CriticalHigh, is critical; either may be nil
+// when there is no such limit.
+type Range struct {
+	CriticalLow  *float64 `json:"critical_low,omitempty"`
+	Low          float64  `json:"low"`
+	High         float64  `json:"high"`
+	CriticalHigh *float64 `json:"critical_high,omitempty"`
+}
+
+// Flag is how a measurement compares with its reference range
+type Flag struct {
+	Value  float64 `json:"value"`
+	Status string  `json:"status"` // normal, low, high, critical
+	// Direction is low or high for a critical value
+	Direction string `json:"direction,omitempty"`
+	Range     Range  `json:"range"`
+}
+
+// Flags are the flags of a set of measurements, keyed by parameter
+type Flags map[string]Flag
+
+// Critical returns the flags of the critical measurements
+func (f Flags) Critical() Flags {
+	critical := make(Flags)
+	for parameter, flag := range f {
+		if flag.Status == Critical {
+			critical[parameter] = flag
+		}
+	}
+	return critical
+}
+
+// Load reads the reference ranges, from VITAL_NORMS_FILE when it is set and
+// from the bundled ranges otherwise. It is called on first use and may be
+// called at startup to surface errors early.
+func Load() error {
+	loadOnce.Do(func() {
+		var r io.Reader = strings.NewReader(bundled)
+		if name := os.Getenv("VITAL_NORMS_FILE"); name != "" {
+			file, err := os.Open(name)
+			if err != nil {
+				loadErr = err
+				return
+			}
+			defer file.Close()
+			r = file
+		}
+		ranges, loadErr = readRanges(r)
+	})
+	return loadErr
+}
+
+func readRanges(r io.Reader) (map[key]Range, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("reference ranges are empty")
+	}
+
+	loaded := make(map[key]Range)
+	for i, record := range records[1:] {
+		line := i + 2
+		if len(record) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 columns", line)
+		}
+		band := demographics.AgeBand(strings.TrimSpace(record[1]))
+		if !knownBand(band) {
+			return nil, fmt.Errorf("line %d: unknown age band %q", line, band)
+		}
+		sex := strings.TrimSpace(record[2])
+		if sex != "" && sex != demographics.SexMale && sex != demographics.SexFemale {
+			return nil, fmt.Errorf("line %d: sex must be empty, male or female", line)
+		}
+
+		var limits [4]*float64
+		for j, text := range record[3:] {
+			if text = strings.TrimSpace(text); text == "" {
+				continue
+			}
+			value, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			limits[j] = &value
+		}
+		if limits[1] == nil || limits[2] == nil || *limits[1] > *limits[2] {
+			return nil, fmt.Errorf("line %d: low and high are required, low first", line)
+		}
+
+		loaded[key{strings.TrimSpace(record[0]), band, sex}] = Range{
+			CriticalLow: limits[0], Low: *limits[1], High: *limits[2], CriticalHigh: limits[3],
+		}
+	}
+	return loaded, nil
+}
+
+func knownBand(band demographics.AgeBand) bool {
+	for _, b := range demographics.Bands {
+		if b == band {
+			return true
+		}
+	}
+	return false
+}
+
+// RangeFor returns the reference range of parameter for patient. Patients
+// of unknown age are given the adult range.
+func RangeFor(parameter string, patient *demographics.Patient) (Range, bool) {
+	if Load() != nil {
+		return Range{}, false
+	}
+	band, sex := demographics.BandAdult, ""
+	if patient != nil {
+		sex = patient.Sex
+		if patient.Age != nil {
+			band = patient.Age.Band
+		}
+	}
+	if r, ok := ranges[key{parameter, band, sex}]; ok && sex != "" {
+		return r, true
+	}
+	r, ok := ranges[key{parameter, band, ""}]
+	return r, ok
+}
+
+// Assess flags a measurement of parameter against the patient's range
+func Assess(parameter string, value float64, patient *demographics.Patient) (Flag, bool) {
+	r, ok := RangeFor(parameter, patient)
+	if !ok {
+		return Flag{}, false
+	}
+
+	flag := Flag{Value: value, Status: Normal, Range: r}
+	switch {
+	case r.CriticalLow != nil && value <= *r.CriticalLow:
+		flag.Status, flag.Direction = Critical, Low
+	case r.CriticalHigh != nil && value >= *r.CriticalHigh:
+		flag.Status, flag.Direction = Critical, High
+	case value < r.Low:
+		flag.Status = Low
+	case value > r.High:
+		flag.Status = High
+	}
+	return flag, true
+}
+
+// AssessAll flags each measurement given, keyed by parameter. Measurements
+// that are nil or have no reference range are left out.
+func AssessAll(values map[string]*float64, patient *demographics.Patient) Flags {
+	flags := make(Flags)
+	for parameter, value := range values {
+		if value == nil {
+			continue
+		}
+		if flag, ok := Assess(parameter, *value, patient); ok {
+			flags[parameter] = flag
+		}
+	}
+	return flags
+}
@@ -0,0 +1,40 @@
+package vitalnorms
+
+import (
+	"testing"
+
+	"barman/internal/demographics"
+)
+
+func TestRangeForPrefersSex(t *testing.T) {
+	t.Setenv("VITAL_NORMS_FILE", "")
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		sex  string
+		band demographics.AgeBand
+		high float64
+	}{
+		{"female adult", demographics.SexFemale, demographics.BandAdult, 105},
+		{"male adult", demographics.SexMale, demographics.BandAdult, 100},
+		{"adult of other sex", "other", demographics.BandAdult, 100},
+		{"adult of unknown sex", "", demographics.BandAdult, 100},
+		{"female elderly", demographics.SexFemale, demographics.BandElderly, 105},
+		{"female child", demographics.SexFemale, demographics.BandChild, 140},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patient := &demographics.Patient{Sex: tt.sex, Age: &demographics.Age{Band: tt.band}}
+			r, ok := RangeFor("heart_rate", patient)
+			if !ok {
+				t.Fatal("no heart rate range")
+			}
+			if r.High != tt.high {
+				t.Errorf("high = %g, want %g", r.High, tt.high)
+			}
+		})
+	}
+}
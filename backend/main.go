@@ -1,11 +1,27 @@
 package main
 
 import (
+	"barman/internal/audit"
+	"barman/internal/auth"
 	"barman/internal/database"
+	"barman/internal/events"
+	"barman/internal/fieldcrypt"
+	"barman/internal/growth"
 	"barman/internal/handlers"
 	"barman/internal/models"
 	"barman/internal/models/triage"
+	"barman/internal/triagequeue"
+	"barman/internal/vitalnorms"
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -18,33 +34,177 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	// Initialize database
+	// Initialize encryption, database and authentication
+	fieldcrypt.Init()
 	database.InitDB()
+	auth.Init()
+
+	// Publish visit, triage and appointment changes to live boards
+	if err := events.Register(database.DB, map[interface{}]string{
+		&models.Visit{}:       events.TopicVisit,
+		&triage.Triage{}:      events.TopicTriage,
+		&models.Appointment{}: events.TopicAppointment,
+	}); err != nil {
+		log.Fatal("Failed to register event callbacks:", err)
+	}
+
+	// Record every read and write of patient records
+	if err := audit.Register(database.DB); err != nil {
+		log.Fatal("Failed to register audit callbacks:", err)
+	}
 
 	// Auto migrate database schema
-	database.DB.AutoMigrate(
-		&models.User{},
-		&models.Visit{},
-		&triage.Triage{},
-		&models.Diagnosis{},
-		&models.Prescription{},
-		&models.Medication{},
-		&models.MedicationCatalog{},
-		&models.Appointment{},
-		&models.HereditaryDisease{},
-		&models.Disability{},
-		&models.MedicalImage{},
-		&models.Surgery{},
-		&models.Allergy{},
-		&models.ChronicCondition{},
-	)
+	database.DB.AutoMigrate(database.Models...)
+
+	// Rank patient search by trigram similarity in the database
+	if err := database.EnableNameSearch(database.DB); err != nil {
+		log.Fatal("Failed to set up patient name search:", err)
+	}
+
+	// Link two patients only once
+	if removed, err := database.UniqueFamilyRelations(database.DB); err != nil {
+		log.Fatal("Failed to add the family link index:", err)
+	} else if removed > 0 {
+		log.Printf("Removed %d repeated family links", removed)
+	}
+
+	// Move free-text emergency contacts into their own table
+	if migrated, err := database.MigrateEmergencyContacts(database.DB); err != nil {
+		log.Fatal("Failed to migrate emergency contacts:", err)
+	} else if migrated > 0 {
+		log.Printf("Migrated %d emergency contacts", migrated)
+	}
+
+	// Encrypt legacy plaintext rows and rows written with a retired key,
+	// which may share a national ID with another patient
+	if err := database.DropLegacyNationalIDIndex(database.DB); err != nil {
+		log.Fatal("Failed to drop the old national ID index:", err)
+	}
+	if updated, err := database.ReencryptUsers(database.DB); err != nil {
+		log.Fatal("Failed to re-encrypt patient data:", err)
+	} else if updated > 0 {
+		log.Printf("Re-encrypted %d patient records", updated)
+	}
+
+	// Register each national ID only once, when no patients share one
+	if duplicates, err := database.UniqueNationalIDs(database.DB); err != nil {
+		log.Fatal("Failed to add the national ID index:", err)
+	} else if len(duplicates) > 0 {
+		log.Printf("%d national IDs are registered more than once, so they are not yet unique. "+
+			"Merge the patients with these IDs: %s", len(duplicates), strings.Join(duplicates, "; "))
+	}
+	if updated, err := database.ReencryptEmergencyContacts(database.DB); err != nil {
+		log.Fatal("Failed to re-encrypt emergency contacts:", err)
+	} else if updated > 0 {
+		log.Printf("Re-encrypted %d emergency contacts", updated)
+	}
+
+	// Start the measurement history from heights and weights entered before it existed
+	if copied, err := database.BackfillAnthropometrics(database.DB); err != nil {
+		log.Fatal("Failed to copy patient measurements:", err)
+	} else if copied > 0 {
+		log.Printf("Copied height and weight of %d patients to their measurement history", copied)
+	}
+
+	// Give visits from before the visit flow a state
+	if updated, retyped, err := database.BackfillVisitFlow(database.DB); err != nil {
+		log.Fatal("Failed to backfill visit states:", err)
+	} else {
+		if updated > 0 {
+			log.Printf("Set the state of %d existing visits", updated)
+		}
+		if retyped > 0 {
+			log.Printf("Gave %d visits with a legacy type a visit type", retyped)
+		}
+	}
+
+	// Triage used to store vital signs that were not measured as 0
+	if cleared, err := database.ClearUnmeasuredVitals(database.DB); err != nil {
+		log.Fatal("Failed to clear unmeasured vital signs:", err)
+	} else if cleared > 0 {
+		log.Printf("Cleared %d vital signs recorded as 0", cleared)
+	}
+
+	// Split free-text blood pressure into its own columns, before scoring
+	if migrated, unparsed, err := database.MigrateBloodPressure(database.DB); err != nil {
+		log.Fatal("Failed to migrate blood pressure readings:", err)
+	} else {
+		if migrated > 0 {
+			log.Printf("Migrated %d blood pressure readings", migrated)
+		}
+		for _, reading := range unparsed {
+			log.Printf("Could not read blood pressure %q of triage %d; it is listed at /api/triage/unparsed-blood-pressure", reading.Text, reading.TriageID)
+		}
+	}
+
+	// Score triage recorded before priorities were computed
+	if scored, err := database.ScoreTriages(database.DB); err != nil {
+		log.Fatal("Failed to score existing triage:", err)
+	} else if scored > 0 {
+		log.Printf("Scored %d existing triage records", scored)
+	}
+
+	// Fail early on unreadable growth reference tables and vital sign
+	// reference ranges
+	if err := growth.Load(); err != nil {
+		log.Fatal("Failed to load growth reference tables:", err)
+	}
+	if err := vitalnorms.Load(); err != nil {
+		log.Fatal("Failed to load vital sign reference ranges:", err)
+	}
+
+	// Flag vital signs recorded before reference ranges existed
+	if flagged, err := database.FlagVitals(database.DB); err != nil {
+		log.Fatal("Failed to flag existing vital signs:", err)
+	} else if flagged > 0 {
+		log.Printf("Flagged vital signs of %d existing readings", flagged)
+	}
+
+	// How long deleted patients are kept before they can be purged
+	if days := os.Getenv("PATIENT_RETENTION_DAYS"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			log.Fatal("Invalid PATIENT_RETENTION_DAYS:", days)
+		}
+		handlers.PatientRetention = time.Duration(n) * 24 * time.Hour
+	}
+
+	// Escalate patients who wait longer than the target for their priority
+	if err := triagequeue.Load(); err != nil {
+		log.Fatal("Invalid triage queue settings: ", err)
+	}
+	escalationInterval := time.Minute
+	if interval := os.Getenv("TRIAGE_ESCALATION_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			log.Fatal("Invalid TRIAGE_ESCALATION_INTERVAL:", interval)
+		}
+		escalationInterval = d
+	}
+
+	// Background work and the server stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	escalating := make(chan struct{})
+	go func() {
+		defer close(escalating)
+		triagequeue.Run(ctx, database.DB, escalationInterval)
+	}()
+
+	// Create the first admin account if none exist
+	auth.SeedAdmin(database.DB)
 
 	// Initialize router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.LoggerWithFormatter(auth.LogFormatter), gin.Recovery())
 
 	// Configure CORS
+	allowedOrigins := []string{"http://localhost:3000"}
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		allowedOrigins = strings.Split(origins, ",")
+	}
 	router.Use(cors.New(cors.Config{
-		AllowAllOrigins:  true,
+		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-Requested-With"},
 		ExposeHeaders:    []string{"Content-Length"},
@@ -55,142 +215,289 @@ func main() {
 	// Initialize handlers
 	medicationHandler := handlers.NewMedicationHandler(database.DB)
 
+	// Role guards
+	clinicians := auth.RequireRoles(models.RoleDoctor, models.RoleNurse)
+	frontDesk := auth.RequireRoles(models.RoleDoctor, models.RoleNurse, models.RoleReceptionist)
+	dispensers := auth.RequireRoles(models.RoleDoctor, models.RoleNurse, models.RolePharmacist)
+	doctors := auth.RequireRoles(models.RoleDoctor)
+	nurses := auth.RequireRoles(models.RoleNurse)
+	receptionists := auth.RequireRoles(models.RoleReceptionist)
+	admins := auth.RequireRoles(models.RoleAdmin)
+
+	// Auth routes
+	router.POST("/api/auth/login", handlers.Login)
+
+	// Everything below requires a signed-in staff member
+	api := router.Group("/api", auth.RequireAuth(), audit.Middleware(), events.Middleware())
+
+	api.GET("/auth/me", handlers.GetCurrentStaff)
+	api.POST("/auth/stream-ticket", handlers.IssueStreamTicket)
+
+	// Staff routes
+	staffRoutes := api.Group("/staff", admins)
+	{
+		staffRoutes.POST("", handlers.CreateStaff)
+		staffRoutes.GET("", handlers.GetAllStaff)
+		staffRoutes.GET("/:id", handlers.GetStaff)
+		staffRoutes.PUT("/:id", handlers.UpdateStaff)
+		staffRoutes.DELETE("/:id", handlers.DeleteStaff)
+	}
+
+	// Audit routes
+	api.GET("/audit-logs", admins, handlers.GetAuditLogs)
+
+	// Encryption maintenance
+	api.POST("/admin/reencrypt", admins, handlers.ReencryptPatientData)
+
+	// Facility routes
+	facilityRoutes := api.Group("/facilities")
+	{
+		facilityRoutes.POST("", admins, handlers.CreateFacility)
+		facilityRoutes.GET("", handlers.GetAllFacilities)
+		facilityRoutes.GET("/:id", handlers.GetFacility)
+		facilityRoutes.PUT("/:id", admins, handlers.UpdateFacility)
+		facilityRoutes.DELETE("/:id", admins, handlers.DeleteFacility)
+		facilityRoutes.GET("/:id/departments", handlers.GetFacilityDepartments)
+	}
+
+	// Department routes
+	departmentRoutes := api.Group("/departments")
+	{
+		departmentRoutes.POST("", admins, handlers.AddDepartment)
+		departmentRoutes.GET("/:id", handlers.GetDepartment)
+		departmentRoutes.PUT("/:id", admins, handlers.UpdateDepartment)
+		departmentRoutes.DELETE("/:id", admins, handlers.DeleteDepartment)
+	}
+
+	// Practitioner routes
+	practitionerRoutes := api.Group("/practitioners")
+	{
+		practitionerRoutes.POST("", admins, handlers.CreatePractitioner)
+		practitionerRoutes.GET("", handlers.GetAllPractitioners)
+		practitionerRoutes.GET("/:id", handlers.GetPractitioner)
+		practitionerRoutes.PUT("/:id", admins, handlers.UpdatePractitioner)
+		practitionerRoutes.DELETE("/:id", admins, handlers.DeletePractitioner)
+	}
+
 	// Stats route
-	router.GET("/api/stats", handlers.GetStats)
+	api.GET("/stats", handlers.GetStats)
+	api.GET("/stats/patient-flow", handlers.GetPatientFlowStats)
 
 	// User routes
-	userRoutes := router.Group("/api/users")
+	userRoutes := api.Group("/users")
+	{
+		userRoutes.POST("", frontDesk, handlers.CreateUser)
+		userRoutes.GET("", frontDesk, handlers.GetAllUsers)
+		userRoutes.GET("/search", frontDesk, handlers.SearchUsers)
+		userRoutes.GET("/duplicates", frontDesk, handlers.FindDuplicatePatients)
+		userRoutes.GET("/:id", frontDesk, handlers.GetUser)
+		userRoutes.GET("/national-id/:national_id", frontDesk, handlers.GetUserByNationalID)
+		userRoutes.PUT("/:id", frontDesk, handlers.UpdateUser)
+		userRoutes.DELETE("/:id", admins, handlers.DeleteUser)
+		userRoutes.POST("/:id/restore", admins, handlers.RestoreUser)
+		userRoutes.POST("/:id/anonymise", admins, handlers.AnonymiseUser)
+		userRoutes.DELETE("/:id/purge", admins, handlers.PurgeUser)
+		userRoutes.POST("/purge-expired", admins, handlers.PurgeExpiredUsers)
+		userRoutes.GET("/:id/lifecycle", admins, handlers.GetUserLifecycle)
+		userRoutes.POST("/:id/merge", admins, handlers.MergePatients)
+		userRoutes.GET("/:id/relatives", clinicians, handlers.GetFamilyRelations)
+		userRoutes.POST("/:id/relatives", clinicians, handlers.AddFamilyRelation)
+		userRoutes.DELETE("/:id/relatives/:relative_id", clinicians, handlers.DeleteFamilyRelation)
+		userRoutes.GET("/:id/pedigree", clinicians, handlers.GetPedigree)
+		userRoutes.GET("/:id/timeline", clinicians, handlers.GetUserTimeline)
+		userRoutes.GET("/:id/summary", clinicians, handlers.GetUserSummary)
+		userRoutes.GET("/:id/insurance-policies", frontDesk, handlers.GetUserInsurancePolicies)
+		userRoutes.POST("/:id/insurance-policies", frontDesk, handlers.AddInsurancePolicy)
+		userRoutes.GET("/:id/emergency-contacts", frontDesk, handlers.GetEmergencyContacts)
+		userRoutes.POST("/:id/emergency-contacts", frontDesk, handlers.AddEmergencyContact)
+		userRoutes.PUT("/:id/emergency-contacts/:contact_id", frontDesk, handlers.UpdateEmergencyContact)
+		userRoutes.DELETE("/:id/emergency-contacts/:contact_id", frontDesk, handlers.DeleteEmergencyContact)
+	}
+
+	// Patient merge log
+	mergeRoutes := api.Group("/patient-merges", admins)
+	{
+		mergeRoutes.GET("", handlers.GetPatientMerges)
+		mergeRoutes.POST("/:id/undo", handlers.UndoPatientMerge)
+	}
+
+	// Insurers and their coverage rules
+	insurerRoutes := api.Group("/insurers")
 	{
-		userRoutes.POST("", handlers.CreateUser)
-		userRoutes.GET("", handlers.GetAllUsers)
-		userRoutes.GET("/:id", handlers.GetUser)
-		userRoutes.GET("/national-id/:national_id", handlers.GetUserByNationalID)
-		userRoutes.PUT("/:id", handlers.UpdateUser)
-		userRoutes.DELETE("/:id", handlers.DeleteUser)
+		insurerRoutes.GET("", frontDesk, handlers.GetAllInsurers)
+		insurerRoutes.POST("", admins, handlers.CreateInsurer)
+		insurerRoutes.GET("/:id", frontDesk, handlers.GetInsurer)
+		insurerRoutes.PUT("/:id", admins, handlers.UpdateInsurer)
+		insurerRoutes.POST("/:id/coverage-rules", admins, handlers.AddCoverageRule)
+		insurerRoutes.DELETE("/:id/coverage-rules/:rule_id", admins, handlers.DeleteCoverageRule)
 	}
 
+	// Insurance policies and eligibility
+	policyRoutes := api.Group("/insurance-policies", frontDesk)
+	{
+		policyRoutes.PUT("/:id", handlers.UpdateInsurancePolicy)
+		policyRoutes.DELETE("/:id", handlers.DeleteInsurancePolicy)
+	}
+	api.POST("/insurance/eligibility", frontDesk, handlers.CheckEligibility)
+
 	// Visit routes
-	visitRoutes := router.Group("/api/visits")
+	visitRoutes := api.Group("/visits")
+	{
+		visitRoutes.POST("", frontDesk, handlers.CreateVisit)
+		visitRoutes.GET("/:id", frontDesk, handlers.GetVisit)
+		visitRoutes.PUT("/:id", clinicians, handlers.UpdateVisit)
+		visitRoutes.POST("/:id/status", frontDesk, handlers.ChangeVisitStatus)
+		visitRoutes.GET("/board", frontDesk, handlers.GetBoard)
+		visitRoutes.GET("/board/stream", frontDesk, handlers.StreamBoard)
+		visitRoutes.POST("/:id/observations", nurses, handlers.RecordObservation)
+		visitRoutes.GET("/:id/observations", clinicians, handlers.GetVisitObservations)
+		visitRoutes.GET("/:id/observations/trend", clinicians, handlers.GetObservationTrend)
+		visitRoutes.GET("/user/:user_id", frontDesk, handlers.GetUserVisits)
+		visitRoutes.GET("/user/:user_id/latest-triage", clinicians, handlers.GetLatestTriage)
+	}
+
+	// Height, weight and head circumference history
+	anthropometricRoutes := api.Group("/anthropometrics", clinicians)
 	{
-		visitRoutes.POST("", handlers.CreateVisit)
-		visitRoutes.GET("/:id", handlers.GetVisit)
-		visitRoutes.PUT("/:id", handlers.UpdateVisit)
-		visitRoutes.GET("/user/:user_id", handlers.GetUserVisits)
-		visitRoutes.GET("/user/:user_id/latest-triage", handlers.GetLatestTriage)
+		anthropometricRoutes.POST("", handlers.RecordAnthropometrics)
+		anthropometricRoutes.DELETE("/:id", handlers.DeleteAnthropometric)
+		anthropometricRoutes.GET("/user/:user_id", handlers.GetUserAnthropometrics)
+		anthropometricRoutes.GET("/user/:user_id/trend", handlers.GetAnthropometricTrend)
+		anthropometricRoutes.GET("/user/:user_id/growth", handlers.GetGrowthAssessment)
 	}
 
 	// Triage routes
-	triageRoutes := router.Group("/api/triage")
+	triageRoutes := api.Group("/triage", clinicians)
 	{
-		triageRoutes.POST("", handlers.CreateTriage)
+		triageRoutes.POST("", nurses, handlers.CreateTriage)
 		triageRoutes.GET("/:id", handlers.GetTriage)
-		triageRoutes.PUT("/:id", handlers.UpdateTriage)
+		triageRoutes.PUT("/:id", nurses, handlers.UpdateTriage)
 		triageRoutes.GET("/user/:user_id/history", handlers.GetUserTriageHistory)
 		triageRoutes.GET("/user/:user_id/latest", handlers.GetLatestTriageByUser)
 		triageRoutes.GET("/pending", handlers.GetPendingTriage)
+		triageRoutes.GET("/unparsed-blood-pressure", handlers.GetUnparsedBloodPressures)
 	}
 
+	// Alerts such as critical vital signs, as they are raised
+	api.GET("/alerts/stream", clinicians, handlers.StreamAlerts)
+
 	// Doctor report routes
-	reportRoutes := router.Group("/api/reports")
+	reportRoutes := api.Group("/reports", clinicians)
 	{
-		reportRoutes.POST("", handlers.CreateDoctorReport)
+		reportRoutes.POST("", doctors, handlers.CreateDoctorReport)
 		reportRoutes.GET("/:id", handlers.GetDoctorReport)
-		reportRoutes.PUT("/:id", handlers.UpdateDoctorReport)
+		reportRoutes.PUT("/:id", doctors, handlers.UpdateDoctorReport)
 		reportRoutes.GET("/user/:user_id", handlers.GetUserDoctorReports)
 		reportRoutes.GET("/visit/:visit_id", handlers.GetVisitDoctorReport)
 	}
 
 	// Prescription routes
-	prescriptionRoutes := router.Group("/api/prescriptions")
+	prescriptionRoutes := api.Group("/prescriptions", dispensers)
 	{
-		prescriptionRoutes.POST("", handlers.CreatePrescription)
+		prescriptionRoutes.POST("", doctors, handlers.CreatePrescription)
 		prescriptionRoutes.GET("/:id", handlers.GetPrescription)
-		prescriptionRoutes.PUT("/:id", handlers.UpdatePrescription)
-		prescriptionRoutes.DELETE("/:id", handlers.DeletePrescription)
+		prescriptionRoutes.PUT("/:id", doctors, handlers.UpdatePrescription)
+		prescriptionRoutes.DELETE("/:id", doctors, handlers.DeletePrescription)
 		prescriptionRoutes.GET("/user/:user_id", handlers.GetPrescriptionsByUser)
 	}
 
 	// Medication catalog routes
-	medicationRoutes := router.Group("/api/medications")
+	medicationRoutes := api.Group("/medications", dispensers)
 	{
 		medicationRoutes.GET("/search", medicationHandler.SearchMedications)
 		medicationRoutes.GET("/:id", medicationHandler.GetMedication)
-		medicationRoutes.POST("/prescriptions", medicationHandler.CreatePrescription)
+		medicationRoutes.POST("/prescriptions", doctors, medicationHandler.CreatePrescription)
 		medicationRoutes.GET("/prescriptions/:id", medicationHandler.GetPrescription)
 		medicationRoutes.GET("/prescriptions/user/:userId", medicationHandler.GetUserPrescriptions)
 	}
 
 	// Appointment routes
-	appointmentRoutes := router.Group("/api/appointments")
+	appointmentRoutes := api.Group("/appointments", frontDesk)
 	{
-		appointmentRoutes.POST("", handlers.CreateAppointment)
+		appointmentRoutes.POST("", receptionists, handlers.CreateAppointment)
 		appointmentRoutes.GET("/:id", handlers.GetAppointment)
-		appointmentRoutes.PUT("/:id", handlers.UpdateAppointment)
-		appointmentRoutes.DELETE("/:id", handlers.DeleteAppointment)
+		appointmentRoutes.PUT("/:id", receptionists, handlers.UpdateAppointment)
+		appointmentRoutes.DELETE("/:id", receptionists, handlers.DeleteAppointment)
 		appointmentRoutes.GET("/user/:user_id", handlers.GetUserAppointments)
 		appointmentRoutes.GET("/upcoming", handlers.GetUpcomingAppointments)
 	}
 
 	// Hereditary Disease routes
-	hereditaryDiseaseRoutes := router.Group("/api/hereditary-diseases")
+	hereditaryDiseaseRoutes := api.Group("/hereditary-diseases", clinicians)
 	{
-		hereditaryDiseaseRoutes.POST("", handlers.AddHereditaryDisease)
+		hereditaryDiseaseRoutes.POST("", doctors, handlers.AddHereditaryDisease)
 		hereditaryDiseaseRoutes.GET("/:id", handlers.GetHereditaryDisease)
-		hereditaryDiseaseRoutes.PUT("/:id", handlers.UpdateHereditaryDisease)
-		hereditaryDiseaseRoutes.DELETE("/:id", handlers.DeleteHereditaryDisease)
+		hereditaryDiseaseRoutes.PUT("/:id", doctors, handlers.UpdateHereditaryDisease)
+		hereditaryDiseaseRoutes.DELETE("/:id", doctors, handlers.DeleteHereditaryDisease)
 		hereditaryDiseaseRoutes.GET("/user/:user_id", handlers.GetUserHereditaryDiseases)
 	}
 
 	// Disability routes
-	disabilityRoutes := router.Group("/api/disabilities")
+	disabilityRoutes := api.Group("/disabilities", clinicians)
 	{
-		disabilityRoutes.POST("", handlers.AddDisability)
+		disabilityRoutes.POST("", doctors, handlers.AddDisability)
 		disabilityRoutes.GET("/:id", handlers.GetDisability)
-		disabilityRoutes.PUT("/:id", handlers.UpdateDisability)
-		disabilityRoutes.DELETE("/:id", handlers.DeleteDisability)
+		disabilityRoutes.PUT("/:id", doctors, handlers.UpdateDisability)
+		disabilityRoutes.DELETE("/:id", doctors, handlers.DeleteDisability)
 		disabilityRoutes.GET("/user/:user_id", handlers.GetUserDisabilities)
 	}
 
 	// Medical Image routes
-	medicalImageRoutes := router.Group("/api/medical-images")
+	medicalImageRoutes := api.Group("/medical-images", clinicians)
 	{
-		medicalImageRoutes.POST("", handlers.AddMedicalImage)
+		medicalImageRoutes.POST("", doctors, handlers.AddMedicalImage)
 		medicalImageRoutes.GET("/:id", handlers.GetMedicalImage)
-		medicalImageRoutes.PUT("/:id", handlers.UpdateMedicalImage)
-		medicalImageRoutes.DELETE("/:id", handlers.DeleteMedicalImage)
+		medicalImageRoutes.PUT("/:id", doctors, handlers.UpdateMedicalImage)
+		medicalImageRoutes.DELETE("/:id", doctors, handlers.DeleteMedicalImage)
 		medicalImageRoutes.GET("/user/:user_id", handlers.GetUserMedicalImages)
 	}
 
 	// Surgery routes
-	surgeryRoutes := router.Group("/api/surgeries")
+	surgeryRoutes := api.Group("/surgeries", clinicians)
 	{
-		surgeryRoutes.POST("", handlers.AddSurgery)
+		surgeryRoutes.POST("", doctors, handlers.AddSurgery)
 		surgeryRoutes.GET("/:id", handlers.GetSurgery)
-		surgeryRoutes.PUT("/:id", handlers.UpdateSurgery)
-		surgeryRoutes.DELETE("/:id", handlers.DeleteSurgery)
+		surgeryRoutes.PUT("/:id", doctors, handlers.UpdateSurgery)
+		surgeryRoutes.DELETE("/:id", doctors, handlers.DeleteSurgery)
 		surgeryRoutes.GET("/user/:user_id", handlers.GetUserSurgeries)
 	}
 
 	// Allergy routes
-	allergyRoutes := router.Group("/api/allergies")
+	allergyRoutes := api.Group("/allergies", clinicians)
 	{
-		allergyRoutes.POST("", handlers.AddAllergy)
+		allergyRoutes.POST("", doctors, handlers.AddAllergy)
 		allergyRoutes.GET("/:id", handlers.GetAllergy)
-		allergyRoutes.PUT("/:id", handlers.UpdateAllergy)
-		allergyRoutes.DELETE("/:id", handlers.DeleteAllergy)
+		allergyRoutes.PUT("/:id", doctors, handlers.UpdateAllergy)
+		allergyRoutes.DELETE("/:id", doctors, handlers.DeleteAllergy)
 		allergyRoutes.GET("/user/:user_id", handlers.GetUserAllergies)
 	}
 
 	// Chronic Condition routes
-	chronicConditionRoutes := router.Group("/api/chronic-conditions")
+	chronicConditionRoutes := api.Group("/chronic-conditions", clinicians)
 	{
-		chronicConditionRoutes.POST("", handlers.AddChronicCondition)
+		chronicConditionRoutes.POST("", doctors, handlers.AddChronicCondition)
 		chronicConditionRoutes.GET("/:id", handlers.GetChronicCondition)
-		chronicConditionRoutes.PUT("/:id", handlers.UpdateChronicCondition)
-		chronicConditionRoutes.DELETE("/:id", handlers.DeleteChronicCondition)
+		chronicConditionRoutes.PUT("/:id", doctors, handlers.UpdateChronicCondition)
+		chronicConditionRoutes.DELETE("/:id", doctors, handlers.DeleteChronicCondition)
 		chronicConditionRoutes.GET("/user/:user_id", handlers.GetUserChronicConditions)
 	}
 
-	// Start server
-	router.Run(":8080")
+	// Start server, and give requests in progress a few seconds to finish
+	// once it is asked to stop
+	server := &http.Server{Addr: ":8080", Handler: router}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(timeout); err != nil {
+			log.Println("Server did not shut down cleanly:", err)
+		}
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("Server failed:", err)
+	}
+	<-stopped
+	<-escalating
 }
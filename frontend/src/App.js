@@ -1,5 +1,5 @@
 import React from 'react';
-import { BrowserRouter as Router, Routes, Route } from 'react-router-dom';
+import { BrowserRouter as Router, Routes, Route, Navigate } from 'react-router-dom';
 import { ThemeProvider } from '@mui/material/styles';
 import { CacheProvider } from '@emotion/react';
 import CssBaseline from '@mui/material/CssBaseline';
@@ -9,10 +9,14 @@ import { ThemeProvider as AppThemeProvider } from './theme/ThemeContext';
 import { useTheme } from './theme/ThemeContext';
 import { getTheme, cacheRtl } from './theme/ThemeConfig';
 
+// Authentication
+import { getToken } from './auth';
+
 // Layout
 import Layout from './components/Layout';
 
 // Pages
+import Login from './pages/Login';
 import Dashboard from './pages/Dashboard';
 import PatientList from './pages/PatientList';
 import PatientDetails from './pages/PatientDetails';
@@ -21,6 +25,31 @@ import DoctorVisit from './pages/DoctorVisit';
 import Prescription from './pages/Prescription';
 import Appointment from './pages/Appointment';
 
+// Pages other than the login page are shown only with a valid token
+function RequireLogin() {
+  if (!getToken()) {
+    return <Navigate to="/login" replace />;
+  }
+
+  return (
+    <Layout>
+      <Routes>
+        <Route path="/" element={<Dashboard />} />
+        <Route path="/patients" element={<PatientList />} />
+        <Route path="/patients/:id" element={<PatientDetails />} />
+        <Route path="/triage" element={<Triage />} />
+        <Route path="/triage/:id" element={<Triage />} />
+        <Route path="/doctor-visit" element={<DoctorVisit />} />
+        <Route path="/doctor-visit/:id" element={<DoctorVisit />} />
+        <Route path="/prescription" element={<Prescription />} />
+        <Route path="/prescription/:id" element={<Prescription />} />
+        <Route path="/appointment" element={<Appointment />} />
+        <Route path="/appointment/:id" element={<Appointment />} />
+      </Routes>
+    </Layout>
+  );
+}
+
 // Theme wrapper to apply the theme based on the context
 function ThemedApp() {
   const { darkMode } = useTheme();
@@ -30,21 +59,10 @@ function ThemedApp() {
     <ThemeProvider theme={theme}>
       <CssBaseline />
       <Router>
-        <Layout>
-          <Routes>
-            <Route path="/" element={<Dashboard />} />
-            <Route path="/patients" element={<PatientList />} />
-            <Route path="/patients/:id" element={<PatientDetails />} />
-            <Route path="/triage" element={<Triage />} />
-            <Route path="/triage/:id" element={<Triage />} />
-            <Route path="/doctor-visit" element={<DoctorVisit />} />
-            <Route path="/doctor-visit/:id" element={<DoctorVisit />} />
-            <Route path="/prescription" element={<Prescription />} />
-            <Route path="/prescription/:id" element={<Prescription />} />
-            <Route path="/appointment" element={<Appointment />} />
-            <Route path="/appointment/:id" element={<Appointment />} />
-          </Routes>
-        </Layout>
+        <Routes>
+          <Route path="/login" element={<Login />} />
+          <Route path="*" element={<RequireLogin />} />
+        </Routes>
       </Router>
     </ThemeProvider>
   );
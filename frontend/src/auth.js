@@ -0,0 +1,51 @@
+// The token issued at login is kept in localStorage so that it survives a
+// reload, and sent with every API request as a bearer token
+const TOKEN_KEY = 'barman_token';
+const EXPIRES_KEY = 'barman_token_expires_at';
+
+export function getToken() {
+  const token = localStorage.getItem(TOKEN_KEY);
+  const expiresAt = localStorage.getItem(EXPIRES_KEY);
+  if (!token || (expiresAt && new Date(expiresAt) <= new Date())) {
+    return null;
+  }
+  return token;
+}
+
+export function setToken(token, expiresAt) {
+  localStorage.setItem(TOKEN_KEY, token);
+  localStorage.setItem(EXPIRES_KEY, expiresAt);
+}
+
+export function clearToken() {
+  localStorage.removeItem(TOKEN_KEY);
+  localStorage.removeItem(EXPIRES_KEY);
+}
+
+// authorize adds the token to each request made with an axios instance and
+// sends the user back to the login page when the token is refused
+export function authorize(instance) {
+  instance.interceptors.request.use((config) => {
+    const token = getToken();
+    if (token) {
+      config.headers.Authorization = `Bearer ${token}`;
+    }
+    return config;
+  });
+
+  instance.interceptors.response.use(
+    (response) => response,
+    (error) => {
+      const loggingIn = error.config && error.config.url && error.config.url.endsWith('/api/auth/login');
+      if (error.response && error.response.status === 401 && !loggingIn) {
+        clearToken();
+        if (window.location.pathname !== '/login') {
+          window.location.assign('/login');
+        }
+      }
+      return Promise.reject(error);
+    }
+  );
+
+  return instance;
+}
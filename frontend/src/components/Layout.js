@@ -28,6 +28,7 @@ import {
 import { useTheme as useMuiTheme } from '@mui/material/styles';
 import ThemeToggle from './ThemeToggle';
 import { useTheme as useAppTheme } from '../theme/ThemeContext';
+import { clearToken } from '../auth';
 
 function Layout({ children }) {
   const navigate = useNavigate();
@@ -46,6 +47,7 @@ function Layout({ children }) {
   ];
 
   const handleLogout = () => {
+    clearToken();
     navigate('/login');
   };
 
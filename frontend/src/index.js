@@ -1,8 +1,13 @@
 import React from 'react';
 import ReactDOM from 'react-dom/client';
+import axios from 'axios';
 import App from './App';
+import { authorize } from './auth';
 import reportWebVitals from './reportWebVitals';
 
+// Send the login token with every API request
+authorize(axios);
+
 const root = ReactDOM.createRoot(document.getElementById('root'));
 root.render(
   <React.StrictMode>
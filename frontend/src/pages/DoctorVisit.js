@@ -102,7 +102,7 @@ function DoctorVisit() {
     try {
       const response = await axios.post(`http://localhost:8080/api/visits`, {
         user_id: parseInt(id, 10),
-        type: 'doctor_visit',
+        type: 'regular',
         doctor_report: visitData,
         date: new Date().toISOString(),
       });
@@ -0,0 +1,107 @@
+import React, { useState } from 'react';
+import { useNavigate } from 'react-router-dom';
+import {
+  Box,
+  Paper,
+  Typography,
+  TextField,
+  Button,
+  Alert,
+  CircularProgress,
+} from '@mui/material';
+import axios from 'axios';
+import { setToken } from '../auth';
+
+function Login() {
+  const navigate = useNavigate();
+  const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
+  const [loading, setLoading] = useState(false);
+  const [error, setError] = useState('');
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setLoading(true);
+    setError('');
+    try {
+      const response = await axios.post('http://localhost:8080/api/auth/login', {
+        username,
+        password,
+      });
+      setToken(response.data.token, response.data.expires_at);
+      navigate('/', { replace: true });
+    } catch (error) {
+      console.error('Error logging in:', error);
+      if (error.response && error.response.status === 401) {
+        setError('نام کاربری یا رمز عبور اشتباه است');
+      } else {
+        setError('خطا در ورود به سیستم');
+      }
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <Box sx={{
+      display: 'flex',
+      alignItems: 'center',
+      justifyContent: 'center',
+      minHeight: '100vh',
+      direction: 'rtl',
+      backgroundColor: 'background.default',
+    }}>
+      <Paper
+        component="form"
+        onSubmit={handleSubmit}
+        sx={{
+          p: 4,
+          width: '90%',
+          maxWidth: '400px',
+          display: 'flex',
+          flexDirection: 'column',
+          boxShadow: 3,
+          borderRadius: 2
+        }}
+      >
+        <Typography variant="h5" gutterBottom align="center" sx={{ mb: 3 }}>
+          ورود به سیستم بارمان
+        </Typography>
+
+        {error && <Alert severity="error" sx={{ mb: 2 }}>{error}</Alert>}
+
+        <TextField
+          fullWidth
+          label="نام کاربری"
+          value={username}
+          onChange={(e) => setUsername(e.target.value)}
+          autoComplete="username"
+          required
+          sx={{ mb: 2 }}
+        />
+        <TextField
+          fullWidth
+          label="رمز عبور"
+          type="password"
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+          autoComplete="current-password"
+          required
+          sx={{ mb: 3 }}
+        />
+
+        <Button
+          type="submit"
+          variant="contained"
+          size="large"
+          fullWidth
+          disabled={loading}
+        >
+          {loading ? <CircularProgress size={24} /> : 'ورود'}
+        </Button>
+      </Paper>
+    </Box>
+  );
+}
+
+export default Login;
@@ -130,6 +130,15 @@ const SAMPLE_MEDICAL_IMAGES = [
   }
 ];
 
+// visitKind tells what was recorded at a visit, which its type (regular,
+// emergency or follow-up) does not say
+function visitKind(visit) {
+  if (visit.doctor_report) return 'doctor_visit';
+  if (visit.triage_data) return 'triage';
+  if (visit.prescription) return 'prescription';
+  return '';
+}
+
 function PatientDetails() {
   const { id } = useParams();
   const navigate = useNavigate();
@@ -216,21 +225,21 @@ function PatientDetails() {
         ]);
 
         if (visitsRes.status === 'fulfilled') {
-          setVisits(visitsRes.value.data);
+          setVisits(visitsRes.value.data.data);
         } else {
           console.warn('Failed to fetch visits:', visitsRes.reason);
           setVisits([]);
         }
 
         if (prescriptionsRes.status === 'fulfilled') {
-          setPrescriptions(prescriptionsRes.value.data);
+          setPrescriptions(prescriptionsRes.value.data.data);
         } else {
           console.warn('Failed to fetch prescriptions:', prescriptionsRes.reason);
           setPrescriptions([]);
         }
 
         if (appointmentsRes.status === 'fulfilled') {
-          setAppointments(appointmentsRes.value.data);
+          setAppointments(appointmentsRes.value.data.data);
         } else {
           console.warn('Failed to fetch appointments:', appointmentsRes.reason);
           setAppointments([]);
@@ -238,42 +247,42 @@ function PatientDetails() {
         
         // New feature data
         if (hereditaryDiseasesRes.status === 'fulfilled') {
-          setHereditaryDiseases(hereditaryDiseasesRes.value.data);
+          setHereditaryDiseases(hereditaryDiseasesRes.value.data.data);
         } else {
           console.warn('Failed to fetch hereditary diseases:', hereditaryDiseasesRes.reason);
           setHereditaryDiseases([]);
         }
         
         if (disabilitiesRes.status === 'fulfilled') {
-          setDisabilities(disabilitiesRes.value.data);
+          setDisabilities(disabilitiesRes.value.data.data);
         } else {
           console.warn('Failed to fetch disabilities:', disabilitiesRes.reason);
           setDisabilities([]);
         }
         
         if (allergiesRes.status === 'fulfilled') {
-          setAllergies(allergiesRes.value.data);
+          setAllergies(allergiesRes.value.data.data);
         } else {
           console.warn('Failed to fetch allergies:', allergiesRes.reason);
           setAllergies([]);
         }
         
         if (chronicConditionsRes.status === 'fulfilled') {
-          setChronicConditions(chronicConditionsRes.value.data);
+          setChronicConditions(chronicConditionsRes.value.data.data);
         } else {
           console.warn('Failed to fetch chronic conditions:', chronicConditionsRes.reason);
           setChronicConditions([]);
         }
         
         if (medicalImagesRes.status === 'fulfilled') {
-          setMedicalImages(medicalImagesRes.value.data);
+          setMedicalImages(medicalImagesRes.value.data.data);
         } else {
           console.warn('Failed to fetch medical images:', medicalImagesRes.reason);
           setMedicalImages([]);
         }
         
         if (surgeriesRes.status === 'fulfilled') {
-          setSurgeries(surgeriesRes.value.data);
+          setSurgeries(surgeriesRes.value.data.data);
         } else {
           console.warn('Failed to fetch surgeries:', surgeriesRes.reason);
           setSurgeries([]);
@@ -815,8 +824,8 @@ function PatientDetails() {
                 >
                   {visits.map((visit, index) => (
                     <MenuItem key={visit.id || visit.ID || index} value={visit.id || visit.ID || index}>
-                      {visit.type === 'triage' ? 'تریاژ' : 
-                       visit.type === 'doctor_visit' ? 'ویزیت پزشک' : 
+                      {visitKind(visit) === 'triage' ? 'تریاژ' : 
+                       visitKind(visit) === 'doctor_visit' ? 'ویزیت پزشک' : 
                        'ویزیت'} - {formatDate(visit.date || visit.Date || visit.created_at || visit.CreatedAt)}
                     </MenuItem>
                   ))}
@@ -1433,13 +1442,13 @@ function PatientDetails() {
                     <ListItem key={visit.id || visit.ID || index} sx={{ px: 0, py: 1 }}>
                       <Box sx={{ width: '100%', border: '1px solid #e0e0e0', borderRadius: '8px', p: 1 }}>
                         <Box sx={{ display: 'flex', alignItems: 'center', mb: 0.5 }}>
-                          {visit.type === 'triage' ? <DescriptionIcon fontSize="small" sx={{ ml: 1, color: 'info.main' }} /> :
-                           visit.type === 'doctor_visit' ? <HistoryEduIcon fontSize="small" sx={{ ml: 1, color: 'success.main' }} /> :
+                          {visitKind(visit) === 'triage' ? <DescriptionIcon fontSize="small" sx={{ ml: 1, color: 'info.main' }} /> :
+                           visitKind(visit) === 'doctor_visit' ? <HistoryEduIcon fontSize="small" sx={{ ml: 1, color: 'success.main' }} /> :
                            <MedicationIcon fontSize="small" sx={{ ml: 1, color: 'warning.main' }} />}
                           <Typography variant="subtitle2">
-                            {visit.type === 'triage' ? 'تریاژ' : 
-                             visit.type === 'doctor_visit' ? 'ویزیت پزشک' : 
-                             visit.type === 'prescription' ? 'نسخه‌نویسی' : 
+                            {visitKind(visit) === 'triage' ? 'تریاژ' : 
+                             visitKind(visit) === 'doctor_visit' ? 'ویزیت پزشک' : 
+                             visitKind(visit) === 'prescription' ? 'نسخه‌نویسی' : 
                              'مراجعه'} - {formatDate(visit.date || visit.Date || visit.created_at || visit.CreatedAt)}
               </Typography>
             </Box>
@@ -2407,13 +2416,13 @@ function PatientDetails() {
                           <Box sx={{ display: 'flex', flexDirection: 'column', width: '100%' }}>
                             <Box sx={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', width: '100%' }}>
                               <Typography variant="subtitle1" sx={{ 
-                                color: visit.type === 'triage' ? 'info.main' : 
-                                        visit.type === 'doctor_visit' ? 'success.main' : 'warning.main',
+                                color: visitKind(visit) === 'triage' ? 'info.main' : 
+                                        visitKind(visit) === 'doctor_visit' ? 'success.main' : 'warning.main',
                                 fontWeight: 'bold'
                               }}>
-                                {visit.type === 'triage' ? 'تریاژ' : 
-                                 visit.type === 'doctor_visit' ? 'ویزیت پزشک' : 
-                                 visit.type === 'prescription' ? 'نسخه‌نویسی' : 
+                                {visitKind(visit) === 'triage' ? 'تریاژ' : 
+                                 visitKind(visit) === 'doctor_visit' ? 'ویزیت پزشک' : 
+                                 visitKind(visit) === 'prescription' ? 'نسخه‌نویسی' : 
                                  'مراجعه'}
                   </Typography>
                               <Typography variant="subtitle2" color="text.secondary">
@@ -2865,13 +2874,13 @@ function PatientDetails() {
                     <Box sx={{ display: 'flex', flexDirection: 'column', width: '100%' }}>
                       <Box sx={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', width: '100%' }}>
                         <Typography variant="subtitle1" sx={{ 
-                          color: visit.type === 'triage' ? 'info.main' : 
-                                  visit.type === 'doctor_visit' ? 'success.main' : 'warning.main',
+                          color: visitKind(visit) === 'triage' ? 'info.main' : 
+                                  visitKind(visit) === 'doctor_visit' ? 'success.main' : 'warning.main',
                           fontWeight: 'bold'
                         }}>
-                          {visit.type === 'triage' ? 'تریاژ' : 
-                                   visit.type === 'doctor_visit' ? 'ویزیت پزشک' : 
-                                   visit.type === 'prescription' ? 'نسخه‌نویسی' : 
+                          {visitKind(visit) === 'triage' ? 'تریاژ' : 
+                                   visitKind(visit) === 'doctor_visit' ? 'ویزیت پزشک' : 
+                                   visitKind(visit) === 'prescription' ? 'نسخه‌نویسی' : 
                                    'مراجعه'}
               </Typography>
                         <Typography variant="subtitle2" color="text.secondary">
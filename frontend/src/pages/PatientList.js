@@ -35,14 +35,15 @@ import {
   Person as PersonIcon,
 } from '@mui/icons-material';
 import axios from 'axios';
+import { authorize } from '../auth';
 
 // Create axios instance with base URL
-const api = axios.create({
+const api = authorize(axios.create({
   baseURL: 'http://localhost:8080',
   headers: {
     'Content-Type': 'application/json',
   },
-});
+}));
 
 // Add styled component for hidden input
 const VisuallyHiddenInput = styled('input')({
@@ -93,7 +94,7 @@ function PatientList() {
     try {
       const response = await api.get('/api/users');
       console.log('Raw API response:', response); // Debug log
-      const processedData = response.data.map(patient => ({
+      const processedData = response.data.data.map(patient => ({
         ...patient,
         fullName: `${patient.first_name} ${patient.last_name}`,
       }));
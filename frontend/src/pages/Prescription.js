@@ -215,7 +215,7 @@ function Prescription() {
   const fetchPatientsList = async () => {
     try {
       const response = await axios.get('http://localhost:8080/api/users');
-      setPatients(response.data);
+      setPatients(response.data.data);
     } catch (error) {
       console.error('Error fetching patients list:', error);
       setError('خطا در دریافت لیست بیماران');
@@ -237,9 +237,10 @@ function Prescription() {
     try {
       // Get the latest doctor visit diagnosis for this patient
       const response = await axios.get(`http://localhost:8080/api/reports/user/${id}`);
-      if (response.data && response.data.length > 0) {
+      const reports = response.data.data;
+      if (reports && reports.length > 0) {
         // Get the most recent diagnosis
-        setDiagnosis(response.data[0]);
+        setDiagnosis(reports[0]);
       }
     } catch (error) {
       console.error('Error fetching diagnosis data:', error);
@@ -271,8 +272,9 @@ function Prescription() {
       // Try searching with backend API
       const response = await axios.get(`http://localhost:8080/api/medications/search?q=${query}`);
       
-      if (response.data && response.data.length > 0) {
-        setSearchResults(response.data);
+      const medications = response.data.data;
+      if (medications && medications.length > 0) {
+        setSearchResults(medications);
       } else {
         // If backend returns no results, use predefined medications
         const filteredMeds = PREDEFINED_MEDICATIONS.filter(med => 
@@ -114,7 +114,7 @@ function Triage() {
 
       await axios.post(`http://localhost:8080/api/visits`, {
         user_id: parseInt(id, 10), // Ensure user_id is a number
-        type: 'triage',
+        type: 'emergency',
         triage_data: formattedTriageData,
         date: new Date().toISOString(),
       });